@@ -97,7 +97,7 @@ func startServer(cfg *config.Config) {
 	defer store.Close()
 
 	// Initialize search manager
-	searchManager := search.NewManager(&cfg.WebSearch)
+	searchManager := search.NewManager(&cfg.WebSearch, store)
 
 	// Create handler
 	proxyHandler := handler.NewProxyHandler(cfg, store, searchManager)
@@ -8,13 +8,53 @@ import (
 )
 
 type Config struct {
-	Server        ServerConfig            `mapstructure:"server"`
-	DefaultTarget TargetConfig            `mapstructure:"default_target"`
-	Providers     map[string]TargetConfig `mapstructure:"providers"`
-	Logging       LoggingConfig           `mapstructure:"logging"`
-	ModelMapping  map[string]string       `mapstructure:"model_mapping"`
-	Storage       StorageConfig           `mapstructure:"storage"`
-	WebSearch     WebSearchConfig         `mapstructure:"web_search"`
+	Server          ServerConfig            `mapstructure:"server"`
+	DefaultTarget   TargetConfig            `mapstructure:"default_target"`
+	Providers       map[string]TargetConfig `mapstructure:"providers"`
+	Logging         LoggingConfig           `mapstructure:"logging"`
+	ModelMapping    map[string]string       `mapstructure:"model_mapping"`
+	Storage         StorageConfig           `mapstructure:"storage"`
+	WebSearch       WebSearchConfig         `mapstructure:"web_search"`
+	Background      BackgroundConfig        `mapstructure:"background"`
+	FileSearch      FileSearchConfig        `mapstructure:"file_search"`
+	CodeInterpreter CodeInterpreterConfig   `mapstructure:"code_interpreter"`
+}
+
+// CodeInterpreterConfig represents the settings of the sandbox that runs the
+// code of code_interpreter tools. The sandbox requires Linux with user
+// namespaces; each container is a working directory beside the database.
+type CodeInterpreterConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`      // Run code_interpreter tools, default false
+	Python      string `mapstructure:"python"`       // Interpreter, default python3
+	Timeout     int    `mapstructure:"timeout"`      // Max run time of the code in seconds, default 30
+	MemoryLimit int    `mapstructure:"memory_limit"` // Address space of the interpreter in MB, default 512
+	MaxOutput   int    `mapstructure:"max_output"`   // Bytes of stdout and of stderr kept per run, default 20000
+}
+
+// FileSearchConfig represents the settings of local vector stores and the
+// file_search tool
+type FileSearchConfig struct {
+	ChunkSize    int `mapstructure:"chunk_size"`    // Max tokens per chunk of the auto strategy, default 800
+	ChunkOverlap int `mapstructure:"chunk_overlap"` // Tokens shared by neighbouring chunks, default 400
+	MaxResults   int `mapstructure:"max_results"`   // Chunks returned per search unless the tool sets max_num_results, default 10
+	// Embeddings ranks chunks by meaning besides BM25. Leave base_url empty
+	// to search with BM25 only.
+	Embeddings EmbeddingsConfig `mapstructure:"embeddings"`
+}
+
+// EmbeddingsConfig represents an OpenAI compatible embeddings provider
+type EmbeddingsConfig struct {
+	BaseURL string `mapstructure:"base_url"` // e.g. https://api.openai.com/v1, /embeddings is appended
+	APIKey  string `mapstructure:"api_key"`
+	Model   string `mapstructure:"model"`
+	Timeout int    `mapstructure:"timeout"`
+}
+
+// BackgroundConfig represents the settings for background responses
+type BackgroundConfig struct {
+	Workers   int `mapstructure:"workers"`    // Jobs run concurrently, default 4
+	QueueSize int `mapstructure:"queue_size"` // Jobs waiting for a worker before new ones are rejected, default 100
+	Timeout   int `mapstructure:"timeout"`    // Max run time of a job in seconds, default 3600
 }
 
 // WebSearchConfig represents web search configuration
@@ -22,6 +62,34 @@ type WebSearchConfig struct {
 	Enabled   bool                      `mapstructure:"enabled"`
 	Default   string                    `mapstructure:"default"` // Default provider name
 	Providers map[string]ProviderConfig `mapstructure:"providers"`
+	// MaxConcurrency bounds the searches of one model turn that run at once
+	MaxConcurrency int `mapstructure:"max_concurrency"`
+	// CallTimeout bounds a single search in seconds, on top of the timeout
+	// of its provider
+	CallTimeout int `mapstructure:"call_timeout"`
+	// Cache keeps search results, so that repeated queries within its TTL
+	// do not reach the provider
+	Cache SearchCacheConfig `mapstructure:"cache"`
+	// Fallback lists the providers tried in order when the default one
+	// fails; empty tries all others by name
+	Fallback       []string             `mapstructure:"fallback"`
+	CircuitBreaker CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+}
+
+// CircuitBreakerConfig represents the circuit breaker of each search provider
+type CircuitBreakerConfig struct {
+	Failures int `mapstructure:"failures"` // Consecutive failures that open the breaker
+	Cooldown int `mapstructure:"cooldown"` // Seconds before an open breaker lets a probe through
+}
+
+// SearchCacheConfig represents the search result cache configuration
+type SearchCacheConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	Size    int  `mapstructure:"size"` // Maximum number of results kept in memory
+	TTL     int  `mapstructure:"ttl"`  // Seconds a result is reused
+	// Persist also keeps results in the conversation store, so that they
+	// survive restarts
+	Persist bool `mapstructure:"persist"`
 }
 
 // ProviderConfig represents a generic search provider configuration
@@ -33,10 +101,50 @@ type ProviderConfig struct {
 	QueryParam string `mapstructure:"query_param"` // MCP: query parameter name
 	Timeout    int    `mapstructure:"timeout"`
 	MaxResults int    `mapstructure:"max_results"` // For firecrawl etc.
+	TBS        string `mapstructure:"tbs"`         // Firecrawl: time-based search filter, e.g. "qdr:m"
+	// MCP: parameters the web_search options are passed in; empty leaves
+	// the option out of the call
+	DomainParam   string `mapstructure:"domain_param"`   // Allowed domain, sent when exactly one is given
+	LocationParam string `mapstructure:"location_param"` // Lowercase country code of the user location
+	CountParam    string `mapstructure:"count_param"`    // Number of results
+	// REST: a search API described by configuration. A preset ("tavily",
+	// "brave", "searxng") fills the settings left empty.
+	Preset  string            `mapstructure:"preset"`
+	Method  string            `mapstructure:"method"`  // "GET" or "POST"
+	URL     string            `mapstructure:"url"`     // URL template, e.g. "{base_url}/search"
+	Params  map[string]string `mapstructure:"params"`  // Query parameter templates, left out when empty
+	Headers map[string]string `mapstructure:"headers"` // Header templates
+	Body    string            `mapstructure:"body"`    // JSON body template, placeholders are JSON values
+	// AuthHeader carries the API key, prefixed with AuthScheme if set;
+	// empty sends no key and makes it optional
+	AuthHeader string `mapstructure:"auth_header"`
+	AuthScheme string `mapstructure:"auth_scheme"` // e.g. "Bearer"
+	// SiteOperators restricts the query to the allowed domains with site:
+	// operators, for APIs without a domain filter
+	SiteOperators bool        `mapstructure:"site_operators"`
+	Mapping       RESTMapping `mapstructure:"mapping"`
+}
+
+// RESTMapping maps a REST search response to search results. Paths are
+// dot-separated keys and array indexes, e.g. "web.results" or "items.0.link".
+type RESTMapping struct {
+	Results string `mapstructure:"results"` // Array of results; empty if the response is the array
+	Title   string `mapstructure:"title"`
+	URL     string `mapstructure:"url"`
+	Snippet string `mapstructure:"snippet"`
+	Content string `mapstructure:"content"`
 }
 
 type StorageConfig struct {
 	Path string `mapstructure:"path"` // Database path, default ./data/conversations.db
+	// Stateless disables all server-side history; clients carry the
+	// conversation as sealed reasoning.encrypted_content instead
+	Stateless bool `mapstructure:"stateless"`
+	// EncryptionKey seals the state returned as reasoning.encrypted_content.
+	// If empty, a random key is generated and sealed state does not survive restarts.
+	EncryptionKey string `mapstructure:"encryption_key"`
+	// MaxFileSize is the upload limit of the Files API in MB
+	MaxFileSize int `mapstructure:"max_file_size"`
 }
 
 type ServerConfig struct {
@@ -52,6 +160,12 @@ type TargetConfig struct {
 	DefaultAPIKey         string `mapstructure:"default_api_key"`
 	Timeout               int    `mapstructure:"timeout"`
 	SupportsDeveloperRole bool   `mapstructure:"supports_developer_role"` // Whether provider supports 'developer' role
+	StructuredOutput      string `mapstructure:"structured_output"`       // "native" (default), "json_object" or "emulate"
+	ToolChoice            string `mapstructure:"tool_choice"`             // "native" (default) or "emulate" for tool_choice/parallel_tool_calls
+	EmulationRetries      int    `mapstructure:"emulation_retries"`       // Max re-asks when an emulated constraint is violated, default 2
+	// ExtraBody is merged into every Chat Completions request body sent to
+	// this provider, e.g. {"enable_thinking": false} for Qwen
+	ExtraBody map[string]interface{} `mapstructure:"extra_body"`
 }
 
 type LoggingConfig struct {
@@ -118,10 +232,39 @@ func setDefaults(v *viper.Viper) {
 
 	// Storage defaults
 	v.SetDefault("storage.path", "./data/conversations.db")
+	v.SetDefault("storage.stateless", false)
+	v.SetDefault("storage.encryption_key", "")
+	v.SetDefault("storage.max_file_size", 32)
+
+	// Background response defaults
+	v.SetDefault("background.workers", 4)
+	v.SetDefault("background.queue_size", 100)
+	v.SetDefault("background.timeout", 3600)
+
+	// File search defaults
+	v.SetDefault("file_search.chunk_size", 800)
+	v.SetDefault("file_search.chunk_overlap", 400)
+	v.SetDefault("file_search.max_results", 10)
+	v.SetDefault("file_search.embeddings.timeout", 30)
+
+	// Code interpreter defaults
+	v.SetDefault("code_interpreter.enabled", false)
+	v.SetDefault("code_interpreter.python", "python3")
+	v.SetDefault("code_interpreter.timeout", 30)
+	v.SetDefault("code_interpreter.memory_limit", 512)
+	v.SetDefault("code_interpreter.max_output", 20000)
 
 	// Web Search defaults
 	v.SetDefault("web_search.enabled", true)
 	v.SetDefault("web_search.default", "zhipu")
+	v.SetDefault("web_search.max_concurrency", 4)
+	v.SetDefault("web_search.call_timeout", 30)
+	v.SetDefault("web_search.cache.enabled", true)
+	v.SetDefault("web_search.cache.size", 500)
+	v.SetDefault("web_search.cache.ttl", 600)
+	v.SetDefault("web_search.cache.persist", false)
+	v.SetDefault("web_search.circuit_breaker.failures", 3)
+	v.SetDefault("web_search.circuit_breaker.cooldown", 30)
 	v.SetDefault("web_search.providers.firecrawl.type", "firecrawl")
 	v.SetDefault("web_search.providers.firecrawl.base_url", "https://api.firecrawl.dev/v2")
 	v.SetDefault("web_search.providers.firecrawl.timeout", 30)
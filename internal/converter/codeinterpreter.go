@@ -0,0 +1,114 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// CodeInterpreterFunctionTool is the function tool that stands in for the
+// code_interpreter built-in tool; the proxy runs its calls in a local sandbox
+var CodeInterpreterFunctionTool = models.ChatTool{
+	Type: "function",
+	Function: models.FunctionDef{
+		Name: "code_interpreter",
+		Description: "Runs Python 3 code in a sandbox without network access and returns its output. " +
+			"Each run starts a new interpreter, so variables do not carry over, but files in the working directory do; " +
+			"the files the user provided are there too. Print the results you need. " +
+			"Files the code writes to the working directory are returned to the user.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"code": map[string]interface{}{
+					"type":        "string",
+					"description": "The Python code to run",
+				},
+			},
+			"required": []string{"code"},
+		},
+	},
+}
+
+// CodeInterpreterContainer reads the container of a code_interpreter tool.
+// Returns the container ID if one is named, otherwise the IDs of the files
+// to put into a new or reused container.
+func CodeInterpreterContainer(tool *models.Tool) (string, []string, error) {
+	switch v := tool.Container.(type) {
+	case nil:
+		return "", nil, nil
+	case string:
+		if v == "auto" {
+			return "", nil, nil
+		}
+		if !strings.HasPrefix(v, "cntr_") {
+			return "", nil, fmt.Errorf("invalid container %q: expected a container ID or auto", v)
+		}
+		return v, nil, nil
+	case map[string]interface{}:
+		if typ, _ := v["type"].(string); typ != "auto" {
+			return "", nil, fmt.Errorf("invalid container type %q: expected auto", typ)
+		}
+		raw, _ := v["file_ids"].([]interface{})
+		fileIDs := make([]string, 0, len(raw))
+		for _, id := range raw {
+			s, ok := id.(string)
+			if !ok || s == "" {
+				return "", nil, fmt.Errorf("container file_ids must be file IDs")
+			}
+			fileIDs = append(fileIDs, s)
+		}
+		return "", fileIDs, nil
+	default:
+		return "", nil, fmt.Errorf("invalid container: expected string or object")
+	}
+}
+
+// FormatCodeInterpreterOutputs formats the outputs of a run for the model
+func FormatCodeInterpreterOutputs(outputs []models.CodeInterpreterOutput) string {
+	var logs, files []string
+	for _, o := range outputs {
+		switch o.Type {
+		case "logs":
+			logs = append(logs, o.Logs)
+		case "file":
+			files = append(files, fmt.Sprintf("- %s (%s)", o.Filename, o.FileID))
+		}
+	}
+
+	var b strings.Builder
+	if text := strings.Join(logs, "\n"); strings.TrimSpace(text) != "" {
+		b.WriteString("Output:\n")
+		b.WriteString(text)
+	} else {
+		b.WriteString("The code ran without output.")
+	}
+	if len(files) > 0 {
+		b.WriteString("\n\nFiles written:\n")
+		b.WriteString(strings.Join(files, "\n"))
+	}
+	return b.String()
+}
+
+// convertCodeInterpreterCallItem converts a code_interpreter_call item to the
+// function call and the tool result the provider sees
+func convertCodeInterpreterCallItem(item *models.InputItem) []models.ChatMessage {
+	args, _ := json.Marshal(map[string]string{"code": item.Code})
+	call := convertFunctionCallItem(&models.InputItem{
+		CallID:    item.ID,
+		Name:      CodeInterpreterFunctionTool.Function.Name,
+		Arguments: string(args),
+	})
+
+	// Items sent back without their outputs read as runs without output
+	output := FormatCodeInterpreterOutputs(item.Outputs)
+	if item.Status == "failed" {
+		output = "Code execution failed."
+	}
+	result := convertFunctionCallOutputItem(&models.InputItem{
+		CallID: item.ID,
+		Output: output,
+	})
+	return []models.ChatMessage{*call, *result}
+}
@@ -2,6 +2,7 @@ package converter
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/young1lin/responses2chat/internal/models"
 )
@@ -45,6 +46,11 @@ func ConvertRequest(req *models.ResponsesRequest, modelMapping map[string]string
 	if len(history) > 0 {
 		messages = make([]models.ChatMessage, len(history))
 		copy(messages, history)
+		// Reasoning content is kept for history retrieval only; providers such
+		// as DeepSeek reject requests that send it back
+		for i := range messages {
+			messages[i].ReasoningContent = ""
+		}
 	}
 
 	// Convert instructions to system message (only if no history or first message is not system)
@@ -67,17 +73,14 @@ func ConvertRequest(req *models.ResponsesRequest, modelMapping map[string]string
 	}
 
 	// Convert input items to messages
-	for _, item := range req.Input {
-		msg := convertInputItemToMessage(&item, supportsDeveloperRole)
-		if msg != nil {
-			messages = append(messages, *msg)
-		}
-	}
+	messages = append(messages, ConvertInputItems(req.Input, supportsDeveloperRole)...)
 
-	chatReq.Messages = messages
+	chatReq.Messages = wrapToolCalls(messages)
 
 	// Track if web_search tool is present
 	hasWebSearchTool := false
+	hasFileSearchTool := false
+	hasCodeInterpreterTool := false
 
 	// Convert tools
 	for _, tool := range req.Tools {
@@ -86,11 +89,25 @@ func ConvertRequest(req *models.ResponsesRequest, modelMapping map[string]string
 			hasWebSearchTool = true
 			// Inject web_search as a callable function
 			chatReq.Tools = append(chatReq.Tools, WebSearchFunctionTool)
-		} else if tool.Type == "function" && tool.Function.Name != "" {
+			chatReq.WebSearch = webSearchOptions(&tool)
+		} else if fn := functionDefOf(&tool); tool.Type == "function" && fn.Name != "" {
 			chatReq.Tools = append(chatReq.Tools, models.ChatTool{
 				Type:     tool.Type,
-				Function: tool.Function,
+				Function: fn,
 			})
+		} else if tool.Type == ToolTypeCustom && tool.Name != "" {
+			// Free-form tools such as Codex apply_patch
+			addEmulatedTool(chatReq, ToolTypeCustom, customFunctionDef(&tool))
+		} else if tool.Type == ToolTypeLocalShell {
+			addEmulatedTool(chatReq, ToolTypeLocalShell, LocalShellFunctionTool.Function)
+		} else if tool.Type == "file_search" && !hasFileSearchTool {
+			// Searched by the proxy in local vector stores
+			hasFileSearchTool = true
+			chatReq.Tools = append(chatReq.Tools, FileSearchFunctionTool)
+		} else if tool.Type == "code_interpreter" && !hasCodeInterpreterTool {
+			// Run by the proxy in a local sandbox
+			hasCodeInterpreterTool = true
+			chatReq.Tools = append(chatReq.Tools, CodeInterpreterFunctionTool)
 		}
 	}
 
@@ -101,19 +118,91 @@ func ConvertRequest(req *models.ResponsesRequest, modelMapping map[string]string
 	if req.MaxTokens > 0 {
 		chatReq.MaxTokens = req.MaxTokens
 	}
+	chatReq.TopP = req.TopP
+	chatReq.Stop = req.Stop
+	chatReq.Seed = req.Seed
+	chatReq.PresencePenalty = req.PresencePenalty
+	chatReq.FrequencyPenalty = req.FrequencyPenalty
+	chatReq.ServiceTier = req.ServiceTier
+
+	// safety_identifier replaces user in the Responses API; third-party
+	// providers only know user
+	chatReq.User = req.User
+	if chatReq.User == "" {
+		chatReq.User = req.SafetyIdentifier
+	}
 
 	return chatReq, hasWebSearchTool
 }
 
+// webSearchOptions returns the search options of a web_search tool
+func webSearchOptions(tool *models.Tool) *models.WebSearchOptions {
+	opts := &models.WebSearchOptions{
+		UserLocation:      tool.UserLocation,
+		SearchContextSize: tool.SearchContextSize,
+	}
+	if tool.Filters != nil {
+		opts.AllowedDomains = tool.Filters.AllowedDomains
+	}
+	return opts
+}
+
+// functionDefOf returns the function definition of a tool, accepting both the
+// flat Responses API form and the nested Chat Completions form
+func functionDefOf(tool *models.Tool) models.FunctionDef {
+	if tool.Function.Name != "" {
+		return tool.Function
+	}
+	return models.FunctionDef{
+		Name:        tool.Name,
+		Description: tool.Description,
+		Parameters:  tool.Parameters,
+		Strict:      tool.Strict,
+	}
+}
+
+// ConvertInputItems converts input items to chat messages, skipping items
+// that have no Chat Completions equivalent
+func ConvertInputItems(items []models.InputItem, supportsDeveloperRole bool) []models.ChatMessage {
+	var messages []models.ChatMessage
+	for _, item := range items {
+		switch item.Type {
+		case "mcp_call":
+			messages = append(messages, convertMCPCallItem(&item)...)
+			continue
+		case "file_search_call":
+			messages = append(messages, convertFileSearchCallItem(&item)...)
+			continue
+		case "code_interpreter_call":
+			messages = append(messages, convertCodeInterpreterCallItem(&item)...)
+			continue
+		case "web_search_call":
+			messages = append(messages, convertWebSearchCallItem(&item)...)
+			continue
+		}
+		msg := convertInputItemToMessage(&item, supportsDeveloperRole)
+		if msg != nil {
+			messages = append(messages, *msg)
+		}
+	}
+	return messages
+}
+
 // convertInputItemToMessage converts an input item to a chat message
 func convertInputItemToMessage(item *models.InputItem, supportsDeveloperRole bool) *models.ChatMessage {
 	switch item.Type {
-	case "message":
+	case "message", "": // Items with only a role are messages
 		return convertMessageItem(item, supportsDeveloperRole)
 	case "function_call":
 		return convertFunctionCallItem(item)
-	case "function_call_output":
+	case "function_call_output", "custom_tool_call_output":
 		return convertFunctionCallOutputItem(item)
+	case "custom_tool_call":
+		return convertCustomToolCallItem(item)
+	case "local_shell_call":
+		return convertLocalShellCallItem(item)
+	case "local_shell_call_output":
+		return convertLocalShellCallOutputItem(item)
 	default:
 		return nil
 	}
@@ -139,18 +228,21 @@ func convertMessageItem(item *models.InputItem, supportsDeveloperRole bool) *mod
 	// Handle content
 	if len(item.Content) > 0 {
 		// Check if content is simple text or multimodal
-		if len(item.Content) == 1 && item.Content[0].Type == "input_text" {
-			msg.Content = item.Content[0].Text
+		// output_text and refusal appear in assistant messages sent back by the client
+		if text, ok := contentText(item.Content[0]); ok && len(item.Content) == 1 {
+			msg.Content = text
 		} else {
 			// Multimodal content - filter and convert
 			var parts []models.ChatContentPart
 			for _, c := range item.Content {
-				switch c.Type {
-				case "input_text":
+				if text, ok := contentText(c); ok {
 					parts = append(parts, models.ChatContentPart{
 						Type: "text",
-						Text: c.Text,
+						Text: text,
 					})
+					continue
+				}
+				switch c.Type {
 				case "input_image":
 					part := models.ChatContentPart{
 						Type: "image_url",
@@ -168,6 +260,13 @@ func convertMessageItem(item *models.InputItem, supportsDeveloperRole bool) *mod
 			// If only one text part after filtering, simplify to string
 			if len(parts) == 1 && parts[0].Type == "text" {
 				msg.Content = parts[0].Text
+			} else if role == "assistant" && allTextParts(parts) {
+				// Assistant content must be plain text for most providers
+				texts := make([]string, len(parts))
+				for i, part := range parts {
+					texts[i] = part.Text
+				}
+				msg.Content = strings.Join(texts, "")
 			} else if len(parts) > 0 {
 				msg.Content = parts
 			}
@@ -177,6 +276,28 @@ func convertMessageItem(item *models.InputItem, supportsDeveloperRole bool) *mod
 	return msg
 }
 
+// contentText returns the text of a text-like content part
+func contentText(c models.ContentItem) (string, bool) {
+	switch c.Type {
+	case "input_text", "output_text":
+		return c.Text, true
+	case "refusal":
+		return c.Refusal, true
+	default:
+		return "", false
+	}
+}
+
+// allTextParts reports whether all chat content parts are text
+func allTextParts(parts []models.ChatContentPart) bool {
+	for _, part := range parts {
+		if part.Type != "text" {
+			return false
+		}
+	}
+	return len(parts) > 0
+}
+
 // convertFunctionCallItem converts a function call input item
 func convertFunctionCallItem(item *models.InputItem) *models.ChatMessage {
 	return &models.ChatMessage{
@@ -217,50 +338,161 @@ func ConvertResponse(resp *models.ChatCompletionResponse, requestID string) *mod
 		Output:    make([]models.OutputItem, 0),
 	}
 
+	reasoningText := ""
 	if len(resp.Choices) > 0 {
-		choice := resp.Choices[0]
-		outputItem := models.OutputItem{
-			Type: "message",
-			ID:   fmt.Sprintf("msg-%s", requestID),
-			Role: choice.Message.Role,
-		}
+		reasoningText = resp.Choices[0].Message.ReasoningContent
+		response.Output = append(response.Output, BuildOutputItems(&resp.Choices[0].Message, requestID)...)
+	}
 
-		// Convert content
-		switch v := choice.Message.Content.(type) {
-		case string:
-			if v != "" {
-				outputItem.Content = []models.ContentItem{
-					{Type: "output_text", Text: v},
-				}
+	// Convert usage
+	if resp.Usage.TotalTokens > 0 {
+		response.Usage = ConvertUsage(
+			resp.Usage.PromptTokens,
+			resp.Usage.CompletionTokens,
+			resp.Usage.TotalTokens,
+			resp.Usage.PromptDetails,
+			resp.Usage.CompletionDetails,
+			reasoningText,
+		)
+	}
+
+	return response
+}
+
+// BuildOutputItems converts an assistant message to output items.
+// Item IDs are derived from the request ID, so the same message always
+// yields the same IDs, matching those sent while streaming.
+func BuildOutputItems(msg *models.ChatMessage, requestID string) []models.OutputItem {
+	var output []models.OutputItem
+
+	// Convert reasoning content to a reasoning item preceding the answer
+	if msg.ReasoningContent != "" {
+		output = append(output, BuildReasoningItem(fmt.Sprintf("rs-%s", requestID), msg.ReasoningContent))
+	}
+
+	outputItem := models.OutputItem{
+		Type: "message",
+		ID:   fmt.Sprintf("msg-%s", requestID),
+		Role: msg.Role,
+	}
+
+	// Convert content
+	switch v := msg.Content.(type) {
+	case string:
+		if v != "" {
+			outputItem.Content = []models.ContentItem{
+				{Type: "output_text", Text: v},
 			}
 		}
+	}
 
-		// Convert tool calls
-		if len(choice.Message.ToolCalls) > 0 {
-			for _, tc := range choice.Message.ToolCalls {
-				toolItem := models.OutputItem{
-					Type:      "function_call",
-					ID:        fmt.Sprintf("fc-%s", requestID),
-					CallID:    tc.ID,
-					Name:      tc.Function.Name,
-					Arguments: tc.Function.Arguments,
-					Status:    "completed",
-				}
-				response.Output = append(response.Output, toolItem)
-			}
+	// Convert tool calls
+	for i, tc := range msg.ToolCalls {
+		if tc.Type == ToolTypeMCPApproval {
+			output = append(output, models.OutputItem{
+				Type:        "mcp_approval_request",
+				ID:          fmt.Sprintf("mcpr-%s-%d", requestID, i),
+				ServerLabel: tc.ServerLabel,
+				Name:        tc.Function.Name,
+				Arguments:   tc.Function.Arguments,
+			})
+			continue
+		}
+		if tc.Type == ToolTypeLocalShell {
+			output = append(output, models.OutputItem{
+				Type:   "local_shell_call",
+				ID:     fmt.Sprintf("lsc-%s-%d", requestID, i),
+				CallID: tc.ID,
+				Action: LocalShellAction(tc.Function.Arguments),
+				Status: "completed",
+			})
+			continue
+		}
+		if tc.Type == ToolTypeCustom {
+			output = append(output, models.OutputItem{
+				Type:   "custom_tool_call",
+				ID:     fmt.Sprintf("ctc-%s-%d", requestID, i),
+				CallID: tc.ID,
+				Name:   tc.Function.Name,
+				Input:  tc.Function.Arguments,
+				Status: "completed",
+			})
+			continue
+		}
+		toolItem := models.OutputItem{
+			Type:      "function_call",
+			ID:        fmt.Sprintf("fc-%s-%d", requestID, i),
+			CallID:    tc.ID,
+			Name:      tc.Function.Name,
+			Arguments: tc.Function.Arguments,
+			Status:    "completed",
 		}
+		output = append(output, toolItem)
+	}
+
+	return append(output, outputItem)
+}
 
-		response.Output = append(response.Output, outputItem)
+// BuildReasoningItem builds a reasoning output item carrying the provider's
+// chain-of-thought as a single summary_text part
+func BuildReasoningItem(id, text string) models.OutputItem {
+	return models.OutputItem{
+		Type:    "reasoning",
+		ID:      id,
+		Summary: []models.ContentItem{{Type: "summary_text", Text: text}},
+		Status:  "completed",
 	}
+}
 
-	// Convert usage
-	if resp.Usage.TotalTokens > 0 {
-		response.Usage = models.UsageInfo{
-			InputTokens:  resp.Usage.PromptTokens,
-			OutputTokens: resp.Usage.CompletionTokens,
-			TotalTokens:  resp.Usage.TotalTokens,
-		}
+// ConvertUsage converts Chat Completions token usage to Responses usage.
+// When the provider returns reasoning text but no reasoning token count,
+// the count is estimated from the text so Codex can still display it.
+func ConvertUsage(
+	promptTokens, completionTokens, totalTokens int,
+	promptDetails *models.ChatChunkPromptDetails,
+	completionDetails *models.ChatChunkCompletionDetails,
+	reasoningText string,
+) models.UsageInfo {
+	usage := models.UsageInfo{
+		InputTokens:  promptTokens,
+		OutputTokens: completionTokens,
+		TotalTokens:  totalTokens,
 	}
 
-	return response
+	// Always set InputTokensDetails with default if not provided
+	cachedTokens := 0
+	if promptDetails != nil {
+		cachedTokens = promptDetails.CachedTokens
+	}
+	usage.InputTokensDetails = &models.InputTokensDetails{
+		CachedTokens: cachedTokens,
+	}
+
+	// Always set OutputTokensDetails with default if not provided
+	reasoningTokens := 0
+	if completionDetails != nil {
+		reasoningTokens = completionDetails.ReasoningTokens
+	}
+	if reasoningTokens == 0 && reasoningText != "" {
+		reasoningTokens = min(estimateTokens(reasoningText), completionTokens)
+	}
+	usage.OutputTokensDetails = &models.OutputTokensDetails{
+		ReasoningTokens: reasoningTokens,
+	}
+
+	return usage
+}
+
+// estimateTokens gives a rough token count: about four ASCII characters per
+// token, and one token per CJK or other non-ASCII character
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
 }
@@ -1,8 +1,14 @@
 package converter
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"go.uber.org/zap"
+
 	"github.com/young1lin/responses2chat/internal/models"
 )
 
@@ -105,6 +111,25 @@ func TestConvertRequest(t *testing.T) {
 		}
 	})
 
+	t.Run("History reasoning content is not sent upstream", func(t *testing.T) {
+		history := []models.ChatMessage{
+			{Role: "user", Content: "Previous question"},
+			{Role: "assistant", Content: "Previous answer", ReasoningContent: "thinking..."},
+		}
+
+		req := &models.ResponsesRequest{Model: "gpt-4"}
+
+		chatReq, _ := ConvertRequest(req, modelMapping, history, false)
+
+		if chatReq.Messages[1].ReasoningContent != "" {
+			t.Errorf("Expected reasoning content to be stripped, got '%s'", chatReq.Messages[1].ReasoningContent)
+		}
+
+		if history[1].ReasoningContent == "" {
+			t.Error("Expected stored history to keep its reasoning content")
+		}
+	})
+
 	t.Run("With history and instructions", func(t *testing.T) {
 		history := []models.ChatMessage{
 			{Role: "user", Content: "Previous question"},
@@ -249,6 +274,100 @@ func TestConvertRequest(t *testing.T) {
 		}
 	})
 
+	t.Run("Sampling parameters", func(t *testing.T) {
+		topP := 0.9
+		seed := 42
+		penalty := 0.5
+		req := &models.ResponsesRequest{
+			Model:            "gpt-4",
+			TopP:             &topP,
+			Stop:             []interface{}{"END"},
+			Seed:             &seed,
+			PresencePenalty:  &penalty,
+			FrequencyPenalty: &penalty,
+			SafetyIdentifier: "user-hash",
+			ServiceTier:      "flex",
+		}
+
+		chatReq, _ := ConvertRequest(req, modelMapping, nil, false)
+
+		if chatReq.TopP == nil || *chatReq.TopP != 0.9 {
+			t.Errorf("Expected top_p 0.9, got %v", chatReq.TopP)
+		}
+		if chatReq.Seed == nil || *chatReq.Seed != 42 {
+			t.Errorf("Expected seed 42, got %v", chatReq.Seed)
+		}
+		if chatReq.Stop == nil {
+			t.Error("Expected stop to be passed through")
+		}
+		if chatReq.PresencePenalty == nil || chatReq.FrequencyPenalty == nil {
+			t.Error("Expected penalties to be passed through")
+		}
+		if chatReq.User != "user-hash" {
+			t.Errorf("Expected safety_identifier mapped to user, got '%s'", chatReq.User)
+		}
+		if chatReq.ServiceTier != "flex" {
+			t.Errorf("Expected service_tier 'flex', got '%s'", chatReq.ServiceTier)
+		}
+	})
+
+	t.Run("Flat Responses function tool", func(t *testing.T) {
+		req := &models.ResponsesRequest{
+			Model: "gpt-4",
+			Tools: []models.Tool{
+				{
+					Type:        "function",
+					Name:        "shell",
+					Description: "Run a shell command",
+					Parameters:  map[string]interface{}{"type": "object"},
+				},
+			},
+		}
+
+		chatReq, _ := ConvertRequest(req, modelMapping, nil, false)
+
+		if len(chatReq.Tools) != 1 || chatReq.Tools[0].Function.Name != "shell" {
+			t.Fatalf("Expected flat function tool 'shell', got %v", chatReq.Tools)
+		}
+	})
+
+	t.Run("Web search options", func(t *testing.T) {
+		req := &models.ResponsesRequest{
+			Model: "gpt-4",
+			Tools: []models.Tool{{
+				Type:              "web_search",
+				Filters:           &models.WebSearchFilters{AllowedDomains: []string{"go.dev"}},
+				UserLocation:      &models.WebSearchUserLocation{Type: "approximate", Country: "US"},
+				SearchContextSize: "high",
+			}},
+		}
+
+		chatReq, hasWebSearch := ConvertRequest(req, modelMapping, nil, false)
+
+		if !hasWebSearch {
+			t.Fatal("Expected hasWebSearch to be true")
+		}
+		opts := chatReq.WebSearch
+		if opts == nil {
+			t.Fatal("Expected web search options")
+		}
+		if len(opts.AllowedDomains) != 1 || opts.AllowedDomains[0] != "go.dev" {
+			t.Errorf("Expected allowed domains [go.dev], got %v", opts.AllowedDomains)
+		}
+		if opts.UserLocation == nil || opts.UserLocation.Country != "US" {
+			t.Errorf("Expected user location country 'US', got %+v", opts.UserLocation)
+		}
+		if opts.SearchContextSize != "high" {
+			t.Errorf("Expected search context size 'high', got '%s'", opts.SearchContextSize)
+		}
+
+		// Options are handled by the proxy, not sent upstream
+		body, _ := json.Marshal(chatReq)
+		if strings.Contains(string(body), "allowed_domains") || strings.Contains(string(body), "search_context_size") {
+			t.Errorf("Expected web search options to stay out of the upstream request, got %s", body)
+		}
+	})
+
 	t.Run("Developer role mapped to user when not supported", func(t *testing.T) {
 		req := &models.ResponsesRequest{
 			Model: "gpt-4",
@@ -401,4 +520,1261 @@ func TestConvertResponse(t *testing.T) {
 			t.Errorf("Expected second output type 'message', got '%s'", resp.Output[1].Type)
 		}
 	})
+	t.Run("With reasoning content", func(t *testing.T) {
+		chatResp := &models.ChatCompletionResponse{
+			ID:      "chatcmpl-789",
+			Created: 1234567890,
+			Model:   "deepseek-reasoner",
+			Choices: []models.ChatChoice{
+				{
+					Message: models.ChatMessage{
+						Role:             "assistant",
+						Content:          "42",
+						ReasoningContent: "Let me think about this carefully.",
+					},
+					FinishReason: "stop",
+				},
+			},
+			Usage: models.ChatUsage{
+				PromptTokens:     10,
+				CompletionTokens: 20,
+				TotalTokens:      30,
+			},
+		}
+
+		resp := ConvertResponse(chatResp, "reasoning-test")
+
+		// Should have: 1 reasoning + 1 message = 2 output items
+		if len(resp.Output) != 2 {
+			t.Fatalf("Expected 2 output items, got %d", len(resp.Output))
+		}
+
+		if resp.Output[0].Type != "reasoning" {
+			t.Errorf("Expected first output type 'reasoning', got '%s'", resp.Output[0].Type)
+		}
+
+		if len(resp.Output[0].Summary) != 1 || resp.Output[0].Summary[0].Text != "Let me think about this carefully." {
+			t.Errorf("Unexpected reasoning summary: %v", resp.Output[0].Summary)
+		}
+
+		// Provider returned no reasoning_tokens, so it is estimated
+		if resp.Usage.OutputTokensDetails == nil || resp.Usage.OutputTokensDetails.ReasoningTokens == 0 {
+			t.Errorf("Expected estimated reasoning tokens, got %+v", resp.Usage.OutputTokensDetails)
+		}
+	})
+}
+
+func TestApplyTextFormat(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"answer": map[string]interface{}{"type": "string"},
+		},
+		"required":             []interface{}{"answer"},
+		"additionalProperties": false,
+	}
+	text := &models.TextConfig{
+		Format: &models.TextFormat{Type: "json_schema", Name: "result", Schema: schema},
+	}
+
+	t.Run("Native json_schema", func(t *testing.T) {
+		chatReq := &models.ChatCompletionRequest{}
+
+		validate := ApplyTextFormat(chatReq, text, StructuredOutputNative)
+
+		if validate != nil {
+			t.Error("Expected no local validation for native mode")
+		}
+		if chatReq.ResponseFormat == nil || chatReq.ResponseFormat.Type != "json_schema" {
+			t.Fatalf("Expected json_schema response_format, got %+v", chatReq.ResponseFormat)
+		}
+		if chatReq.ResponseFormat.JSONSchema.Name != "result" {
+			t.Errorf("Expected schema name 'result', got '%s'", chatReq.ResponseFormat.JSONSchema.Name)
+		}
+	})
+
+	t.Run("json_object mode", func(t *testing.T) {
+		chatReq := &models.ChatCompletionRequest{
+			Messages: []models.ChatMessage{{Role: "user", Content: "Hi"}},
+		}
+
+		validate := ApplyTextFormat(chatReq, text, StructuredOutputJSONObject)
+
+		if validate == nil {
+			t.Error("Expected local validation for json_object mode")
+		}
+		if chatReq.ResponseFormat == nil || chatReq.ResponseFormat.Type != "json_object" {
+			t.Errorf("Expected json_object response_format, got %+v", chatReq.ResponseFormat)
+		}
+		if chatReq.Messages[0].Role != "system" {
+			t.Errorf("Expected injected system prompt, got role '%s'", chatReq.Messages[0].Role)
+		}
+	})
+
+	t.Run("Emulate keeps original messages", func(t *testing.T) {
+		original := []models.ChatMessage{
+			{Role: "system", Content: "Be brief"},
+			{Role: "user", Content: "Hi"},
+		}
+		chatReq := &models.ChatCompletionRequest{Messages: original}
+
+		validate := ApplyTextFormat(chatReq, text, StructuredOutputEmulate)
+
+		if validate == nil {
+			t.Error("Expected local validation for emulate mode")
+		}
+		if chatReq.ResponseFormat != nil {
+			t.Errorf("Expected no response_format, got %+v", chatReq.ResponseFormat)
+		}
+		if chatReq.Messages[0].Content == "Be brief" {
+			t.Error("Expected schema instructions in system prompt")
+		}
+		if original[0].Content != "Be brief" {
+			t.Errorf("Expected original messages untouched, got '%v'", original[0].Content)
+		}
+	})
+}
+
+func TestValidateStructuredOutput(t *testing.T) {
+	format := &models.TextFormat{
+		Type: "json_schema",
+		Schema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name":  map[string]interface{}{"type": "string"},
+				"count": map[string]interface{}{"type": "integer"},
+				"tags": map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"$ref": "#/$defs/tag"},
+				},
+			},
+			"required":             []interface{}{"name", "count"},
+			"additionalProperties": false,
+			"$defs": map[string]interface{}{
+				"tag": map[string]interface{}{"type": "string", "enum": []interface{}{"a", "b"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid", `{"name":"x","count":2,"tags":["a"]}`, false},
+		{"code fence", "```json\n{\"name\":\"x\",\"count\":2}\n```", false},
+		{"not json", `name: x`, true},
+		{"missing required", `{"name":"x"}`, true},
+		{"wrong type", `{"name":"x","count":1.5}`, true},
+		{"additional property", `{"name":"x","count":1,"extra":true}`, true},
+		{"enum via ref", `{"name":"x","count":1,"tags":["c"]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateStructuredOutput(tt.content, format)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateStructuredOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyToolChoice(t *testing.T) {
+	newChatReq := func() *models.ChatCompletionRequest {
+		return &models.ChatCompletionRequest{
+			Tools: []models.ChatTool{
+				{Type: "function", Function: models.FunctionDef{Name: "shell"}},
+				{Type: "function", Function: models.FunctionDef{Name: "read_file"}},
+			},
+		}
+	}
+	parallel := false
+
+	t.Run("Native forced function", func(t *testing.T) {
+		chatReq := newChatReq()
+		req := &models.ResponsesRequest{
+			ToolChoice:        map[string]interface{}{"type": "function", "name": "shell"},
+			ParallelToolCalls: &parallel,
+		}
+
+		emulation, err := ApplyToolChoice(chatReq, req, ToolChoiceNative)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if emulation != nil {
+			t.Error("Expected no emulation in native mode")
+		}
+
+		forced, ok := chatReq.ToolChoice.(models.ChatToolChoice)
+		if !ok || forced.Function.Name != "shell" {
+			t.Errorf("Expected forced function 'shell', got %#v", chatReq.ToolChoice)
+		}
+		if chatReq.ParallelToolCalls == nil || *chatReq.ParallelToolCalls {
+			t.Error("Expected parallel_tool_calls false to be passed through")
+		}
+	})
+
+	t.Run("Native web_search choice", func(t *testing.T) {
+		chatReq := newChatReq()
+		req := &models.ResponsesRequest{ToolChoice: map[string]interface{}{"type": "web_search"}}
+
+		ApplyToolChoice(chatReq, req, ToolChoiceNative)
+
+		forced, ok := chatReq.ToolChoice.(models.ChatToolChoice)
+		if !ok || forced.Function.Name != "web_search" {
+			t.Errorf("Expected forced function 'web_search', got %#v", chatReq.ToolChoice)
+		}
+	})
+
+	t.Run("Emulated none strips tools", func(t *testing.T) {
+		chatReq := newChatReq()
+		req := &models.ResponsesRequest{ToolChoice: "none"}
+
+		emulation, _ := ApplyToolChoice(chatReq, req, ToolChoiceEmulate)
+
+		if len(chatReq.Tools) != 0 {
+			t.Errorf("Expected tools to be stripped, got %d", len(chatReq.Tools))
+		}
+		if emulation != nil {
+			t.Error("Expected nothing left to emulate")
+		}
+		if chatReq.ToolChoice != nil {
+			t.Error("Expected tool_choice not to be sent")
+		}
+	})
+
+	t.Run("Emulated forced function filters tools", func(t *testing.T) {
+		chatReq := newChatReq()
+		req := &models.ResponsesRequest{
+			ToolChoice:        map[string]interface{}{"type": "function", "name": "read_file"},
+			ParallelToolCalls: &parallel,
+		}
+
+		emulation, _ := ApplyToolChoice(chatReq, req, ToolChoiceEmulate)
+
+		if len(chatReq.Tools) != 1 || chatReq.Tools[0].Function.Name != "read_file" {
+			t.Fatalf("Expected only read_file tool, got %v", chatReq.Tools)
+		}
+		if emulation == nil || !emulation.RequireToolCall || !emulation.SingleToolCall {
+			t.Errorf("Expected required single tool call emulation, got %+v", emulation)
+		}
+		if chatReq.ParallelToolCalls != nil {
+			t.Error("Expected parallel_tool_calls not to be sent")
+		}
+	})
+
+	t.Run("Invalid tool_choice", func(t *testing.T) {
+		req := &models.ResponsesRequest{ToolChoice: "always"}
+
+		if _, err := ApplyToolChoice(newChatReq(), req, ToolChoiceNative); err == nil {
+			t.Error("Expected error for invalid tool_choice")
+		}
+	})
+}
+
+func TestMergeExtraBody(t *testing.T) {
+	provider := map[string]interface{}{
+		"enable_thinking": true,
+		"do_sample":       false,
+	}
+
+	t.Run("Provider only", func(t *testing.T) {
+		merged, err := MergeExtraBody(provider, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if merged["enable_thinking"] != true {
+			t.Errorf("Expected provider extra_body, got %v", merged)
+		}
+	})
+
+	t.Run("String metadata override", func(t *testing.T) {
+		metadata := map[string]interface{}{
+			"extra_body": `{"enable_thinking": false, "do_sample": null}`,
+		}
+
+		merged, err := MergeExtraBody(provider, metadata)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if merged["enable_thinking"] != false {
+			t.Errorf("Expected request override to win, got %v", merged["enable_thinking"])
+		}
+
+		chatReq := models.ChatCompletionRequest{Model: "qwen-plus", ExtraBody: merged}
+		data, err := json.Marshal(chatReq)
+		if err != nil {
+			t.Fatalf("Failed to marshal: %v", err)
+		}
+		var body map[string]interface{}
+		json.Unmarshal(data, &body)
+		if body["enable_thinking"] != false {
+			t.Errorf("Expected enable_thinking at top level, got %s", data)
+		}
+		if _, ok := body["do_sample"]; ok {
+			t.Errorf("Expected null override to remove do_sample, got %s", data)
+		}
+		if body["model"] != "qwen-plus" {
+			t.Errorf("Expected model to be kept, got %s", data)
+		}
+	})
+
+	t.Run("Request fields win", func(t *testing.T) {
+		parallel := false
+		chatReq := models.ChatCompletionRequest{
+			Model:             "qwen-plus",
+			Tools:             []models.ChatTool{{Type: "function", Function: models.FunctionDef{Name: "lookup"}}},
+			ToolChoice:        "required",
+			MaxTokens:         100,
+			ResponseFormat:    &models.ResponseFormat{Type: "json_object"},
+			ParallelToolCalls: &parallel,
+			ExtraBody: map[string]interface{}{
+				"tools":               []interface{}{},
+				"tool_choice":         "none",
+				"max_tokens":          1,
+				"response_format":     map[string]interface{}{"type": "text"},
+				"parallel_tool_calls": nil,
+				"stream":              true,
+				"enable_thinking":     true,
+			},
+		}
+		data, err := json.Marshal(chatReq)
+		if err != nil {
+			t.Fatalf("Failed to marshal: %v", err)
+		}
+		var body map[string]interface{}
+		json.Unmarshal(data, &body)
+
+		if tools, _ := body["tools"].([]interface{}); len(tools) != 1 {
+			t.Errorf("Expected the converted tools, got %s", data)
+		}
+		if body["tool_choice"] != "required" || body["max_tokens"] != float64(100) || body["parallel_tool_calls"] != false {
+			t.Errorf("Expected the converted tool_choice, max_tokens and parallel_tool_calls, got %s", data)
+		}
+		if format, _ := body["response_format"].(map[string]interface{}); format["type"] != "json_object" {
+			t.Errorf("Expected the converted response_format, got %s", data)
+		}
+		if _, ok := body["stream"]; ok {
+			t.Errorf("Expected stream not to be set, got %s", data)
+		}
+		if body["enable_thinking"] != true {
+			t.Errorf("Expected vendor fields to be added, got %s", data)
+		}
+	})
+
+	t.Run("Invalid metadata", func(t *testing.T) {
+		metadata := map[string]interface{}{"extra_body": "not json"}
+
+		if _, err := MergeExtraBody(provider, metadata); err == nil {
+			t.Error("Expected error for invalid extra_body metadata")
+		}
+	})
+}
+
+func TestLenientInput(t *testing.T) {
+	parse := func(t *testing.T, body string) *models.ResponsesRequest {
+		t.Helper()
+		var req models.ResponsesRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatalf("Failed to parse request: %v", err)
+		}
+		return &req
+	}
+
+	t.Run("String input", func(t *testing.T) {
+		req := parse(t, `{"model": "gpt-4", "input": "Hello"}`)
+
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if len(chatReq.Messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(chatReq.Messages))
+		}
+		if chatReq.Messages[0].Role != "user" || chatReq.Messages[0].Content != "Hello" {
+			t.Errorf("Expected user message 'Hello', got %+v", chatReq.Messages[0])
+		}
+	})
+
+	t.Run("Untyped items with string content", func(t *testing.T) {
+		req := parse(t, `{"model": "gpt-4", "input": [
+			{"role": "user", "content": "Hi"},
+			{"role": "assistant", "content": "Hello!"},
+			{"role": "user", "content": "Bye"}
+		]}`)
+
+		if req.Input[1].Type != "message" || req.Input[1].Content[0].Type != "output_text" {
+			t.Errorf("Expected assistant message with output_text, got %+v", req.Input[1])
+		}
+
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if len(chatReq.Messages) != 3 {
+			t.Fatalf("Expected 3 messages, got %d", len(chatReq.Messages))
+		}
+		if chatReq.Messages[1].Role != "assistant" || chatReq.Messages[1].Content != "Hello!" {
+			t.Errorf("Expected assistant message 'Hello!', got %+v", chatReq.Messages[1])
+		}
+	})
+
+	t.Run("Assistant output_text and refusal parts", func(t *testing.T) {
+		req := parse(t, `{"model": "gpt-4", "input": [
+			{"type": "message", "role": "assistant", "content": [
+				{"type": "output_text", "text": "I can help with that. "},
+				{"type": "refusal", "refusal": "But not with this."}
+			]}
+		]}`)
+
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if got := chatReq.Messages[0].Content; got != "I can help with that. But not with this." {
+			t.Errorf("Expected joined assistant text, got %v", got)
+		}
+	})
+
+	t.Run("Function call output parts", func(t *testing.T) {
+		req := parse(t, `{"model": "gpt-4", "input": [
+			{"type": "function_call_output", "call_id": "call_1", "output": [{"type": "input_text", "text": "42"}]}
+		]}`)
+
+		if req.Input[0].Output != "42" {
+			t.Errorf("Expected output '42', got %q", req.Input[0].Output)
+		}
+	})
+
+	t.Run("Invalid shapes", func(t *testing.T) {
+		tests := []struct {
+			body  string
+			param string
+		}{
+			{`{"input": 42}`, "input"},
+			{`{"input": [{"content": "Hi"}]}`, "input[0].type"},
+			{`{"input": [{"type": "message", "content": "Hi"}]}`, "input[0].role"},
+			{`{"input": [{"role": "user", "content": 42}]}`, "input[0].content"},
+			{`{"input": ["Hi", {"role": "user", "content": [{"text": "Hi"}]}]}`, "input[0]"},
+			{`{"input": [{"role": "user", "content": [{"text": "Hi"}]}]}`, "input[0].content[0].type"},
+			{`{"input": [{"type": "function_call", "call_id": 1}]}`, "input[0].call_id"},
+		}
+
+		for _, tt := range tests {
+			var req models.ResponsesRequest
+			err := json.Unmarshal([]byte(tt.body), &req)
+			paramErr, ok := err.(*models.ParamError)
+			if !ok {
+				t.Errorf("%s: expected ParamError, got %v", tt.body, err)
+				continue
+			}
+			if paramErr.Param != tt.param {
+				t.Errorf("%s: expected param %q, got %q", tt.body, tt.param, paramErr.Param)
+			}
+		}
+	})
+}
+
+func TestFileContentItem(t *testing.T) {
+	t.Run("Text file", func(t *testing.T) {
+		part, err := FileContentItem("main.go", []byte("package main\n"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if part.Type != "input_text" || !strings.Contains(part.Text, "package main") || !strings.Contains(part.Text, "main.go") {
+			t.Errorf("Expected inline text with file name, got %+v", part)
+		}
+	})
+
+	t.Run("Image file", func(t *testing.T) {
+		png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+		part, err := FileContentItem("chart.png", png)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if part.Type != "input_image" || !strings.HasPrefix(part.ImageURL, "data:image/png;base64,") {
+			t.Errorf("Expected image data URL, got %+v", part)
+		}
+	})
+
+	t.Run("Unsupported file", func(t *testing.T) {
+		pdf := []byte("%PDF-1.7\n\x00\x01\x02")
+		if _, err := FileContentItem("report.pdf", pdf); err == nil {
+			t.Error("Expected error for PDF file")
+		}
+	})
+
+	t.Run("Data URL", func(t *testing.T) {
+		data, err := DecodeFileData("data:text/plain;base64,aGVsbG8=")
+		if err != nil || string(data) != "hello" {
+			t.Errorf("Expected 'hello', got %q, %v", data, err)
+		}
+		if _, err := DecodeFileData("data:text/plain,hello"); err == nil {
+			t.Error("Expected error for non-base64 data URL")
+		}
+	})
+}
+
+func TestCustomTools(t *testing.T) {
+	patchTool := models.Tool{
+		Type:        "custom",
+		Name:        "apply_patch",
+		Description: "Apply a patch",
+		Format:      &models.CustomToolFormat{Type: "grammar", Syntax: "lark", Definition: "start: patch"},
+	}
+
+	t.Run("Custom tool wrapped as function", func(t *testing.T) {
+		req := &models.ResponsesRequest{Model: "gpt-4", Tools: []models.Tool{patchTool}}
+
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if len(chatReq.Tools) != 1 || chatReq.Tools[0].Function.Name != "apply_patch" {
+			t.Fatalf("Expected apply_patch function tool, got %+v", chatReq.Tools)
+		}
+		props := chatReq.Tools[0].Function.Parameters["properties"].(map[string]interface{})
+		input := props["input"].(map[string]interface{})
+		if !strings.Contains(input["description"].(string), "start: patch") {
+			t.Errorf("Expected grammar in input description, got %v", input["description"])
+		}
+		if chatReq.ToolTypes["apply_patch"] != ToolTypeCustom {
+			t.Error("Expected apply_patch to be recorded as a custom tool")
+		}
+	})
+
+	t.Run("Custom tool call input wrapped on the next turn", func(t *testing.T) {
+		req := &models.ResponsesRequest{
+			Model: "gpt-4",
+			Input: []models.InputItem{
+				{Type: "custom_tool_call", CallID: "call_1", Name: "apply_patch", Input: "*** Begin Patch\n\"x\""},
+				{Type: "custom_tool_call_output", CallID: "call_1", Output: "Done"},
+			},
+		}
+
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if len(chatReq.Messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(chatReq.Messages))
+		}
+		tc := chatReq.Messages[0].ToolCalls[0]
+		if tc.Type != "function" || tc.Function.Arguments != `{"input":"*** Begin Patch\n\"x\""}` {
+			t.Errorf("Expected wrapped function call, got %+v", tc)
+		}
+		if chatReq.Messages[1].Role != "tool" || chatReq.Messages[1].ToolCallID != "call_1" {
+			t.Errorf("Expected tool message for call_1, got %+v", chatReq.Messages[1])
+		}
+	})
+
+	t.Run("Wrapped call unwrapped to custom_tool_call", func(t *testing.T) {
+		msg := models.ChatMessage{Role: "assistant"}
+		msg.ToolCalls = make([]models.ToolCall, 1)
+		msg.ToolCalls[0].ID = "call_1"
+		msg.ToolCalls[0].Type = "function"
+		msg.ToolCalls[0].Function.Name = "apply_patch"
+		msg.ToolCalls[0].Function.Arguments = `{"input": "*** Begin Patch"}`
+
+		UnwrapToolCalls(&msg, map[string]string{"apply_patch": ToolTypeCustom})
+		output := BuildOutputItems(&msg, "abc")
+
+		if output[0].Type != "custom_tool_call" || output[0].Input != "*** Begin Patch" || output[0].CallID != "call_1" {
+			t.Errorf("Expected custom_tool_call with raw input, got %+v", output[0])
+		}
+
+		// The stored message is wrapped again when sent upstream
+		chatReq, _ := ConvertRequest(&models.ResponsesRequest{Model: "gpt-4"}, nil, []models.ChatMessage{msg}, false)
+		if got := chatReq.Messages[0].ToolCalls[0].Function.Arguments; got != `{"input":"*** Begin Patch"}` {
+			t.Errorf("Expected wrapped arguments upstream, got %s", got)
+		}
+		if msg.ToolCalls[0].Type != "custom" {
+			t.Error("Expected history message to be left unchanged")
+		}
+	})
+
+	t.Run("Streamed input decoded across chunks", func(t *testing.T) {
+		want := "line \"one\"\n\ttab é 😀 end"
+		args := `{"input": "line \"one\"\n\ttab \u00e9 \ud83d\ude00 end"}`
+
+		// Split the arguments at every position to cover broken escapes
+		for size := 1; size <= 7; size++ {
+			var d customInputDecoder
+			var got string
+			for i := 0; i < len(args); i += size {
+				got += d.feed(string(args[i:min(i+size, len(args))]))
+			}
+			if got != want {
+				t.Errorf("Chunk size %d: expected %q, got %q", size, want, got)
+			}
+		}
+	})
+}
+
+func TestLocalShell(t *testing.T) {
+	t.Run("local_shell tool synthesized as function", func(t *testing.T) {
+		req := &models.ResponsesRequest{Model: "gpt-4", Tools: []models.Tool{{Type: "local_shell"}}}
+
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if len(chatReq.Tools) != 1 || chatReq.Tools[0].Function.Name != "local_shell" {
+			t.Fatalf("Expected local_shell function tool, got %+v", chatReq.Tools)
+		}
+		if chatReq.ToolTypes["local_shell"] != ToolTypeLocalShell {
+			t.Error("Expected local_shell to be recorded as an emulated tool")
+		}
+	})
+
+	t.Run("Call mapped to local_shell_call with exec action", func(t *testing.T) {
+		msg := models.ChatMessage{Role: "assistant"}
+		msg.ToolCalls = make([]models.ToolCall, 1)
+		msg.ToolCalls[0].ID = "call_1"
+		msg.ToolCalls[0].Type = "function"
+		msg.ToolCalls[0].Function.Name = "local_shell"
+		msg.ToolCalls[0].Function.Arguments = `{"command": ["ls", "-la"], "workdir": "/tmp", "timeout_ms": 5000}`
+
+		UnwrapToolCalls(&msg, map[string]string{"local_shell": ToolTypeLocalShell})
+		output := BuildOutputItems(&msg, "abc")
+
+		item := output[0]
+		if item.Type != "local_shell_call" || item.CallID != "call_1" || item.Action == nil {
+			t.Fatalf("Expected local_shell_call with action, got %+v", item)
+		}
+		if item.Action.Type != "exec" || strings.Join(item.Action.Command, " ") != "ls -la" || item.Action.WorkingDirectory != "/tmp" {
+			t.Errorf("Unexpected action %+v", item.Action)
+		}
+		if item.Action.TimeoutMs == nil || *item.Action.TimeoutMs != 5000 {
+			t.Errorf("Expected timeout 5000, got %v", item.Action.TimeoutMs)
+		}
+	})
+
+	t.Run("String command run by bash", func(t *testing.T) {
+		action := LocalShellAction(`{"command": "ls | wc -l"}`)
+		if strings.Join(action.Command, "|") != "bash|-lc|ls | wc -l" {
+			t.Errorf("Expected bash -lc wrapper, got %v", action.Command)
+		}
+	})
+
+	t.Run("Call and output sent back as function call and tool message", func(t *testing.T) {
+		req := &models.ResponsesRequest{
+			Model: "gpt-4",
+			Input: []models.InputItem{
+				{Type: "local_shell_call", CallID: "call_1", Action: &models.LocalShellAction{Type: "exec", Command: []string{"pwd"}}},
+				{Type: "local_shell_call_output", ID: "call_1", Output: "/home"},
+			},
+		}
+
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if len(chatReq.Messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(chatReq.Messages))
+		}
+		tc := chatReq.Messages[0].ToolCalls[0]
+		if tc.Type != "function" || tc.Function.Name != "local_shell" || tc.Function.Arguments != `{"command":["pwd"]}` {
+			t.Errorf("Expected local_shell function call, got %+v", tc)
+		}
+		if chatReq.Messages[1].ToolCallID != "call_1" || chatReq.Messages[1].Content != "/home" {
+			t.Errorf("Expected tool message for call_1, got %+v", chatReq.Messages[1])
+		}
+	})
+}
+
+func TestMCP(t *testing.T) {
+	t.Run("Function names", func(t *testing.T) {
+		if name := MCPFunctionName("deepwiki", "ask_question"); name != "deepwiki__ask_question" {
+			t.Errorf("Expected deepwiki__ask_question, got %s", name)
+		}
+		if name := MCPFunctionName("my.server", "tools/run"); name != "my_server__tools_run" {
+			t.Errorf("Expected invalid characters replaced, got %s", name)
+		}
+		if name := MCPFunctionName(strings.Repeat("a", 60), "tool"); len(name) != 64 {
+			t.Errorf("Expected name cut to 64 characters, got %d", len(name))
+		}
+	})
+
+	t.Run("Allowed tools and approval policy", func(t *testing.T) {
+		var tool models.Tool
+		err := json.Unmarshal([]byte(`{
+			"type": "mcp",
+			"server_label": "wiki",
+			"allowed_tools": {"tool_names": ["ask", "read"]},
+			"require_approval": {"never": {"tool_names": ["read"]}}
+		}`), &tool)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !MCPToolAllowed(&tool, "ask") || MCPToolAllowed(&tool, "delete") {
+			t.Error("Expected only allowed_tools to be allowed")
+		}
+		if !MCPRequiresApproval(&tool, "ask") {
+			t.Error("Expected approval by default")
+		}
+		if MCPRequiresApproval(&tool, "read") {
+			t.Error("Expected no approval for tools listed under never")
+		}
+
+		tool.RequireApproval = "never"
+		if MCPRequiresApproval(&tool, "ask") {
+			t.Error("Expected no approval with require_approval never")
+		}
+	})
+
+	t.Run("mcp_call item sent as call and result", func(t *testing.T) {
+		messages := ConvertInputItems([]models.InputItem{
+			{Type: "mcp_list_tools", ID: "mcpl-1", ServerLabel: "wiki"},
+			{Type: "mcp_call", ID: "mcp-1", ServerLabel: "wiki", Name: "ask", Arguments: `{"q":"go"}`, Error: "timeout"},
+		}, false)
+
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(messages))
+		}
+		tc := messages[0].ToolCalls[0]
+		if tc.ID != "mcp-1" || tc.Function.Name != "wiki__ask" || tc.Function.Arguments != `{"q":"go"}` {
+			t.Errorf("Unexpected tool call %+v", tc)
+		}
+		if messages[1].ToolCallID != "mcp-1" || messages[1].Content != "Error: timeout" {
+			t.Errorf("Expected error result for mcp-1, got %+v", messages[1])
+		}
+	})
+
+	t.Run("Pending approvals not sent upstream", func(t *testing.T) {
+		pending := models.ChatMessage{Role: "assistant"}
+		pending.ToolCalls = make([]models.ToolCall, 1)
+		pending.ToolCalls[0].ID = "call_1"
+		pending.ToolCalls[0].Type = ToolTypeMCPApproval
+		pending.ToolCalls[0].ServerLabel = "wiki"
+		pending.ToolCalls[0].Function.Name = "ask"
+		pending.ToolCalls[0].Function.Arguments = `{"q":"go"}`
+
+		output := BuildOutputItems(&pending, "abc")
+		if output[0].Type != "mcp_approval_request" || output[0].ID != "mcpr-abc-0" || output[0].ServerLabel != "wiki" || output[0].Name != "ask" {
+			t.Errorf("Expected mcp_approval_request item, got %+v", output[0])
+		}
+
+		history := []models.ChatMessage{{Role: "user", Content: "hi"}, pending}
+		req := &models.ResponsesRequest{Model: "gpt-4", Input: []models.InputItem{{Role: "user", Content: []models.ContentItem{{Type: "input_text", Text: "next"}}}}}
+		chatReq, _ := ConvertRequest(req, nil, history, false)
+		if len(chatReq.Messages) != 2 || chatReq.Messages[1].Content != "next" {
+			t.Errorf("Expected the pending approval to be dropped, got %+v", chatReq.Messages)
+		}
+		if history[1].ToolCalls[0].Type != ToolTypeMCPApproval {
+			t.Error("Expected stored history to be left unchanged")
+		}
+	})
+
+	t.Run("tool_choice for an MCP tool", func(t *testing.T) {
+		choice, err := ParseToolChoice(map[string]interface{}{"type": "mcp", "server_label": "wiki", "name": "ask"})
+		if err != nil || choice.Mode != "function" || choice.Name != "wiki__ask" {
+			t.Errorf("Expected function wiki__ask, got %+v, %v", choice, err)
+		}
+	})
+}
+
+func TestFileSearch(t *testing.T) {
+	t.Run("Tool replaced by a function tool", func(t *testing.T) {
+		req := &models.ResponsesRequest{
+			Model: "gpt-4",
+			Input: []models.InputItem{{Role: "user", Content: []models.ContentItem{{Type: "input_text", Text: "find it"}}}},
+			Tools: []models.Tool{{Type: "file_search", VectorStoreIDs: []string{"vs_1"}}},
+		}
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if len(chatReq.Tools) != 1 || chatReq.Tools[0].Function.Name != "file_search" {
+			t.Errorf("Expected the file_search function tool, got %+v", chatReq.Tools)
+		}
+
+		choice, err := ParseToolChoice(map[string]interface{}{"type": "file_search"})
+		if err != nil || choice.Mode != "function" || choice.Name != "file_search" {
+			t.Errorf("Expected function file_search, got %+v, %v", choice, err)
+		}
+	})
+
+	t.Run("Tool validation", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			tool  models.Tool
+			valid bool
+		}{
+			{"valid", models.Tool{Type: "file_search", VectorStoreIDs: []string{"vs_1"}, MaxNumResults: 5}, true},
+			{"no vector stores", models.Tool{Type: "file_search"}, false},
+			{"too many results", models.Tool{Type: "file_search", VectorStoreIDs: []string{"vs_1"}, MaxNumResults: 51}, false},
+			{"bad threshold", models.Tool{Type: "file_search", VectorStoreIDs: []string{"vs_1"}, RankingOptions: &models.FileSearchRanking{ScoreThreshold: 1.5}}, false},
+		}
+		for _, tt := range tests {
+			if err := ValidateFileSearchTool(&tt.tool); (err == nil) != tt.valid {
+				t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+			}
+		}
+	})
+
+	t.Run("file_search_call item sent as call and result", func(t *testing.T) {
+		messages := ConvertInputItems([]models.InputItem{{
+			Type:    "file_search_call",
+			ID:      "fs-1",
+			Status:  "completed",
+			Queries: []string{"retry policy"},
+			Results: []models.FileSearchResult{{FileID: "file-1", Filename: "notes.md", Score: 0.9, Text: "Retry three times."}},
+		}}, false)
+
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(messages))
+		}
+		tc := messages[0].ToolCalls[0]
+		if tc.ID != "fs-1" || tc.Function.Name != "file_search" || tc.Function.Arguments != `{"query":"retry policy"}` {
+			t.Errorf("Unexpected tool call %+v", tc)
+		}
+		content, _ := messages[1].Content.(string)
+		if messages[1].ToolCallID != "fs-1" || !strings.Contains(content, "notes.md") || !strings.Contains(content, "Retry three times.") {
+			t.Errorf("Expected the results for fs-1, got %+v", messages[1])
+		}
+	})
+
+	t.Run("Citations", func(t *testing.T) {
+		results := []models.FileSearchResult{
+			{FileID: "file-1", Filename: "notes.md"},
+			{FileID: "file-1", Filename: "notes.md"},
+			{FileID: "file-2", Filename: "guide.txt"},
+		}
+
+		citations := FileCitations("Per notes.md, retry.", results)
+		if len(citations) != 1 || citations[0].FileID != "file-1" || citations[0].Index != 12 {
+			t.Errorf("Expected one citation after notes.md, got %+v", citations)
+		}
+
+		citations = FileCitations("Retry three times.", results)
+		if len(citations) != 2 || citations[0].Index != 18 || citations[1].FileID != "file-2" {
+			t.Errorf("Expected every file cited at the end, got %+v", citations)
+		}
+
+		if FileCitations("", results) != nil {
+			t.Error("Expected no citations for empty text")
+		}
+	})
+}
+
+func TestCodeInterpreter(t *testing.T) {
+	t.Run("Tool replaced by a function tool", func(t *testing.T) {
+		req := &models.ResponsesRequest{
+			Model: "gpt-4",
+			Input: []models.InputItem{{Role: "user", Content: []models.ContentItem{{Type: "input_text", Text: "plot it"}}}},
+			Tools: []models.Tool{{Type: "code_interpreter", Container: "auto"}},
+		}
+		chatReq, _ := ConvertRequest(req, nil, nil, false)
+		if len(chatReq.Tools) != 1 || chatReq.Tools[0].Function.Name != "code_interpreter" {
+			t.Errorf("Expected the code_interpreter function tool, got %+v", chatReq.Tools)
+		}
+
+		choice, err := ParseToolChoice(map[string]interface{}{"type": "code_interpreter"})
+		if err != nil || choice.Mode != "function" || choice.Name != "code_interpreter" {
+			t.Errorf("Expected function code_interpreter, got %+v, %v", choice, err)
+		}
+	})
+
+	t.Run("Container", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			container interface{}
+			id        string
+			fileIDs   []string
+			valid     bool
+		}{
+			{"none", nil, "", nil, true},
+			{"auto", "auto", "", nil, true},
+			{"container ID", "cntr_abc", "cntr_abc", nil, true},
+			{"auto with files", map[string]interface{}{"type": "auto", "file_ids": []interface{}{"file-1"}}, "", []string{"file-1"}, true},
+			{"unknown string", "mine", "", nil, false},
+			{"unknown type", map[string]interface{}{"type": "shared"}, "", nil, false},
+			{"bad file ID", map[string]interface{}{"type": "auto", "file_ids": []interface{}{1}}, "", nil, false},
+		}
+		for _, tt := range tests {
+			id, fileIDs, err := CodeInterpreterContainer(&models.Tool{Type: "code_interpreter", Container: tt.container})
+			if (err == nil) != tt.valid {
+				t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+				continue
+			}
+			if id != tt.id || len(fileIDs) != len(tt.fileIDs) || (len(fileIDs) > 0 && fileIDs[0] != tt.fileIDs[0]) {
+				t.Errorf("%s: expected %q %v, got %q %v", tt.name, tt.id, tt.fileIDs, id, fileIDs)
+			}
+		}
+	})
+
+	t.Run("code_interpreter_call item sent as call and result", func(t *testing.T) {
+		messages := ConvertInputItems([]models.InputItem{{
+			Type:        "code_interpreter_call",
+			ID:          "ci-1",
+			Status:      "completed",
+			Code:        "print(6*7)",
+			ContainerID: "cntr_1",
+			Outputs: []models.CodeInterpreterOutput{
+				{Type: "logs", Logs: "42\n"},
+				{Type: "file", FileID: "file-1", Filename: "plot.png"},
+			},
+		}}, false)
+
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(messages))
+		}
+		tc := messages[0].ToolCalls[0]
+		if tc.ID != "ci-1" || tc.Function.Name != "code_interpreter" || tc.Function.Arguments != `{"code":"print(6*7)"}` {
+			t.Errorf("Unexpected tool call %+v", tc)
+		}
+		content, _ := messages[1].Content.(string)
+		if messages[1].ToolCallID != "ci-1" || !strings.Contains(content, "42") || !strings.Contains(content, "plot.png (file-1)") {
+			t.Errorf("Expected the outputs for ci-1, got %+v", messages[1])
+		}
+	})
+
+	t.Run("Runs without output", func(t *testing.T) {
+		if got := FormatCodeInterpreterOutputs(nil); got != "The code ran without output." {
+			t.Errorf("Unexpected text %q", got)
+		}
+	})
+}
+
+func TestWriteResponseStream(t *testing.T) {
+	response := &models.ResponsesResponse{
+		ID:     "resp-1",
+		Object: "response",
+		Model:  "gpt-4",
+		Status: "completed",
+		Output: []models.OutputItem{
+			BuildReasoningItem("rs-1", "thinking"),
+			{Type: "message", ID: "msg-1", Role: "assistant", Status: "completed",
+				Content: []models.ContentItem{{Type: "output_text", Text: "Hello"}}},
+			{Type: "function_call", ID: "fc-1", CallID: "call_1", Name: "f", Arguments: `{"a":1}`, Status: "completed"},
+		},
+	}
+	rec := httptest.NewRecorder()
+	WriteResponseStream(rec, response, zap.NewNop())
+
+	var types []string
+	var completed map[string]interface{}
+	for i, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("Invalid event on line %d: %v", i, err)
+		}
+		if seq, _ := event["sequence_number"].(float64); int(seq) != len(types) {
+			t.Errorf("Event %s: expected sequence_number %d, got %v", event["type"], len(types), event["sequence_number"])
+		}
+		types = append(types, event["type"].(string))
+		if event["type"] == "response.completed" {
+			completed = event["response"].(map[string]interface{})
+		}
+		if event["type"] == "response.output_text.delta" && event["output_index"] != float64(1) {
+			t.Errorf("Expected text at output_index 1, got %v", event["output_index"])
+		}
+	}
+
+	expected := []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.reasoning_summary_part.added",
+		"response.reasoning_summary_text.delta", "response.reasoning_summary_text.done",
+		"response.reasoning_summary_part.done", "response.output_item.done",
+		"response.output_item.added", "response.content_part.added",
+		"response.output_text.delta", "response.output_text.done",
+		"response.content_part.done", "response.output_item.done",
+		"response.output_item.added", "response.function_call_arguments.delta",
+		"response.function_call_arguments.done", "response.output_item.done",
+		"response.completed",
+	}
+	if strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected event sequence:\n%s", strings.Join(types, "\n"))
+	}
+	if completed == nil || completed["status"] != "completed" || len(completed["output"].([]interface{})) != 3 {
+		t.Errorf("Expected the complete response in response.completed, got %v", completed)
+	}
+}
+
+func TestStreamTurn(t *testing.T) {
+	// chunk is an SSE line of a Chat Completions chunk with the given delta
+	chunk := func(delta string) string {
+		return `data: {"model":"m","choices":[{"index":0,"delta":` + delta + `}]}`
+	}
+	// call is a chunk of the tool call at index
+	call := func(index int, fields string) string {
+		return chunk(fmt.Sprintf(`{"tool_calls":[{"index":%d,%s}]}`, index, fields))
+	}
+
+	tests := []struct {
+		name   string
+		opts   StreamOptions
+		chunks []string
+		// events lists type[output_index] and the delta, if any, of each
+		// event, without the response. prefix
+		events []string
+		check  func(t *testing.T, result *StreamResult, output []models.OutputItem)
+	}{
+		{
+			name: "Text then several tool calls",
+			chunks: []string{
+				chunk(`{"content":"Hi"}`),
+				chunk(`{"content":" there"}`),
+				call(0, `"id":"call_1","function":{"name":"f","arguments":"{\"a\":"}`),
+				call(0, `"function":{"arguments":"1}"}`),
+				call(1, `"id":"call_2","function":{"name":"g","arguments":"{}"}`),
+				`data: {"model":"m","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":3,"completion_tokens":4,"total_tokens":7}}`,
+				"data: [DONE]",
+			},
+			events: []string{
+				"output_item.added[0]", "content_part.added[0]",
+				"output_text.delta[0] Hi", "output_text.delta[0]  there",
+				"output_item.added[1]",
+				`function_call_arguments.delta[1] {"a":`, "function_call_arguments.delta[1] 1}",
+				"output_item.added[2]", "function_call_arguments.delta[2] {}",
+				"output_text.done[0]", "content_part.done[0]", "output_item.done[0]",
+				"function_call_arguments.done[1]", "output_item.done[1]",
+				"function_call_arguments.done[2]", "output_item.done[2]",
+			},
+			check: func(t *testing.T, result *StreamResult, output []models.OutputItem) {
+				if result.OutputText != "Hi there" || len(result.ToolCalls) != 2 || len(result.Calls) != 2 {
+					t.Errorf("Unexpected result %+v", result)
+				}
+				if output[1].CallID != "call_1" || output[1].Arguments != `{"a":1}` || output[2].Name != "g" {
+					t.Errorf("Unexpected tool calls %+v", output[1:])
+				}
+				if result.Usage == nil || result.Usage.TotalTokens != 7 {
+					t.Errorf("Expected the usage of the last chunk, got %+v", result.Usage)
+				}
+			},
+		},
+		{
+			name: "Reasoning, tool call, then text",
+			chunks: []string{
+				chunk(`{"reasoning_content":"think"}`),
+				call(0, `"id":"call_1","function":{"name":"f","arguments":"{}"}`),
+				chunk(`{"content":"ok"}`),
+				"data: [DONE]",
+			},
+			events: []string{
+				"output_item.added[0]", "reasoning_summary_part.added[0]", "reasoning_summary_text.delta[0] think",
+				"reasoning_summary_text.done[0]", "reasoning_summary_part.done[0]", "output_item.done[0]",
+				"output_item.added[1]", "function_call_arguments.delta[1] {}",
+				"output_item.added[2]", "content_part.added[2]", "output_text.delta[2] ok",
+				"function_call_arguments.done[1]", "output_item.done[1]",
+				"output_text.done[2]", "content_part.done[2]", "output_item.done[2]",
+			},
+			check: func(t *testing.T, result *StreamResult, output []models.OutputItem) {
+				if output[0].Type != "reasoning" || output[1].Type != "function_call" || output[2].Type != "message" {
+					t.Errorf("Unexpected output order %+v", output)
+				}
+				if result.ReasoningText != "think" {
+					t.Errorf("Expected reasoning text think, got %q", result.ReasoningText)
+				}
+			},
+		},
+		{
+			name: "Reasoning after the answer started is kept but not streamed",
+			chunks: []string{
+				chunk(`{"reasoning_content":"think"}`),
+				chunk(`{"content":"ok"}`),
+				chunk(`{"reasoning_content":" more"}`),
+				"data: [DONE]",
+			},
+			events: []string{
+				"output_item.added[0]", "reasoning_summary_part.added[0]", "reasoning_summary_text.delta[0] think",
+				"reasoning_summary_text.done[0]", "reasoning_summary_part.done[0]", "output_item.done[0]",
+				"output_item.added[1]", "content_part.added[1]", "output_text.delta[1] ok",
+				"output_text.done[1]", "content_part.done[1]", "output_item.done[1]",
+			},
+			check: func(t *testing.T, result *StreamResult, output []models.OutputItem) {
+				if result.ReasoningText != "think more" {
+					t.Errorf("Expected reasoning text think more, got %q", result.ReasoningText)
+				}
+			},
+		},
+		{
+			name: "SingleToolCall drops later calls",
+			opts: StreamOptions{SingleToolCall: true},
+			chunks: []string{
+				call(0, `"id":"call_1","function":{"name":"f","arguments":"{}"}`),
+				call(1, `"id":"call_2","function":{"name":"g","arguments":"{\"x\":"}`),
+				call(1, `"function":{"arguments":"1}"}`),
+				"data: [DONE]",
+			},
+			events: []string{
+				"output_item.added[0]", "function_call_arguments.delta[0] {}",
+				"function_call_arguments.done[0]", "output_item.done[0]",
+			},
+			check: func(t *testing.T, result *StreamResult, output []models.OutputItem) {
+				if len(result.Calls) != 1 || result.Calls[0].ID != "call_1" || len(output) != 1 {
+					t.Errorf("Expected only call_1, got %+v", result.Calls)
+				}
+			},
+		},
+		{
+			name: "Custom tool input decoded from arguments",
+			opts: StreamOptions{ToolTypes: map[string]string{"apply_patch": ToolTypeCustom}},
+			chunks: []string{
+				call(0, `"id":"call_1","function":{"name":"apply_patch","arguments":"{\"input\": \"a\\n"}`),
+				call(0, `"function":{"arguments":"b\"}"}`),
+				"data: [DONE]",
+			},
+			events: []string{
+				"output_item.added[0]",
+				"custom_tool_call_input.delta[0] a\n", "custom_tool_call_input.delta[0] b",
+				"custom_tool_call_input.done[0]", "output_item.done[0]",
+			},
+			check: func(t *testing.T, result *StreamResult, output []models.OutputItem) {
+				item := output[0]
+				if item.Type != "custom_tool_call" || item.Input != "a\nb" || item.Arguments != "" {
+					t.Errorf("Expected a custom_tool_call with the decoded input, got %+v", item)
+				}
+				if result.Calls[0].Function.Arguments != `{"input": "a\nb"}` {
+					t.Errorf("Expected the raw call to keep its arguments, got %s", result.Calls[0].Function.Arguments)
+				}
+			},
+		},
+		{
+			name: "local_shell action read from arguments",
+			opts: StreamOptions{ToolTypes: map[string]string{"local_shell": ToolTypeLocalShell}},
+			chunks: []string{
+				call(0, `"id":"call_1","function":{"name":"local_shell","arguments":"{\"command\":"}`),
+				call(0, `"function":{"arguments":"[\"ls\",\"-la\"]}"}`),
+				"data: [DONE]",
+			},
+			events: []string{"output_item.added[0]", "output_item.done[0]"},
+			check: func(t *testing.T, result *StreamResult, output []models.OutputItem) {
+				item := output[0]
+				if item.Type != "local_shell_call" || item.Action == nil || strings.Join(item.Action.Command, " ") != "ls -la" {
+					t.Errorf("Expected a local_shell_call running ls -la, got %+v", item)
+				}
+				if item.Name != "" || item.Arguments != "" {
+					t.Errorf("Expected no name or arguments, got %+v", item)
+				}
+			},
+		},
+		{
+			name: "HeldTools are returned, not streamed",
+			opts: StreamOptions{HeldTools: map[string]bool{"web_search": true}},
+			chunks: []string{
+				chunk(`{"content":"Searching"}`),
+				call(0, `"id":"call_1","function":{"name":"web_search","arguments":"{\"query\":\"x\"}"}`),
+				call(1, `"id":"call_2","function":{"name":"f","arguments":"{}"}`),
+			},
+			events: []string{
+				"output_item.added[0]", "content_part.added[0]", "output_text.delta[0] Searching",
+				"output_item.added[1]", "function_call_arguments.delta[1] {}",
+				"output_text.done[0]", "content_part.done[0]", "output_item.done[0]",
+				"function_call_arguments.done[1]", "output_item.done[1]",
+			},
+			check: func(t *testing.T, result *StreamResult, output []models.OutputItem) {
+				if len(result.HeldCalls) != 1 || result.HeldCalls[0].Function.Arguments != `{"query":"x"}` {
+					t.Errorf("Expected the held web_search call, got %+v", result.HeldCalls)
+				}
+				if len(result.ToolCalls) != 1 || result.ToolCalls[0].Name != "f" || len(result.Calls) != 2 {
+					t.Errorf("Expected f as the only streamed call, got %+v", result.ToolCalls)
+				}
+				if len(output) != 2 {
+					t.Errorf("Expected the held call not to be an output item, got %+v", output)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			stream := NewEventStream(rec, models.ResponsesResponse{ID: "resp-1"}, zap.NewNop())
+			body := strings.NewReader(strings.Join(tt.chunks, "\n\n") + "\n\n")
+
+			result, err := StreamTurn(stream, body, "1", tt.opts, zap.NewNop())
+			if err != nil {
+				t.Fatalf("StreamTurn failed: %v", err)
+			}
+
+			var events []string
+			for _, line := range strings.Split(rec.Body.String(), "\n") {
+				data, ok := strings.CutPrefix(line, "data: ")
+				if !ok {
+					continue
+				}
+				var e struct {
+					Type           string  `json:"type"`
+					SequenceNumber int     `json:"sequence_number"`
+					OutputIndex    int     `json:"output_index"`
+					Delta          *string `json:"delta"`
+				}
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					t.Fatalf("Invalid event %s: %v", data, err)
+				}
+				if e.SequenceNumber != len(events) {
+					t.Errorf("Event %s: expected sequence_number %d, got %d", e.Type, len(events), e.SequenceNumber)
+				}
+				desc := fmt.Sprintf("%s[%d]", strings.TrimPrefix(e.Type, "response."), e.OutputIndex)
+				if e.Delta != nil {
+					desc += " " + *e.Delta
+				}
+				events = append(events, desc)
+			}
+			if strings.Join(events, "\n") != strings.Join(tt.events, "\n") {
+				t.Errorf("Unexpected events:\n%s\nexpected:\n%s", strings.Join(events, "\n"), strings.Join(tt.events, "\n"))
+			}
+			for _, item := range stream.Response.Output {
+				if item.Status != "completed" {
+					t.Errorf("Expected item %s to be completed, got %s", item.ID, item.Status)
+				}
+			}
+			tt.check(t, result, stream.Response.Output)
+		})
+	}
+}
+
+func TestURLCitations(t *testing.T) {
+	sources := []models.SearchResult{
+		{Title: "Go", URL: "https://go.dev"},
+		{Title: "Blog", URL: "https://go.dev/blog"},
+	}
+
+	text := "Go 1.23 is out [1]. Iterators landed [1, 2], see a[1] and [3]. 新版本 [2]"
+	citations := URLCitations(text, sources)
+	if len(citations) != 4 {
+		t.Fatalf("Expected 4 citations, got %+v", citations)
+	}
+	first := citations[0]
+	if first.Type != "url_citation" || first.URL != "https://go.dev" || first.Title != "Go" ||
+		first.StartIndex != 15 || first.EndIndex != 18 {
+		t.Errorf("Unexpected first citation %+v", first)
+	}
+	if citations[1].URL != "https://go.dev" || citations[2].URL != "https://go.dev/blog" || citations[1].StartIndex != citations[2].StartIndex {
+		t.Errorf("Expected both sources of [1, 2] on the same range, got %+v", citations[1:3])
+	}
+	// Indices count characters, not bytes
+	last := citations[3]
+	if want := len([]rune(text)) - 3; last.StartIndex != want || last.EndIndex != want+3 {
+		t.Errorf("Expected the last citation at %d, got %+v", want, last)
+	}
+
+	data, _ := json.Marshal(first)
+	if string(data) != `{"type":"url_citation","start_index":15,"end_index":18,"url":"https://go.dev","title":"Go"}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	if got := URLCitations("No sources cited.", sources); len(got) != 0 {
+		t.Errorf("Expected no citations, got %+v", got)
+	}
+}
+
+func TestWebSearchCallItem(t *testing.T) {
+	data := `{"type":"web_search_call","id":"ws_1","status":"completed","action":{"type":"search","query":"go release","sources":[{"type":"url","url":"https://go.dev/blog"}]}}`
+	var item models.InputItem
+	if err := json.Unmarshal([]byte(data), &item); err != nil {
+		t.Fatalf("Failed to parse item: %v", err)
+	}
+	if item.SearchAction == nil || item.SearchAction.Query != "go release" || item.Action != nil {
+		t.Fatalf("Expected a search action, got %+v", item)
+	}
+
+	// Stored items keep the action
+	stored, _ := json.Marshal(item)
+	if string(stored) != data {
+		t.Errorf("Expected %s, got %s", data, stored)
+	}
+
+	messages := ConvertInputItems([]models.InputItem{item}, false)
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+	tc := messages[0].ToolCalls[0]
+	if tc.ID != "ws_1" || tc.Function.Name != "web_search" || tc.Function.Arguments != `{"query":"go release"}` {
+		t.Errorf("Unexpected tool call %+v", tc)
+	}
+	content, _ := messages[1].Content.(string)
+	if messages[1].ToolCallID != "ws_1" || !strings.Contains(content, "https://go.dev/blog") {
+		t.Errorf("Expected the sources of ws_1, got %+v", messages[1])
+	}
+
+	item.Status = "failed"
+	messages = ConvertInputItems([]models.InputItem{item}, false)
+	if content, _ := messages[1].Content.(string); content != "Search failed." {
+		t.Errorf("Expected a failed search, got %q", content)
+	}
 }
@@ -0,0 +1,54 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ExtraBodyMetadataKey is the request metadata key holding per-request
+// extra_body overrides, either as an object or as a JSON-encoded string
+// (OpenAI clients only allow string metadata values)
+const ExtraBodyMetadataKey = "extra_body"
+
+// MergeExtraBody combines the provider's configured extra_body with the
+// per-request override from metadata. Request values win; a null value
+// removes a provider default.
+func MergeExtraBody(providerExtra map[string]interface{}, metadata map[string]interface{}) (map[string]interface{}, error) {
+	override, err := extraBodyFromMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(providerExtra) == 0 && len(override) == 0 {
+		return nil, nil
+	}
+
+	merged := make(map[string]interface{}, len(providerExtra)+len(override))
+	for k, v := range providerExtra {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// extraBodyFromMetadata reads the extra_body override from request metadata
+func extraBodyFromMetadata(metadata map[string]interface{}) (map[string]interface{}, error) {
+	raw, ok := metadata[ExtraBodyMetadataKey]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case string:
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(v), &parsed); err != nil {
+			return nil, fmt.Errorf("metadata.%s must be a JSON object: %v", ExtraBodyMetadataKey, err)
+		}
+		return parsed, nil
+	default:
+		return nil, fmt.Errorf("metadata.%s must be a JSON object", ExtraBodyMetadataKey)
+	}
+}
@@ -0,0 +1,64 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// FileContentItem converts the content of an input_file part to a part Chat
+// Completions providers accept: images become input_image parts with a data
+// URL and text files become input_text parts. Other files are an error.
+func FileContentItem(filename string, data []byte) (models.ContentItem, error) {
+	mimeType := http.DetectContentType(data)
+
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return models.ContentItem{
+			Type:     "input_image",
+			ImageURL: fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)),
+		}, nil
+
+	case IsText(data):
+		name := filename
+		if name == "" {
+			name = "file"
+		}
+		return models.ContentItem{
+			Type: "input_text",
+			Text: fmt.Sprintf("<file name=%q>\n%s\n</file>", name, data),
+		}, nil
+
+	default:
+		return models.ContentItem{}, fmt.Errorf("file %q has unsupported type %s; only text and image files can be sent to chat completions providers", filename, mimeType)
+	}
+}
+
+// DecodeFileData decodes the file_data of an input_file part, which is
+// base64 either bare or as a data URL
+func DecodeFileData(fileData string) ([]byte, error) {
+	encoded := fileData
+	if strings.HasPrefix(encoded, "data:") {
+		comma := strings.IndexByte(encoded, ',')
+		if comma < 0 || !strings.HasSuffix(encoded[:comma], ";base64") {
+			return nil, fmt.Errorf("file_data must be base64 encoded")
+		}
+		encoded = encoded[comma+1:]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("file_data is not valid base64: %w", err)
+	}
+	return data, nil
+}
+
+// IsText reports whether data looks like a plain-text or source file
+func IsText(data []byte) bool {
+	return utf8.Valid(data) && !bytes.ContainsRune(data, 0)
+}
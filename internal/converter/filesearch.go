@@ -0,0 +1,134 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// FileSearchFunctionTool is the function tool that stands in for the
+// file_search built-in tool; the proxy runs its calls against local vector
+// stores
+var FileSearchFunctionTool = models.ChatTool{
+	Type: "function",
+	Function: models.FunctionDef{
+		Name:        "file_search",
+		Description: "Searches the files the user provided and returns the most relevant passages. When you use a passage, mention the name of its file.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "What to look for in the files",
+				},
+			},
+			"required": []string{"query"},
+		},
+	},
+}
+
+// maxFileSearchResults is the upper limit of max_num_results
+const maxFileSearchResults = 50
+
+// ValidateFileSearchTool checks the fields of a file_search tool
+func ValidateFileSearchTool(tool *models.Tool) error {
+	if len(tool.VectorStoreIDs) == 0 {
+		return fmt.Errorf("file_search tools require vector_store_ids")
+	}
+	if tool.MaxNumResults < 0 || tool.MaxNumResults > maxFileSearchResults {
+		return fmt.Errorf("max_num_results must be between 1 and %d", maxFileSearchResults)
+	}
+	if r := tool.RankingOptions; r != nil && (r.ScoreThreshold < 0 || r.ScoreThreshold > 1) {
+		return fmt.Errorf("ranking_options.score_threshold must be between 0 and 1")
+	}
+	return nil
+}
+
+// FormatFileSearchResults formats the results of a search for the model
+func FormatFileSearchResults(results []models.FileSearchResult) string {
+	if len(results) == 0 {
+		return "No matching passages found in the files."
+	}
+
+	var b strings.Builder
+	b.WriteString("Passages found in the files:\n")
+	for i, r := range results {
+		fmt.Fprintf(&b, "\n[%d] File: %s (%s), score %.2f\n%s\n", i+1, r.Filename, r.FileID, r.Score, r.Text)
+	}
+	return b.String()
+}
+
+// FileCitations returns file_citation annotations for the files of results
+// that text mentions by name, placed after the first mention. If text
+// mentions none of them, every file is cited at the end of the text.
+func FileCitations(text string, results []models.FileSearchResult) []models.Annotation {
+	if text == "" {
+		return nil
+	}
+
+	var files []models.FileSearchResult
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if !seen[r.FileID] {
+			seen[r.FileID] = true
+			files = append(files, r)
+		}
+	}
+
+	var citations []models.Annotation
+	for _, f := range files {
+		if f.Filename == "" {
+			continue
+		}
+		if i := strings.Index(text, f.Filename); i >= 0 {
+			citations = append(citations, models.Annotation{
+				Type:     "file_citation",
+				Index:    utf8.RuneCountInString(text[:i+len(f.Filename)]),
+				FileID:   f.FileID,
+				Filename: f.Filename,
+			})
+		}
+	}
+	if len(citations) == 0 {
+		end := utf8.RuneCountInString(text)
+		for _, f := range files {
+			citations = append(citations, models.Annotation{
+				Type:     "file_citation",
+				Index:    end,
+				FileID:   f.FileID,
+				Filename: f.Filename,
+			})
+		}
+	}
+
+	sort.SliceStable(citations, func(i, j int) bool {
+		return citations[i].Index < citations[j].Index
+	})
+	return citations
+}
+
+// convertFileSearchCallItem converts a file_search_call item to the function
+// call and the tool result the provider sees
+func convertFileSearchCallItem(item *models.InputItem) []models.ChatMessage {
+	args, _ := json.Marshal(map[string]string{"query": strings.Join(item.Queries, "\n")})
+	call := convertFunctionCallItem(&models.InputItem{
+		CallID:    item.ID,
+		Name:      FileSearchFunctionTool.Function.Name,
+		Arguments: string(args),
+	})
+
+	// Items sent back without their results read as searches without matches
+	output := FormatFileSearchResults(item.Results)
+	if item.Status == "failed" {
+		output = "File search failed."
+	}
+	result := convertFunctionCallOutputItem(&models.InputItem{
+		CallID: item.ID,
+		Output: output,
+	})
+	return []models.ChatMessage{*call, *result}
+}
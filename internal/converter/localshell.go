@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"encoding/json"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// LocalShellFunctionTool is the function tool that stands in for the
+// local_shell built-in tool
+var LocalShellFunctionTool = models.ChatTool{
+	Type: "function",
+	Function: models.FunctionDef{
+		Name:        "local_shell",
+		Description: "Runs a shell command on the user's machine and returns its output.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"command": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "string"},
+					"description": `The command and its arguments, e.g. ["bash", "-lc", "ls -la"]`,
+				},
+				"workdir": map[string]interface{}{
+					"type":        "string",
+					"description": "The working directory to run the command in",
+				},
+				"timeout_ms": map[string]interface{}{
+					"type":        "integer",
+					"description": "The timeout of the command in milliseconds",
+				},
+				"env": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "string"},
+					"description":          "Environment variables to set for the command",
+				},
+			},
+			"required": []string{"command"},
+		},
+	},
+}
+
+// localShellArgs are the arguments of the local_shell function
+type localShellArgs struct {
+	Command   interface{}       `json:"command"` // []string; a plain string is run by bash
+	Workdir   string            `json:"workdir,omitempty"`
+	TimeoutMs *int              `json:"timeout_ms,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// LocalShellAction reads the exec action of a local_shell_call from the
+// arguments of a local_shell function call
+func LocalShellAction(arguments string) *models.LocalShellAction {
+	action := &models.LocalShellAction{
+		Type:    "exec",
+		Command: []string{},
+		Env:     map[string]string{},
+	}
+
+	var args localShellArgs
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return action
+	}
+
+	switch v := args.Command.(type) {
+	case string:
+		// Models sometimes send the command line as one string
+		action.Command = []string{"bash", "-lc", v}
+	case []interface{}:
+		for _, part := range v {
+			if s, ok := part.(string); ok {
+				action.Command = append(action.Command, s)
+			}
+		}
+	}
+	action.WorkingDirectory = args.Workdir
+	action.TimeoutMs = args.TimeoutMs
+	if args.Env != nil {
+		action.Env = args.Env
+	}
+	return action
+}
+
+// LocalShellArguments converts the action of a local_shell_call back to the
+// arguments of a local_shell function call
+func LocalShellArguments(action *models.LocalShellAction) string {
+	if action == nil {
+		return "{}"
+	}
+	data, _ := json.Marshal(localShellArgs{
+		Command:   action.Command,
+		Workdir:   action.WorkingDirectory,
+		TimeoutMs: action.TimeoutMs,
+		Env:       action.Env,
+	})
+	return string(data)
+}
+
+// convertLocalShellCallItem converts a local shell call input item
+func convertLocalShellCallItem(item *models.InputItem) *models.ChatMessage {
+	msg := convertFunctionCallItem(item)
+	msg.ToolCalls[0].Type = ToolTypeLocalShell
+	msg.ToolCalls[0].Function.Name = LocalShellFunctionTool.Function.Name
+	msg.ToolCalls[0].Function.Arguments = LocalShellArguments(item.Action)
+	return msg
+}
+
+// convertLocalShellCallOutputItem converts a local shell call output input
+// item; older clients send the call ID as the item ID
+func convertLocalShellCallOutputItem(item *models.InputItem) *models.ChatMessage {
+	msg := convertFunctionCallOutputItem(item)
+	if msg.ToolCallID == "" {
+		msg.ToolCallID = item.ID
+	}
+	return msg
+}
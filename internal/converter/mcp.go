@@ -0,0 +1,134 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// ToolTypeMCPApproval marks MCP tool calls waiting for the client's approval.
+// They are kept in stored history to answer mcp_approval_response items but
+// never sent upstream; the approved call is sent as an mcp_call instead.
+const ToolTypeMCPApproval = "mcp_approval_request"
+
+// invalidFunctionNameChars matches characters providers reject in function names
+var invalidFunctionNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// maxFunctionNameLength is the function name limit of Chat Completions
+const maxFunctionNameLength = 64
+
+// MCPFunctionName returns the name of the function tool that stands in for
+// a tool of an MCP server. The server label keeps tools of different
+// servers apart.
+func MCPFunctionName(serverLabel, toolName string) string {
+	name := invalidFunctionNameChars.ReplaceAllString(serverLabel+"__"+toolName, "_")
+	if len(name) > maxFunctionNameLength {
+		name = name[:maxFunctionNameLength]
+	}
+	return name
+}
+
+// MCPFunctionTool converts a tool listed by an MCP server to a function tool
+func MCPFunctionTool(serverLabel string, tool models.MCPToolInfo) models.ChatTool {
+	params := tool.InputSchema
+	if params == nil {
+		params = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	return models.ChatTool{
+		Type: "function",
+		Function: models.FunctionDef{
+			Name:        MCPFunctionName(serverLabel, tool.Name),
+			Description: tool.Description,
+			Parameters:  params,
+		},
+	}
+}
+
+// MCPToolAllowed reports whether allowed_tools of an mcp tool lets the model
+// use the named tool. Without allowed_tools every tool is allowed.
+func MCPToolAllowed(tool *models.Tool, name string) bool {
+	names, ok := mcpToolNames(tool.AllowedTools)
+	if !ok {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MCPRequiresApproval reports whether calls of the named tool need the
+// client's approval. Like the Responses API, approval is required unless
+// require_approval is "never" or lists the tool under "never".
+func MCPRequiresApproval(tool *models.Tool, name string) bool {
+	switch v := tool.RequireApproval.(type) {
+	case string:
+		return v != "never"
+	case map[string]interface{}:
+		for _, policy := range []string{"never", "always"} {
+			names, _ := mcpToolNames(v[policy])
+			for _, n := range names {
+				if n == name {
+					return policy == "always"
+				}
+			}
+		}
+	}
+	return true
+}
+
+// ValidateMCPTool checks the fields of an mcp tool
+func ValidateMCPTool(tool *models.Tool) error {
+	if tool.ServerLabel == "" {
+		return fmt.Errorf("mcp tools require a server_label")
+	}
+	if tool.ServerURL == "" {
+		return fmt.Errorf("mcp tool %s requires a server_url", tool.ServerLabel)
+	}
+	if _, ok := tool.RequireApproval.(string); ok && tool.RequireApproval != "always" && tool.RequireApproval != "never" {
+		return fmt.Errorf("invalid require_approval %q for mcp tool %s: expected always or never", tool.RequireApproval, tool.ServerLabel)
+	}
+	return nil
+}
+
+// mcpToolNames reads a tool name filter, either a list of names or an
+// object with tool_names. Returns false if there is no filter.
+func mcpToolNames(filter interface{}) ([]string, bool) {
+	if m, ok := filter.(map[string]interface{}); ok {
+		filter = m["tool_names"]
+	}
+	list, ok := filter.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	names := make([]string, 0, len(list))
+	for _, v := range list {
+		if name, ok := v.(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// convertMCPCallItem converts an mcp_call item to the function call and the
+// tool result the provider sees
+func convertMCPCallItem(item *models.InputItem) []models.ChatMessage {
+	call := convertFunctionCallItem(&models.InputItem{
+		CallID:    item.ID,
+		Name:      MCPFunctionName(item.ServerLabel, item.Name),
+		Arguments: item.Arguments,
+	})
+
+	output := item.Output
+	if item.Error != "" {
+		output = fmt.Sprintf("Error: %s", item.Error)
+	}
+	result := convertFunctionCallOutputItem(&models.InputItem{
+		CallID: item.ID,
+		Output: output,
+	})
+	return []models.ChatMessage{*call, *result}
+}
@@ -0,0 +1,315 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// schemaValidator validates decoded JSON values against the subset of JSON
+// Schema used by Structured Outputs: type, enum, const, properties,
+// required, additionalProperties, items, anyOf/oneOf/allOf, $ref and the
+// usual length and range keywords
+type schemaValidator struct {
+	root map[string]interface{}
+}
+
+// validate checks value against schema; path is used in error messages
+func (v *schemaValidator) validate(value interface{}, schema map[string]interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, err := v.resolveRef(ref)
+		if err != nil {
+			return err
+		}
+		return v.validate(value, resolved, path)
+	}
+
+	if t, ok := schema["type"]; ok {
+		if err := checkType(value, t, path); err != nil {
+			return err
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value %s is not one of the allowed enum values", path, compactJSON(value))
+		}
+	}
+
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		return fmt.Errorf("%s: value must be %s", path, compactJSON(c))
+	}
+
+	if err := v.validateComposition(value, schema, path); err != nil {
+		return err
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		return v.validateObject(val, schema, path)
+	case []interface{}:
+		return v.validateArray(val, schema, path)
+	case string:
+		n := float64(len([]rune(val)))
+		if min, ok := schemaNumber(schema, "minLength"); ok && n < min {
+			return fmt.Errorf("%s: string shorter than minLength %v", path, min)
+		}
+		if max, ok := schemaNumber(schema, "maxLength"); ok && n > max {
+			return fmt.Errorf("%s: string longer than maxLength %v", path, max)
+		}
+	case float64:
+		if min, ok := schemaNumber(schema, "minimum"); ok && val < min {
+			return fmt.Errorf("%s: %v is less than minimum %v", path, val, min)
+		}
+		if max, ok := schemaNumber(schema, "maximum"); ok && val > max {
+			return fmt.Errorf("%s: %v is greater than maximum %v", path, val, max)
+		}
+		if min, ok := schemaNumber(schema, "exclusiveMinimum"); ok && val <= min {
+			return fmt.Errorf("%s: %v must be greater than %v", path, val, min)
+		}
+		if max, ok := schemaNumber(schema, "exclusiveMaximum"); ok && val >= max {
+			return fmt.Errorf("%s: %v must be less than %v", path, val, max)
+		}
+	}
+
+	return nil
+}
+
+// validateComposition handles anyOf, oneOf and allOf
+func (v *schemaValidator) validateComposition(value interface{}, schema map[string]interface{}, path string) error {
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range all {
+			if sub, ok := s.(map[string]interface{}); ok {
+				if err := v.validate(value, sub, path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, key := range []string{"anyOf", "oneOf"} {
+		options, ok := schema[key].([]interface{})
+		if !ok {
+			continue
+		}
+		matched := 0
+		var lastErr error
+		for _, s := range options {
+			sub, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := v.validate(value, sub, path); err != nil {
+				lastErr = err
+			} else {
+				matched++
+			}
+		}
+		if matched == 0 {
+			return fmt.Errorf("%s: value does not match any %s alternative (last error: %v)", path, key, lastErr)
+		}
+		if key == "oneOf" && matched > 1 {
+			return fmt.Errorf("%s: value matches more than one oneOf alternative", path)
+		}
+	}
+
+	return nil
+}
+
+// validateObject checks required, properties and additionalProperties
+func (v *schemaValidator) validateObject(obj map[string]interface{}, schema map[string]interface{}, path string) error {
+	for _, name := range stringList(schema["required"]) {
+		if _, exists := obj[name]; !exists {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	// Iterate in sorted order so error messages are deterministic
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := path + "." + k
+		if propSchema, ok := properties[k].(map[string]interface{}); ok {
+			if err := v.validate(obj[k], propSchema, childPath); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: additional property %q is not allowed", path, k)
+			}
+		case map[string]interface{}:
+			if err := v.validate(obj[k], additional, childPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateArray checks items and the array length keywords
+func (v *schemaValidator) validateArray(arr []interface{}, schema map[string]interface{}, path string) error {
+	n := float64(len(arr))
+	if min, ok := schemaNumber(schema, "minItems"); ok && n < min {
+		return fmt.Errorf("%s: array has fewer than minItems %v", path, min)
+	}
+	if max, ok := schemaNumber(schema, "maxItems"); ok && n > max {
+		return fmt.Errorf("%s: array has more than maxItems %v", path, max)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range arr {
+			if err := v.validate(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRef resolves a local JSON pointer such as "#/$defs/Item"
+func (v *schemaValidator) resolveRef(ref string) (map[string]interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q: only local references are supported", ref)
+	}
+
+	var node interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		node = m[part]
+	}
+
+	resolved, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return resolved, nil
+}
+
+// checkType checks the "type" keyword, which may be a string or a list
+func checkType(value interface{}, t interface{}, path string) error {
+	types := stringList(t)
+	if len(types) == 0 {
+		return nil
+	}
+
+	for _, typ := range types {
+		if matchesType(value, typ) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+}
+
+// matchesType reports whether a decoded JSON value has the given schema type
+func matchesType(value interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return true
+	}
+}
+
+// jsonTypeName returns the JSON type name of a decoded value
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// stringList reads a keyword that holds a string or a list of strings.
+// Schemas decoded from JSON use []interface{}; schemas built in Go may use []string.
+func stringList(x interface{}) []string {
+	switch v := x.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// schemaNumber reads a numeric schema keyword
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	switch n := schema[key].(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// compactJSON renders a value for error messages
+func compactJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
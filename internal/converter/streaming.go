@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -50,8 +52,374 @@ func truncateString(s string, maxLen int) string {
 
 // StreamResult contains the result of streaming response for storage
 type StreamResult struct {
-	OutputText string
-	ToolCalls  []models.OutputItem
+	OutputText    string
+	ReasoningText string
+	ToolCalls     []models.OutputItem
+	// Calls holds every tool call of the turn as the upstream sent it,
+	// including held ones; HeldCalls holds only the held ones
+	Calls     []models.ToolCall
+	HeldCalls []models.ToolCall
+	Usage     *models.UsageInfo
+}
+
+// StreamOptions tunes how a Chat Completions stream is converted
+type StreamOptions struct {
+	// Model and Conversation fill the response object of the lifecycle
+	// events; the model is replaced by the one the upstream reports
+	Model        string
+	Conversation *models.ConversationRef
+	// SingleToolCall drops every tool call after the first one
+	// (parallel_tool_calls: false on providers that ignore it)
+	SingleToolCall bool
+	// FinalItems is called once the upstream stream is complete; the items
+	// it returns are emitted after the model output, before response.completed
+	FinalItems func(result *StreamResult) []models.OutputItem
+	// ToolTypes maps the function tools that stand in for custom and
+	// local_shell tools to the tool type; their calls are streamed as the
+	// matching items
+	ToolTypes map[string]string
+	// HeldTools names the functions the proxy runs itself, e.g. web_search;
+	// their calls are returned instead of streamed
+	HeldTools map[string]bool
+	// Annotate returns the annotations of the streamed text, such as
+	// citations; they are added when the message is closed
+	Annotate func(text string) []models.Annotation
+}
+
+// EventStream writes the events of one streamed response. Events are
+// numbered in the order they are sent and output items are indexed in the
+// order they are added. Response holds the output so far and is sent in
+// full with response.completed.
+type EventStream struct {
+	writer   *SSEWriter
+	sequence int
+	Response models.ResponsesResponse
+}
+
+// NewEventStream sets the SSE headers and starts the stream of response,
+// whose output is reset to empty
+func NewEventStream(w http.ResponseWriter, response models.ResponsesResponse, logger *zap.Logger) *EventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+
+	response.Object = "response"
+	if response.CreatedAt == 0 {
+		response.CreatedAt = time.Now().Unix()
+	}
+	response.Output = []models.OutputItem{}
+	return &EventStream{writer: NewSSEWriter(w, logger), Response: response}
+}
+
+// event returns the common fields of an event of the given type
+func event(eventType string) models.StreamEvent {
+	return models.StreamEvent{Type: eventType}
+}
+
+// send numbers an event and writes it
+func (s *EventStream) send(e interface{ Sequence(int) string }) {
+	eventType := e.Sequence(s.sequence)
+	s.sequence++
+	data, _ := json.Marshal(e)
+	s.writer.WriteEvent(eventType, string(data))
+}
+
+// Start sends response.created and response.in_progress
+func (s *EventStream) Start() {
+	s.Response.Status = "in_progress"
+	s.send(&models.ResponseEvent{StreamEvent: event("response.created"), Response: s.Response})
+	s.send(&models.ResponseEvent{StreamEvent: event("response.in_progress"), Response: s.Response})
+}
+
+// Complete sends response.completed with the complete response
+func (s *EventStream) Complete() {
+	s.Response.Status = "completed"
+	s.send(&models.ResponseEvent{StreamEvent: event("response.completed"), Response: s.Response})
+}
+
+// Fail sends an error event followed by response.failed
+func (s *EventStream) Fail(err error) {
+	s.send(&models.ErrorEvent{StreamEvent: event("error"), Code: "server_error", Message: err.Error()})
+	s.Response.Status = "failed"
+	s.Response.Error = &models.ErrorDetail{Type: "server_error", Message: err.Error()}
+	s.send(&models.ResponseEvent{StreamEvent: event("response.failed"), Response: s.Response})
+}
+
+// AddItem sends response.output_item.added and returns the output index of
+// the item
+func (s *EventStream) AddItem(item models.OutputItem) int {
+	index := len(s.Response.Output)
+	s.Response.Output = append(s.Response.Output, item)
+	s.send(&models.OutputItemEvent{StreamEvent: event("response.output_item.added"), OutputIndex: index, Item: item})
+	return index
+}
+
+// FinishItem records the final form of an item and sends
+// response.output_item.done
+func (s *EventStream) FinishItem(index int, item models.OutputItem) {
+	s.Response.Output[index] = item
+	s.send(&models.OutputItemEvent{StreamEvent: event("response.output_item.done"), OutputIndex: index, Item: item})
+}
+
+// AddContentPart opens a content part of a message item and returns its
+// content index
+func (s *EventStream) AddContentPart(index int, part models.ContentItem) int {
+	item := &s.Response.Output[index]
+	contentIndex := len(item.Content)
+	item.Content = append(item.Content, part)
+	s.send(&models.ContentPartEvent{
+		StreamEvent:  event("response.content_part.added"),
+		ItemID:       item.ID,
+		OutputIndex:  index,
+		ContentIndex: contentIndex,
+		Part:         part,
+	})
+	return contentIndex
+}
+
+// TextDelta appends text to an output_text part
+func (s *EventStream) TextDelta(index, contentIndex int, delta string) {
+	item := &s.Response.Output[index]
+	item.Content[contentIndex].Text += delta
+	s.send(&models.OutputTextEvent{
+		StreamEvent:  event("response.output_text.delta"),
+		ItemID:       item.ID,
+		OutputIndex:  index,
+		ContentIndex: contentIndex,
+		Delta:        &delta,
+		Logprobs:     []interface{}{},
+	})
+}
+
+// FinishContentPart closes a content part. The annotations of an
+// output_text part are added before its text is settled.
+func (s *EventStream) FinishContentPart(index, contentIndex int, annotations []models.Annotation) {
+	item := &s.Response.Output[index]
+	part := &item.Content[contentIndex]
+	if part.Type == "output_text" {
+		for _, annotation := range annotations {
+			part.Annotations = append(part.Annotations, annotation)
+			s.send(&models.AnnotationAddedEvent{
+				StreamEvent:     event("response.output_text.annotation.added"),
+				ItemID:          item.ID,
+				OutputIndex:     index,
+				ContentIndex:    contentIndex,
+				AnnotationIndex: len(part.Annotations) - 1,
+				Annotation:      annotation,
+			})
+		}
+		text := part.Text
+		s.send(&models.OutputTextEvent{
+			StreamEvent:  event("response.output_text.done"),
+			ItemID:       item.ID,
+			OutputIndex:  index,
+			ContentIndex: contentIndex,
+			Text:         &text,
+			Logprobs:     []interface{}{},
+		})
+	}
+	s.send(&models.ContentPartEvent{
+		StreamEvent:  event("response.content_part.done"),
+		ItemID:       item.ID,
+		OutputIndex:  index,
+		ContentIndex: contentIndex,
+		Part:         *part,
+	})
+}
+
+// AddReasoning opens a reasoning item and its single summary part and
+// returns the output index of the item
+func (s *EventStream) AddReasoning(id string) int {
+	index := s.AddItem(models.OutputItem{
+		Type:    "reasoning",
+		ID:      id,
+		Summary: []models.ContentItem{},
+		Status:  "in_progress",
+	})
+	part := models.ContentItem{Type: "summary_text"}
+	s.Response.Output[index].Summary = []models.ContentItem{part}
+	s.send(&models.ReasoningSummaryPartEvent{
+		StreamEvent: event("response.reasoning_summary_part.added"),
+		ItemID:      id,
+		OutputIndex: index,
+		Part:        part,
+	})
+	return index
+}
+
+// ReasoningDelta appends text to the summary of a reasoning item
+func (s *EventStream) ReasoningDelta(index int, delta string) {
+	item := &s.Response.Output[index]
+	item.Summary[0].Text += delta
+	s.send(&models.ReasoningSummaryTextEvent{
+		StreamEvent: event("response.reasoning_summary_text.delta"),
+		ItemID:      item.ID,
+		OutputIndex: index,
+		Delta:       &delta,
+	})
+}
+
+// FinishReasoning closes the summary part and the reasoning item
+func (s *EventStream) FinishReasoning(index int) {
+	s.finishReasoningSummary(index)
+	item := s.Response.Output[index]
+	s.FinishItem(index, BuildReasoningItem(item.ID, item.Summary[0].Text))
+}
+
+// finishReasoningSummary settles the summary text of a reasoning item
+func (s *EventStream) finishReasoningSummary(index int) {
+	item := s.Response.Output[index]
+	text := item.Summary[0].Text
+	s.send(&models.ReasoningSummaryTextEvent{
+		StreamEvent: event("response.reasoning_summary_text.done"),
+		ItemID:      item.ID,
+		OutputIndex: index,
+		Text:        &text,
+	})
+	s.send(&models.ReasoningSummaryPartEvent{
+		StreamEvent: event("response.reasoning_summary_part.done"),
+		ItemID:      item.ID,
+		OutputIndex: index,
+		Part:        item.Summary[0],
+	})
+}
+
+// ItemDelta sends an event streaming part of a string field of an item,
+// e.g. response.function_call_arguments.delta
+func (s *EventStream) ItemDelta(eventType string, index int, delta string) {
+	if delta == "" {
+		return
+	}
+	s.send(&models.ItemDeltaEvent{
+		StreamEvent: event(eventType),
+		ItemID:      s.Response.Output[index].ID,
+		OutputIndex: index,
+		Delta:       &delta,
+	})
+}
+
+// ItemDone sends the event ending the stream of a string field of an item;
+// e carries the type and the final value
+func (s *EventStream) ItemDone(index int, e *models.ItemDeltaEvent) {
+	e.ItemID = s.Response.Output[index].ID
+	e.OutputIndex = index
+	s.send(e)
+}
+
+// ItemStatus sends a progress event of a built-in tool call, e.g.
+// response.file_search_call.searching
+func (s *EventStream) ItemStatus(eventType string, index int) {
+	s.send(&models.ItemStatusEvent{
+		StreamEvent: event(eventType),
+		ItemID:      s.Response.Output[index].ID,
+		OutputIndex: index,
+	})
+}
+
+// WriteItem sends a complete item with the events that would have streamed
+// it
+func (s *EventStream) WriteItem(item models.OutputItem) {
+	added := item
+	added.Status = "in_progress"
+
+	switch item.Type {
+	case "message":
+		added.Content = nil
+		index := s.AddItem(added)
+		for _, part := range item.Content {
+			opened := models.ContentItem{Type: part.Type}
+			if part.Type != "output_text" {
+				opened = part
+			}
+			contentIndex := s.AddContentPart(index, opened)
+			if part.Type == "output_text" && part.Text != "" {
+				s.TextDelta(index, contentIndex, part.Text)
+			}
+			s.FinishContentPart(index, contentIndex, part.Annotations)
+		}
+		s.FinishItem(index, item)
+
+	case "reasoning":
+		if item.EncryptedContent != "" || len(item.Summary) == 0 {
+			s.FinishItem(s.AddItem(item), item)
+			return
+		}
+		index := s.AddReasoning(item.ID)
+		if text := item.Summary[0].Text; text != "" {
+			s.ReasoningDelta(index, text)
+		}
+		s.finishReasoningSummary(index)
+		s.FinishItem(index, item)
+
+	case "function_call":
+		added.Arguments = ""
+		index := s.AddItem(added)
+		arguments := item.Arguments
+		s.ItemDelta("response.function_call_arguments.delta", index, arguments)
+		s.ItemDone(index, &models.ItemDeltaEvent{StreamEvent: event("response.function_call_arguments.done"), Arguments: &arguments})
+		s.FinishItem(index, item)
+
+	case "custom_tool_call":
+		added.Input = ""
+		index := s.AddItem(added)
+		input := item.Input
+		s.ItemDelta("response.custom_tool_call_input.delta", index, input)
+		s.ItemDone(index, &models.ItemDeltaEvent{StreamEvent: event("response.custom_tool_call_input.done"), Input: &input})
+		s.FinishItem(index, item)
+
+	case "mcp_call":
+		added.Arguments = ""
+		added.Output = ""
+		added.Error = ""
+		index := s.AddItem(added)
+		s.ItemStatus("response.mcp_call.in_progress", index)
+		arguments := item.Arguments
+		s.ItemDelta("response.mcp_call_arguments.delta", index, arguments)
+		s.ItemDone(index, &models.ItemDeltaEvent{StreamEvent: event("response.mcp_call_arguments.done"), Arguments: &arguments})
+		if item.Error != "" {
+			s.ItemStatus("response.mcp_call.failed", index)
+		} else {
+			s.ItemStatus("response.mcp_call.completed", index)
+		}
+		s.FinishItem(index, item)
+
+	case "mcp_list_tools":
+		added.Tools = nil
+		added.Error = ""
+		index := s.AddItem(added)
+		s.ItemStatus("response.mcp_list_tools.in_progress", index)
+		if item.Error != "" {
+			s.ItemStatus("response.mcp_list_tools.failed", index)
+		} else {
+			s.ItemStatus("response.mcp_list_tools.completed", index)
+		}
+		s.FinishItem(index, item)
+
+	case "web_search_call", "file_search_call":
+		added.Results = nil
+		index := s.AddItem(added)
+		s.ItemStatus("response."+item.Type+".in_progress", index)
+		s.ItemStatus("response."+item.Type+".searching", index)
+		s.ItemStatus("response."+item.Type+".completed", index)
+		s.FinishItem(index, item)
+
+	case "code_interpreter_call":
+		added.Code = ""
+		added.Outputs = nil
+		index := s.AddItem(added)
+		s.ItemStatus("response.code_interpreter_call.in_progress", index)
+		code := item.Code
+		s.ItemDelta("response.code_interpreter_call_code.delta", index, code)
+		s.ItemDone(index, &models.ItemDeltaEvent{StreamEvent: event("response.code_interpreter_call_code.done"), Code: &code})
+		s.ItemStatus("response.code_interpreter_call.interpreting", index)
+		s.ItemStatus("response.code_interpreter_call.completed", index)
+		s.FinishItem(index, item)
+
+	default:
+		// Items without incremental content, e.g. mcp_approval_request
+		s.FinishItem(s.AddItem(item), item)
+	}
 }
 
 // HandleStreamingResponse handles streaming response conversion
@@ -60,40 +428,174 @@ func HandleStreamingResponse(
 	resp *http.Response,
 	w http.ResponseWriter,
 	responseID string,
+	opts StreamOptions,
 	logger *zap.Logger,
 ) *StreamResult {
-	// Set SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+	// Note: Use "resp-" prefix to match storage format for multi-turn conversation support
+	stream := NewEventStream(w, models.ResponsesResponse{
+		ID:           fmt.Sprintf("resp-%s", responseID),
+		Model:        opts.Model,
+		Conversation: opts.Conversation,
+	}, logger)
+	stream.Start()
+
+	result, err := StreamTurn(stream, resp.Body, responseID, opts, logger)
+	if err != nil {
+		logger.Error("Error reading stream", zap.Error(err))
+		stream.Fail(err)
+		return result
+	}
 
-	writer := NewSSEWriter(w, logger)
+	if opts.FinalItems != nil {
+		for _, item := range opts.FinalItems(result) {
+			stream.WriteItem(item)
+		}
+	}
 
-	// Send response.created event
-	// Note: Use "resp-" prefix to match storage format for multi-turn conversation support
-	createdEvent := models.ResponseCreatedEvent{
-		Type: "response.created",
-		Response: models.ResponseSummary{
-			ID:     fmt.Sprintf("resp-%s", responseID),
-			Status: "in_progress",
-		},
+	// Include usage if available (required for Codex token display)
+	if result.Usage != nil {
+		stream.Response.Usage = *result.Usage
 	}
-	createdJSON, _ := json.Marshal(createdEvent)
-	writer.WriteEvent("response.created", string(createdJSON))
+	stream.Complete()
+
+	// Return collected result for storage
+	return result
+}
 
-	scanner := bufio.NewScanner(resp.Body)
+// StreamTurn streams one Chat Completions stream as output items of stream,
+// closing them when the upstream stream ends. Item IDs are derived from
+// itemID. Calls of the functions in opts.HeldTools are only collected, for
+// the caller to run. A broken stream is returned as an error with what was
+// read so far.
+func StreamTurn(
+	stream *EventStream,
+	body io.Reader,
+	itemID string,
+	opts StreamOptions,
+	logger *zap.Logger,
+) (*StreamResult, error) {
+	scanner := bufio.NewScanner(body)
 	// Increase buffer size for large chunks
 	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 
 	var (
 		outputText       string
-		currentToolID    int
-		toolCalls        = make(map[int]*models.OutputItem)
-		messageItemAdded bool // Track if we've sent the message item added event
+		toolCalls        []*models.OutputItem               // In the order the model emitted them
+		rawCalls         []*models.ToolCall                 // toolCalls as the upstream sent them
+		toolIndex        = make(map[*models.OutputItem]int) // Output index of each streamed tool call
+		held             = make(map[*models.OutputItem]bool)
+		toolByIndex      = make(map[int]*models.OutputItem)
+		toolByCallID     = make(map[string]*models.OutputItem)
+		customInputs     = make(map[*models.OutputItem]*customInputDecoder)
+		droppingToolCall bool              // Set while skipping the chunks of an extra tool call
 		lastUsage        *models.UsageInfo // Track usage from final chunk
+		reasoningText    string
+		reasoningState   = reasoningNone
+		reasoningIndex   = -1 // Output index of the reasoning item, once added
+		messageIndex     = -1 // Output index of the message item, once added
 	)
 
+	reasoningID := fmt.Sprintf("rs-%s", itemID)
+	messageID := fmt.Sprintf("msg-%s", itemID)
+
+	// finishReasoning closes the reasoning item once the model starts answering
+	finishReasoning := func() {
+		if reasoningState != reasoningStreaming {
+			return
+		}
+		reasoningState = reasoningDone
+		stream.FinishReasoning(reasoningIndex)
+	}
+
+	// openMessage adds the message item and its output_text part
+	openMessage := func() {
+		messageIndex = stream.AddItem(models.OutputItem{
+			Type:   "message",
+			ID:     messageID,
+			Role:   "assistant",
+			Status: "in_progress",
+		})
+		stream.AddContentPart(messageIndex, models.ContentItem{Type: "output_text"})
+	}
+
+	// finishMessage closes the output_text part and the message item
+	finishMessage := func() {
+		var annotations []models.Annotation
+		if opts.Annotate != nil {
+			annotations = opts.Annotate(outputText)
+		}
+		stream.FinishContentPart(messageIndex, 0, annotations)
+		stream.FinishItem(messageIndex, models.OutputItem{
+			Type:    "message",
+			ID:      messageID,
+			Role:    "assistant",
+			Content: []models.ContentItem{{Type: "output_text", Text: outputText, Annotations: annotations}},
+			Status:  "completed",
+		})
+	}
+
+	// finishToolCall settles the arguments of a tool call and closes its item
+	finishToolCall := func(tc *models.OutputItem) {
+		index := toolIndex[tc]
+		switch tc.Type {
+		case "custom_tool_call":
+			finishCustomToolCall(stream, tc, index)
+		case "local_shell_call":
+			tc.Action = LocalShellAction(tc.Arguments)
+			tc.Arguments = ""
+		default:
+			arguments := tc.Arguments
+			stream.ItemDone(index, &models.ItemDeltaEvent{
+				StreamEvent: event("response.function_call_arguments.done"),
+				Arguments:   &arguments,
+			})
+		}
+		tc.Status = "completed"
+		stream.FinishItem(index, *tc)
+	}
+
+	// collectResult gathers what was streamed so far
+	collectResult := func() *StreamResult {
+		result := &StreamResult{
+			OutputText:    outputText,
+			ReasoningText: reasoningText,
+			Usage:         lastUsage,
+		}
+		for i, tc := range toolCalls {
+			result.Calls = append(result.Calls, *rawCalls[i])
+			if held[tc] {
+				result.HeldCalls = append(result.HeldCalls, *rawCalls[i])
+			} else {
+				result.ToolCalls = append(result.ToolCalls, *tc)
+			}
+		}
+		return result
+	}
+
+	// finish closes the open items in output order
+	finish := func() {
+		finishReasoning()
+
+		// A reply without text or tool calls still carries an empty message
+		if messageIndex < 0 && len(toolCalls) == 0 {
+			openMessage()
+		}
+		messageDone := messageIndex < 0
+		for _, tc := range toolCalls {
+			if held[tc] {
+				continue
+			}
+			if !messageDone && messageIndex < toolIndex[tc] {
+				finishMessage()
+				messageDone = true
+			}
+			finishToolCall(tc)
+		}
+		if !messageDone {
+			finishMessage()
+		}
+	}
+
 	for scanner.Scan() {
 		line := scanner.Text()
 
@@ -109,48 +611,8 @@ func HandleStreamingResponse(
 		logger.Debug("Received SSE chunk", zap.String("data", truncateString(data, 500)))
 
 		if data == "[DONE]" {
-			// Send tool call items done events
-			for _, tc := range toolCalls {
-				itemDone := models.OutputItemDoneEvent{
-					Type: "response.output_item.done",
-					Item: *tc,
-				}
-				itemJSON, _ := json.Marshal(itemDone)
-				writer.WriteEvent("response.output_item.done", string(itemJSON))
-			}
-
-			// Send message output item done event
-			messageItem := models.OutputItem{
-				Type:    "message",
-				ID:      fmt.Sprintf("msg-%s", responseID),
-				Role:    "assistant",
-				Content: []models.ContentItem{{Type: "output_text", Text: outputText}},
-				Status:  "completed",
-			}
-			msgDone := models.OutputItemDoneEvent{
-				Type: "response.output_item.done",
-				Item: messageItem,
-			}
-			msgJSON, _ := json.Marshal(msgDone)
-			writer.WriteEvent("response.output_item.done", string(msgJSON))
-
-			// Send response.completed event with usage info
-			// Note: Use "resp-" prefix to match storage format for multi-turn conversation support
-			completedResponse := models.ResponsesResponse{
-				ID:     fmt.Sprintf("resp-%s", responseID),
-				Status: "completed",
-			}
-			// Include usage if available (required for Codex token display)
-			if lastUsage != nil {
-				completedResponse.Usage = *lastUsage
-			}
-			completedEvent := models.ResponseCompletedEvent{
-				Type:     "response.completed",
-				Response: completedResponse,
-			}
-			completedJSON, _ := json.Marshal(completedEvent)
-			writer.WriteEvent("response.completed", string(completedJSON))
-			break
+			finish()
+			return collectResult(), nil
 		}
 
 		var chunk models.ChatCompletionChunk
@@ -159,75 +621,125 @@ func HandleStreamingResponse(
 			continue
 		}
 
+		// Report the model the upstream actually used, as non-streaming responses do
+		if chunk.Model != "" {
+			stream.Response.Model = chunk.Model
+		}
+
 		if len(chunk.Choices) == 0 {
 			continue
 		}
 
 		delta := chunk.Choices[0].Delta
 
-		// Handle text content
-		if delta.Content != "" {
-			// Send message item added event on first text delta
-			if !messageItemAdded {
-				messageItemAdded = true
-				addedEvent := models.OutputItemAddedEvent{
-					Type: "response.output_item.added",
-					Item: models.OutputItem{
-						Type:   "message",
-						ID:     fmt.Sprintf("msg-%s", responseID),
-						Role:   "assistant",
-						Status: "in_progress",
-					},
-				}
-				addedJSON, _ := json.Marshal(addedEvent)
-				writer.WriteEvent("response.output_item.added", string(addedJSON))
+		// Handle reasoning content (DeepSeek-R1, GLM-Z1, Qwen thinking models)
+		if delta.ReasoningContent != "" {
+			switch reasoningState {
+			case reasoningNone:
+				reasoningState = reasoningStreaming
+				reasoningIndex = stream.AddReasoning(reasoningID)
+				fallthrough
+			case reasoningStreaming:
+				stream.ReasoningDelta(reasoningIndex, delta.ReasoningContent)
+			case reasoningDone:
+				// The reasoning item is already closed; keep the text for
+				// the stored history only
+				logger.Debug("Reasoning after the answer started is not streamed",
+					zap.Int("length", len(delta.ReasoningContent)))
 			}
+			reasoningText += delta.ReasoningContent
+		}
 
-			outputText += delta.Content
-			deltaEvent := models.OutputTextDeltaEvent{
-				Type:        "response.output_text.delta",
-				Delta:       delta.Content,
-				OutputIndex: 0,
+		if delta.Content != "" || len(delta.ToolCalls) > 0 {
+			finishReasoning()
+		}
+
+		// Handle text content; the message item is added on the first text delta
+		if delta.Content != "" {
+			if messageIndex < 0 {
+				openMessage()
 			}
-			deltaJSON, _ := json.Marshal(deltaEvent)
-			writer.WriteEvent("response.output_text.delta", string(deltaJSON))
+			outputText += delta.Content
+			stream.TextDelta(messageIndex, 0, delta.Content)
 		}
 
 		// Handle tool calls
 		for _, tc := range delta.ToolCalls {
-			// Get or create tool call item
-			var idx int
-			if tc.ID != "" && len(tc.ID) > 0 {
-				idx = hashToolCallID(tc.ID)
-			} else {
-				idx = currentToolID
+			// Chunks of one call share its index; providers that omit the
+			// index send the call ID on the first chunk and nothing after
+			var item *models.OutputItem
+			switch {
+			case tc.Index != nil:
+				item = toolByIndex[*tc.Index]
+			case tc.ID != "":
+				item = toolByCallID[tc.ID]
+			case droppingToolCall:
+				continue
+			case len(toolCalls) > 0:
+				item = toolCalls[len(toolCalls)-1]
 			}
-			item, exists := toolCalls[idx]
-			if !exists {
+
+			if item == nil {
+				if opts.SingleToolCall && len(toolCalls) > 0 {
+					logger.Debug("dropping extra tool call", zap.String("call_id", tc.ID))
+					droppingToolCall = true
+					continue
+				}
+				droppingToolCall = false
 				item = &models.OutputItem{
 					Type:   "function_call",
-					ID:     fmt.Sprintf("fc-%s-%d", responseID, currentToolID),
+					ID:     fmt.Sprintf("fc-%s-%d", itemID, len(toolCalls)),
 					CallID: tc.ID,
+					Name:   tc.Function.Name,
 					Status: "in_progress",
 				}
-				toolCalls[currentToolID] = item
-				currentToolID++
-
-				// Send output_item.added event
-				addedEvent := models.OutputItemAddedEvent{
-					Type: "response.output_item.added",
-					Item: *item,
+				switch opts.ToolTypes[item.Name] {
+				case ToolTypeCustom:
+					item.Type = "custom_tool_call"
+					item.ID = fmt.Sprintf("ctc-%s-%d", itemID, len(toolCalls))
+					customInputs[item] = &customInputDecoder{}
+				case ToolTypeLocalShell:
+					item.Type = "local_shell_call"
+					item.ID = fmt.Sprintf("lsc-%s-%d", itemID, len(toolCalls))
+					item.Name = ""
+				}
+				raw := &models.ToolCall{ID: tc.ID, Type: "function"}
+				raw.Function.Name = tc.Function.Name
+				toolCalls = append(toolCalls, item)
+				rawCalls = append(rawCalls, raw)
+				if tc.Index != nil {
+					toolByIndex[*tc.Index] = item
+				}
+				if tc.ID != "" {
+					toolByCallID[tc.ID] = item
+				}
+				if opts.HeldTools[item.Name] {
+					held[item] = true
+				} else {
+					toolIndex[item] = stream.AddItem(*item)
 				}
-				addedJSON, _ := json.Marshal(addedEvent)
-				writer.WriteEvent("response.output_item.added", string(addedJSON))
 			}
 
 			// Update tool call
+			raw := rawCalls[slices.Index(toolCalls, item)]
 			if tc.Function.Name != "" {
-				item.Name = tc.Function.Name
+				raw.Function.Name = tc.Function.Name
+				if item.Type != "local_shell_call" {
+					item.Name = tc.Function.Name
+				}
 			}
 			if tc.Function.Arguments != "" {
+				raw.Function.Arguments += tc.Function.Arguments
 				item.Arguments += tc.Function.Arguments
+				if held[item] {
+					continue
+				}
+				switch item.Type {
+				case "custom_tool_call":
+					writeCustomToolInputDelta(stream, item, toolIndex[item], customInputs[item].feed(tc.Function.Arguments))
+				case "function_call":
+					stream.ItemDelta("response.function_call_arguments.delta", toolIndex[item], tc.Function.Arguments)
+				}
 			}
 		}
 
@@ -239,97 +751,81 @@ func HandleStreamingResponse(
 
 		// Extract usage from final chunk (some providers include usage in the last chunk)
 		if chunk.Usage != nil {
-			lastUsage = &models.UsageInfo{
-				InputTokens:  chunk.Usage.PromptTokens,
-				OutputTokens: chunk.Usage.CompletionTokens,
-				TotalTokens:  chunk.Usage.TotalTokens,
-			}
-			// Always set InputTokensDetails with default if not provided
-			cachedTokens := 0
-			if chunk.Usage.PromptDetails != nil {
-				cachedTokens = chunk.Usage.PromptDetails.CachedTokens
-			}
-			lastUsage.InputTokensDetails = &models.InputTokensDetails{
-				CachedTokens: cachedTokens,
-			}
-			// Always set OutputTokensDetails with default if not provided
-			reasoningTokens := 0
-			if chunk.Usage.CompletionDetails != nil {
-				reasoningTokens = chunk.Usage.CompletionDetails.ReasoningTokens
-			}
-			lastUsage.OutputTokensDetails = &models.OutputTokensDetails{
-				ReasoningTokens: reasoningTokens,
-			}
+			usage := ConvertUsage(
+				chunk.Usage.PromptTokens,
+				chunk.Usage.CompletionTokens,
+				chunk.Usage.TotalTokens,
+				chunk.Usage.PromptDetails,
+				chunk.Usage.CompletionDetails,
+				reasoningText,
+			)
+			lastUsage = &usage
 			logger.Debug("Extracted usage from stream",
 				zap.Int("input_tokens", lastUsage.InputTokens),
 				zap.Int("output_tokens", lastUsage.OutputTokens),
-				zap.Int("total_tokens", lastUsage.TotalTokens))
+				zap.Int("total_tokens", lastUsage.TotalTokens),
+				zap.Int("reasoning_tokens", lastUsage.OutputTokensDetails.ReasoningTokens))
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		logger.Error("Error reading stream", zap.Error(err))
+		return collectResult(), fmt.Errorf("error reading upstream stream: %w", err)
 	}
 
-	// Return collected result for storage
-	result := &StreamResult{
-		OutputText: outputText,
-	}
-	for _, tc := range toolCalls {
-		result.ToolCalls = append(result.ToolCalls, *tc)
+	// Providers that close the stream without [DONE] still complete the turn
+	finish()
+	return collectResult(), nil
+}
+
+// WriteResponseStream replays an already complete response as an SSE stream.
+// It is used when the proxy had to buffer the upstream reply, e.g. to
+// validate or retry it, but the client asked for streaming.
+func WriteResponseStream(w http.ResponseWriter, response *models.ResponsesResponse, logger *zap.Logger) {
+	stream := NewEventStream(w, *response, logger)
+	stream.Start()
+	for _, item := range response.Output {
+		stream.WriteItem(item)
 	}
-	return result
+	stream.Response.Usage = response.Usage
+	stream.Complete()
 }
 
-// hashToolCallID creates a simple hash for tool call ID indexing
-func hashToolCallID(id string) int {
-	hash := 0
-	for _, c := range id {
-		hash = hash*31 + int(c)
+// writeCustomToolInputDelta streams newly decoded input of a custom tool call
+func writeCustomToolInputDelta(stream *EventStream, item *models.OutputItem, outputIndex int, delta string) {
+	if delta == "" {
+		return
 	}
-	if hash < 0 {
-		hash = -hash
+	item.Input += delta
+	stream.ItemDelta("response.custom_tool_call_input.delta", outputIndex, delta)
+}
+
+// finishCustomToolCall settles the input of a custom tool call from its
+// complete arguments, streaming whatever the incremental decoder missed
+func finishCustomToolCall(stream *EventStream, item *models.OutputItem, outputIndex int) {
+	input := CustomToolInput(item.Arguments)
+	if strings.HasPrefix(input, item.Input) {
+		writeCustomToolInputDelta(stream, item, outputIndex, input[len(item.Input):])
 	}
-	return hash
+	item.Input = input
+	item.Arguments = ""
+
+	stream.ItemDone(outputIndex, &models.ItemDeltaEvent{
+		StreamEvent: event("response.custom_tool_call_input.done"),
+		Input:       &input,
+	})
 }
 
-// HandleStreamingError handles streaming error response
+// Reasoning item lifecycle while streaming
+const (
+	reasoningNone = iota
+	reasoningStreaming
+	reasoningDone
+)
+
+// HandleStreamingError fails a stream that has not started yet
 func HandleStreamingError(w http.ResponseWriter, responseID string, err error, logger *zap.Logger) {
-	writer := NewSSEWriter(w, logger)
-
-	// Send error event
-	errorEvent := struct {
-		Type  string             `json:"type"`
-		Error models.ErrorDetail `json:"error"`
-	}{
-		Type: "error",
-		Error: models.ErrorDetail{
-			Type:    "internal_error",
-			Message: err.Error(),
-		},
-	}
-	errorJSON, _ := json.Marshal(errorEvent)
-	writer.WriteEvent("error", string(errorJSON))
-
-	// Send response.failed event
-	failedEvent := struct {
-		Type     string `json:"type"`
-		Response struct {
-			ID     string `json:"id"`
-			Status string `json:"status"`
-		} `json:"response"`
-	}{
-		Type: "response.failed",
-		Response: struct {
-			ID     string `json:"id"`
-			Status string `json:"status"`
-		}{
-			ID:     responseID,
-			Status: "failed",
-		},
-	}
-	failedJSON, _ := json.Marshal(failedEvent)
-	writer.WriteEvent("response.failed", string(failedJSON))
+	stream := NewEventStream(w, models.ResponsesResponse{ID: responseID}, logger)
+	stream.Fail(err)
 }
 
 // ReadResponseBody reads the response body with a limit
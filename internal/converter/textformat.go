@@ -0,0 +1,150 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// Structured output modes for TargetConfig.StructuredOutput
+const (
+	// StructuredOutputNative forwards text.format as response_format unchanged
+	StructuredOutputNative = "native"
+	// StructuredOutputJSONObject sends response_format json_object and enforces the schema locally
+	StructuredOutputJSONObject = "json_object"
+	// StructuredOutputEmulate sends no response_format and enforces everything locally
+	StructuredOutputEmulate = "emulate"
+)
+
+// ApplyTextFormat maps the Responses text.format onto the chat request
+// according to the provider's structured output mode.
+// Returns the format the proxy must validate locally, or nil when the
+// provider enforces it natively (or no format was requested).
+func ApplyTextFormat(chatReq *models.ChatCompletionRequest, text *models.TextConfig, mode string) *models.TextFormat {
+	if text == nil || text.Format == nil {
+		return nil
+	}
+	format := text.Format
+
+	switch format.Type {
+	case "json_object":
+		if mode == StructuredOutputEmulate {
+			injectFormatInstructions(chatReq, format)
+			return format
+		}
+		chatReq.ResponseFormat = &models.ResponseFormat{Type: "json_object"}
+		return nil
+
+	case "json_schema":
+		switch mode {
+		case StructuredOutputJSONObject:
+			chatReq.ResponseFormat = &models.ResponseFormat{Type: "json_object"}
+			injectFormatInstructions(chatReq, format)
+			return format
+		case StructuredOutputEmulate:
+			injectFormatInstructions(chatReq, format)
+			return format
+		default:
+			chatReq.ResponseFormat = &models.ResponseFormat{
+				Type: "json_schema",
+				JSONSchema: &models.JSONSchemaFormat{
+					Name:        format.Name,
+					Description: format.Description,
+					Schema:      format.Schema,
+					Strict:      format.Strict,
+				},
+			}
+			return nil
+		}
+
+	default:
+		// "text" or unknown: plain text output, nothing to do
+		return nil
+	}
+}
+
+// injectFormatInstructions tells the model about the required output format
+// through the system prompt
+func injectFormatInstructions(chatReq *models.ChatCompletionRequest, format *models.TextFormat) {
+	var b strings.Builder
+	b.WriteString("You must reply with a single valid JSON value and nothing else: no prose, no markdown code fences.")
+	if format.Type == "json_schema" && format.Schema != nil {
+		schemaJSON, _ := json.Marshal(format.Schema)
+		b.WriteString("\nThe JSON must conform to this JSON Schema")
+		if format.Name != "" {
+			fmt.Fprintf(&b, " (%s)", format.Name)
+		}
+		b.WriteString(":\n")
+		b.Write(schemaJSON)
+		if format.Description != "" {
+			b.WriteString("\nSchema description: ")
+			b.WriteString(format.Description)
+		}
+	}
+	appendSystemPrompt(chatReq, b.String())
+}
+
+// appendSystemPrompt appends text to the first system message, or prepends
+// a new system message if there is none.
+// The message slice is copied so callers can keep the original for history.
+func appendSystemPrompt(chatReq *models.ChatCompletionRequest, text string) {
+	for i, m := range chatReq.Messages {
+		if m.Role != "system" {
+			continue
+		}
+		if content, ok := m.Content.(string); ok {
+			messages := make([]models.ChatMessage, len(chatReq.Messages))
+			copy(messages, chatReq.Messages)
+			messages[i].Content = content + "\n\n" + text
+			chatReq.Messages = messages
+			return
+		}
+	}
+	chatReq.Messages = append([]models.ChatMessage{{
+		Role:    "system",
+		Content: text,
+	}}, chatReq.Messages...)
+}
+
+// ValidateStructuredOutput checks a reply against the requested format.
+// It tolerates surrounding markdown code fences and returns the bare JSON
+// text on success.
+func ValidateStructuredOutput(content string, format *models.TextFormat) (string, error) {
+	text := stripCodeFence(content)
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return "", fmt.Errorf("reply is not valid JSON: %v", err)
+	}
+
+	if format.Type == "json_object" {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return "", fmt.Errorf("reply must be a JSON object")
+		}
+		return text, nil
+	}
+
+	if format.Schema != nil {
+		v := &schemaValidator{root: format.Schema}
+		if err := v.validate(value, format.Schema, "$"); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// stripCodeFence removes a surrounding ```json ... ``` block if present
+func stripCodeFence(content string) string {
+	text := strings.TrimSpace(content)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if nl := strings.Index(text, "\n"); nl >= 0 {
+		text = text[nl+1:]
+	}
+	text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,163 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// Tool choice modes for TargetConfig.ToolChoice
+const (
+	// ToolChoiceNative forwards tool_choice and parallel_tool_calls unchanged
+	ToolChoiceNative = "native"
+	// ToolChoiceEmulate enforces tool_choice and parallel_tool_calls in the proxy
+	ToolChoiceEmulate = "emulate"
+)
+
+// ToolChoice is a normalized Responses tool_choice
+type ToolChoice struct {
+	Mode string // "auto", "none", "required" or "function"
+	Name string // Function name when Mode is "function"
+}
+
+// ToolChoiceEmulation lists what the proxy must enforce on replies when the
+// provider does not support tool_choice or parallel_tool_calls
+type ToolChoiceEmulation struct {
+	// RequireToolCall re-prompts the model when it answers without a tool call
+	RequireToolCall bool
+	// FunctionName is the function the model was forced to call, if any
+	FunctionName string
+	// SingleToolCall keeps only the first tool call of a reply
+	SingleToolCall bool
+}
+
+// ParseToolChoice normalizes the tool_choice forms of the Responses API:
+// "auto", "none", "required", {type: "function", name},
+// {type: "custom", name}, {type: "local_shell"}, {type: "web_search"},
+// {type: "file_search"}, {type: "code_interpreter"}, {type: "mcp", server_label, name}
+// and the nested Chat Completions form
+func ParseToolChoice(raw interface{}) (*ToolChoice, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		switch v {
+		case "auto", "none", "required":
+			return &ToolChoice{Mode: v}, nil
+		}
+		return nil, fmt.Errorf("invalid tool_choice %q: expected auto, none or required", v)
+	case map[string]interface{}:
+		typ, _ := v["type"].(string)
+		switch typ {
+		case "function":
+			name, _ := v["name"].(string)
+			if name == "" {
+				// Chat Completions form: {type: function, function: {name}}
+				if fn, ok := v["function"].(map[string]interface{}); ok {
+					name, _ = fn["name"].(string)
+				}
+			}
+			if name == "" {
+				return nil, fmt.Errorf("tool_choice of type function requires a name")
+			}
+			return &ToolChoice{Mode: "function", Name: name}, nil
+		case "custom":
+			// Custom tools are sent upstream as functions of the same name
+			name, _ := v["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("tool_choice of type custom requires a name")
+			}
+			return &ToolChoice{Mode: "function", Name: name}, nil
+		case "local_shell":
+			return &ToolChoice{Mode: "function", Name: LocalShellFunctionTool.Function.Name}, nil
+		case "web_search", "web_search_preview":
+			return &ToolChoice{Mode: "function", Name: WebSearchFunctionTool.Function.Name}, nil
+		case "file_search":
+			return &ToolChoice{Mode: "function", Name: FileSearchFunctionTool.Function.Name}, nil
+		case "code_interpreter":
+			return &ToolChoice{Mode: "function", Name: CodeInterpreterFunctionTool.Function.Name}, nil
+		case "mcp":
+			// Without a name any tool of the server may be called
+			label, _ := v["server_label"].(string)
+			name, _ := v["name"].(string)
+			if label == "" {
+				return nil, fmt.Errorf("tool_choice of type mcp requires a server_label")
+			}
+			if name == "" {
+				return &ToolChoice{Mode: "required"}, nil
+			}
+			return &ToolChoice{Mode: "function", Name: MCPFunctionName(label, name)}, nil
+		}
+		return nil, fmt.Errorf("unsupported tool_choice type %q", typ)
+	default:
+		return nil, fmt.Errorf("invalid tool_choice: expected string or object")
+	}
+}
+
+// ApplyToolChoice maps tool_choice and parallel_tool_calls onto the chat
+// request according to the provider's tool choice mode.
+// Returns what the proxy has to emulate, or nil if nothing.
+func ApplyToolChoice(chatReq *models.ChatCompletionRequest, req *models.ResponsesRequest, mode string) (*ToolChoiceEmulation, error) {
+	choice, err := ParseToolChoice(req.ToolChoice)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without tools there is nothing to choose from; most providers reject
+	// tool_choice and parallel_tool_calls in that case
+	if len(chatReq.Tools) == 0 {
+		return nil, nil
+	}
+
+	if mode != ToolChoiceEmulate {
+		if choice != nil {
+			if choice.Mode == "function" {
+				forced := models.ChatToolChoice{Type: "function"}
+				forced.Function.Name = choice.Name
+				chatReq.ToolChoice = forced
+			} else {
+				chatReq.ToolChoice = choice.Mode
+			}
+		}
+		chatReq.ParallelToolCalls = req.ParallelToolCalls
+		return nil, nil
+	}
+
+	emulation := &ToolChoiceEmulation{
+		SingleToolCall: req.ParallelToolCalls != nil && !*req.ParallelToolCalls,
+	}
+
+	if choice != nil {
+		switch choice.Mode {
+		case "none":
+			chatReq.Tools = nil
+		case "required":
+			emulation.RequireToolCall = true
+		case "function":
+			var forced []models.ChatTool
+			for _, t := range chatReq.Tools {
+				if t.Function.Name == choice.Name {
+					forced = append(forced, t)
+				}
+			}
+			if len(forced) == 0 {
+				return nil, fmt.Errorf("tool_choice references unknown function %q", choice.Name)
+			}
+			chatReq.Tools = forced
+			emulation.RequireToolCall = true
+			emulation.FunctionName = choice.Name
+		}
+	}
+
+	if !emulation.RequireToolCall && !emulation.SingleToolCall {
+		return nil, nil
+	}
+	return emulation, nil
+}
+
+// KeepFirstToolCall drops every tool call after the first one
+func KeepFirstToolCall(msg *models.ChatMessage) {
+	if len(msg.ToolCalls) > 1 {
+		msg.ToolCalls = msg.ToolCalls[:1]
+	}
+}
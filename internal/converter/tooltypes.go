@@ -0,0 +1,255 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// customToolInputField is the single string argument a custom tool is
+// wrapped in for providers that only know function tools
+const customToolInputField = "input"
+
+// customFunctionDef wraps a custom (free-form) tool such as Codex apply_patch
+// as a function taking its input as one string argument. A grammar is passed
+// on in the argument description; providers cannot enforce it.
+func customFunctionDef(tool *models.Tool) models.FunctionDef {
+	inputDesc := "The raw input of the tool"
+	if f := tool.Format; f != nil && f.Type == "grammar" && f.Definition != "" {
+		inputDesc = fmt.Sprintf("The raw input of the tool. It must match this %s grammar:\n%s", f.Syntax, f.Definition)
+	}
+
+	return models.FunctionDef{
+		Name:        tool.Name,
+		Description: tool.Description,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				customToolInputField: map[string]interface{}{
+					"type":        "string",
+					"description": inputDesc,
+				},
+			},
+			"required":             []string{customToolInputField},
+			"additionalProperties": false,
+		},
+	}
+}
+
+// Tool types the proxy emulates with function tools. Inside the proxy a
+// call of such a tool keeps the tool type as its ToolCall.Type; it is turned
+// back into a function call whenever messages are sent upstream.
+const (
+	ToolTypeCustom     = "custom"
+	ToolTypeLocalShell = "local_shell"
+)
+
+// UnwrapToolCalls restores the calls of emulated tools in an upstream reply.
+// toolTypes maps function names to the tool types they stand in for.
+func UnwrapToolCalls(msg *models.ChatMessage, toolTypes map[string]string) {
+	for i := range msg.ToolCalls {
+		tc := &msg.ToolCalls[i]
+		if tc.Type != "function" && tc.Type != "" {
+			continue
+		}
+		switch toolTypes[tc.Function.Name] {
+		case ToolTypeCustom:
+			tc.Type = ToolTypeCustom
+			tc.Function.Arguments = CustomToolInput(tc.Function.Arguments)
+		case ToolTypeLocalShell:
+			// The arguments already are the JSON the action is read from
+			tc.Type = ToolTypeLocalShell
+		}
+	}
+}
+
+// CustomToolInput extracts the raw input from the arguments of a wrapped
+// custom tool call. Arguments that are not the expected object are taken
+// as the input itself.
+func CustomToolInput(arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err == nil {
+		if input, ok := args[customToolInputField].(string); ok {
+			return input
+		}
+	}
+	var input string
+	if err := json.Unmarshal([]byte(arguments), &input); err == nil {
+		return input
+	}
+	return arguments
+}
+
+// wrapToolCalls converts calls of emulated tools back to the function calls
+// the provider made and drops MCP calls still waiting for approval, along
+// with assistant messages left empty. Tool call slices are copied, as they
+// may be shared with stored history.
+func wrapToolCalls(messages []models.ChatMessage) []models.ChatMessage {
+	result := messages[:0]
+	for _, msg := range messages {
+		var wrapped []models.ToolCall
+		for j, tc := range msg.ToolCalls {
+			switch tc.Type {
+			case ToolTypeMCPApproval:
+			case ToolTypeCustom:
+				args, _ := json.Marshal(map[string]string{customToolInputField: tc.Function.Arguments})
+				tc.Function.Arguments = string(args)
+				tc.Type = "function"
+			case ToolTypeLocalShell:
+				tc.Type = "function"
+			default:
+				if wrapped != nil {
+					wrapped = append(wrapped, tc)
+				}
+				continue
+			}
+			if wrapped == nil {
+				wrapped = append([]models.ToolCall{}, msg.ToolCalls[:j]...)
+			}
+			if tc.Type == "function" {
+				wrapped = append(wrapped, tc)
+			}
+		}
+		if wrapped != nil {
+			msg.ToolCalls = wrapped
+			if len(wrapped) == 0 && msg.Role == "assistant" && (msg.Content == nil || msg.Content == "") {
+				continue
+			}
+		}
+		result = append(result, msg)
+	}
+	return result
+}
+
+// convertCustomToolCallItem converts a custom tool call input item
+func convertCustomToolCallItem(item *models.InputItem) *models.ChatMessage {
+	msg := convertFunctionCallItem(item)
+	msg.ToolCalls[0].Type = ToolTypeCustom
+	msg.ToolCalls[0].Function.Arguments = item.Input
+	return msg
+}
+
+// customInputStart matches the arguments of a wrapped custom tool call up to
+// the opening quote of the input value
+var customInputStart = regexp.MustCompile(`^\s*\{\s*"` + customToolInputField + `"\s*:\s*"`)
+
+// customInputDecoder extracts the input of a wrapped custom tool call from
+// its streamed arguments, so the input can be streamed as it arrives
+type customInputDecoder struct {
+	pending string // Arguments not decoded yet
+	inValue bool   // Past the opening quote of the input value
+	done    bool   // Past the closing quote
+}
+
+// feed adds an arguments chunk and returns the newly decoded input
+func (d *customInputDecoder) feed(chunk string) string {
+	if d.done {
+		return ""
+	}
+	d.pending += chunk
+
+	if !d.inValue {
+		loc := customInputStart.FindStringIndex(d.pending)
+		if loc == nil {
+			return ""
+		}
+		d.pending = d.pending[loc[1]:]
+		d.inValue = true
+	}
+
+	var out strings.Builder
+	i := 0
+	for i < len(d.pending) {
+		c := d.pending[i]
+		if c == '"' {
+			d.done = true
+			i = len(d.pending)
+			break
+		}
+		if c != '\\' {
+			out.WriteByte(c)
+			i++
+			continue
+		}
+		r, n := decodeEscape(d.pending[i:])
+		if n == 0 {
+			break // Incomplete escape, wait for the next chunk
+		}
+		out.WriteRune(r)
+		i += n
+	}
+	d.pending = d.pending[i:]
+	return out.String()
+}
+
+// decodeEscape decodes the JSON escape sequence at the start of s.
+// Returns the rune and the length of the sequence, or 0 if s ends early.
+func decodeEscape(s string) (rune, int) {
+	if len(s) < 2 {
+		return 0, 0
+	}
+	switch s[1] {
+	case 'b':
+		return '\b', 2
+	case 'f':
+		return '\f', 2
+	case 'n':
+		return '\n', 2
+	case 'r':
+		return '\r', 2
+	case 't':
+		return '\t', 2
+	case 'u':
+	default:
+		return rune(s[1]), 2
+	}
+
+	if len(s) < 6 {
+		return 0, 0
+	}
+	code, err := strconv.ParseUint(s[2:6], 16, 16)
+	if err != nil {
+		return unicode.ReplacementChar, 6
+	}
+	r := rune(code)
+	if !utf16.IsSurrogate(r) {
+		return r, 6
+	}
+	if r >= 0xDC00 {
+		return unicode.ReplacementChar, 6 // Low surrogate without a high one
+	}
+
+	// A surrogate pair spans two escapes
+	if len(s) < 12 {
+		if strings.HasPrefix(`\u`, s[6:min(len(s), 8)]) {
+			return 0, 0
+		}
+		return unicode.ReplacementChar, 6
+	}
+	if s[6:8] != `\u` {
+		return unicode.ReplacementChar, 6
+	}
+	low, err := strconv.ParseUint(s[8:12], 16, 16)
+	if err != nil {
+		return unicode.ReplacementChar, 6
+	}
+	return utf16.DecodeRune(r, rune(low)), 12
+}
+
+// addEmulatedTool adds the function tool standing in for a tool of the given type
+func addEmulatedTool(chatReq *models.ChatCompletionRequest, toolType string, fn models.FunctionDef) {
+	chatReq.Tools = append(chatReq.Tools, models.ChatTool{
+		Type:     "function",
+		Function: fn,
+	})
+	if chatReq.ToolTypes == nil {
+		chatReq.ToolTypes = make(map[string]string)
+	}
+	chatReq.ToolTypes[fn.Name] = toolType
+}
@@ -0,0 +1,86 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// sourceCitationPattern matches source numbers cited in brackets, e.g. [1]
+// or [1, 3]
+var sourceCitationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// URLCitations returns url_citation annotations for the sources text cites
+// by number, sources[0] being [1]. Each annotation spans the bracketed
+// citation. Numbers without a source and brackets right after a word, such
+// as indexes in code, are ignored.
+func URLCitations(text string, sources []models.SearchResult) []models.Annotation {
+	if text == "" || len(sources) == 0 {
+		return nil
+	}
+
+	var citations []models.Annotation
+	for _, m := range sourceCitationPattern.FindAllStringSubmatchIndex(text, -1) {
+		if prev, _ := utf8.DecodeLastRuneInString(text[:m[0]]); m[0] > 0 && (unicode.IsLetter(prev) || unicode.IsDigit(prev) || prev == '_') {
+			continue
+		}
+		start := utf8.RuneCountInString(text[:m[0]])
+		end := start + utf8.RuneCountInString(text[m[0]:m[1]])
+		for _, n := range strings.Split(text[m[2]:m[3]], ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(n))
+			if err != nil || i < 1 || i > len(sources) || sources[i-1].URL == "" {
+				continue
+			}
+			citations = append(citations, models.Annotation{
+				Type:       "url_citation",
+				StartIndex: start,
+				EndIndex:   end,
+				URL:        sources[i-1].URL,
+				Title:      sources[i-1].Title,
+			})
+		}
+	}
+	return citations
+}
+
+// convertWebSearchCallItem converts a web_search_call item to the function
+// call and a compact tool result that lists the sources of the search; the
+// page contents are not kept in items
+func convertWebSearchCallItem(item *models.InputItem) []models.ChatMessage {
+	var query string
+	var sources []models.WebSearchSource
+	if item.SearchAction != nil {
+		query = item.SearchAction.Query
+		sources = item.SearchAction.Sources
+	}
+	args, _ := json.Marshal(map[string]string{"query": query})
+	call := convertFunctionCallItem(&models.InputItem{
+		CallID:    item.ID,
+		Name:      WebSearchFunctionTool.Function.Name,
+		Arguments: string(args),
+	})
+
+	output := "Search completed."
+	switch {
+	case item.Status == "failed":
+		output = "Search failed."
+	case len(sources) > 0:
+		var b strings.Builder
+		fmt.Fprintf(&b, "Search results for: %s\n\n", query)
+		for _, source := range sources {
+			fmt.Fprintf(&b, "- %s\n", source.URL)
+		}
+		output = b.String()
+	}
+	result := convertFunctionCallOutputItem(&models.InputItem{
+		CallID: item.ID,
+		Output: output,
+	})
+	return []models.ChatMessage{*call, *result}
+}
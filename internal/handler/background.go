@@ -0,0 +1,384 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/converter"
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/pkg/logger"
+)
+
+// responseJob is a converted request that is run to completion without
+// streaming, either inline or by a background worker
+type responseJob struct {
+	responseID       string // Without the "resp-" prefix
+	traceID          string
+	chatReq          *models.ChatCompletionRequest
+	hasWebSearch     bool
+	webSearchSources bool // include asks for the sources of web_search_call actions
+	plan             *emulationPlan
+	mcp              *mcpToolset             // Nil unless the request has mcp tools
+	fileSearch       *fileSearchRequest      // Nil unless the request has a file_search tool
+	codeInterpreter  *codeInterpreterRequest // Nil unless the request has a code_interpreter tool
+	turn             turnOptions
+	apiKey           string
+	targetCfg        *config.TargetConfig
+	log              *zap.Logger
+}
+
+// completedTurn is the conversation of a completed job, recorded with
+// recordTurn once the caller knows the response is to be kept
+type completedTurn struct {
+	messages []models.ChatMessage
+	opts     turnOptions
+}
+
+// completeResponse runs a job to completion and returns the converted
+// response and the turn to record; it stores nothing itself
+func (h *ProxyHandler) completeResponse(ctx context.Context, client *http.Client, job *responseJob) (*models.ResponsesResponse, *completedTurn, error) {
+	var (
+		chatResp        *models.ChatCompletionResponse
+		webSearchCalls  []WebSearchCall
+		fileSearchCalls []FileSearchCall
+		codeCalls       []CodeInterpreterCall
+		toolExchange    []models.ChatMessage
+		err             error
+	)
+
+	switch {
+	case job.mcp != nil:
+		chatResp, toolExchange, err = h.completeWithMCP(ctx, client, job)
+	case job.fileSearch != nil:
+		chatResp, fileSearchCalls, toolExchange, err = h.fileSearchHandler.HandleWithFileSearch(
+			ctx, client, job.chatReq, job.fileSearch, job.plan.singleToolCall(), job.apiKey, job.targetCfg, job.log)
+	case job.codeInterpreter != nil:
+		chatResp, codeCalls, toolExchange, err = h.codeInterpreterHandler.HandleWithCodeInterpreter(
+			ctx, client, job.chatReq, job.codeInterpreter, job.plan.singleToolCall(), job.apiKey, job.targetCfg, job.log)
+	case job.hasWebSearch && h.webSearchHandler != nil && h.webSearchHandler.HasWebSearchCapability():
+		chatResp, webSearchCalls, toolExchange, err = h.webSearchHandler.HandleWithWebSearch(ctx, job.chatReq, job.apiKey, job.targetCfg, job.log)
+	case job.plan.active():
+		chatResp, err = h.completeWithEmulation(ctx, client, job.chatReq, job.plan, job.apiKey, job.targetCfg, job.log)
+	default:
+		currentReq := *job.chatReq
+		currentReq.Stream = false
+		chatResp, err = sendChatCompletion(ctx, client, &currentReq, job.apiKey, job.targetCfg, job.log)
+		if err == nil && job.plan.singleToolCall() && len(chatResp.Choices) > 0 {
+			converter.KeepFirstToolCall(&chatResp.Choices[0].Message)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(chatResp.Choices) > 0 {
+		converter.UnwrapToolCalls(&chatResp.Choices[0].Message, job.chatReq.ToolTypes)
+	}
+
+	responsesResp := ConvertResponseWithWebSearch(chatResp, job.responseID, webSearchCalls, job.webSearchSources)
+	responsesResp.Conversation = conversationRef(job.turn.conversation)
+
+	// MCP tool listings and calls, file searches and code runs precede the
+	// reply. Stored file_search_call and code_interpreter_call items keep
+	// their results and outputs for later turns, web_search_call items their
+	// sources.
+	var toolItems, storedItems []models.OutputItem
+	switch {
+	case job.mcp != nil:
+		toolItems, storedItems = job.mcp.items, job.mcp.items
+	case job.fileSearch != nil:
+		annotateFileCitations(responsesResp, fileSearchCalls)
+		toolItems = BuildFileSearchOutputItems(fileSearchCalls, job.fileSearch.includeResults)
+		storedItems = BuildFileSearchOutputItems(fileSearchCalls, true)
+	case job.codeInterpreter != nil:
+		toolItems = BuildCodeInterpreterOutputItems(codeCalls, job.codeInterpreter.includeOutputs)
+		storedItems = BuildCodeInterpreterOutputItems(codeCalls, true)
+	case len(webSearchCalls) > 0:
+		// Already part of the converted response
+		storedItems = BuildWebSearchOutputItems(webSearchCalls, true)
+	}
+	turn := job.turn
+	for _, item := range storedItems {
+		turn.output = append(turn.output, inputItemOf(item))
+	}
+	if len(toolItems) > 0 {
+		responsesResp.Output = append(append([]models.OutputItem{}, toolItems...), responsesResp.Output...)
+	}
+
+	job.log.Info("response completed",
+		zap.String("response_id", responsesResp.ID),
+		zap.Int("output_count", len(responsesResp.Output)),
+		zap.Int("web_search_calls", len(webSearchCalls)),
+		zap.Int("file_search_calls", len(fileSearchCalls)),
+		zap.Int("code_interpreter_calls", len(codeCalls)),
+	)
+
+	// Store complete conversation history; emulation retry exchanges are not
+	// kept, MCP, file_search, code_interpreter and web_search tool exchanges
+	// are
+	completeMessages := make([]models.ChatMessage, len(job.plan.history))
+	copy(completeMessages, job.plan.history)
+	completeMessages = append(completeMessages, toolExchange...)
+	if len(chatResp.Choices) > 0 {
+		completeMessages = append(completeMessages, chatResp.Choices[0].Message)
+	}
+
+	return responsesResp, &completedTurn{messages: completeMessages, opts: turn}, nil
+}
+
+// recordTurn stores the conversation of a completed job and appends its
+// sealed state to the response, if any
+func (h *ProxyHandler) recordTurn(resp *models.ResponsesResponse, turn *completedTurn, log *zap.Logger) {
+	if state := h.finishTurn(resp.ID, turn.messages, turn.opts, log); state != nil {
+		resp.Output = append(resp.Output, *state)
+	}
+}
+
+// backgroundRunner executes background responses on a fixed pool of workers
+// and keeps their records in the store up to date
+type backgroundRunner struct {
+	h       *ProxyHandler
+	client  *http.Client // Without a client timeout; jobs are bounded by timeout
+	jobs    chan *responseJob
+	timeout time.Duration
+
+	// mu serializes status transitions of background records
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc // Running jobs by response ID
+}
+
+// newBackgroundRunner marks jobs interrupted by a previous shutdown as failed
+// and starts the workers
+func newBackgroundRunner(h *ProxyHandler, cfg config.BackgroundConfig) *backgroundRunner {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 100
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 3600
+	}
+
+	b := &backgroundRunner{
+		h: h,
+		client: &http.Client{
+			CheckRedirect: h.client.CheckRedirect,
+		},
+		jobs:    make(chan *responseJob, queueSize),
+		timeout: time.Duration(timeout) * time.Second,
+		cancels: make(map[string]context.CancelFunc),
+	}
+
+	if count, err := h.store.FailInterruptedResponses("The response was interrupted by a server restart"); err != nil {
+		logger.Error("failed to mark interrupted background responses", zap.Error(err))
+	} else if count > 0 {
+		logger.Warn("marked interrupted background responses as failed", zap.Int("count", count))
+	}
+
+	for i := 0; i < workers; i++ {
+		go b.work()
+	}
+	return b
+}
+
+// submit records a queued response and hands the job to the workers.
+// Returns false, without recording anything, if the queue is full.
+func (b *backgroundRunner) submit(job *responseJob, record *models.ResponsesResponse) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Only submit adds jobs and it holds mu, so the queue cannot fill up
+	// between this check and the send
+	if len(b.jobs) == cap(b.jobs) {
+		return false, nil
+	}
+	if err := b.h.store.StoreResponse(record); err != nil {
+		return false, err
+	}
+	b.jobs <- job
+	return true, nil
+}
+
+// work runs jobs until the process exits
+func (b *backgroundRunner) work() {
+	for job := range b.jobs {
+		b.run(job)
+	}
+}
+
+// run executes a single job and stores its outcome unless it was cancelled
+func (b *backgroundRunner) run(job *responseJob) {
+	id := fmt.Sprintf("resp-%s", job.responseID)
+	log := job.log.With(zap.String("response_id", id))
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, traceIDKey, job.traceID)
+
+	b.mu.Lock()
+	record, ok := b.h.store.GetResponse(id)
+	if !ok || record.Status != "queued" {
+		// Cancelled while waiting in the queue
+		b.mu.Unlock()
+		log.Info("skipping background response", zap.Bool("found", ok))
+		return
+	}
+	record.Status = "in_progress"
+	if err := b.h.store.StoreResponse(record); err != nil {
+		log.Error("failed to update background response", zap.Error(err))
+	}
+	b.cancels[id] = cancel
+	b.mu.Unlock()
+
+	log.Info("background response started")
+	start := time.Now()
+	resp, turn, err := b.h.completeResponse(ctx, b.client, job)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.cancels, id)
+
+	// Cancelled or deleted while running: nothing of the turn is stored
+	if current, ok := b.h.store.GetResponse(id); !ok || current.Status == "cancelled" {
+		log.Info("background response cancelled", zap.Duration("elapsed", time.Since(start)))
+		return
+	}
+
+	if err != nil {
+		log.Error("background response failed", zap.Error(err))
+		record.Status = "failed"
+		record.Error = backgroundError(ctx, err)
+	} else {
+		log.Info("background response completed", zap.Duration("elapsed", time.Since(start)))
+		b.h.recordTurn(resp, turn, log)
+		resp.CreatedAt = record.CreatedAt
+		resp.Background = true
+		record = resp
+	}
+
+	if err := b.h.store.StoreResponse(record); err != nil {
+		log.Error("failed to store background response", zap.Error(err))
+	}
+}
+
+// cancel cancels a queued or running response.
+// Returns the updated record, or nil and false if it does not exist.
+func (b *backgroundRunner) cancel(id string) (*models.ResponsesResponse, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	record, ok := b.h.store.GetResponse(id)
+	if !ok {
+		return nil, false, nil
+	}
+	if record.Status != "queued" && record.Status != "in_progress" {
+		return record, true, nil
+	}
+
+	record.Status = "cancelled"
+	if err := b.h.store.StoreResponse(record); err != nil {
+		return nil, true, err
+	}
+	if cancel, ok := b.cancels[id]; ok {
+		cancel()
+	}
+	return record, true, nil
+}
+
+// backgroundError describes why a background job failed
+func backgroundError(ctx context.Context, err error) *models.ErrorDetail {
+	var upstreamErr *UpstreamError
+	switch {
+	case errors.As(err, &upstreamErr):
+		return &models.ErrorDetail{
+			Type:    "upstream_error",
+			Code:    fmt.Sprintf("%d", upstreamErr.StatusCode),
+			Message: string(upstreamErr.Body),
+		}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &models.ErrorDetail{Type: "timeout", Message: "Background response exceeded the configured timeout"}
+	default:
+		return &models.ErrorDetail{Type: "server_error", Message: err.Error()}
+	}
+}
+
+// handleBackgroundResponse queues a job and answers with the queued response
+func (h *ProxyHandler) handleBackgroundResponse(w http.ResponseWriter, r *http.Request, req *models.ResponsesRequest, job *responseJob, log *zap.Logger) {
+	if req.Stream {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", "Streaming is not supported for background responses; poll GET /v1/responses/{id} instead", log)
+		return
+	}
+	if !job.turn.persist {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", "Background responses require store to be enabled", log)
+		return
+	}
+
+	record := &models.ResponsesResponse{
+		ID:         fmt.Sprintf("resp-%s", job.responseID),
+		Object:     "response",
+		CreatedAt:  time.Now().Unix(),
+		Status:     "queued",
+		Model:      req.Model,
+		Output:     []models.OutputItem{},
+		Background: true,
+	}
+
+	queued, err := h.background.submit(job, record)
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to store background response: %v", err), log)
+		return
+	}
+	if !queued {
+		h.handleError(w, r, http.StatusServiceUnavailable, "server_busy", "Too many background responses in progress, try again later", log)
+		return
+	}
+
+	log.Info("background response queued", zap.String("response_id", record.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(record)
+}
+
+// handleCancelResponse handles POST /v1/responses/{id}/cancel
+func (h *ProxyHandler) handleCancelResponse(w http.ResponseWriter, r *http.Request, responseID string, log *zap.Logger) {
+	record, ok := h.store.GetResponse(responseID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Response not found", log)
+		return
+	}
+	if !record.Background {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", "Only background responses can be cancelled", log)
+		return
+	}
+
+	record, ok, err := h.background.cancel(responseID)
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to cancel response: %v", err), log)
+		return
+	}
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Response not found", log)
+		return
+	}
+
+	log.Info("background response cancel requested",
+		zap.String("response_id", responseID),
+		zap.String("status", record.Status),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(record)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+func TestBackgroundResponse(t *testing.T) {
+	t.Run("Cancelled while running stores nothing", func(t *testing.T) {
+		responseIDs := make(chan string, 1)
+		replied := make(chan struct{})
+		var cancelRunning func(id string)
+
+		h, store := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			// The cancel lands after the upstream replied, before the job
+			// records its outcome
+			cancelRunning(<-responseIDs)
+			fmt.Fprint(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","content":"done"}}]}`)
+			close(replied)
+		}, nil)
+		cancelRunning = func(id string) {
+			record, ok := store.GetResponse(id)
+			if !ok {
+				t.Errorf("Response %s not found", id)
+				return
+			}
+			record.Status = "cancelled"
+			store.StoreResponse(record)
+		}
+
+		conv := &models.Conversation{ID: "conv_test", Object: "conversation", CreatedAt: time.Now().Unix()}
+		if err := store.CreateConversation(conv, nil); err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+
+		rec := serve(h, http.MethodPost, "/v1/responses",
+			`{"model":"m","input":"hi","background":true,"store":true,"conversation":"conv_test"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		id := decodeBody(t, rec)["id"].(string)
+		responseIDs <- id
+
+		select {
+		case <-replied:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Upstream was not called")
+		}
+		// The job has finished once it removed its cancel func and released
+		// the lock it checks the status under
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			h.background.mu.Lock()
+			_, running := h.background.cancels[id]
+			h.background.mu.Unlock()
+			if !running {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Job did not finish")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if _, ok := store.Get(id); ok {
+			t.Error("Expected no conversation history for a cancelled response")
+		}
+		if _, ok := store.GetInputItems(id); ok {
+			t.Error("Expected no input items for a cancelled response")
+		}
+		if items, _ := store.GetConversationItems("conv_test"); len(items) != 0 {
+			t.Errorf("Expected no conversation items, got %d", len(items))
+		}
+		if record, _ := store.GetResponse(id); len(record.Output) != 0 {
+			t.Errorf("Expected the cancelled record to keep no output, got %d items", len(record.Output))
+		}
+	})
+	t.Run("Full queue stores nothing", func(t *testing.T) {
+		h, store := newTestHandler(t, nil, nil)
+		// A runner without workers, so the queued job stays put
+		b := &backgroundRunner{h: h, jobs: make(chan *responseJob, 1), cancels: make(map[string]context.CancelFunc)}
+		b.jobs <- &responseJob{}
+
+		record := &models.ResponsesResponse{ID: "resp-full", Object: "response", Status: "queued", Background: true}
+		queued, err := b.submit(&responseJob{}, record)
+		if err != nil || queued {
+			t.Fatalf("Expected the job to be refused, got queued %v, err %v", queued, err)
+		}
+		if _, ok := store.GetResponse("resp-full"); ok {
+			t.Error("Expected no record for a refused job")
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/converter"
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/internal/sandbox"
+	"github.com/young1lin/responses2chat/internal/storage"
+)
+
+// includeCodeInterpreterOutputs is the include value asking for the outputs
+// of code_interpreter_call items
+const includeCodeInterpreterOutputs = "code_interpreter_call.outputs"
+
+// maxCodeInterpreterIterations caps the upstream round trips of a turn
+// running code
+const maxCodeInterpreterIterations = 8
+
+// maxCodeInterpreterFiles caps the files of a run added to the Files API
+const maxCodeInterpreterFiles = 10
+
+// CodeInterpreterHandler runs code_interpreter calls in a local sandbox
+type CodeInterpreterHandler struct {
+	store   *storage.ConversationStore
+	sandbox *sandbox.Sandbox
+}
+
+// NewCodeInterpreterHandler creates a code interpreter handler keeping its
+// containers beside the database. Files written by runs are accepted up to
+// maxFileBytes.
+func NewCodeInterpreterHandler(cfg *config.Config, store *storage.ConversationStore, maxFileBytes int64) (*CodeInterpreterHandler, error) {
+	dir := filepath.Join(filepath.Dir(cfg.Storage.Path), "containers")
+	sb, err := sandbox.New(cfg.CodeInterpreter, dir, maxFileBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &CodeInterpreterHandler{
+		store:   store,
+		sandbox: sb,
+	}, nil
+}
+
+// CodeInterpreterCall represents a tracked code_interpreter call
+type CodeInterpreterCall struct {
+	ID          string
+	Code        string
+	Status      string
+	ContainerID string
+	Outputs     []models.CodeInterpreterOutput
+}
+
+// codeInterpreterRequest is the code_interpreter tool of a request
+type codeInterpreterRequest struct {
+	tool           models.Tool
+	containerID    string
+	fileIDs        []string // Files of the container object, copied into it by prepareContainer
+	includeOutputs bool     // Whether the client asked for code_interpreter_call outputs
+}
+
+// codeInterpreterRequestOf checks the code_interpreter tool of a request and
+// picks its container: the named one, the one of the conversation or of the
+// previous runs in history, or a new one. Nothing is created yet; see
+// prepareContainer. Returns nil if there is no such tool.
+func (h *ProxyHandler) codeInterpreterRequestOf(req *models.ResponsesRequest, history []models.ChatMessage, conversation string) (*codeInterpreterRequest, error) {
+	var ci *codeInterpreterRequest
+	for i := range req.Tools {
+		tool := req.Tools[i]
+		if tool.Type != "code_interpreter" {
+			continue
+		}
+		param := fmt.Sprintf("tools[%d]", i)
+		if ci != nil {
+			return nil, &models.ParamError{Param: param, Message: "only one code_interpreter tool is supported"}
+		}
+		if h.codeInterpreterHandler == nil {
+			return nil, &models.ParamError{Param: param, Message: "code_interpreter is not enabled on this server"}
+		}
+		containerID, ids, err := converter.CodeInterpreterContainer(&tool)
+		if err != nil {
+			return nil, &models.ParamError{Param: param + ".container", Message: err.Error()}
+		}
+		if containerID != "" && !h.codeInterpreterHandler.sandbox.Exists(containerID) {
+			return nil, &models.ParamError{
+				Param:   param + ".container",
+				Message: fmt.Sprintf("container %q not found", containerID),
+			}
+		}
+		for j, id := range ids {
+			if _, ok := h.store.GetFile(id); !ok {
+				return nil, &models.ParamError{
+					Param:   fmt.Sprintf("%s.container.file_ids[%d]", param, j),
+					Message: fmt.Sprintf("file %s not found", id),
+				}
+			}
+		}
+		ci = &codeInterpreterRequest{tool: tool, containerID: containerID, fileIDs: ids}
+	}
+	if ci == nil {
+		return nil, nil
+	}
+
+	// Each of these tools is run by its own loop
+	for i, tool := range req.Tools {
+		if tool.Type == "mcp" || tool.Type == "web_search" || tool.Type == "file_search" {
+			return nil, &models.ParamError{
+				Param:   fmt.Sprintf("tools[%d]", i),
+				Message: fmt.Sprintf("code_interpreter cannot be combined with %s tools", tool.Type),
+			}
+		}
+	}
+
+	if ci.containerID == "" {
+		ci.containerID = h.codeInterpreterHandler.containerFor(conversation, history)
+	}
+	for _, inc := range req.Include {
+		if inc == includeCodeInterpreterOutputs {
+			ci.includeOutputs = true
+		}
+	}
+	return ci, nil
+}
+
+// prepareContainer creates the container of an accepted turn unless it
+// exists and copies the files of the container object into it
+func (h *CodeInterpreterHandler) prepareContainer(ci *codeInterpreterRequest) error {
+	if err := h.sandbox.Create(ci.containerID); err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	for _, id := range ci.fileIDs {
+		file, content, ok := h.store.ReadFile(id)
+		if !ok {
+			return fmt.Errorf("file %s not found", id)
+		}
+		if err := h.sandbox.WriteFile(ci.containerID, file.Filename, content); err != nil {
+			return fmt.Errorf("failed to copy file %s into the container: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// containerFor returns the container of a turn: a conversation has a
+// container of its own; other turns reuse the container of the last run in
+// their history, if it was stored. Otherwise a new container ID is returned.
+func (h *CodeInterpreterHandler) containerFor(conversation string, history []models.ChatMessage) string {
+	if conversation != "" {
+		return "cntr_" + strings.TrimPrefix(conversation, "conv_")
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		calls := history[i].ToolCalls
+		for j := len(calls) - 1; j >= 0; j-- {
+			if calls[j].Function.Name != converter.CodeInterpreterFunctionTool.Function.Name {
+				continue
+			}
+			// Runs are recorded under the ID of their code_interpreter_call item
+			if item, ok := h.store.GetItem(calls[j].ID); ok && item.ContainerID != "" && h.sandbox.Exists(item.ContainerID) {
+				return item.ContainerID
+			}
+		}
+	}
+	return sandbox.NewContainerID()
+}
+
+// Run executes code in a container and returns the call with its logs and
+// the files the code wrote, which are added to the Files API
+func (h *CodeInterpreterHandler) Run(ctx context.Context, containerID, code string, log *zap.Logger) CodeInterpreterCall {
+	call := CodeInterpreterCall{
+		ID:          fmt.Sprintf("ci-%s", generateResponseID()),
+		Code:        code,
+		Status:      "completed",
+		ContainerID: containerID,
+	}
+
+	result, err := h.sandbox.Run(ctx, containerID, code)
+	if err != nil {
+		log.Error("code_interpreter failed", zap.Error(err))
+		call.Status = "failed"
+		call.Outputs = []models.CodeInterpreterOutput{{Type: "logs", Logs: err.Error()}}
+		return call
+	}
+	if result.TimedOut {
+		call.Status = "incomplete"
+	}
+	call.Outputs = append(call.Outputs, models.CodeInterpreterOutput{Type: "logs", Logs: result.Logs()})
+
+	files := result.Files
+	if len(files) > maxCodeInterpreterFiles {
+		log.Warn("code_interpreter wrote too many files, keeping the first ones",
+			zap.Int("file_count", len(files)),
+		)
+		files = files[:maxCodeInterpreterFiles]
+	}
+	for _, name := range files {
+		content, err := h.sandbox.ReadFile(containerID, name)
+		if err != nil {
+			log.Warn("skipping file written by code_interpreter", zap.String("filename", name), zap.Error(err))
+			continue
+		}
+		obj := &models.FileObject{
+			ID:        fmt.Sprintf("file-%s", strings.ReplaceAll(uuid.New().String(), "-", "")),
+			Object:    "file",
+			CreatedAt: time.Now().Unix(),
+			Filename:  path.Base(name),
+			Purpose:   "assistants_output",
+			Status:    "processed",
+		}
+		if err := h.store.SaveFile(obj, bytes.NewReader(content), int64(len(content))); err != nil {
+			log.Error("failed to store file written by code_interpreter", zap.String("filename", name), zap.Error(err))
+			continue
+		}
+		call.Outputs = append(call.Outputs, models.CodeInterpreterOutput{
+			Type:     "file",
+			FileID:   obj.ID,
+			Filename: obj.Filename,
+		})
+	}
+
+	log.Info("code_interpreter run finished",
+		zap.String("container_id", containerID),
+		zap.Int("exit_code", result.ExitCode),
+		zap.Bool("timed_out", result.TimedOut),
+		zap.Int("file_count", len(call.Outputs)-1),
+	)
+	return call
+}
+
+// HandleWithCodeInterpreter sends the request upstream without streaming and
+// runs the code_interpreter calls of the replies until the model answers or
+// calls a client tool. Returns the last reply, the runs and the exchanges
+// with the code_interpreter tool that precede the reply.
+func (h *CodeInterpreterHandler) HandleWithCodeInterpreter(
+	ctx context.Context,
+	client *http.Client,
+	chatReq *models.ChatCompletionRequest,
+	ci *codeInterpreterRequest,
+	singleToolCall bool,
+	apiKey string,
+	targetCfg *config.TargetConfig,
+	log *zap.Logger,
+) (*models.ChatCompletionResponse, []CodeInterpreterCall, []models.ChatMessage, error) {
+	messages := make([]models.ChatMessage, len(chatReq.Messages))
+	copy(messages, chatReq.Messages)
+	var (
+		calls    []CodeInterpreterCall
+		exchange []models.ChatMessage
+	)
+
+	for i := 0; ; i++ {
+		log.Debug("code_interpreter iteration",
+			zap.Int("iteration", i+1),
+			zap.Int("message_count", len(messages)),
+		)
+
+		currentReq := *chatReq
+		currentReq.Messages = messages
+		currentReq.Stream = false
+		// A forced run is done once; the last request no longer offers
+		// code_interpreter, so the model has to answer
+		if i > 0 && forcesCodeInterpreter(chatReq.ToolChoice) {
+			currentReq.ToolChoice = nil
+		}
+		if i+1 >= maxCodeInterpreterIterations {
+			currentReq.Tools = nil
+			for _, t := range chatReq.Tools {
+				if t.Function.Name != converter.CodeInterpreterFunctionTool.Function.Name {
+					currentReq.Tools = append(currentReq.Tools, t)
+				}
+			}
+			if len(currentReq.Tools) == 0 {
+				currentReq.ToolChoice = nil
+				currentReq.ParallelToolCalls = nil
+			}
+		}
+
+		resp, err := sendChatCompletion(ctx, client, &currentReq, apiKey, targetCfg, log)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(resp.Choices) == 0 {
+			return resp, calls, exchange, nil
+		}
+
+		msg := &resp.Choices[0].Message
+		if singleToolCall {
+			converter.KeepFirstToolCall(msg)
+		}
+
+		// Client tool calls end the turn
+		var run, rest []models.ToolCall
+		for _, tc := range msg.ToolCalls {
+			if tc.Function.Name == converter.CodeInterpreterFunctionTool.Function.Name {
+				run = append(run, tc)
+			} else {
+				rest = append(rest, tc)
+			}
+		}
+		if len(run) == 0 {
+			return resp, calls, exchange, nil
+		}
+		msg.ToolCalls = rest
+
+		log.Info("detected code_interpreter calls", zap.Int("count", len(run)))
+
+		step := []models.ChatMessage{{Role: "assistant"}}
+		if len(rest) == 0 {
+			step[0].Content = msg.Content
+		}
+		for _, tc := range run {
+			var args struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				log.Error("failed to parse code_interpreter arguments",
+					zap.Error(err),
+					zap.String("arguments", tc.Function.Arguments),
+				)
+			}
+
+			log.Info("executing code_interpreter",
+				zap.String("container_id", ci.containerID),
+				zap.String("call_id", tc.ID),
+			)
+
+			call := h.Run(ctx, ci.containerID, args.Code, log)
+			calls = append(calls, call)
+
+			// The exchange refers to the run by its item ID, which later
+			// turns use to find the container
+			tc.ID = call.ID
+			step[0].ToolCalls = append(step[0].ToolCalls, tc)
+			content := converter.FormatCodeInterpreterOutputs(call.Outputs)
+			if call.Status == "failed" {
+				content = fmt.Sprintf("Code execution failed: %s", call.Outputs[0].Logs)
+			}
+			step = append(step, models.ChatMessage{
+				Role:       "tool",
+				Content:    content,
+				ToolCallID: tc.ID,
+			})
+		}
+		exchange = append(exchange, step...)
+		messages = append(messages, step...)
+
+		// The model sees the outputs of runs that came with client tool
+		// calls with the next request
+		if len(rest) > 0 {
+			return resp, calls, exchange, nil
+		}
+	}
+}
+
+// forcesCodeInterpreter reports whether a tool_choice demands a tool call
+// that code_interpreter can satisfy
+func forcesCodeInterpreter(choice interface{}) bool {
+	switch v := choice.(type) {
+	case string:
+		return v == "required"
+	case models.ChatToolChoice:
+		return v.Function.Name == converter.CodeInterpreterFunctionTool.Function.Name
+	}
+	return false
+}
+
+// BuildCodeInterpreterOutputItems builds the code_interpreter_call items of
+// the runs; outputs are left out unless included
+func BuildCodeInterpreterOutputItems(calls []CodeInterpreterCall, includeOutputs bool) []models.OutputItem {
+	items := make([]models.OutputItem, 0, len(calls))
+	for _, call := range calls {
+		item := models.OutputItem{
+			Type:        "code_interpreter_call",
+			ID:          call.ID,
+			Status:      call.Status,
+			Code:        call.Code,
+			ContainerID: call.ContainerID,
+		}
+		if includeOutputs {
+			item.Outputs = call.Outputs
+		}
+		items = append(items, item)
+	}
+	return items
+}
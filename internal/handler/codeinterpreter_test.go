@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/young1lin/responses2chat/internal/config"
+)
+
+func TestCodeInterpreterContainerAfterAuth(t *testing.T) {
+	dir := t.TempDir()
+	h, _ := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Upstream must not be called")
+	}, func(cfg *config.Config) {
+		cfg.DefaultTarget.DefaultAPIKey = ""
+		cfg.Storage.Path = filepath.Join(dir, "test.db")
+		cfg.CodeInterpreter.Enabled = true
+	})
+	if h.codeInterpreterHandler == nil {
+		t.Skip("code_interpreter sandbox is not supported here")
+	}
+
+	body := `{"model":"m","input":"hi","tools":[{"type":"code_interpreter","container":{"type":"auto"}}]}`
+	rec := serve(h, http.MethodPost, "/v1/responses", body)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d: %s", rec.Code, rec.Body.String())
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "containers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != ".root" {
+			t.Errorf("Expected no container for an unauthorized request, found %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/internal/storage"
+)
+
+// Conversations API limits, matching OpenAI
+const (
+	maxConversationItems    = 20 // Items per create or add request
+	maxConversationMetadata = 16 // Metadata key-value pairs
+)
+
+// conversationIDOf extracts the conversation ID from the conversation field
+// of a Responses request, which is either an ID or {"id": ID}
+func conversationIDOf(raw interface{}) (string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case map[string]interface{}:
+		if id, ok := v["id"].(string); ok && id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("conversation must be a conversation ID or an object with an id")
+}
+
+// conversationRef returns the conversation reference of a response, or nil
+func conversationRef(convID string) *models.ConversationRef {
+	if convID == "" {
+		return nil
+	}
+	return &models.ConversationRef{ID: convID}
+}
+
+// handleConversations routes /v1/conversations requests
+func (h *ProxyHandler) handleConversations(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
+	rest := r.URL.Path[strings.Index(r.URL.Path, "/v1/conversations")+len("/v1/conversations"):]
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+
+	switch {
+	case parts[0] == "":
+		if r.Method != http.MethodPost {
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Only POST method is allowed", log)
+			return
+		}
+		h.handleCreateConversation(w, r, log)
+
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGetConversation(w, r, parts[0], log)
+		case http.MethodPost:
+			h.handleUpdateConversation(w, r, parts[0], log)
+		case http.MethodDelete:
+			h.handleDeleteConversation(w, r, parts[0], log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	case len(parts) == 2 && parts[1] == "items":
+		switch r.Method {
+		case http.MethodGet:
+			h.handleListConversationItems(w, r, parts[0], log)
+		case http.MethodPost:
+			h.handleAddConversationItems(w, r, parts[0], log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	case len(parts) == 3 && parts[1] == "items":
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGetConversationItem(w, r, parts[0], parts[2], log)
+		case http.MethodDelete:
+			h.handleDeleteConversationItem(w, r, parts[0], parts[2], log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	default:
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Endpoint not found", log)
+	}
+}
+
+// readConversationRequest parses a conversation request body; an empty body
+// is an empty request
+func (h *ProxyHandler) readConversationRequest(w http.ResponseWriter, r *http.Request, log *zap.Logger) (*models.ConversationRequest, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "read_error", "Failed to read request body", log)
+		return nil, false
+	}
+	defer r.Body.Close()
+
+	var req models.ConversationRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			// Items are parsed like Responses input; report them as items
+			var paramErr *models.ParamError
+			if errors.As(err, &paramErr) {
+				paramErr.Param = "items" + strings.TrimPrefix(paramErr.Param, "input")
+			}
+			h.handleParseError(w, r, err, log)
+			return nil, false
+		}
+	}
+
+	if len(req.Items) > maxConversationItems {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("At most %d items can be added at once", maxConversationItems), log)
+		return nil, false
+	}
+	if len(req.Metadata) > maxConversationMetadata {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("metadata can have at most %d keys", maxConversationMetadata), log)
+		return nil, false
+	}
+	return &req, true
+}
+
+// handleCreateConversation handles POST /v1/conversations
+func (h *ProxyHandler) handleCreateConversation(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
+	req, ok := h.readConversationRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	items, err := h.resolveInputItems(req.Items)
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	conv := &models.Conversation{
+		ID:        fmt.Sprintf("conv_%s", strings.ReplaceAll(uuid.New().String(), "-", "")),
+		Object:    "conversation",
+		CreatedAt: time.Now().Unix(),
+		Metadata:  req.Metadata,
+	}
+	if conv.Metadata == nil {
+		conv.Metadata = map[string]interface{}{}
+	}
+
+	if err := h.store.CreateConversation(conv, items); err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to create conversation: %v", err), log)
+		return
+	}
+
+	log.Info("conversation created",
+		zap.String("conversation_id", conv.ID),
+		zap.Int("item_count", len(items)),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(conv)
+}
+
+// handleGetConversation handles GET /v1/conversations/{id}
+func (h *ProxyHandler) handleGetConversation(w http.ResponseWriter, r *http.Request, convID string, log *zap.Logger) {
+	conv, ok := h.store.GetConversation(convID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Conversation not found", log)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conv)
+}
+
+// handleUpdateConversation handles POST /v1/conversations/{id}, which
+// replaces the conversation metadata
+func (h *ProxyHandler) handleUpdateConversation(w http.ResponseWriter, r *http.Request, convID string, log *zap.Logger) {
+	req, ok := h.readConversationRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	conv, ok := h.store.GetConversation(convID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Conversation not found", log)
+		return
+	}
+
+	conv.Metadata = req.Metadata
+	if conv.Metadata == nil {
+		conv.Metadata = map[string]interface{}{}
+	}
+
+	if err := h.store.UpdateConversation(conv); err != nil {
+		h.handleConversationStoreError(w, r, err, log)
+		return
+	}
+
+	log.Info("conversation updated", zap.String("conversation_id", convID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(conv)
+}
+
+// handleDeleteConversation handles DELETE /v1/conversations/{id}
+func (h *ProxyHandler) handleDeleteConversation(w http.ResponseWriter, r *http.Request, convID string, log *zap.Logger) {
+	found, err := h.store.DeleteConversation(convID)
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to delete conversation: %v", err), log)
+		return
+	}
+	if !found {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Conversation not found", log)
+		return
+	}
+
+	log.Info("conversation deleted", zap.String("conversation_id", convID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.DeletedObject{
+		ID:      convID,
+		Object:  "conversation.deleted",
+		Deleted: true,
+	})
+}
+
+// handleListConversationItems handles GET /v1/conversations/{id}/items
+func (h *ProxyHandler) handleListConversationItems(w http.ResponseWriter, r *http.Request, convID string, log *zap.Logger) {
+	params, err := parseListParams(r, "desc")
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	items, ok := h.store.GetConversationItems(convID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Conversation not found", log)
+		return
+	}
+
+	if err := writeList(w, items, func(item models.InputItem) string { return item.ID }, params); err != nil {
+		h.handleParseError(w, r, err, log)
+	}
+}
+
+// handleAddConversationItems handles POST /v1/conversations/{id}/items
+func (h *ProxyHandler) handleAddConversationItems(w http.ResponseWriter, r *http.Request, convID string, log *zap.Logger) {
+	req, ok := h.readConversationRequest(w, r, log)
+	if !ok {
+		return
+	}
+
+	items, err := h.resolveInputItems(req.Items)
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	if err := h.store.AppendConversationItems(convID, items); err != nil {
+		h.handleConversationStoreError(w, r, err, log)
+		return
+	}
+
+	log.Info("conversation items added",
+		zap.String("conversation_id", convID),
+		zap.Int("item_count", len(items)),
+	)
+
+	// The added items in the order they were given
+	writeList(w, items, func(item models.InputItem) string { return item.ID }, listParams{
+		limit: len(items),
+		order: "asc",
+	})
+}
+
+// handleGetConversationItem handles GET /v1/conversations/{id}/items/{item_id}
+func (h *ProxyHandler) handleGetConversationItem(w http.ResponseWriter, r *http.Request, convID, itemID string, log *zap.Logger) {
+	items, ok := h.store.GetConversationItems(convID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Conversation not found", log)
+		return
+	}
+
+	for _, item := range items {
+		if item.ID == itemID {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(item)
+			return
+		}
+	}
+	h.handleError(w, r, http.StatusNotFound, "not_found", "Item not found", log)
+}
+
+// handleDeleteConversationItem handles DELETE /v1/conversations/{id}/items/{item_id}
+// and returns the conversation
+func (h *ProxyHandler) handleDeleteConversationItem(w http.ResponseWriter, r *http.Request, convID, itemID string, log *zap.Logger) {
+	conv, ok := h.store.GetConversation(convID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Conversation not found", log)
+		return
+	}
+
+	found, err := h.store.DeleteConversationItem(convID, itemID)
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to delete item: %v", err), log)
+		return
+	}
+	if !found {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Item not found", log)
+		return
+	}
+
+	log.Info("conversation item deleted",
+		zap.String("conversation_id", convID),
+		zap.String("item_id", itemID),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(conv)
+}
+
+// handleConversationStoreError maps conversation store errors to responses
+func (h *ProxyHandler) handleConversationStoreError(w http.ResponseWriter, r *http.Request, err error, log *zap.Logger) {
+	if errors.Is(err, storage.ErrConversationNotFound) {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Conversation not found", log)
+		return
+	}
+	h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to update conversation: %v", err), log)
+}
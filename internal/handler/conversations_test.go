@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// listIDs returns the IDs of a list response, comma separated, and checks
+// its has_more flag
+func listIDs(t *testing.T, body map[string]interface{}, hasMore bool) string {
+	t.Helper()
+	if body["object"] != "list" || body["has_more"] != hasMore {
+		t.Errorf("Expected a list with has_more %v, got %v", hasMore, body)
+	}
+	var ids []string
+	for _, item := range body["data"].([]interface{}) {
+		ids = append(ids, item.(map[string]interface{})["id"].(string))
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestConversations(t *testing.T) {
+	h, _ := newTestHandler(t, nil, nil)
+
+	message := func(id, text string) string {
+		return fmt.Sprintf(`{"type":"message","id":%q,"role":"user","content":%q}`, id, text)
+	}
+	rec := serve(h, http.MethodPost, "/v1/conversations",
+		`{"metadata":{"topic":"demo"},"items":[`+message("msg_1", "one")+`,`+message("msg_2", "two")+`,`+message("msg_3", "three")+`]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Failed to create conversation: %d %s", rec.Code, rec.Body.String())
+	}
+	created := decodeBody(t, rec)
+	convID := created["id"].(string)
+	if !strings.HasPrefix(convID, "conv_") || created["object"] != "conversation" {
+		t.Fatalf("Unexpected conversation %v", created)
+	}
+	convPath := "/v1/conversations/" + convID
+	itemsPath := convPath + "/items"
+
+	tooMany := make([]string, maxConversationItems+1)
+	for i := range tooMany {
+		tooMany[i] = message(fmt.Sprintf("msg_x%d", i), "x")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		check  func(t *testing.T, body map[string]interface{})
+	}{
+		{"Create with too many items", http.MethodPost, "/v1/conversations", `{"items":[` + strings.Join(tooMany, ",") + `]}`, http.StatusBadRequest, nil},
+		{"Create with an unknown item reference", http.MethodPost, "/v1/conversations", `{"items":[{"type":"item_reference","id":"msg_missing"}]}`, http.StatusBadRequest, nil},
+		{"Get", http.MethodGet, convPath, "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if body["metadata"].(map[string]interface{})["topic"] != "demo" {
+				t.Errorf("Expected the metadata, got %v", body)
+			}
+		}},
+		{"Get unknown", http.MethodGet, "/v1/conversations/conv_missing", "", http.StatusNotFound, nil},
+		{"Update metadata", http.MethodPost, convPath, `{"metadata":{"topic":"updated"}}`, http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if body["metadata"].(map[string]interface{})["topic"] != "updated" {
+				t.Errorf("Expected the new metadata, got %v", body)
+			}
+		}},
+		{"Update unknown", http.MethodPost, "/v1/conversations/conv_missing", `{"metadata":{}}`, http.StatusNotFound, nil},
+		{"List newest first", http.MethodGet, itemsPath + "?limit=2", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if ids := listIDs(t, body, true); ids != "msg_3,msg_2" {
+				t.Errorf("Expected msg_3,msg_2, got %s", ids)
+			}
+			if body["first_id"] != "msg_3" || body["last_id"] != "msg_2" {
+				t.Errorf("Unexpected first_id and last_id in %v", body)
+			}
+		}},
+		{"List the next page", http.MethodGet, itemsPath + "?limit=2&after=msg_2", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if ids := listIDs(t, body, false); ids != "msg_1" {
+				t.Errorf("Expected msg_1, got %s", ids)
+			}
+		}},
+		{"List oldest first before an item", http.MethodGet, itemsPath + "?order=asc&before=msg_3", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if ids := listIDs(t, body, false); ids != "msg_1,msg_2" {
+				t.Errorf("Expected msg_1,msg_2, got %s", ids)
+			}
+		}},
+		{"List after an unknown item", http.MethodGet, itemsPath + "?after=msg_missing", "", http.StatusBadRequest, func(t *testing.T, body map[string]interface{}) {
+			if err := body["error"].(map[string]interface{}); err["param"] != "after" || err["type"] != "invalid_request_error" {
+				t.Errorf("Expected an invalid after, got %v", err)
+			}
+		}},
+		{"List before an unknown item", http.MethodGet, itemsPath + "?before=msg_missing", "", http.StatusBadRequest, func(t *testing.T, body map[string]interface{}) {
+			if err := body["error"].(map[string]interface{}); err["param"] != "before" {
+				t.Errorf("Expected an invalid before, got %v", err)
+			}
+		}},
+		{"List with an invalid limit", http.MethodGet, itemsPath + "?limit=0", "", http.StatusBadRequest, nil},
+		{"List with an invalid order", http.MethodGet, itemsPath + "?order=up", "", http.StatusBadRequest, nil},
+		{"List items of unknown", http.MethodGet, "/v1/conversations/conv_missing/items", "", http.StatusNotFound, nil},
+		{"Add items", http.MethodPost, itemsPath, `{"items":[` + message("msg_4", "four") + `,{"type":"message","role":"user","content":"five"}]}`, http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			ids := listIDs(t, body, false)
+			if !strings.HasPrefix(ids, "msg_4,msg-") {
+				t.Errorf("Expected msg_4 and a generated message ID, got %s", ids)
+			}
+		}},
+		{"Add items to unknown", http.MethodPost, "/v1/conversations/conv_missing/items", `{"items":[` + message("msg_5", "x") + `]}`, http.StatusNotFound, nil},
+		{"Get item", http.MethodGet, itemsPath + "/msg_4", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if body["id"] != "msg_4" || body["role"] != "user" {
+				t.Errorf("Unexpected item %v", body)
+			}
+		}},
+		{"Get unknown item", http.MethodGet, itemsPath + "/msg_missing", "", http.StatusNotFound, nil},
+		{"Delete item", http.MethodDelete, itemsPath + "/msg_2", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if body["id"] != convID || body["object"] != "conversation" {
+				t.Errorf("Expected the conversation, got %v", body)
+			}
+		}},
+		{"Delete deleted item", http.MethodDelete, itemsPath + "/msg_2", "", http.StatusNotFound, nil},
+		{"List after changes", http.MethodGet, itemsPath + "?order=asc&limit=3", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if ids := listIDs(t, body, true); ids != "msg_1,msg_3,msg_4" {
+				t.Errorf("Expected msg_1,msg_3,msg_4, got %s", ids)
+			}
+		}},
+		{"Unsupported method", http.MethodPut, convPath, "", http.StatusMethodNotAllowed, nil},
+		{"Unknown route", http.MethodGet, convPath + "/items/msg_1/extra", "", http.StatusNotFound, nil},
+		{"Delete", http.MethodDelete, convPath, "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if body["deleted"] != true || body["object"] != "conversation.deleted" {
+				t.Errorf("Unexpected deletion %v", body)
+			}
+		}},
+		{"Get deleted", http.MethodGet, convPath, "", http.StatusNotFound, nil},
+		{"Delete deleted", http.MethodDelete, convPath, "", http.StatusNotFound, nil},
+	}
+
+	// Steps build on each other, so they run in order
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, tt.method, tt.path, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.check != nil {
+				tt.check(t, decodeBody(t, rec))
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/converter"
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// defaultEmulationRetries is used when the provider config does not set emulation_retries
+const defaultEmulationRetries = 2
+
+// emulationPlan lists the request semantics the proxy has to enforce itself
+// because the target provider cannot
+type emulationPlan struct {
+	// format is the structured output format to validate replies against
+	format *models.TextFormat
+	// toolChoice holds the tool_choice/parallel_tool_calls semantics to enforce
+	toolChoice *converter.ToolChoiceEmulation
+	// retries is how many times the proxy re-asks upstream after a violation
+	retries int
+	// history is the conversation before any emulation prompt was injected;
+	// it is what gets stored for follow-up turns
+	history []models.ChatMessage
+}
+
+// buildEmulationPlan applies the request options that depend on provider
+// capabilities to chatReq and returns what is left for the proxy to emulate
+func buildEmulationPlan(req *models.ResponsesRequest, chatReq *models.ChatCompletionRequest, targetCfg *config.TargetConfig) (*emulationPlan, error) {
+	plan := &emulationPlan{
+		retries: targetCfg.EmulationRetries,
+		history: chatReq.Messages,
+	}
+	if plan.retries == 0 {
+		plan.retries = defaultEmulationRetries
+	}
+
+	plan.format = converter.ApplyTextFormat(chatReq, req.Text, targetCfg.StructuredOutput)
+
+	toolChoice, err := converter.ApplyToolChoice(chatReq, req, targetCfg.ToolChoice)
+	if err != nil {
+		return nil, err
+	}
+	plan.toolChoice = toolChoice
+
+	return plan, nil
+}
+
+// active reports whether the proxy must buffer and check upstream replies
+func (p *emulationPlan) active() bool {
+	return p.format != nil || (p.toolChoice != nil && p.toolChoice.RequireToolCall)
+}
+
+// singleToolCall reports whether replies must be cut down to one tool call
+func (p *emulationPlan) singleToolCall() bool {
+	return p.toolChoice != nil && p.toolChoice.SingleToolCall
+}
+
+// check inspects a reply and returns feedback for the model if it violates
+// an emulated constraint, or "" if it is acceptable. It may normalise the
+// reply in place, e.g. by stripping code fences around JSON.
+func (p *emulationPlan) check(resp *models.ChatCompletionResponse) string {
+	if len(resp.Choices) == 0 {
+		return ""
+	}
+	msg := &resp.Choices[0].Message
+
+	if p.singleToolCall() {
+		converter.KeepFirstToolCall(msg)
+	}
+
+	if p.toolChoice != nil && p.toolChoice.RequireToolCall && len(msg.ToolCalls) == 0 {
+		if p.toolChoice.FunctionName != "" {
+			return fmt.Sprintf("You must call the %s tool now instead of answering in text.", p.toolChoice.FunctionName)
+		}
+		return "You must call one of the available tools now instead of answering in text."
+	}
+
+	if p.format != nil && len(msg.ToolCalls) == 0 {
+		content, _ := msg.Content.(string)
+		normalized, err := converter.ValidateStructuredOutput(content, p.format)
+		if err != nil {
+			return fmt.Sprintf("Your previous reply does not satisfy the required output format: %v. "+
+				"Reply again with only the corrected JSON.", err)
+		}
+		msg.Content = normalized
+	}
+
+	return ""
+}
+
+// completeWithEmulation sends chatReq upstream without streaming and re-asks
+// the model, up to plan.retries times, while the reply violates the plan.
+// The last reply is returned even if it still violates the plan.
+func (h *ProxyHandler) completeWithEmulation(
+	ctx context.Context,
+	client *http.Client,
+	chatReq *models.ChatCompletionRequest,
+	plan *emulationPlan,
+	apiKey string,
+	targetCfg *config.TargetConfig,
+	log *zap.Logger,
+) (*models.ChatCompletionResponse, error) {
+	messages := make([]models.ChatMessage, len(chatReq.Messages))
+	copy(messages, chatReq.Messages)
+
+	for attempt := 0; ; attempt++ {
+		currentReq := *chatReq
+		currentReq.Messages = messages
+		currentReq.Stream = false
+
+		resp, err := sendChatCompletion(ctx, client, &currentReq, apiKey, targetCfg, log)
+		if err != nil {
+			return nil, err
+		}
+
+		feedback := plan.check(resp)
+		if feedback == "" {
+			return resp, nil
+		}
+		if attempt >= plan.retries {
+			log.Warn("emulated constraint still violated, giving up",
+				zap.Int("attempts", attempt+1),
+				zap.String("feedback", feedback),
+			)
+			return resp, nil
+		}
+
+		log.Info("re-asking upstream after constraint violation",
+			zap.Int("attempt", attempt+1),
+			zap.String("feedback", feedback),
+		)
+
+		reply := resp.Choices[0].Message
+		reply.ReasoningContent = ""
+		messages = append(messages, reply, models.ChatMessage{
+			Role:    "user",
+			Content: feedback,
+		})
+	}
+}
+
+// handleEmulatedResponse serves a request whose reply must be checked or
+// completed by the proxy before it reaches the client. Streaming clients get
+// the final reply replayed as SSE events.
+func (h *ProxyHandler) handleEmulatedResponse(w http.ResponseWriter, r *http.Request, req *models.ResponsesRequest, job *responseJob, log *zap.Logger) {
+	responsesResp, turn, err := h.completeResponse(r.Context(), h.client, job)
+	if err != nil {
+		h.handleCompletionError(w, r, err, log)
+		return
+	}
+	h.recordTurn(responsesResp, turn, log)
+
+	if req.Stream {
+		converter.WriteResponseStream(w, responsesResp, log)
+		return
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responsesResp)
+}
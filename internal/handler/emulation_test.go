@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/young1lin/responses2chat/internal/config"
+)
+
+// emulatedFormatRequest asks for output matching a schema with an integer a
+const emulatedFormatRequest = `{"model":"m","input":"give me a","text":{"format":{"type":"json_schema","name":"a","schema":{"type":"object","properties":{"a":{"type":"integer"}},"required":["a"]}}}}`
+
+// replyQueue is a fake upstream answering with one text reply per request,
+// repeating the last one, and recording the messages it was sent
+type replyQueue struct {
+	mu       sync.Mutex
+	replies  []string
+	requests [][]map[string]interface{}
+}
+
+func (q *replyQueue) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	q.mu.Lock()
+	q.requests = append(q.requests, req.Messages)
+	reply := q.replies[min(len(q.requests), len(q.replies))-1]
+	q.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","content":%q}}]}`, reply)
+}
+
+// withEmulatedFormat makes the proxy validate structured output itself
+func withEmulatedFormat(retries int) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.DefaultTarget.StructuredOutput = "emulate"
+		cfg.DefaultTarget.EmulationRetries = retries
+	}
+}
+
+// outputText returns the text of the first message of a response
+func outputText(t *testing.T, body map[string]interface{}) string {
+	t.Helper()
+	for _, item := range body["output"].([]interface{}) {
+		item := item.(map[string]interface{})
+		if item["type"] == "message" {
+			return item["content"].([]interface{})[0].(map[string]interface{})["text"].(string)
+		}
+	}
+	t.Fatalf("No message in %v", body)
+	return ""
+}
+
+func TestEmulatedFormat(t *testing.T) {
+	t.Run("Invalid reply is retried", func(t *testing.T) {
+		q := &replyQueue{replies: []string{"a is 1", "```json\n{\"a\": 1}\n```"}}
+		h, _ := newTestHandler(t, q.serve, withEmulatedFormat(2))
+
+		rec := serve(h, http.MethodPost, "/v1/responses", emulatedFormatRequest)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		body := decodeBody(t, rec)
+		if text := outputText(t, body); text != `{"a": 1}` {
+			t.Errorf("Expected the second reply without its code fence, got %q", text)
+		}
+
+		if len(q.requests) != 2 {
+			t.Fatalf("Expected 2 upstream requests, got %d", len(q.requests))
+		}
+		retry := q.requests[1]
+		if len(retry) != len(q.requests[0])+2 {
+			t.Fatalf("Expected the retry to add the reply and feedback, got %v", retry)
+		}
+		if reply := retry[len(retry)-2]; reply["role"] != "assistant" || reply["content"] != "a is 1" {
+			t.Errorf("Expected the invalid reply, got %v", reply)
+		}
+		if feedback := retry[len(retry)-1]; feedback["role"] != "user" || !strings.Contains(feedback["content"].(string), "not valid JSON") {
+			t.Errorf("Expected feedback on the invalid reply, got %v", feedback)
+		}
+
+		// Follow-up turns see the accepted reply, not the retries
+		follow := fmt.Sprintf(`{"model":"m","input":"thanks","previous_response_id":%q}`, body["id"])
+		if rec := serve(h, http.MethodPost, "/v1/responses", follow); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		for _, msg := range q.requests[2] {
+			if content, _ := msg["content"].(string); content == "a is 1" || strings.Contains(content, "not valid JSON") {
+				t.Errorf("Expected the retries not to be stored, got %v", msg)
+			}
+		}
+	})
+
+	t.Run("Last reply is kept once retries are exhausted", func(t *testing.T) {
+		q := &replyQueue{replies: []string{"no", "still no"}}
+		h, _ := newTestHandler(t, q.serve, withEmulatedFormat(1))
+
+		rec := serve(h, http.MethodPost, "/v1/responses", emulatedFormatRequest)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if text := outputText(t, decodeBody(t, rec)); text != "still no" {
+			t.Errorf("Expected the last reply, got %q", text)
+		}
+		if len(q.requests) != 2 {
+			t.Errorf("Expected one retry, got %d upstream requests", len(q.requests))
+		}
+	})
+}
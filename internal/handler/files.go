@@ -0,0 +1,397 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/converter"
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/internal/storage"
+)
+
+// fileFetchTimeout bounds downloads of input_file file_url parts
+const fileFetchTimeout = 60 * time.Second
+
+// fileURLClient downloads file_url parts
+var fileURLClient = newPublicClient(0)
+
+// errNotPublic is returned when a client-chosen URL resolves to a
+// non-public address
+var errNotPublic = errors.New("address is not public")
+
+// newPublicClient returns a client for URLs chosen by clients. It only
+// connects to public addresses, checked after DNS resolution and again on
+// every redirect, and never through a proxy that would hide the address.
+func newPublicClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 30 * time.Second,
+				Control: checkPublicAddress,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return checkPublicURL(req.URL)
+		},
+	}
+}
+
+// blockedPrefixes are non-public ranges the netip predicates miss
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+}
+
+// checkPublicURL accepts http and https URLs with a host
+func checkPublicURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL scheme %q is not allowed", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("URL has no host")
+	}
+	return nil
+}
+
+// checkPublicAddress rejects connections to loopback, private, link-local
+// and other non-public addresses
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("address %q is not an IP", host)
+	}
+	ip = ip.Unmap()
+
+	blocked := ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+	for _, prefix := range blockedPrefixes {
+		blocked = blocked || prefix.Contains(ip)
+	}
+	if blocked {
+		return fmt.Errorf("%w: %s", errNotPublic, ip)
+	}
+	return nil
+}
+
+// filePurposes lists the purposes accepted by the Files API
+var filePurposes = map[string]bool{
+	"assistants": true,
+	"batch":      true,
+	"fine-tune":  true,
+	"vision":     true,
+	"user_data":  true,
+	"evals":      true,
+}
+
+// maxFileBytes returns the upload limit of the Files API
+func (h *ProxyHandler) maxFileBytes() int64 {
+	size := h.config.Storage.MaxFileSize
+	if size <= 0 {
+		size = 32
+	}
+	return int64(size) << 20
+}
+
+// handleFiles routes /v1/files requests
+func (h *ProxyHandler) handleFiles(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
+	rest := r.URL.Path[strings.Index(r.URL.Path, "/v1/files")+len("/v1/files"):]
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+
+	switch {
+	case parts[0] == "":
+		switch r.Method {
+		case http.MethodPost:
+			h.handleUploadFile(w, r, log)
+		case http.MethodGet:
+			h.handleListFiles(w, r, log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGetFile(w, r, parts[0], log)
+		case http.MethodDelete:
+			h.handleDeleteFile(w, r, parts[0], log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	case len(parts) == 2 && parts[1] == "content":
+		if r.Method != http.MethodGet {
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Only GET method is allowed", log)
+			return
+		}
+		h.handleFileContent(w, r, parts[0], log)
+
+	default:
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Endpoint not found", log)
+	}
+}
+
+// handleUploadFile handles POST /v1/files with a multipart body holding
+// the file and its purpose
+func (h *ProxyHandler) handleUploadFile(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
+	maxBytes := h.maxFileBytes()
+	// Leave room for the other multipart fields
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.handleError(w, r, http.StatusRequestEntityTooLarge, "invalid_request_error", fmt.Sprintf("File exceeds the maximum size of %d bytes", maxBytes), log)
+			return
+		}
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", "A multipart file field is required", log)
+		return
+	}
+	defer file.Close()
+
+	purpose := r.FormValue("purpose")
+	if !filePurposes[purpose] {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Invalid purpose %q", purpose), log)
+		return
+	}
+
+	obj := &models.FileObject{
+		ID:        fmt.Sprintf("file-%s", strings.ReplaceAll(uuid.New().String(), "-", "")),
+		Object:    "file",
+		CreatedAt: time.Now().Unix(),
+		Filename:  header.Filename,
+		Purpose:   purpose,
+		Status:    "processed",
+	}
+	if err := h.store.SaveFile(obj, file, maxBytes); err != nil {
+		if errors.Is(err, storage.ErrFileTooLarge) {
+			h.handleError(w, r, http.StatusRequestEntityTooLarge, "invalid_request_error", fmt.Sprintf("File exceeds the maximum size of %d bytes", maxBytes), log)
+			return
+		}
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to store file: %v", err), log)
+		return
+	}
+
+	log.Info("file uploaded",
+		zap.String("file_id", obj.ID),
+		zap.String("filename", obj.Filename),
+		zap.Int64("bytes", obj.Bytes),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(obj)
+}
+
+// handleListFiles handles GET /v1/files, optionally filtered by purpose
+func (h *ProxyHandler) handleListFiles(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
+	params, err := parseListParams(r, "desc")
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	files, err := h.store.ListFiles()
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to list files: %v", err), log)
+		return
+	}
+
+	if purpose := r.URL.Query().Get("purpose"); purpose != "" {
+		filtered := files[:0]
+		for _, f := range files {
+			if f.Purpose == purpose {
+				filtered = append(filtered, f)
+			}
+		}
+		files = filtered
+	}
+
+	if err := writeList(w, files, func(f models.FileObject) string { return f.ID }, params); err != nil {
+		h.handleParseError(w, r, err, log)
+	}
+}
+
+// handleGetFile handles GET /v1/files/{id}
+func (h *ProxyHandler) handleGetFile(w http.ResponseWriter, r *http.Request, fileID string, log *zap.Logger) {
+	file, ok := h.store.GetFile(fileID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "File not found", log)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// handleFileContent handles GET /v1/files/{id}/content
+func (h *ProxyHandler) handleFileContent(w http.ResponseWriter, r *http.Request, fileID string, log *zap.Logger) {
+	file, content, ok := h.store.ReadFile(fileID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "File not found", log)
+		return
+	}
+
+	// Uploads are client-controlled, so they are served as downloads and
+	// never rendered, e.g. as HTML on this origin
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// handleDeleteFile handles DELETE /v1/files/{id}
+func (h *ProxyHandler) handleDeleteFile(w http.ResponseWriter, r *http.Request, fileID string, log *zap.Logger) {
+	found, err := h.store.DeleteFile(fileID)
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to delete file: %v", err), log)
+		return
+	}
+	if !found {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "File not found", log)
+		return
+	}
+
+	log.Info("file deleted", zap.String("file_id", fileID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.DeletedObject{
+		ID:      fileID,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+// inlineFiles replaces input_file parts, and input_image parts that
+// reference an uploaded file, with content the target provider accepts.
+// Errors name the part under param. The items passed in are not modified.
+func (h *ProxyHandler) inlineFiles(ctx context.Context, items []models.InputItem, param string) ([]models.InputItem, error) {
+	var result []models.InputItem
+	for i, item := range items {
+		var content []models.ContentItem
+		for j, part := range item.Content {
+			if part.Type != "input_file" && !(part.Type == "input_image" && part.FileID != "") {
+				continue
+			}
+
+			inlined, err := h.inlineFile(ctx, part)
+			if err != nil {
+				return nil, &models.ParamError{
+					Param:   fmt.Sprintf("%s[%d].content[%d]", param, i, j),
+					Message: err.Error(),
+				}
+			}
+			if content == nil {
+				content = append([]models.ContentItem{}, item.Content...)
+			}
+			content[j] = inlined
+		}
+
+		if content != nil {
+			if result == nil {
+				result = append([]models.InputItem{}, items...)
+			}
+			result[i].Content = content
+		}
+	}
+
+	if result == nil {
+		return items, nil
+	}
+	return result, nil
+}
+
+// inlineFile loads the file of a part and converts it
+func (h *ProxyHandler) inlineFile(ctx context.Context, part models.ContentItem) (models.ContentItem, error) {
+	var (
+		data     []byte
+		filename = part.Filename
+		err      error
+	)
+
+	switch {
+	case part.FileID != "":
+		file, content, ok := h.store.ReadFile(part.FileID)
+		if !ok {
+			return models.ContentItem{}, fmt.Errorf("file %s not found", part.FileID)
+		}
+		data = content
+		if filename == "" {
+			filename = file.Filename
+		}
+	case part.FileData != "":
+		data, err = converter.DecodeFileData(part.FileData)
+	case part.FileURL != "":
+		data, err = h.fetchFileURL(ctx, part.FileURL)
+		if filename == "" {
+			filename = part.FileURL[strings.LastIndex(part.FileURL, "/")+1:]
+		}
+	default:
+		return models.ContentItem{}, fmt.Errorf("input_file requires file_id, file_data or file_url")
+	}
+	if err != nil {
+		return models.ContentItem{}, err
+	}
+
+	return converter.FileContentItem(filename, data)
+}
+
+// fetchFileURL downloads the file of a file_url part within the upload limit
+func (h *ProxyHandler) fetchFileURL(ctx context.Context, fileURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fileFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file_url: %w", err)
+	}
+	if err := checkPublicURL(req.URL); err != nil {
+		return nil, fmt.Errorf("invalid file_url: %w", err)
+	}
+	resp, err := fileURLClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch file_url: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch file_url: status %d", resp.StatusCode)
+	}
+
+	maxBytes := h.maxFileBytes()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch file_url: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("file_url exceeds the maximum size of %d bytes", maxBytes)
+	}
+	return data, nil
+}
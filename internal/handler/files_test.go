@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFileURL(t *testing.T) {
+	t.Run("Loopback server is refused", func(t *testing.T) {
+		var fetched, upstreamCalls atomic.Int32
+		files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetched.Add(1)
+			fmt.Fprint(w, "secret")
+		}))
+		defer files.Close()
+
+		h, _ := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			fmt.Fprint(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","content":"ok"}}]}`)
+		}, nil)
+
+		body := fmt.Sprintf(`{"model":"m","input":[{"type":"message","role":"user","content":[{"type":"input_file","file_url":%q}]}]}`, files.URL+"/doc.txt")
+		rec := serve(h, http.MethodPost, "/v1/responses", body)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "not public") {
+			t.Errorf("Expected the error to name the refused address, got %s", rec.Body.String())
+		}
+		if fetched.Load() != 0 {
+			t.Error("Expected the loopback server not to be fetched")
+		}
+		if upstreamCalls.Load() != 0 {
+			t.Error("Expected no upstream call")
+		}
+	})
+
+	t.Run("Addresses", func(t *testing.T) {
+		tests := []struct {
+			address string
+			allowed bool
+		}{
+			{"127.0.0.1:80", false},
+			{"10.0.0.1:80", false},
+			{"172.16.5.4:80", false},
+			{"192.168.1.1:443", false},
+			{"169.254.169.254:80", false},
+			{"100.64.0.1:80", false},
+			{"0.0.0.0:80", false},
+			{"[::1]:80", false},
+			{"[fe80::1]:80", false},
+			{"[fc00::1]:80", false},
+			{"[::ffff:127.0.0.1]:80", false},
+			{"93.184.216.34:443", true},
+			{"[2606:4700:4700::1111]:443", true},
+		}
+		for _, tt := range tests {
+			err := checkPublicAddress("tcp", tt.address, nil)
+			if (err == nil) != tt.allowed {
+				t.Errorf("%s: expected allowed=%v, got error %v", tt.address, tt.allowed, err)
+			}
+		}
+	})
+
+	t.Run("Schemes and redirects", func(t *testing.T) {
+		for _, raw := range []string{"file:///etc/passwd", "ftp://example.com/a", "gopher://example.com", "http:///path"} {
+			u, _ := url.Parse(raw)
+			if checkPublicURL(u) == nil {
+				t.Errorf("Expected %s to be refused", raw)
+			}
+		}
+
+		redirect, _ := http.NewRequest(http.MethodGet, "file:///etc/passwd", nil)
+		via := []*http.Request{httptest.NewRequest(http.MethodGet, "https://example.com/a", nil)}
+		if fileURLClient.CheckRedirect(redirect, via) == nil {
+			t.Error("Expected a redirect to file:// to be refused")
+		}
+	})
+}
+
+func TestFileContent(t *testing.T) {
+	h, store := newTestHandler(t, nil, nil)
+	html := "<html><script>alert(document.cookie)</script></html>"
+	saveFile(t, store, "file-1", `page "1".html`, html)
+
+	rec := serve(h, http.MethodGet, "/v1/files/file-1/content", "")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for header, want := range map[string]string{
+		"Content-Type":           "application/octet-stream",
+		"X-Content-Type-Options": "nosniff",
+		"Content-Disposition":    `attachment; filename="page \"1\".html"`,
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("Expected %s %q, got %q", header, want, got)
+		}
+	}
+	if rec.Body.String() != html {
+		t.Errorf("Expected the stored content, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,462 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/converter"
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/internal/retrieval"
+	"github.com/young1lin/responses2chat/internal/storage"
+)
+
+// includeFileSearchResults is the include value asking for the results of
+// file_search_call items
+const includeFileSearchResults = "file_search_call.results"
+
+// maxFileSearchIterations caps the upstream round trips of a turn running
+// file searches
+const maxFileSearchIterations = 5
+
+// Bounds of the static chunking strategy
+const (
+	minChunkSizeTokens = 100
+	maxChunkSizeTokens = 4096
+)
+
+// FileSearchHandler indexes the files of local vector stores and handles
+// file_search tool interception
+type FileSearchHandler struct {
+	config   config.FileSearchConfig
+	store    *storage.ConversationStore
+	embedder *retrieval.Embedder // Nil unless an embeddings provider is configured
+}
+
+// NewFileSearchHandler creates a new file search handler
+func NewFileSearchHandler(cfg *config.Config, store *storage.ConversationStore) *FileSearchHandler {
+	return &FileSearchHandler{
+		config:   cfg.FileSearch,
+		store:    store,
+		embedder: retrieval.NewEmbedder(cfg.FileSearch.Embeddings),
+	}
+}
+
+// FileSearchCall represents a tracked file_search call
+type FileSearchCall struct {
+	ID      string
+	Query   string
+	Status  string
+	Results []models.FileSearchResult
+}
+
+// fileSearchRequest is the file_search tool of a request
+type fileSearchRequest struct {
+	tool           models.Tool
+	includeResults bool // Whether the client asked for file_search_call results
+}
+
+// fileSearchRequestOf checks the file_search tool of a request.
+// Returns nil if there is none.
+func (h *ProxyHandler) fileSearchRequestOf(req *models.ResponsesRequest) (*fileSearchRequest, error) {
+	var fs *fileSearchRequest
+	for i := range req.Tools {
+		tool := req.Tools[i]
+		if tool.Type != "file_search" {
+			continue
+		}
+		param := fmt.Sprintf("tools[%d]", i)
+		if fs != nil {
+			return nil, &models.ParamError{Param: param, Message: "only one file_search tool is supported"}
+		}
+		if err := converter.ValidateFileSearchTool(&tool); err != nil {
+			return nil, &models.ParamError{Param: param, Message: err.Error()}
+		}
+		for j, id := range tool.VectorStoreIDs {
+			if _, ok := h.store.GetVectorStore(id); !ok {
+				return nil, &models.ParamError{
+					Param:   fmt.Sprintf("%s.vector_store_ids[%d]", param, j),
+					Message: fmt.Sprintf("vector store %q not found", id),
+				}
+			}
+		}
+		fs = &fileSearchRequest{tool: tool}
+	}
+	if fs == nil {
+		return nil, nil
+	}
+
+	// Each of these tools is run by its own loop
+	for i, tool := range req.Tools {
+		if tool.Type == "mcp" || tool.Type == "web_search" {
+			return nil, &models.ParamError{
+				Param:   fmt.Sprintf("tools[%d]", i),
+				Message: fmt.Sprintf("file_search cannot be combined with %s tools", tool.Type),
+			}
+		}
+	}
+
+	for _, inc := range req.Include {
+		if inc == includeFileSearchResults {
+			fs.includeResults = true
+		}
+	}
+	return fs, nil
+}
+
+// chunkingStrategy validates a chunking strategy and returns it in its
+// static form; "auto" and nil use the configured chunk size
+func (h *FileSearchHandler) chunkingStrategy(s *models.ChunkingStrategy) (*models.ChunkingStrategy, error) {
+	if s == nil || s.Type == "auto" {
+		size, overlap := h.config.ChunkSize, h.config.ChunkOverlap
+		if size <= 0 {
+			size = 800
+		}
+		if overlap < 0 || overlap > size/2 {
+			overlap = size / 2
+		}
+		return &models.ChunkingStrategy{
+			Type:   "static",
+			Static: &models.StaticChunking{MaxChunkSizeTokens: size, ChunkOverlapTokens: overlap},
+		}, nil
+	}
+
+	if s.Type != "static" {
+		return nil, fmt.Errorf("invalid chunking_strategy type %q: expected auto or static", s.Type)
+	}
+	if s.Static == nil {
+		return nil, fmt.Errorf("chunking_strategy of type static requires static")
+	}
+	if size := s.Static.MaxChunkSizeTokens; size < minChunkSizeTokens || size > maxChunkSizeTokens {
+		return nil, fmt.Errorf("max_chunk_size_tokens must be between %d and %d", minChunkSizeTokens, maxChunkSizeTokens)
+	}
+	if overlap := s.Static.ChunkOverlapTokens; overlap < 0 || overlap > s.Static.MaxChunkSizeTokens/2 {
+		return nil, fmt.Errorf("chunk_overlap_tokens must not be negative or exceed half of max_chunk_size_tokens")
+	}
+	return s, nil
+}
+
+// IndexFile splits an uploaded file into chunks and adds it to a vector
+// store. Files that cannot be indexed are added with status "failed".
+// strategy must come from chunkingStrategy.
+func (h *FileSearchHandler) IndexFile(ctx context.Context, storeID, fileID string, attributes map[string]interface{}, strategy *models.ChunkingStrategy, log *zap.Logger) (*models.VectorStoreFile, error) {
+	file, content, ok := h.store.ReadFile(fileID)
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", fileID)
+	}
+
+	vsFile := &models.VectorStoreFile{
+		ID:               fileID,
+		Object:           "vector_store.file",
+		CreatedAt:        time.Now().Unix(),
+		VectorStoreID:    storeID,
+		Status:           "completed",
+		ChunkingStrategy: strategy,
+		Attributes:       attributes,
+	}
+	if existing, ok := h.store.GetVectorStoreFile(storeID, fileID); ok {
+		vsFile.CreatedAt = existing.CreatedAt
+	}
+
+	var chunks []models.VectorStoreChunk
+	if converter.IsText(content) {
+		texts := retrieval.Chunk(string(content), strategy.Static.MaxChunkSizeTokens, strategy.Static.ChunkOverlapTokens)
+		for _, text := range texts {
+			chunks = append(chunks, models.VectorStoreChunk{FileID: fileID, Filename: file.Filename, Text: text})
+		}
+		vsFile.UsageBytes = int64(len(content))
+
+		if h.embedder != nil && len(texts) > 0 {
+			vectors, err := h.embedder.Embed(ctx, texts)
+			if err != nil {
+				log.Error("failed to embed file chunks", zap.String("file_id", fileID), zap.Error(err))
+				vsFile.Status = "failed"
+				vsFile.LastError = &models.VectorStoreFileError{Code: "server_error", Message: err.Error()}
+				vsFile.UsageBytes = 0
+				chunks = nil
+			} else {
+				for i := range chunks {
+					chunks[i].Embedding = vectors[i]
+				}
+			}
+		}
+	} else {
+		vsFile.Status = "failed"
+		vsFile.LastError = &models.VectorStoreFileError{
+			Code:    "unsupported_file",
+			Message: fmt.Sprintf("file %q is not a text file", file.Filename),
+		}
+	}
+
+	if err := h.store.PutVectorStoreFile(vsFile, chunks); err != nil {
+		return nil, err
+	}
+
+	log.Info("vector store file indexed",
+		zap.String("vector_store_id", storeID),
+		zap.String("file_id", fileID),
+		zap.String("status", vsFile.Status),
+		zap.Int("chunk_count", len(chunks)),
+	)
+	return vsFile, nil
+}
+
+// Search returns the chunks of the tool's vector stores that best match the
+// query. The query is embedded only if the chunks have embeddings; if that
+// fails, the chunks are ranked with BM25 alone.
+func (h *FileSearchHandler) Search(ctx context.Context, tool *models.Tool, query string, log *zap.Logger) ([]models.FileSearchResult, error) {
+	chunks, err := h.store.GetVectorStoreChunks(tool.VectorStoreIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var queryEmbedding []float32
+	if h.embedder != nil && hasEmbeddings(chunks) {
+		vectors, err := h.embedder.Embed(ctx, []string{query})
+		if err != nil {
+			log.Warn("failed to embed file_search query, ranking with BM25 only", zap.Error(err))
+		} else {
+			queryEmbedding = vectors[0]
+		}
+	}
+
+	limit := tool.MaxNumResults
+	if limit <= 0 {
+		limit = h.config.MaxResults
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	threshold := 0.0
+	if tool.RankingOptions != nil {
+		threshold = tool.RankingOptions.ScoreThreshold
+	}
+
+	// Attributes are kept with the files, not the chunks
+	attributes := make(map[string]map[string]interface{})
+	for _, id := range tool.VectorStoreIDs {
+		files, err := h.store.ListVectorStoreFiles(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			attributes[f.ID] = f.Attributes
+		}
+	}
+
+	results := []models.FileSearchResult{}
+	for _, r := range retrieval.Rank(query, queryEmbedding, chunks, limit) {
+		if r.Score < threshold {
+			continue
+		}
+		results = append(results, models.FileSearchResult{
+			FileID:     r.Chunk.FileID,
+			Filename:   r.Chunk.Filename,
+			Score:      r.Score,
+			Text:       r.Chunk.Text,
+			Attributes: attributes[r.Chunk.FileID],
+		})
+	}
+	return results, nil
+}
+
+// hasEmbeddings reports whether any chunk has an embedding
+func hasEmbeddings(chunks []models.VectorStoreChunk) bool {
+	for _, c := range chunks {
+		if len(c.Embedding) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleWithFileSearch sends the request upstream without streaming and runs
+// the file_search calls of the replies until the model answers or calls a
+// client tool. Returns the last reply, the searches and the exchanges with
+// the file_search tool that precede the reply.
+func (h *FileSearchHandler) HandleWithFileSearch(
+	ctx context.Context,
+	client *http.Client,
+	chatReq *models.ChatCompletionRequest,
+	fs *fileSearchRequest,
+	singleToolCall bool,
+	apiKey string,
+	targetCfg *config.TargetConfig,
+	log *zap.Logger,
+) (*models.ChatCompletionResponse, []FileSearchCall, []models.ChatMessage, error) {
+	messages := make([]models.ChatMessage, len(chatReq.Messages))
+	copy(messages, chatReq.Messages)
+	var (
+		calls    []FileSearchCall
+		exchange []models.ChatMessage
+	)
+
+	for i := 0; ; i++ {
+		log.Debug("file_search iteration",
+			zap.Int("iteration", i+1),
+			zap.Int("message_count", len(messages)),
+		)
+
+		currentReq := *chatReq
+		currentReq.Messages = messages
+		currentReq.Stream = false
+		// A forced search is done once; the last request no longer offers
+		// file_search, so the model has to answer
+		if i > 0 && forcesFileSearch(chatReq.ToolChoice) {
+			currentReq.ToolChoice = nil
+		}
+		if i+1 >= maxFileSearchIterations {
+			currentReq.Tools = nil
+			for _, t := range chatReq.Tools {
+				if t.Function.Name != converter.FileSearchFunctionTool.Function.Name {
+					currentReq.Tools = append(currentReq.Tools, t)
+				}
+			}
+			if len(currentReq.Tools) == 0 {
+				currentReq.ToolChoice = nil
+				currentReq.ParallelToolCalls = nil
+			}
+		}
+
+		resp, err := sendChatCompletion(ctx, client, &currentReq, apiKey, targetCfg, log)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(resp.Choices) == 0 {
+			return resp, calls, exchange, nil
+		}
+
+		msg := &resp.Choices[0].Message
+		if singleToolCall {
+			converter.KeepFirstToolCall(msg)
+		}
+
+		// Client tool calls end the turn
+		var run, rest []models.ToolCall
+		for _, tc := range msg.ToolCalls {
+			if tc.Function.Name == converter.FileSearchFunctionTool.Function.Name {
+				run = append(run, tc)
+			} else {
+				rest = append(rest, tc)
+			}
+		}
+		if len(run) == 0 {
+			return resp, calls, exchange, nil
+		}
+		msg.ToolCalls = rest
+
+		log.Info("detected file_search calls", zap.Int("count", len(run)))
+
+		step := []models.ChatMessage{{Role: "assistant", ToolCalls: run}}
+		if len(rest) == 0 {
+			step[0].Content = msg.Content
+		}
+		for _, tc := range run {
+			var args struct {
+				Query string `json:"query"`
+			}
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				log.Error("failed to parse file_search arguments",
+					zap.Error(err),
+					zap.String("arguments", tc.Function.Arguments),
+				)
+			}
+
+			log.Info("executing file_search",
+				zap.String("query", args.Query),
+				zap.String("call_id", tc.ID),
+			)
+
+			call := FileSearchCall{
+				ID:     fmt.Sprintf("fs-%s", generateResponseID()),
+				Query:  args.Query,
+				Status: "completed",
+			}
+			var content string
+			results, err := h.Search(ctx, &fs.tool, args.Query, log)
+			if err != nil {
+				log.Error("file_search failed", zap.Error(err))
+				call.Status = "failed"
+				content = fmt.Sprintf("File search failed: %s", err.Error())
+			} else {
+				call.Results = results
+				content = converter.FormatFileSearchResults(results)
+			}
+			calls = append(calls, call)
+
+			step = append(step, models.ChatMessage{
+				Role:       "tool",
+				Content:    content,
+				ToolCallID: tc.ID,
+			})
+		}
+		exchange = append(exchange, step...)
+		messages = append(messages, step...)
+
+		// The model sees the results of searches that came with client tool
+		// calls with the next request
+		if len(rest) > 0 {
+			return resp, calls, exchange, nil
+		}
+	}
+}
+
+// forcesFileSearch reports whether a tool_choice demands a tool call that
+// file_search can satisfy
+func forcesFileSearch(choice interface{}) bool {
+	switch v := choice.(type) {
+	case string:
+		return v == "required"
+	case models.ChatToolChoice:
+		return v.Function.Name == converter.FileSearchFunctionTool.Function.Name
+	}
+	return false
+}
+
+// BuildFileSearchOutputItems builds the file_search_call items of the
+// searches; results are left out unless included
+func BuildFileSearchOutputItems(calls []FileSearchCall, includeResults bool) []models.OutputItem {
+	items := make([]models.OutputItem, 0, len(calls))
+	for _, call := range calls {
+		item := models.OutputItem{
+			Type:    "file_search_call",
+			ID:      call.ID,
+			Status:  call.Status,
+			Queries: []string{call.Query},
+		}
+		if includeResults {
+			item.Results = call.Results
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// annotateFileCitations adds file_citation annotations for the search
+// results to the output_text parts of a response
+func annotateFileCitations(resp *models.ResponsesResponse, calls []FileSearchCall) {
+	var results []models.FileSearchResult
+	for _, call := range calls {
+		results = append(results, call.Results...)
+	}
+	if len(results) == 0 {
+		return
+	}
+
+	for i := range resp.Output {
+		item := &resp.Output[i]
+		if item.Type != "message" {
+			continue
+		}
+		for j := range item.Content {
+			if item.Content[j].Type == "output_text" {
+				item.Content[j].Annotations = converter.FileCitations(item.Content[j].Text, results)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/internal/storage"
+)
+
+// saveFile adds a file to the Files API of store
+func saveFile(t *testing.T, store *storage.ConversationStore, id, filename, content string) {
+	t.Helper()
+	file := &models.FileObject{ID: id, Object: "file", Filename: filename, Purpose: "assistants"}
+	if err := store.SaveFile(file, strings.NewReader(content), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createVectorStore creates a vector store of the files and returns its ID
+func createVectorStore(t *testing.T, h *ProxyHandler, fileIDs ...string) string {
+	t.Helper()
+	ids, _ := json.Marshal(fileIDs)
+	rec := serve(h, http.MethodPost, "/v1/vector_stores", fmt.Sprintf(`{"name":"docs","file_ids":%s}`, ids))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Failed to create vector store: %d %s", rec.Code, rec.Body.String())
+	}
+	return decodeBody(t, rec)["id"].(string)
+}
+
+func TestFileSearch(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	var searchReply string // Second upstream request, with the search results
+	answer := "Laut « notes.txt » ist der Code 1234."
+	h, store := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if upstreamCalls.Add(1) == 1 {
+			fmt.Fprint(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"file_search","arguments":"{\"query\":\"launch code\"}"}}]}}]}`)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		searchReply = string(body)
+		fmt.Fprintf(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","content":%q}}]}`, answer)
+	}, nil)
+
+	saveFile(t, store, "file-notes", "notes.txt", "The launch code is 1234.")
+	storeID := createVectorStore(t, h, "file-notes")
+
+	body := fmt.Sprintf(`{"model":"m","input":"what is the launch code?","include":["file_search_call.results"],"tools":[{"type":"file_search","vector_store_ids":[%q]}]}`, storeID)
+	rec := serve(h, http.MethodPost, "/v1/responses", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if upstreamCalls.Load() != 2 {
+		t.Fatalf("Expected 2 upstream calls, got %d", upstreamCalls.Load())
+	}
+	if !strings.Contains(searchReply, "The launch code is 1234.") || !strings.Contains(searchReply, `"tool_call_id":"call_1"`) {
+		t.Errorf("Expected the passage as the result of call_1, got %s", searchReply)
+	}
+
+	var resp models.ResponsesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Output) != 2 || resp.Output[0].Type != "file_search_call" || resp.Output[1].Type != "message" {
+		t.Fatalf("Expected a file_search_call and a message, got %+v", resp.Output)
+	}
+	call := resp.Output[0]
+	if call.Status != "completed" || len(call.Queries) != 1 || call.Queries[0] != "launch code" {
+		t.Errorf("Unexpected file_search_call %+v", call)
+	}
+	if len(call.Results) != 1 || call.Results[0].FileID != "file-notes" || call.Results[0].Filename != "notes.txt" {
+		t.Errorf("Expected the included result of notes.txt, got %+v", call.Results)
+	}
+
+	annotations := resp.Output[1].Content[0].Annotations
+	// Indices count characters, not bytes, up to the end of the file name
+	wantIndex := utf8.RuneCountInString("Laut « notes.txt")
+	if len(annotations) != 1 || annotations[0].Type != "file_citation" || annotations[0].FileID != "file-notes" || annotations[0].Index != wantIndex {
+		t.Errorf("Expected a file_citation of file-notes at %d, got %+v", wantIndex, annotations)
+	}
+}
+
+func TestVectorStores(t *testing.T) {
+	h, store := newTestHandler(t, nil, nil)
+	saveFile(t, store, "file-text", "a.txt", "Some searchable text.")
+	saveFile(t, store, "file-binary", "a.bin", "\x00\x01\x02\x03")
+	storeID := createVectorStore(t, h, "file-text")
+	filesPath := "/v1/vector_stores/" + storeID + "/files"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		check  func(t *testing.T, body map[string]interface{})
+	}{
+		{"Create with an unknown file", http.MethodPost, "/v1/vector_stores", `{"file_ids":["file-missing"]}`, http.StatusBadRequest, func(t *testing.T, body map[string]interface{}) {
+			if param := body["error"].(map[string]interface{})["param"]; param != "file_ids[0]" {
+				t.Errorf("Expected param file_ids[0], got %v", param)
+			}
+		}},
+		{"Create with an invalid chunking strategy", http.MethodPost, "/v1/vector_stores", `{"chunking_strategy":{"type":"static","static":{"max_chunk_size_tokens":10}}}`, http.StatusBadRequest, nil},
+		{"Get", http.MethodGet, "/v1/vector_stores/" + storeID, "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			counts := body["file_counts"].(map[string]interface{})
+			if counts["completed"] != float64(1) || counts["total"] != float64(1) {
+				t.Errorf("Expected one completed file, got %v", counts)
+			}
+		}},
+		{"Get unknown", http.MethodGet, "/v1/vector_stores/vs_missing", "", http.StatusNotFound, nil},
+		{"List", http.MethodGet, "/v1/vector_stores", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if data := body["data"].([]interface{}); len(data) != 1 {
+				t.Errorf("Expected one vector store, got %v", data)
+			}
+		}},
+		{"Add without file_id", http.MethodPost, filesPath, `{}`, http.StatusBadRequest, nil},
+		{"Add unknown file", http.MethodPost, filesPath, `{"file_id":"file-missing"}`, http.StatusNotFound, nil},
+		{"Add to unknown store", http.MethodPost, "/v1/vector_stores/vs_missing/files", `{"file_id":"file-text"}`, http.StatusNotFound, nil},
+		{"Add binary file", http.MethodPost, filesPath, `{"file_id":"file-binary"}`, http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if body["status"] != "failed" || body["last_error"].(map[string]interface{})["code"] != "unsupported_file" {
+				t.Errorf("Expected the binary file to fail, got %v", body)
+			}
+		}},
+		{"List failed files", http.MethodGet, filesPath + "?filter=failed", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			data := body["data"].([]interface{})
+			if len(data) != 1 || data[0].(map[string]interface{})["id"] != "file-binary" {
+				t.Errorf("Expected only file-binary, got %v", data)
+			}
+		}},
+		{"Get file", http.MethodGet, filesPath + "/file-text", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if body["status"] != "completed" || body["vector_store_id"] != storeID {
+				t.Errorf("Unexpected vector store file %v", body)
+			}
+		}},
+		{"Delete file", http.MethodDelete, filesPath + "/file-text", "", http.StatusOK, func(t *testing.T, body map[string]interface{}) {
+			if body["deleted"] != true || body["object"] != "vector_store.file.deleted" {
+				t.Errorf("Unexpected deletion %v", body)
+			}
+		}},
+		{"Get deleted file", http.MethodGet, filesPath + "/file-text", "", http.StatusNotFound, nil},
+		{"Delete deleted file", http.MethodDelete, filesPath + "/file-text", "", http.StatusNotFound, nil},
+		{"Unsupported method", http.MethodPut, "/v1/vector_stores/" + storeID, "", http.StatusMethodNotAllowed, nil},
+		{"Delete", http.MethodDelete, "/v1/vector_stores/" + storeID, "", http.StatusOK, nil},
+		{"Get deleted", http.MethodGet, "/v1/vector_stores/" + storeID, "", http.StatusNotFound, nil},
+		{"List files of deleted", http.MethodGet, filesPath, "", http.StatusNotFound, nil},
+	}
+
+	// Steps build on each other, so they run in order
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, tt.method, tt.path, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.check != nil {
+				tt.check(t, decodeBody(t, rec))
+			}
+		})
+	}
+
+	if _, ok := store.GetFile("file-text"); !ok {
+		t.Error("Expected the file to stay in the Files API")
+	}
+}
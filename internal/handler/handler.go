@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -23,11 +25,16 @@ import (
 
 // ProxyHandler handles the proxy requests
 type ProxyHandler struct {
-	config           *config.Config
-	client           *http.Client
-	store            *storage.ConversationStore
-	searchManager    *search.Manager
-	webSearchHandler *WebSearchHandler
+	config                 *config.Config
+	client                 *http.Client
+	mcpClient              *http.Client // Reaches the MCP servers named in requests, public addresses only
+	store                  *storage.ConversationStore
+	sealer                 *storage.StateSealer // Nil unless sealed conversation state is enabled
+	searchManager          *search.Manager
+	webSearchHandler       *WebSearchHandler
+	fileSearchHandler      *FileSearchHandler
+	codeInterpreterHandler *CodeInterpreterHandler // Nil unless the code_interpreter sandbox is enabled
+	background             *backgroundRunner
 }
 
 // contextKey is used for context values
@@ -47,6 +54,20 @@ func NewProxyHandler(cfg *config.Config, store *storage.ConversationStore, searc
 				return http.ErrUseLastResponse
 			},
 		},
+		mcpClient: newPublicClient(mcpTimeout),
+	}
+
+	// Initialize the sealer for clients that keep conversation state themselves
+	if cfg.Storage.Stateless || cfg.Storage.EncryptionKey != "" {
+		sealer, err := storage.NewStateSealer(cfg.Storage.EncryptionKey)
+		if err != nil {
+			logger.Error("failed to init state sealer", zap.Error(err))
+		} else {
+			h.sealer = sealer
+			if cfg.Storage.EncryptionKey == "" {
+				logger.Warn("storage.encryption_key not set, sealed conversation state will not survive restarts")
+			}
+		}
 	}
 
 	// Initialize web search handler if search manager is available
@@ -54,6 +75,24 @@ func NewProxyHandler(cfg *config.Config, store *storage.ConversationStore, searc
 		h.webSearchHandler = NewWebSearchHandler(cfg, searchManager)
 	}
 
+	// Local vector stores back the file_search tool
+	h.fileSearchHandler = NewFileSearchHandler(cfg, store)
+
+	// Running model code on this host is opt-in
+	if cfg.CodeInterpreter.Enabled {
+		ci, err := NewCodeInterpreterHandler(cfg, store, h.maxFileBytes())
+		if err != nil {
+			logger.Error("failed to init code_interpreter sandbox", zap.Error(err))
+		} else {
+			h.codeInterpreterHandler = ci
+		}
+	}
+
+	// Start background response workers
+	if store != nil {
+		h.background = newBackgroundRunner(h, cfg.Background)
+	}
+
 	return h
 }
 
@@ -91,15 +130,32 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleProviders(w, r, log)
 	case strings.HasSuffix(r.URL.Path, "/v1/responses"):
 		h.handleResponses(w, r, log)
-	default:
-		// Handle GET /v1/responses/{id} for history lookup
-		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/v1/responses/") {
-			responseID := extractResponseID(r.URL.Path)
-			if responseID != "" {
-				h.handleGetResponse(w, r, responseID, log)
-				return
-			}
+	case strings.Contains(r.URL.Path, "/v1/conversations"):
+		h.handleConversations(w, r, log)
+	case strings.Contains(r.URL.Path, "/v1/files"):
+		h.handleFiles(w, r, log)
+	case strings.Contains(r.URL.Path, "/v1/vector_stores"):
+		h.handleVectorStores(w, r, log)
+	case strings.Contains(r.URL.Path, "/v1/responses/"):
+		responseID, action := splitResponsePath(r.URL.Path)
+		switch {
+		case responseID == "":
+			h.handleError(w, r, http.StatusNotFound, "not_found", "Endpoint not found", log)
+		case action == "" && r.Method == http.MethodGet:
+			// GET /v1/responses/{id} for history lookup and background polling
+			h.handleGetResponse(w, r, responseID, log)
+		case action == "" && r.Method == http.MethodDelete:
+			h.handleDeleteResponse(w, r, responseID, log)
+		case action == "input_items" && r.Method == http.MethodGet:
+			h.handleListInputItems(w, r, responseID, log)
+		case action == "cancel" && r.Method == http.MethodPost && h.background != nil:
+			h.handleCancelResponse(w, r, responseID, log)
+		case action == "" || action == "cancel" || action == "input_items":
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		default:
+			h.handleError(w, r, http.StatusNotFound, "not_found", "Endpoint not found", log)
 		}
+	default:
 		h.handleError(w, r, http.StatusNotFound, "not_found", "Endpoint not found", log)
 	}
 
@@ -112,11 +168,18 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // handleHealth handles health check requests
 func (h *ProxyHandler) handleHealth(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	health := map[string]interface{}{
 		"status":    "healthy",
 		"timestamp": time.Now().Unix(),
-	})
+	}
+	if h.searchManager != nil {
+		if stats, ok := h.searchManager.CacheStats(); ok {
+			health["search_cache"] = stats
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
 }
 
 // handleProviders handles provider list requests
@@ -126,17 +189,29 @@ func (h *ProxyHandler) handleProviders(w http.ResponseWriter, r *http.Request, l
 		providers = append(providers, name)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	resp := map[string]interface{}{
 		"providers": providers,
 		"default":   h.config.DefaultTarget.BaseURL,
-	})
+	}
+	if h.searchManager != nil {
+		resp["search_providers"] = h.searchManager.ProviderStats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
 // handleGetResponse handles GET /v1/responses/{id} to retrieve conversation history
 func (h *ProxyHandler) handleGetResponse(w http.ResponseWriter, r *http.Request, responseID string, log *zap.Logger) {
 	log.Info("retrieving conversation history", zap.String("response_id", responseID))
 
+	// Background responses keep a full record with their live status
+	if record, ok := h.store.GetResponse(responseID); ok {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
+		return
+	}
+
 	messages, ok := h.store.Get(responseID)
 	if !ok {
 		h.handleError(w, r, http.StatusNotFound, "not_found", "Response not found", log)
@@ -149,7 +224,7 @@ func (h *ProxyHandler) handleGetResponse(w http.ResponseWriter, r *http.Request,
 		Object:    "response",
 		CreatedAt: time.Now().Unix(),
 		Status:    "completed",
-		Output:    convertMessagesToOutput(messages),
+		Output:    convertMessagesToOutput(responseID, messages),
 	}
 
 	log.Info("conversation history retrieved",
@@ -161,14 +236,50 @@ func (h *ProxyHandler) handleGetResponse(w http.ResponseWriter, r *http.Request,
 	json.NewEncoder(w).Encode(resp)
 }
 
-// convertMessagesToOutput converts ChatMessage slice to OutputItem slice
-func convertMessagesToOutput(messages []models.ChatMessage) []models.OutputItem {
+// convertMessagesToOutput converts the stored history of a response to
+// OutputItem slice
+func convertMessagesToOutput(responseID string, messages []models.ChatMessage) []models.OutputItem {
+	// web_search calls are shown as web_search_call items, which stand for
+	// their tool results as well
+	webSearchResults := webSearchToolResults(messages)
+
+	// Reasoning of this response keeps the ID it was streamed with, one
+	// per upstream round; reasoning of earlier turns gets one derived from it
+	itemID := strings.TrimPrefix(responseID, "resp-")
+	turnStart := 0
+	for i, msg := range messages {
+		if msg.Role == "user" {
+			turnStart = i
+		}
+	}
+	round := 0
+
 	var output []models.OutputItem
 	for i, msg := range messages {
 		// Skip system messages in output
 		if msg.Role == "system" {
 			continue
 		}
+		if _, ok := webSearchResults[msg.ToolCallID]; ok && msg.Role == "tool" {
+			continue
+		}
+
+		// Reasoning precedes the answer it produced
+		if msg.ReasoningContent != "" {
+			var reasoningID string
+			switch {
+			case i < turnStart:
+				reasoningID = fmt.Sprintf("rs-%s-history-%d", itemID, i)
+			case round == 0:
+				reasoningID = fmt.Sprintf("rs-%s", itemID)
+			default:
+				reasoningID = fmt.Sprintf("rs-%s-%d", itemID, round)
+			}
+			output = append(output, converter.BuildReasoningItem(reasoningID, msg.ReasoningContent))
+		}
+		if msg.Role == "assistant" && i > turnStart {
+			round++
+		}
 
 		item := models.OutputItem{
 			ID:   fmt.Sprintf("msg_%d", i),
@@ -189,6 +300,10 @@ func convertMessagesToOutput(messages []models.ChatMessage) []models.OutputItem
 		// Handle tool calls
 		if len(msg.ToolCalls) > 0 {
 			for _, tc := range msg.ToolCalls {
+				if result, ok := webSearchResults[tc.ID]; ok {
+					output = append(output, storedWebSearchItem(tc, result))
+					continue
+				}
 				toolItem := models.OutputItem{
 					Type:      "function_call",
 					ID:        fmt.Sprintf("fc_%d", i),
@@ -209,20 +324,15 @@ func convertMessagesToOutput(messages []models.ChatMessage) []models.OutputItem
 	return output
 }
 
-// extractResponseID extracts response ID from URL path
-func extractResponseID(path string) string {
-	// Handle /v1/responses/{id}
-	if strings.HasPrefix(path, "/v1/responses/") {
-		return strings.TrimPrefix(path, "/v1/responses/")
-	}
-	// Handle /{provider}/v1/responses/{id}
-	parts := strings.Split(path, "/")
-	for i, p := range parts {
-		if p == "responses" && i+1 < len(parts) {
-			return parts[i+1]
-		}
+// splitResponsePath extracts the response ID and the optional action from
+// /v1/responses/{id}[/{action}] or /{provider}/v1/responses/{id}[/{action}]
+func splitResponsePath(path string) (string, string) {
+	rest := path[strings.Index(path, "/v1/responses/")+len("/v1/responses/"):]
+	parts := strings.SplitN(strings.Trim(rest, "/"), "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
 	}
-	return ""
+	return parts[0], ""
 }
 
 // handleResponses handles /v1/responses requests
@@ -250,7 +360,7 @@ func (h *ProxyHandler) handleResponses(w http.ResponseWriter, r *http.Request, l
 	// Parse Responses API request
 	var req models.ResponsesRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		h.handleError(w, r, http.StatusBadRequest, "parse_error", fmt.Sprintf("Failed to parse request: %v", err), log)
+		h.handleParseError(w, r, err, log)
 		return
 	}
 
@@ -261,9 +371,56 @@ func (h *ProxyHandler) handleResponses(w http.ResponseWriter, r *http.Request, l
 		zap.String("previous_response_id", req.PreviousResponseID),
 	)
 
-	// Get history if previous_response_id is provided
-	var history []models.ChatMessage
-	if req.PreviousResponseID != "" {
+	turn := h.turnOptionsFor(&req)
+
+	// Sealed state sent back by the client takes precedence over
+	// previous_response_id
+	history, input, sealed := h.restoreSealedState(req.Input, log)
+
+	// Resolve item_reference items and give every item a stable ID
+	req.Input, err = h.resolveInputItems(input)
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+	turn.input = req.Input
+
+	// Inline file parts for the provider; stored items keep the references
+	req.Input, err = h.inlineFiles(r.Context(), req.Input, "input")
+	if err != nil {
+		h.handleParseError(w, r, err, log)
+		return
+	}
+
+	turn.conversation, err = conversationIDOf(req.Conversation)
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	// Get history from the conversation or by previous_response_id
+	switch {
+	case turn.conversation != "":
+		if req.PreviousResponseID != "" {
+			h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", "previous_response_id cannot be used together with conversation", log)
+			return
+		}
+		items, found := h.store.GetConversationItems(turn.conversation)
+		if !found {
+			h.handleError(w, r, http.StatusNotFound, "not_found", fmt.Sprintf("Conversation %s not found", turn.conversation), log)
+			return
+		}
+		items, err = h.inlineFiles(r.Context(), items, "items")
+		if err != nil {
+			h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Conversation %s: %v", turn.conversation, err), log)
+			return
+		}
+		history = converter.ConvertInputItems(items, targetCfg.SupportsDeveloperRole)
+		log.Info("loaded conversation items",
+			zap.String("conversation_id", turn.conversation),
+			zap.Int("item_count", len(items)),
+		)
+	case !sealed && req.PreviousResponseID != "" && !h.config.Storage.Stateless:
 		var found bool
 		history, found = h.store.Get(req.PreviousResponseID)
 		if found {
@@ -278,12 +435,56 @@ func (h *ProxyHandler) handleResponses(w http.ResponseWriter, r *http.Request, l
 		}
 	}
 
+	// Remote MCP servers are listed up front, approved calls are run before
+	// the model sees them
+	mcpTools, err := h.connectMCPServers(r.Context(), req.Tools, log)
+	if err != nil {
+		var paramErr *models.ParamError
+		if errors.As(err, &paramErr) {
+			h.handleParseError(w, r, err, log)
+			return
+		}
+		h.handleError(w, r, http.StatusFailedDependency, "mcp_error", err.Error(), log)
+		return
+	}
+	req.Input, err = h.resolveMCPApprovals(r.Context(), mcpTools, req.Input, log)
+	if err != nil {
+		h.handleParseError(w, r, err, log)
+		return
+	}
+	fileSearch, err := h.fileSearchRequestOf(&req)
+	if err != nil {
+		h.handleParseError(w, r, err, log)
+		return
+	}
+	codeInterpreter, err := h.codeInterpreterRequestOf(&req, history, turn.conversation)
+	if err != nil {
+		h.handleParseError(w, r, err, log)
+		return
+	}
+
 	// Convert to Chat Completions format with history
 	chatReq, hasWebSearch := converter.ConvertRequest(&req, h.config.ModelMapping, history, targetCfg.SupportsDeveloperRole)
+	if mcpTools != nil {
+		chatReq.Tools = append(chatReq.Tools, mcpTools.tools...)
+	}
+	// Vendor switches from the provider config and the request metadata
+	chatReq.ExtraBody, err = converter.MergeExtraBody(targetCfg.ExtraBody, req.Metadata)
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	plan, err := buildEmulationPlan(&req, chatReq, targetCfg)
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
 	log.Debug("converted request",
 		zap.String("model", chatReq.Model),
 		zap.Int("message_count", len(chatReq.Messages)),
 		zap.Bool("has_web_search", hasWebSearch),
+		zap.Bool("emulated", plan.active()),
 	)
 
 	// Get API Key - prefer default_api_key from config if available
@@ -301,6 +502,14 @@ func (h *ProxyHandler) handleResponses(w http.ResponseWriter, r *http.Request, l
 		return
 	}
 
+	// Containers are only created for turns that are run
+	if codeInterpreter != nil {
+		if err := h.codeInterpreterHandler.prepareContainer(codeInterpreter); err != nil {
+			h.handleError(w, r, http.StatusInternalServerError, "server_error", err.Error(), log)
+			return
+		}
+	}
+
 	// Build target URL
 	targetURL := targetCfg.BaseURL + targetCfg.PathSuffix
 	log.Info("sending request to target",
@@ -318,37 +527,93 @@ func (h *ProxyHandler) handleResponses(w http.ResponseWriter, r *http.Request, l
 		log.Debug("tools being sent", zap.Strings("tool_names", toolNames))
 	}
 
+	job := &responseJob{
+		responseID:       generateResponseID(),
+		chatReq:          chatReq,
+		hasWebSearch:     hasWebSearch,
+		webSearchSources: slices.Contains(req.Include, includeWebSearchSources),
+		plan:             plan,
+		mcp:              mcpTools,
+		fileSearch:       fileSearch,
+		codeInterpreter:  codeInterpreter,
+		turn:             turn,
+		apiKey:           apiKey,
+		targetCfg:        targetCfg,
+		log:              log,
+	}
+	if traceID, ok := r.Context().Value(traceIDKey).(string); ok {
+		job.traceID = traceID
+	}
+
+	// Background responses run detached from this request
+	if req.Background {
+		if h.background == nil {
+			h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", "Background responses require storage", log)
+			return
+		}
+		h.handleBackgroundResponse(w, r, &req, job, log)
+		return
+	}
+
+	// MCP tools are run by the proxy between upstream requests
+	if job.mcp != nil {
+		log.Info("running MCP tools for request")
+		h.handleEmulatedResponse(w, r, &req, job, log)
+		return
+	}
+
+	// file_search runs against local vector stores between upstream requests
+	if job.fileSearch != nil {
+		log.Info("using file_search handler for request")
+		h.handleEmulatedResponse(w, r, &req, job, log)
+		return
+	}
+
+	// code_interpreter code runs in the local sandbox between upstream requests
+	if job.codeInterpreter != nil {
+		log.Info("using code_interpreter handler for request")
+		h.handleEmulatedResponse(w, r, &req, job, log)
+		return
+	}
+
 	// Check if we should handle web_search tool
 	if hasWebSearch && h.webSearchHandler != nil && h.webSearchHandler.HasWebSearchCapability() {
 		log.Info("using web_search handler for request")
 
-		// Generate response ID
-		responseID := generateResponseID()
+		responseID := job.responseID
 
 		if req.Stream {
-			result := h.webSearchHandler.HandleStreamingWithWebSearch(w, r, chatReq, apiKey, targetCfg, responseID, log)
-			if result != nil {
-				// Store complete conversation history
+			// Store complete conversation history before response.completed
+			finish := func(result *StreamingResult, itemID string) []models.OutputItem {
 				completeMessages := make([]models.ChatMessage, len(chatReq.Messages))
 				copy(completeMessages, chatReq.Messages)
+				completeMessages = append(completeMessages, result.Exchange...)
 				completeMessages = append(completeMessages, result.AssistantMsg)
 
+				replyTurn := storedWebSearchTurn(turn, result.WebSearchCalls)
+				replyTurn.replyID = itemID
 				fullResponseID := fmt.Sprintf("resp-%s", responseID)
-				if err := h.store.Store(fullResponseID, completeMessages); err != nil {
-					log.Error("failed to store streaming web_search conversation history", zap.Error(err))
-				} else {
-					log.Info("stored streaming web_search conversation history",
-						zap.String("response_id", fullResponseID),
-						zap.Int("message_count", len(completeMessages)),
-					)
+				if state := h.finishTurn(fullResponseID, completeMessages, replyTurn, log); state != nil {
+					return []models.OutputItem{*state}
 				}
+				return nil
+			}
+			if _, err := h.webSearchHandler.HandleStreamingWithWebSearch(w, r, chatReq, apiKey, targetCfg, responseID, conversationRef(turn.conversation), job.webSearchSources, finish, log); err != nil {
+				h.handleCompletionError(w, r, err, log)
 			}
 		} else {
-			h.handleNonStreamingWithWebSearch(w, r, chatReq, apiKey, targetCfg, responseID, log)
+			h.handleNonStreamingWithWebSearch(w, r, chatReq, apiKey, targetCfg, responseID, job.webSearchSources, turn, log)
 		}
 		return
 	}
 
+	// Provider lacks native support for some requested semantics
+	if plan.active() {
+		log.Info("emulating unsupported request options")
+		h.handleEmulatedResponse(w, r, &req, job, log)
+		return
+	}
+
 	// Standard request handling without web_search interception
 	// Marshal request
 	chatReqBody, err := json.Marshal(chatReq)
@@ -397,67 +662,83 @@ func (h *ProxyHandler) handleResponses(w http.ResponseWriter, r *http.Request, l
 		return
 	}
 
-	// Generate response ID
-	responseID := generateResponseID()
+	responseID := job.responseID
 
 	if req.Stream {
-		h.handleStreamingResponse(w, r, resp, responseID, chatReq.Messages, log)
+		h.handleStreamingResponse(w, r, resp, responseID, chatReq, plan, turn, log)
 	} else {
-		h.handleNonStreamingResponse(w, r, resp, responseID, chatReq.Messages, log)
+		h.handleNonStreamingResponse(w, r, resp, responseID, chatReq, plan, turn, log)
 	}
 }
 
 // handleStreamingResponse handles streaming responses
-func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, responseID string, requestMessages []models.ChatMessage, log *zap.Logger) {
-	// Handle streaming and collect result
-	result := converter.HandleStreamingResponse(resp, w, responseID, log)
-	if result == nil {
-		return
+func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, responseID string, chatReq *models.ChatCompletionRequest, plan *emulationPlan, turn turnOptions, log *zap.Logger) {
+	// Handle streaming; the turn is recorded before response.completed so
+	// the client can continue from it right away
+	opts := converter.StreamOptions{
+		Model:          chatReq.Model,
+		Conversation:   conversationRef(turn.conversation),
+		SingleToolCall: plan.singleToolCall(),
+		FinalItems: func(result *converter.StreamResult) []models.OutputItem {
+			return h.finishStreamingTurn(responseID, chatReq.Messages, result, turn, log)
+		},
+		ToolTypes: chatReq.ToolTypes,
 	}
+	converter.HandleStreamingResponse(resp, w, responseID, opts, log)
+}
 
+// finishStreamingTurn records a streamed turn and returns the extra output
+// items to emit, if any
+func (h *ProxyHandler) finishStreamingTurn(responseID string, requestMessages []models.ChatMessage, result *converter.StreamResult, turn turnOptions, log *zap.Logger) []models.OutputItem {
 	// Store complete conversation history
 	completeMessages := make([]models.ChatMessage, len(requestMessages))
 	copy(completeMessages, requestMessages)
 
 	// Build assistant message from streaming result
+	assistantMsg := streamedAssistantMessage(result)
+	completeMessages = append(completeMessages, assistantMsg)
+
+	// Store with "resp-" prefix to match the response ID format
+	fullResponseID := fmt.Sprintf("resp-%s", responseID)
+	if state := h.finishTurn(fullResponseID, completeMessages, turn, log); state != nil {
+		return []models.OutputItem{*state}
+	}
+	return nil
+}
+
+// streamedAssistantMessage rebuilds the assistant message of a streamed turn,
+// in the form replies are stored
+func streamedAssistantMessage(result *converter.StreamResult) models.ChatMessage {
 	assistantMsg := models.ChatMessage{
-		Role:    "assistant",
-		Content: result.OutputText,
+		Role:             "assistant",
+		Content:          result.OutputText,
+		ReasoningContent: result.ReasoningText,
 	}
 
 	// Add tool calls if any
-	if len(result.ToolCalls) > 0 {
-		for _, tc := range result.ToolCalls {
-			assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, models.ToolCall{
-				ID:   tc.CallID,
-				Type: "function",
-				Function: struct {
-					Name      string `json:"name"`
-					Arguments string `json:"arguments"`
-				}{
-					Name:      tc.Name,
-					Arguments: tc.Arguments,
-				},
-			})
+	for _, tc := range result.ToolCalls {
+		toolCall := models.ToolCall{
+			ID:   tc.CallID,
+			Type: "function",
 		}
+		toolCall.Function.Name = tc.Name
+		toolCall.Function.Arguments = tc.Arguments
+		switch tc.Type {
+		case "custom_tool_call":
+			toolCall.Type = converter.ToolTypeCustom
+			toolCall.Function.Arguments = tc.Input
+		case "local_shell_call":
+			toolCall.Type = converter.ToolTypeLocalShell
+			toolCall.Function.Name = converter.LocalShellFunctionTool.Function.Name
+			toolCall.Function.Arguments = converter.LocalShellArguments(tc.Action)
+		}
+		assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, toolCall)
 	}
-
-	completeMessages = append(completeMessages, assistantMsg)
-
-	// Store with "resp-" prefix to match the response ID format
-	fullResponseID := fmt.Sprintf("resp-%s", responseID)
-	if err := h.store.Store(fullResponseID, completeMessages); err != nil {
-		log.Error("failed to store streaming conversation history", zap.Error(err))
-	} else {
-		log.Info("stored streaming conversation history",
-			zap.String("response_id", fullResponseID),
-			zap.Int("message_count", len(completeMessages)),
-		)
-	}
+	return assistantMsg
 }
 
 // handleNonStreamingResponse handles non-streaming responses
-func (h *ProxyHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, responseID string, requestMessages []models.ChatMessage, log *zap.Logger) {
+func (h *ProxyHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, responseID string, chatReq *models.ChatCompletionRequest, plan *emulationPlan, turn turnOptions, log *zap.Logger) {
 	// Read response body
 	body, err := converter.ReadResponseBody(resp.Body, 10*1024*1024) // 10MB limit
 	if err != nil {
@@ -474,8 +755,16 @@ func (h *ProxyHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http
 		return
 	}
 
+	if len(chatResp.Choices) > 0 {
+		if plan.singleToolCall() {
+			converter.KeepFirstToolCall(&chatResp.Choices[0].Message)
+		}
+		converter.UnwrapToolCalls(&chatResp.Choices[0].Message, chatReq.ToolTypes)
+	}
+
 	// Convert to Responses API format
 	responsesResp := converter.ConvertResponse(&chatResp, responseID)
+	responsesResp.Conversation = conversationRef(turn.conversation)
 
 	log.Info("response converted",
 		zap.String("response_id", responsesResp.ID),
@@ -485,8 +774,8 @@ func (h *ProxyHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http
 	)
 
 	// Store complete conversation history
-	completeMessages := make([]models.ChatMessage, len(requestMessages))
-	copy(completeMessages, requestMessages)
+	completeMessages := make([]models.ChatMessage, len(chatReq.Messages))
+	copy(completeMessages, chatReq.Messages)
 
 	// Add assistant response to history
 	if len(chatResp.Choices) > 0 {
@@ -494,13 +783,8 @@ func (h *ProxyHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http
 		completeMessages = append(completeMessages, assistantMsg)
 	}
 
-	if err := h.store.Store(responsesResp.ID, completeMessages); err != nil {
-		log.Error("failed to store conversation history", zap.Error(err))
-	} else {
-		log.Info("stored conversation history",
-			zap.String("response_id", responsesResp.ID),
-			zap.Int("message_count", len(completeMessages)),
-		)
+	if state := h.finishTurn(responsesResp.ID, completeMessages, turn, log); state != nil {
+		responsesResp.Output = append(responsesResp.Output, *state)
 	}
 
 	// Send response
@@ -517,19 +801,25 @@ func (h *ProxyHandler) handleNonStreamingWithWebSearch(
 	apiKey string,
 	targetCfg *config.TargetConfig,
 	responseID string,
+	includeSources bool,
+	turn turnOptions,
 	log *zap.Logger,
 ) {
 	ctx := r.Context()
 
 	// Use web search handler to process the request
-	chatResp, webSearchCalls, err := h.webSearchHandler.HandleWithWebSearch(ctx, chatReq, apiKey, targetCfg, log)
+	chatResp, webSearchCalls, exchange, err := h.webSearchHandler.HandleWithWebSearch(ctx, chatReq, apiKey, targetCfg, log)
 	if err != nil {
-		h.handleError(w, r, http.StatusBadGateway, "web_search_error", fmt.Sprintf("Web search handling failed: %v", err), log)
+		h.handleCompletionError(w, r, err, log)
 		return
 	}
+	if len(chatResp.Choices) > 0 {
+		converter.UnwrapToolCalls(&chatResp.Choices[0].Message, chatReq.ToolTypes)
+	}
 
 	// Convert to Responses API format with web_search_call items
-	responsesResp := ConvertResponseWithWebSearch(chatResp, responseID, webSearchCalls)
+	responsesResp := ConvertResponseWithWebSearch(chatResp, responseID, webSearchCalls, includeSources)
+	responsesResp.Conversation = conversationRef(turn.conversation)
 
 	log.Info("web_search response converted",
 		zap.String("response_id", responsesResp.ID),
@@ -537,9 +827,11 @@ func (h *ProxyHandler) handleNonStreamingWithWebSearch(
 		zap.Int("web_search_calls", len(webSearchCalls)),
 	)
 
-	// Store complete conversation history
+	// Store complete conversation history with the web_search exchanges, so
+	// that follow-up turns keep the search results the reply was built on
 	completeMessages := make([]models.ChatMessage, len(chatReq.Messages))
 	copy(completeMessages, chatReq.Messages)
+	completeMessages = append(completeMessages, exchange...)
 
 	// Add assistant response to history
 	if len(chatResp.Choices) > 0 {
@@ -547,13 +839,8 @@ func (h *ProxyHandler) handleNonStreamingWithWebSearch(
 		completeMessages = append(completeMessages, assistantMsg)
 	}
 
-	if err := h.store.Store(responsesResp.ID, completeMessages); err != nil {
-		log.Error("failed to store conversation history", zap.Error(err))
-	} else {
-		log.Info("stored conversation history",
-			zap.String("response_id", responsesResp.ID),
-			zap.Int("message_count", len(completeMessages)),
-		)
+	if state := h.finishTurn(responsesResp.ID, completeMessages, storedWebSearchTurn(turn, webSearchCalls), log); state != nil {
+		responsesResp.Output = append(responsesResp.Output, *state)
 	}
 
 	// Send response
@@ -565,8 +852,13 @@ func (h *ProxyHandler) handleNonStreamingWithWebSearch(
 // handleUpstreamError handles upstream errors
 func (h *ProxyHandler) handleUpstreamError(w http.ResponseWriter, r *http.Request, resp *http.Response, log *zap.Logger) {
 	body, _ := io.ReadAll(resp.Body)
+	h.writeUpstreamError(w, resp.StatusCode, body, log)
+}
+
+// writeUpstreamError writes an upstream error body in Responses API format
+func (h *ProxyHandler) writeUpstreamError(w http.ResponseWriter, statusCode int, body []byte, log *zap.Logger) {
 	log.Error("upstream error",
-		zap.Int("status", resp.StatusCode),
+		zap.Int("status", statusCode),
 		zap.String("body", string(body)),
 	)
 
@@ -587,11 +879,11 @@ func (h *ProxyHandler) handleUpstreamError(w http.ResponseWriter, r *http.Reques
 
 	// Return error in Responses API format
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
+	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(models.ErrorResponse{
 		Error: models.ErrorDetail{
 			Type:    "upstream_error",
-			Code:    fmt.Sprintf("%d", resp.StatusCode),
+			Code:    fmt.Sprintf("%d", statusCode),
 			Message: errorMsg,
 		},
 	})
@@ -615,6 +907,39 @@ func (h *ProxyHandler) handleError(w http.ResponseWriter, r *http.Request, statu
 	})
 }
 
+// handleParseError reports a request body that could not be parsed, naming
+// the invalid parameter when it is known
+func (h *ProxyHandler) handleParseError(w http.ResponseWriter, r *http.Request, err error, log *zap.Logger) {
+	detail := models.ErrorDetail{
+		Type:    "parse_error",
+		Message: fmt.Sprintf("Failed to parse request: %v", err),
+	}
+
+	var paramErr *models.ParamError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &paramErr):
+		detail.Type = "invalid_request_error"
+		detail.Param = paramErr.Param
+		detail.Message = fmt.Sprintf("Invalid value for '%s': %s", paramErr.Param, paramErr.Message)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		detail.Type = "invalid_request_error"
+		detail.Param = typeErr.Field
+		detail.Message = fmt.Sprintf("Invalid type for '%s': expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+
+	log.Error("request error",
+		zap.String("error_type", detail.Type),
+		zap.String("param", detail.Param),
+		zap.String("message", detail.Message),
+		zap.Int("status", http.StatusBadRequest),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(models.ErrorResponse{Error: detail})
+}
+
 // parseProvider parses the provider from URL path or header
 func (h *ProxyHandler) parseProvider(r *http.Request) string {
 	// Check X-Target-Provider header first
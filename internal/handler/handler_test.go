@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/search"
+	"github.com/young1lin/responses2chat/internal/storage"
+	"github.com/young1lin/responses2chat/pkg/logger"
+)
+
+func init() {
+	logger.Init("error", "text")
+}
+
+// newTestHandler creates a proxy handler whose default target is upstream.
+// configure may adjust the config before the handler is created.
+func newTestHandler(t *testing.T, upstream http.HandlerFunc, configure func(cfg *config.Config)) (*ProxyHandler, *storage.ConversationStore) {
+	t.Helper()
+
+	up := httptest.NewServer(upstream)
+	t.Cleanup(up.Close)
+
+	store, err := storage.NewConversationStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	cfg := &config.Config{
+		DefaultTarget: config.TargetConfig{BaseURL: up.URL, PathSuffix: "/chat/completions", Timeout: 10, DefaultAPIKey: "test-key"},
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	var searchManager *search.Manager
+	if cfg.WebSearch.Enabled {
+		searchManager = search.NewManager(&cfg.WebSearch, nil)
+	}
+	return NewProxyHandler(cfg, store, searchManager), store
+}
+
+// serve sends a request to the handler and returns the recorded response
+func serve(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+	return rec
+}
+
+// decodeBody decodes a JSON response body
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON response %q: %v", rec.Body.String(), err)
+	}
+	return body
+}
+
+func TestGetResponseReasoningIDs(t *testing.T) {
+	h, _ := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","reasoning_content":"think","content":"ok"}}]}`)
+	}, nil)
+
+	first := decodeBody(t, serve(h, http.MethodPost, "/v1/responses", `{"model":"m","input":"hi"}`))
+	rec := serve(h, http.MethodPost, "/v1/responses", fmt.Sprintf(`{"model":"m","input":"again","previous_response_id":%q}`, first["id"]))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	live := decodeBody(t, rec)
+	id := live["id"].(string)
+	liveReasoning := live["output"].([]interface{})[0].(map[string]interface{})
+
+	rec = serve(h, http.MethodGet, "/v1/responses/"+id, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var reasoningIDs []string
+	for _, item := range decodeBody(t, rec)["output"].([]interface{}) {
+		if item := item.(map[string]interface{}); item["type"] == "reasoning" {
+			reasoningIDs = append(reasoningIDs, item["id"].(string))
+		}
+	}
+	// Reasoning of earlier turns is not kept in the history
+	if liveReasoning["type"] != "reasoning" || len(reasoningIDs) != 1 || reasoningIDs[0] != liveReasoning["id"] {
+		t.Errorf("Expected the live reasoning ID %v, got %v", liveReasoning["id"], reasoningIDs)
+	}
+}
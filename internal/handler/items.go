@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/converter"
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// List endpoint limits
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// itemIDPrefixes maps item types to the prefix of the IDs the proxy assigns
+var itemIDPrefixes = map[string]string{
+	"message":                 "msg",
+	"function_call":           "fc",
+	"function_call_output":    "fco",
+	"custom_tool_call":        "ctc",
+	"custom_tool_call_output": "ctco",
+	"local_shell_call":        "lsc",
+	"local_shell_call_output": "lsco",
+	"mcp_list_tools":          "mcpl",
+	"mcp_call":                "mcp",
+	"mcp_approval_request":    "mcpr",
+	"mcp_approval_response":   "mcpa",
+	"web_search_call":         "ws",
+	"file_search_call":        "fs",
+	"code_interpreter_call":   "ci",
+	"reasoning":               "rs",
+}
+
+// listParams holds the cursor parameters of list endpoints
+type listParams struct {
+	limit  int
+	order  string // "asc" or "desc"
+	after  string // Item ID to start after, in listing order
+	before string // Item ID to stop before, in listing order
+}
+
+// parseListParams reads limit, order, after and before from the query string
+func parseListParams(r *http.Request, defaultOrder string) (listParams, error) {
+	q := r.URL.Query()
+	p := listParams{
+		limit:  defaultListLimit,
+		order:  defaultOrder,
+		after:  q.Get("after"),
+		before: q.Get("before"),
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return p, fmt.Errorf("limit must be an integer between 1 and %d", maxListLimit)
+		}
+		p.limit = limit
+	}
+
+	if v := q.Get("order"); v != "" {
+		if v != "asc" && v != "desc" {
+			return p, fmt.Errorf("order must be asc or desc")
+		}
+		p.order = v
+	}
+
+	return p, nil
+}
+
+// page selects a page from ids, which are in ascending (creation) order.
+// Returns the positions of the selected ids in listing order and whether
+// more ids follow the page. A cursor that is not among ids is a ParamError.
+func (p listParams) page(ids []string) ([]int, bool, error) {
+	ordered := make([]int, len(ids))
+	for i := range ids {
+		if p.order == "desc" {
+			ordered[i] = len(ids) - 1 - i
+		} else {
+			ordered[i] = i
+		}
+	}
+
+	if p.after != "" {
+		i := slices.IndexFunc(ordered, func(idx int) bool { return ids[idx] == p.after })
+		if i < 0 {
+			return nil, false, &models.ParamError{Param: "after", Message: fmt.Sprintf("no item with ID %q", p.after)}
+		}
+		ordered = ordered[i+1:]
+	}
+	if p.before != "" {
+		i := slices.IndexFunc(ordered, func(idx int) bool { return ids[idx] == p.before })
+		if i < 0 {
+			return nil, false, &models.ParamError{Param: "before", Message: fmt.Sprintf("no item with ID %q", p.before)}
+		}
+		ordered = ordered[:i]
+	}
+
+	if len(ordered) > p.limit {
+		return ordered[:p.limit], true, nil
+	}
+	return ordered, false, nil
+}
+
+// writeList writes a page of items in the list envelope. Nothing is written
+// if the page cannot be selected; see listParams.page.
+func writeList[T any](w http.ResponseWriter, items []T, idOf func(T) string, p listParams) error {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = idOf(item)
+	}
+
+	positions, hasMore, err := p.page(ids)
+	if err != nil {
+		return err
+	}
+	data := make([]T, 0, len(positions))
+	for _, idx := range positions {
+		data = append(data, items[idx])
+	}
+
+	list := models.ListResponse{
+		Object:  "list",
+		Data:    data,
+		HasMore: hasMore,
+	}
+	if len(positions) > 0 {
+		list.FirstID = ids[positions[0]]
+		list.LastID = ids[positions[len(positions)-1]]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+	return nil
+}
+
+// resolveInputItems replaces item_reference items with the stored items they
+// point at and assigns an ID to every item that has none
+func (h *ProxyHandler) resolveInputItems(input []models.InputItem) ([]models.InputItem, error) {
+	resolved := make([]models.InputItem, 0, len(input))
+	for _, item := range input {
+		if item.Type == "item_reference" {
+			stored, ok := h.store.GetItem(item.ID)
+			if !ok {
+				return nil, fmt.Errorf("item_reference %q not found", item.ID)
+			}
+			item = *stored
+		}
+		if item.ID == "" {
+			prefix, ok := itemIDPrefixes[item.Type]
+			if !ok {
+				prefix = "item"
+			}
+			item.ID = fmt.Sprintf("%s-%s", prefix, generateResponseID())
+		}
+		resolved = append(resolved, item)
+	}
+	return resolved, nil
+}
+
+// outputInputItems converts the reply of a turn to input items, with the same
+// IDs the client received, so they can be used as item_reference targets
+func outputInputItems(responseID string, msg *models.ChatMessage) []models.InputItem {
+	output := converter.BuildOutputItems(msg, responseID)
+	items := make([]models.InputItem, 0, len(output))
+	for _, o := range output {
+		items = append(items, inputItemOf(o))
+	}
+	return items
+}
+
+// inputItemOf converts an output item to the input item it stands for
+func inputItemOf(o models.OutputItem) models.InputItem {
+	return models.InputItem{
+		Type:              o.Type,
+		ID:                o.ID,
+		Role:              o.Role,
+		Content:           o.Content,
+		CallID:            o.CallID,
+		Name:              o.Name,
+		Arguments:         o.Arguments,
+		Input:             o.Input,
+		Action:            o.Action,
+		Output:            o.Output,
+		Status:            o.Status,
+		SearchAction:      o.SearchAction,
+		ServerLabel:       o.ServerLabel,
+		Tools:             o.Tools,
+		Error:             o.Error,
+		ApprovalRequestID: o.ApprovalRequestID,
+		Queries:           o.Queries,
+		Results:           o.Results,
+		Code:              o.Code,
+		ContainerID:       o.ContainerID,
+		Outputs:           o.Outputs,
+		Summary:           o.Summary,
+	}
+}
+
+// handleListInputItems handles GET /v1/responses/{id}/input_items
+func (h *ProxyHandler) handleListInputItems(w http.ResponseWriter, r *http.Request, responseID string, log *zap.Logger) {
+	params, err := parseListParams(r, "desc")
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	items, ok := h.store.GetInputItems(responseID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Response not found", log)
+		return
+	}
+
+	log.Info("listing input items",
+		zap.String("response_id", responseID),
+		zap.Int("item_count", len(items)),
+	)
+
+	if err := writeList(w, items, func(item models.InputItem) string { return item.ID }, params); err != nil {
+		h.handleParseError(w, r, err, log)
+	}
+}
+
+// handleDeleteResponse handles DELETE /v1/responses/{id}
+func (h *ProxyHandler) handleDeleteResponse(w http.ResponseWriter, r *http.Request, responseID string, log *zap.Logger) {
+	// Stop the job first so it cannot store its result afterwards
+	if h.background != nil {
+		if _, _, err := h.background.cancel(responseID); err != nil {
+			log.Warn("failed to cancel background response before delete", zap.Error(err))
+		}
+	}
+
+	found, err := h.store.DeleteResponse(responseID)
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to delete response: %v", err), log)
+		return
+	}
+	if !found {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Response not found", log)
+		return
+	}
+
+	log.Info("response deleted", zap.String("response_id", responseID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.DeletedObject{
+		ID:      responseID,
+		Object:  "response.deleted",
+		Deleted: true,
+	})
+}
@@ -0,0 +1,327 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/converter"
+	"github.com/young1lin/responses2chat/internal/mcp"
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// mcpTimeout bounds each request to an MCP server
+const mcpTimeout = 120 * time.Second
+
+// maxMCPIterations caps the upstream round trips of a turn running MCP tools
+const maxMCPIterations = 10
+
+// mcpServer is a remote MCP server declared by an mcp tool
+type mcpServer struct {
+	tool   models.Tool
+	client *mcp.Client
+}
+
+// mcpFunction identifies the MCP tool behind a function tool
+type mcpFunction struct {
+	server *mcpServer
+	name   string
+}
+
+// mcpToolset holds the MCP servers of a request and the MCP items the proxy
+// produced for it
+type mcpToolset struct {
+	servers   map[string]*mcpServer  // By server label
+	functions map[string]mcpFunction // By function name
+	tools     []models.ChatTool      // Function tools offered to the model
+	// items holds the mcp_list_tools and mcp_call items that precede the reply
+	items []models.OutputItem
+}
+
+// connectMCPServers lists the tools of the MCP servers declared in tools.
+// Returns nil if there are none.
+func (h *ProxyHandler) connectMCPServers(ctx context.Context, tools []models.Tool, log *zap.Logger) (*mcpToolset, error) {
+	// web_search is run by its own loop
+	if slices.ContainsFunc(tools, func(t models.Tool) bool { return t.Type == "mcp" }) {
+		for i, tool := range tools {
+			if tool.Type == "web_search" {
+				return nil, &models.ParamError{
+					Param:   fmt.Sprintf("tools[%d]", i),
+					Message: "mcp cannot be combined with web_search tools",
+				}
+			}
+		}
+	}
+
+	var ts *mcpToolset
+	for i := range tools {
+		tool := tools[i]
+		if tool.Type != "mcp" {
+			continue
+		}
+		if err := converter.ValidateMCPTool(&tool); err != nil {
+			return nil, &models.ParamError{Param: fmt.Sprintf("tools[%d]", i), Message: err.Error()}
+		}
+		if ts == nil {
+			ts = &mcpToolset{
+				servers:   make(map[string]*mcpServer),
+				functions: make(map[string]mcpFunction),
+			}
+		}
+		if _, ok := ts.servers[tool.ServerLabel]; ok {
+			return nil, &models.ParamError{
+				Param:   fmt.Sprintf("tools[%d].server_label", i),
+				Message: fmt.Sprintf("duplicate server_label %q", tool.ServerLabel),
+			}
+		}
+
+		serverURL, err := url.Parse(tool.ServerURL)
+		if err == nil {
+			err = checkPublicURL(serverURL)
+		}
+		if err != nil {
+			return nil, &models.ParamError{Param: fmt.Sprintf("tools[%d].server_url", i), Message: err.Error()}
+		}
+
+		server := &mcpServer{
+			tool:   tool,
+			client: mcp.NewClient(tool.ServerURL, tool.Headers, h.mcpClient),
+		}
+		ts.servers[tool.ServerLabel] = server
+
+		listed, err := server.client.ListTools(ctx)
+		if errors.Is(err, errNotPublic) {
+			return nil, &models.ParamError{Param: fmt.Sprintf("tools[%d].server_url", i), Message: err.Error()}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tools of MCP server %s: %w", tool.ServerLabel, err)
+		}
+
+		item := models.OutputItem{
+			Type:        "mcp_list_tools",
+			ID:          fmt.Sprintf("mcpl-%s", generateResponseID()),
+			ServerLabel: tool.ServerLabel,
+			Tools:       []models.MCPToolInfo{},
+		}
+		for _, t := range listed {
+			if !converter.MCPToolAllowed(&tool, t.Name) {
+				continue
+			}
+			info := models.MCPToolInfo{
+				Name:        t.Name,
+				Description: t.Description,
+				InputSchema: t.InputSchema,
+				Annotations: t.Annotations,
+			}
+			item.Tools = append(item.Tools, info)
+
+			fn := converter.MCPFunctionTool(tool.ServerLabel, info)
+			ts.functions[fn.Function.Name] = mcpFunction{server: server, name: t.Name}
+			ts.tools = append(ts.tools, fn)
+		}
+		ts.items = append(ts.items, item)
+
+		log.Info("listed MCP tools",
+			zap.String("server_label", tool.ServerLabel),
+			zap.Int("tool_count", len(listed)),
+			zap.Int("allowed_count", len(item.Tools)),
+		)
+	}
+	return ts, nil
+}
+
+// resolveMCPApprovals runs or declines the MCP calls answered by the
+// mcp_approval_response items of the input. Each response is replaced by
+// the resulting mcp_call, which the model sees as the call and its output.
+// The items passed in are not modified.
+func (h *ProxyHandler) resolveMCPApprovals(ctx context.Context, ts *mcpToolset, input []models.InputItem, log *zap.Logger) ([]models.InputItem, error) {
+	var result []models.InputItem
+	for i, item := range input {
+		if item.Type != "mcp_approval_response" {
+			continue
+		}
+		param := fmt.Sprintf("input[%d].approval_request_id", i)
+
+		request := findApprovalRequest(input, item.ApprovalRequestID)
+		if request == nil {
+			if stored, ok := h.store.GetItem(item.ApprovalRequestID); ok && stored.Type == "mcp_approval_request" {
+				request = stored
+			}
+		}
+		if request == nil {
+			return nil, &models.ParamError{Param: param, Message: fmt.Sprintf("approval request %q not found", item.ApprovalRequestID)}
+		}
+
+		var server *mcpServer
+		if ts != nil {
+			server = ts.servers[request.ServerLabel]
+		}
+		if server == nil {
+			return nil, &models.ParamError{Param: param, Message: fmt.Sprintf("no mcp tool with server_label %q", request.ServerLabel)}
+		}
+
+		call := models.OutputItem{
+			Type:              "mcp_call",
+			ID:                fmt.Sprintf("mcp-%s", generateResponseID()),
+			ServerLabel:       request.ServerLabel,
+			Name:              request.Name,
+			Arguments:         request.Arguments,
+			ApprovalRequestID: item.ApprovalRequestID,
+		}
+		if item.Approve != nil && *item.Approve {
+			call.Output, call.Error = server.callTool(ctx, request.Name, request.Arguments, log)
+		} else {
+			call.Error = "The user declined to run this tool call"
+			if item.Reason != "" {
+				call.Error += ": " + item.Reason
+			}
+		}
+		ts.items = append(ts.items, call)
+
+		if result == nil {
+			result = append([]models.InputItem{}, input...)
+		}
+		result[i] = inputItemOf(call)
+	}
+
+	if result == nil {
+		return input, nil
+	}
+	return result, nil
+}
+
+// findApprovalRequest returns the mcp_approval_request item with the given
+// ID, or nil
+func findApprovalRequest(items []models.InputItem, id string) *models.InputItem {
+	for i := range items {
+		if items[i].Type == "mcp_approval_request" && items[i].ID == id {
+			return &items[i]
+		}
+	}
+	return nil
+}
+
+// callTool runs a tool call and returns its output, or the error to report
+func (s *mcpServer) callTool(ctx context.Context, name, arguments string, log *zap.Logger) (string, string) {
+	var args map[string]interface{}
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Sprintf("Invalid arguments: %v", err)
+		}
+	}
+
+	log.Info("calling MCP tool",
+		zap.String("server_label", s.tool.ServerLabel),
+		zap.String("tool", name),
+	)
+
+	result, err := s.client.CallTool(ctx, name, args)
+	if err != nil {
+		log.Error("MCP tool call failed",
+			zap.String("server_label", s.tool.ServerLabel),
+			zap.String("tool", name),
+			zap.Error(err),
+		)
+		return "", err.Error()
+	}
+	if result.IsError {
+		return "", result.Text()
+	}
+	return result.Text(), ""
+}
+
+// completeWithMCP sends the job upstream without streaming and runs the MCP
+// calls of the replies until the model answers, calls a client tool or
+// calls an MCP tool that needs approval. Returns the last reply and the
+// exchanges with the MCP tools that precede it.
+func (h *ProxyHandler) completeWithMCP(ctx context.Context, client *http.Client, job *responseJob) (*models.ChatCompletionResponse, []models.ChatMessage, error) {
+	ts := job.mcp
+	messages := make([]models.ChatMessage, len(job.chatReq.Messages))
+	copy(messages, job.chatReq.Messages)
+	var exchange []models.ChatMessage
+
+	for i := 0; ; i++ {
+		currentReq := *job.chatReq
+		currentReq.Messages = messages
+		currentReq.Stream = false
+
+		resp, err := sendChatCompletion(ctx, client, &currentReq, job.apiKey, job.targetCfg, job.log)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(resp.Choices) == 0 {
+			return resp, exchange, nil
+		}
+
+		msg := &resp.Choices[0].Message
+		if job.plan.singleToolCall() {
+			converter.KeepFirstToolCall(msg)
+		}
+
+		// Split the calls into those the proxy runs now and those that end
+		// the turn: client tools and MCP tools that need approval. Past the
+		// iteration limit every MCP call needs approval.
+		var run, rest []models.ToolCall
+		for _, tc := range msg.ToolCalls {
+			fn, ok := ts.functions[tc.Function.Name]
+			switch {
+			case !ok:
+				rest = append(rest, tc)
+			case i+1 >= maxMCPIterations || converter.MCPRequiresApproval(&fn.server.tool, fn.name):
+				tc.Type = converter.ToolTypeMCPApproval
+				tc.ServerLabel = fn.server.tool.ServerLabel
+				tc.Function.Name = fn.name
+				rest = append(rest, tc)
+			default:
+				run = append(run, tc)
+			}
+		}
+		msg.ToolCalls = rest
+		if len(run) == 0 {
+			return resp, exchange, nil
+		}
+
+		step := []models.ChatMessage{{Role: "assistant", ToolCalls: run}}
+		if len(rest) == 0 {
+			step[0].Content = msg.Content
+		}
+		for _, tc := range run {
+			fn := ts.functions[tc.Function.Name]
+			call := models.OutputItem{
+				Type:        "mcp_call",
+				ID:          fmt.Sprintf("mcp-%s", generateResponseID()),
+				ServerLabel: fn.server.tool.ServerLabel,
+				Name:        fn.name,
+				Arguments:   tc.Function.Arguments,
+			}
+			call.Output, call.Error = fn.server.callTool(ctx, fn.name, tc.Function.Arguments, job.log)
+			ts.items = append(ts.items, call)
+
+			output := call.Output
+			if call.Error != "" {
+				output = fmt.Sprintf("Error: %s", call.Error)
+			}
+			step = append(step, models.ChatMessage{
+				Role:       "tool",
+				Content:    output,
+				ToolCallID: tc.ID,
+			})
+		}
+		exchange = append(exchange, step...)
+		messages = append(messages, step...)
+
+		// Client tool calls and approvals end the turn; the model sees the
+		// MCP results with the next request
+		if len(rest) > 0 {
+			return resp, exchange, nil
+		}
+	}
+
+}
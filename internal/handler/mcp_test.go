@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// newMCPServer starts an MCP server offering an echo tool and counts its
+// tool calls
+func newMCPServer(t *testing.T, toolCalls *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var msg struct {
+			ID     interface{} `json:"id"`
+			Method string      `json:"method"`
+			Params struct {
+				Arguments map[string]interface{} `json:"arguments"`
+			} `json:"params"`
+		}
+		json.Unmarshal(data, &msg)
+
+		var result string
+		switch msg.Method {
+		case "initialize":
+			result = `{"protocolVersion":"2025-03-26","capabilities":{}}`
+		case "tools/list":
+			result = `{"tools":[{"name":"echo","inputSchema":{"type":"object"}}]}`
+		case "tools/call":
+			toolCalls.Add(1)
+			text, _ := json.Marshal(msg.Params.Arguments["text"])
+			result = fmt.Sprintf(`{"content":[{"type":"text","text":%s}]}`, text)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%v,"result":%s}`, msg.ID, result)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMCPApprovals(t *testing.T) {
+	var toolCalls atomic.Int32
+	srv := newMCPServer(t, &toolCalls)
+	h, _ := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Upstream must not be called")
+	}, nil)
+	h.mcpClient = &http.Client{} // The test server listens on loopback
+	ctx := context.Background()
+	tools := []models.Tool{{Type: "mcp", ServerLabel: "srv", ServerURL: srv.URL}}
+
+	ts, err := h.connectMCPServers(ctx, tools, zap.NewNop())
+	if err != nil {
+		t.Fatalf("connectMCPServers failed: %v", err)
+	}
+
+	approve, decline := true, false
+	input := []models.InputItem{
+		{Type: "mcp_approval_request", ID: "mcpr_1", ServerLabel: "srv", Name: "echo", Arguments: `{"text":"hi"}`},
+		{Type: "mcp_approval_response", ApprovalRequestID: "mcpr_1", Approve: &approve},
+		{Type: "mcp_approval_request", ID: "mcpr_2", ServerLabel: "srv", Name: "echo", Arguments: `{"text":"no"}`},
+		{Type: "mcp_approval_response", ApprovalRequestID: "mcpr_2", Approve: &decline, Reason: "not now"},
+	}
+
+	t.Run("Approve and decline", func(t *testing.T) {
+		result, err := h.resolveMCPApprovals(ctx, ts, input, zap.NewNop())
+		if err != nil {
+			t.Fatalf("resolveMCPApprovals failed: %v", err)
+		}
+
+		approved := result[1]
+		if approved.Type != "mcp_call" || approved.Output != "hi" || approved.Error != "" {
+			t.Errorf("Expected an mcp_call with output hi, got %+v", approved)
+		}
+		if approved.ApprovalRequestID != "mcpr_1" {
+			t.Errorf("Expected approval_request_id mcpr_1, got %s", approved.ApprovalRequestID)
+		}
+
+		declined := result[3]
+		if declined.Type != "mcp_call" || declined.Output != "" {
+			t.Errorf("Expected an mcp_call without output, got %+v", declined)
+		}
+		if !strings.Contains(declined.Error, "declined") || !strings.Contains(declined.Error, "not now") {
+			t.Errorf("Expected the decline and its reason, got %q", declined.Error)
+		}
+
+		if n := toolCalls.Load(); n != 1 {
+			t.Errorf("Expected only the approved call to run, got %d calls", n)
+		}
+		if input[1].Type != "mcp_approval_response" {
+			t.Error("Expected the input not to be modified")
+		}
+	})
+
+	t.Run("Unknown approval request", func(t *testing.T) {
+		_, err := h.resolveMCPApprovals(ctx, ts, []models.InputItem{
+			{Type: "mcp_approval_response", ApprovalRequestID: "mcpr_missing", Approve: &approve},
+		}, zap.NewNop())
+		var paramErr *models.ParamError
+		if !errors.As(err, &paramErr) {
+			t.Errorf("Expected a ParamError, got %v", err)
+		}
+	})
+
+	t.Run("Combined with web_search", func(t *testing.T) {
+		before := toolCalls.Load()
+		body := fmt.Sprintf(`{"model":"m","input":"hi","tools":[{"type":"mcp","server_label":"srv","server_url":%q},{"type":"web_search"}]}`, srv.URL)
+		rec := serve(h, http.MethodPost, "/v1/responses", body)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "web_search") {
+			t.Errorf("Expected the error to name web_search, got %s", rec.Body.String())
+		}
+		if toolCalls.Load() != before {
+			t.Error("Expected no MCP tool calls")
+		}
+	})
+}
+
+func TestMCPServerURL(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer srv.Close()
+	h, _ := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Upstream must not be called")
+	}, nil)
+
+	tests := []struct {
+		name      string
+		serverURL string
+		want      string
+	}{
+		{"Loopback server is refused", srv.URL, "not public"},
+		{"Scheme is refused", "file:///etc/passwd", "scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"model":"m","input":"hi","tools":[{"type":"mcp","server_label":"srv","server_url":%q}]}`, tt.serverURL)
+			rec := serve(h, http.MethodPost, "/v1/responses", body)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+			}
+			errBody := decodeBody(t, rec)["error"].(map[string]interface{})
+			if errBody["param"] != "tools[0].server_url" {
+				t.Errorf("Expected param tools[0].server_url, got %v", errBody["param"])
+			}
+			if !strings.Contains(errBody["message"].(string), tt.want) {
+				t.Errorf("Expected the error to mention %q, got %v", tt.want, errBody["message"])
+			}
+		})
+	}
+	if requests.Load() != 0 {
+		t.Error("Expected the loopback server not to be contacted")
+	}
+}
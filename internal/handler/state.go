@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// includeEncryptedReasoning is the include value asking for reasoning items
+// that carry encrypted_content, sent by Codex together with store: false
+const includeEncryptedReasoning = "reasoning.encrypted_content"
+
+// turnOptions controls what happens to a finished conversation turn
+type turnOptions struct {
+	// persist writes the turn to the conversation store
+	persist bool
+	// seal returns the turn to the client as an encrypted reasoning item
+	seal bool
+	// input holds the input items of the turn, stored alongside the history
+	input []models.InputItem
+	// output holds the items the proxy produced before the reply, such as
+	// MCP calls
+	output []models.InputItem
+	// conversation is the ID of the conversation the turn is appended to
+	conversation string
+	// replyID derives the IDs of the reply items when it differs from the
+	// response ID, e.g. after several upstream turns
+	replyID string
+}
+
+// turnOptionsFor derives the turn options from store, include and the
+// storage configuration
+func (h *ProxyHandler) turnOptionsFor(req *models.ResponsesRequest) turnOptions {
+	opts := turnOptions{
+		persist: !h.config.Storage.Stateless && (req.Store == nil || *req.Store),
+	}
+	if h.sealer == nil {
+		return opts
+	}
+	if h.config.Storage.Stateless {
+		opts.seal = true
+		return opts
+	}
+	for _, inc := range req.Include {
+		if inc == includeEncryptedReasoning {
+			opts.seal = true
+			break
+		}
+	}
+	return opts
+}
+
+// restoreSealedState finds the newest reasoning item in the input that holds
+// sealed state produced by this proxy. It returns the conversation history it
+// contains and the input items that follow it, i.e. the new turn.
+func (h *ProxyHandler) restoreSealedState(input []models.InputItem, log *zap.Logger) ([]models.ChatMessage, []models.InputItem, bool) {
+	if h.sealer == nil {
+		return nil, input, false
+	}
+
+	for i := len(input) - 1; i >= 0; i-- {
+		item := input[i]
+		if item.Type != "reasoning" || item.EncryptedContent == "" {
+			continue
+		}
+		history, err := h.sealer.Open(item.EncryptedContent)
+		if err != nil {
+			// Foreign or stale state (e.g. sealed with a previous random key)
+			log.Debug("ignoring unreadable encrypted_content", zap.Error(err))
+			continue
+		}
+		log.Info("restored sealed conversation state",
+			zap.Int("history_count", len(history)),
+			zap.Int("new_input_count", len(input)-i-1),
+		)
+		return history, input[i+1:], true
+	}
+	return nil, input, false
+}
+
+// finishTurn records the complete conversation of a turn as requested by
+// opts. It returns the sealed reasoning item to append to the output, or nil.
+func (h *ProxyHandler) finishTurn(responseID string, messages []models.ChatMessage, opts turnOptions, log *zap.Logger) *models.OutputItem {
+	output := append([]models.InputItem{}, opts.output...)
+	if len(messages) > 0 {
+		replyID := opts.replyID
+		if replyID == "" {
+			replyID = strings.TrimPrefix(responseID, "resp-")
+		}
+		output = append(output, outputInputItems(replyID, &messages[len(messages)-1])...)
+	}
+
+	if opts.conversation != "" {
+		items := append(append([]models.InputItem{}, opts.input...), output...)
+		if err := h.store.AppendConversationItems(opts.conversation, items); err != nil {
+			log.Error("failed to append to conversation",
+				zap.String("conversation_id", opts.conversation),
+				zap.Error(err),
+			)
+		}
+	}
+
+	if opts.persist {
+		if err := h.store.Store(responseID, messages); err != nil {
+			log.Error("failed to store conversation history", zap.Error(err))
+		} else {
+			log.Info("stored conversation history",
+				zap.String("response_id", responseID),
+				zap.Int("message_count", len(messages)),
+			)
+		}
+
+		if err := h.store.StoreItems(responseID, opts.input, output); err != nil {
+			log.Error("failed to store response items", zap.Error(err))
+		}
+	} else {
+		log.Debug("conversation history not stored", zap.String("response_id", responseID))
+	}
+	if droppedWebSearches(messages, opts) {
+		log.Info("web_search results are not kept for the client tool calls of the turn; store the response or enable sealed state to keep them",
+			zap.String("response_id", responseID),
+		)
+	}
+
+	if !opts.seal {
+		return nil
+	}
+
+	token, err := h.sealer.Seal(messages)
+	if err != nil {
+		log.Error("failed to seal conversation state", zap.Error(err))
+		return nil
+	}
+	return &models.OutputItem{
+		Type:             "reasoning",
+		ID:               fmt.Sprintf("rs-%s-state", strings.TrimPrefix(responseID, "resp-")),
+		Summary:          []models.ContentItem{},
+		EncryptedContent: token,
+	}
+}
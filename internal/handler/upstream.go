@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// UpstreamError is returned when the target API answers with an error status
+type UpstreamError struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Error implements the error interface
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream error: status %d, body: %s", e.StatusCode, string(e.Body))
+}
+
+// sendChatCompletion sends a non-streaming Chat Completions request upstream
+// and parses the response
+func sendChatCompletion(
+	ctx context.Context,
+	client *http.Client,
+	chatReq *models.ChatCompletionRequest,
+	apiKey string,
+	targetCfg *config.TargetConfig,
+	log *zap.Logger,
+) (*models.ChatCompletionResponse, error) {
+	resp, err := openChatCompletion(ctx, client, chatReq, apiKey, targetCfg, log)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	log.Debug("upstream response",
+		zap.Int("status", resp.StatusCode),
+		zap.String("body", string(body)),
+	)
+
+	// Parse response
+	var chatResp models.ChatCompletionResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &chatResp, nil
+}
+
+// openChatCompletion sends a Chat Completions request upstream and returns
+// the response for the caller to read and close. Error statuses are returned
+// as *UpstreamError.
+func openChatCompletion(
+	ctx context.Context,
+	client *http.Client,
+	chatReq *models.ChatCompletionRequest,
+	apiKey string,
+	targetCfg *config.TargetConfig,
+	log *zap.Logger,
+) (*http.Response, error) {
+	// Marshal request
+	reqBody, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Build target URL
+	targetURL := targetCfg.BaseURL + targetCfg.PathSuffix
+
+	// Create request
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", apiKey)
+
+	// Forward trace ID to upstream
+	if traceID, ok := ctx.Value(traceIDKey).(string); ok && traceID != "" {
+		req.Header.Set("X-Trace-ID", traceID)
+	}
+
+	// Send request
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		log.Debug("upstream error response",
+			zap.Int("status", resp.StatusCode),
+			zap.String("body", string(body)),
+		)
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Body: body}
+	}
+
+	return resp, nil
+}
+
+// handleCompletionError writes an error returned by sendChatCompletion,
+// keeping the upstream status code when there is one
+func (h *ProxyHandler) handleCompletionError(w http.ResponseWriter, r *http.Request, err error, log *zap.Logger) {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		h.writeUpstreamError(w, upstreamErr.StatusCode, upstreamErr.Body, log)
+		return
+	}
+	h.handleError(w, r, http.StatusBadGateway, "upstream_error", fmt.Sprintf("Failed to reach upstream: %v", err), log)
+}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/internal/storage"
+)
+
+// handleVectorStores routes /v1/vector_stores requests
+func (h *ProxyHandler) handleVectorStores(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
+	rest := r.URL.Path[strings.Index(r.URL.Path, "/v1/vector_stores")+len("/v1/vector_stores"):]
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+
+	switch {
+	case parts[0] == "":
+		switch r.Method {
+		case http.MethodPost:
+			h.handleCreateVectorStore(w, r, log)
+		case http.MethodGet:
+			h.handleListVectorStores(w, r, log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGetVectorStore(w, r, parts[0], log)
+		case http.MethodDelete:
+			h.handleDeleteVectorStore(w, r, parts[0], log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	case len(parts) == 2 && parts[1] == "files":
+		switch r.Method {
+		case http.MethodPost:
+			h.handleAddVectorStoreFile(w, r, parts[0], log)
+		case http.MethodGet:
+			h.handleListVectorStoreFiles(w, r, parts[0], log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	case len(parts) == 3 && parts[1] == "files":
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGetVectorStoreFile(w, r, parts[0], parts[2], log)
+		case http.MethodDelete:
+			h.handleDeleteVectorStoreFile(w, r, parts[0], parts[2], log)
+		default:
+			h.handleError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", log)
+		}
+
+	default:
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Endpoint not found", log)
+	}
+}
+
+// readJSONBody parses a JSON request body into v; an empty body leaves v
+// unchanged
+func (h *ProxyHandler) readJSONBody(w http.ResponseWriter, r *http.Request, v interface{}, log *zap.Logger) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "read_error", "Failed to read request body", log)
+		return false
+	}
+	defer r.Body.Close()
+
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, v); err != nil {
+			h.handleParseError(w, r, err, log)
+			return false
+		}
+	}
+	return true
+}
+
+// handleCreateVectorStore handles POST /v1/vector_stores. The files of
+// file_ids are indexed before the store is returned.
+func (h *ProxyHandler) handleCreateVectorStore(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
+	var req models.VectorStoreRequest
+	if !h.readJSONBody(w, r, &req, log) {
+		return
+	}
+
+	strategy, err := h.fileSearchHandler.chunkingStrategy(req.ChunkingStrategy)
+	if err != nil {
+		h.handleParseError(w, r, &models.ParamError{Param: "chunking_strategy", Message: err.Error()}, log)
+		return
+	}
+	for i, fileID := range req.FileIDs {
+		if _, ok := h.store.GetFile(fileID); !ok {
+			h.handleParseError(w, r, &models.ParamError{
+				Param:   fmt.Sprintf("file_ids[%d]", i),
+				Message: fmt.Sprintf("file %s not found", fileID),
+			}, log)
+			return
+		}
+	}
+
+	metadata := req.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	now := time.Now().Unix()
+	store := &models.VectorStore{
+		ID:           fmt.Sprintf("vs_%s", strings.ReplaceAll(uuid.New().String(), "-", "")),
+		Object:       "vector_store",
+		CreatedAt:    now,
+		Name:         req.Name,
+		Status:       "completed",
+		LastActiveAt: now,
+		Metadata:     metadata,
+	}
+	if err := h.store.CreateVectorStore(store); err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to create vector store: %v", err), log)
+		return
+	}
+
+	for _, fileID := range req.FileIDs {
+		if _, err := h.fileSearchHandler.IndexFile(r.Context(), store.ID, fileID, nil, strategy, log); err != nil {
+			h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to add file %s: %v", fileID, err), log)
+			return
+		}
+	}
+
+	log.Info("vector store created",
+		zap.String("vector_store_id", store.ID),
+		zap.Int("file_count", len(req.FileIDs)),
+	)
+
+	// File counts were updated while indexing
+	if updated, ok := h.store.GetVectorStore(store.ID); ok {
+		store = updated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(store)
+}
+
+// handleListVectorStores handles GET /v1/vector_stores
+func (h *ProxyHandler) handleListVectorStores(w http.ResponseWriter, r *http.Request, log *zap.Logger) {
+	params, err := parseListParams(r, "desc")
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	stores, err := h.store.ListVectorStores()
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to list vector stores: %v", err), log)
+		return
+	}
+
+	if err := writeList(w, stores, func(s models.VectorStore) string { return s.ID }, params); err != nil {
+		h.handleParseError(w, r, err, log)
+	}
+}
+
+// handleGetVectorStore handles GET /v1/vector_stores/{id}
+func (h *ProxyHandler) handleGetVectorStore(w http.ResponseWriter, r *http.Request, storeID string, log *zap.Logger) {
+	store, ok := h.store.GetVectorStore(storeID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Vector store not found", log)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(store)
+}
+
+// handleDeleteVectorStore handles DELETE /v1/vector_stores/{id}
+func (h *ProxyHandler) handleDeleteVectorStore(w http.ResponseWriter, r *http.Request, storeID string, log *zap.Logger) {
+	found, err := h.store.DeleteVectorStore(storeID)
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to delete vector store: %v", err), log)
+		return
+	}
+	if !found {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Vector store not found", log)
+		return
+	}
+
+	log.Info("vector store deleted", zap.String("vector_store_id", storeID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.DeletedObject{
+		ID:      storeID,
+		Object:  "vector_store.deleted",
+		Deleted: true,
+	})
+}
+
+// handleAddVectorStoreFile handles POST /v1/vector_stores/{id}/files. The
+// file is indexed before it is returned; adding it again re-indexes it.
+func (h *ProxyHandler) handleAddVectorStoreFile(w http.ResponseWriter, r *http.Request, storeID string, log *zap.Logger) {
+	if _, ok := h.store.GetVectorStore(storeID); !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Vector store not found", log)
+		return
+	}
+
+	var req models.VectorStoreFileRequest
+	if !h.readJSONBody(w, r, &req, log) {
+		return
+	}
+	if req.FileID == "" {
+		h.handleParseError(w, r, &models.ParamError{Param: "file_id", Message: "file_id is required"}, log)
+		return
+	}
+	if _, ok := h.store.GetFile(req.FileID); !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "File not found", log)
+		return
+	}
+	strategy, err := h.fileSearchHandler.chunkingStrategy(req.ChunkingStrategy)
+	if err != nil {
+		h.handleParseError(w, r, &models.ParamError{Param: "chunking_strategy", Message: err.Error()}, log)
+		return
+	}
+
+	file, err := h.fileSearchHandler.IndexFile(r.Context(), storeID, req.FileID, req.Attributes, strategy, log)
+	if err != nil {
+		if errors.Is(err, storage.ErrVectorStoreNotFound) {
+			h.handleError(w, r, http.StatusNotFound, "not_found", "Vector store not found", log)
+			return
+		}
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to add file: %v", err), log)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(file)
+}
+
+// handleListVectorStoreFiles handles GET /v1/vector_stores/{id}/files,
+// optionally filtered by status
+func (h *ProxyHandler) handleListVectorStoreFiles(w http.ResponseWriter, r *http.Request, storeID string, log *zap.Logger) {
+	params, err := parseListParams(r, "desc")
+	if err != nil {
+		h.handleError(w, r, http.StatusBadRequest, "invalid_request_error", err.Error(), log)
+		return
+	}
+
+	files, err := h.store.ListVectorStoreFiles(storeID)
+	if errors.Is(err, storage.ErrVectorStoreNotFound) {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Vector store not found", log)
+		return
+	}
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to list vector store files: %v", err), log)
+		return
+	}
+
+	if status := r.URL.Query().Get("filter"); status != "" {
+		filtered := files[:0]
+		for _, f := range files {
+			if f.Status == status {
+				filtered = append(filtered, f)
+			}
+		}
+		files = filtered
+	}
+
+	if err := writeList(w, files, func(f models.VectorStoreFile) string { return f.ID }, params); err != nil {
+		h.handleParseError(w, r, err, log)
+	}
+}
+
+// handleGetVectorStoreFile handles GET /v1/vector_stores/{id}/files/{file_id}
+func (h *ProxyHandler) handleGetVectorStoreFile(w http.ResponseWriter, r *http.Request, storeID, fileID string, log *zap.Logger) {
+	file, ok := h.store.GetVectorStoreFile(storeID, fileID)
+	if !ok {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Vector store file not found", log)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// handleDeleteVectorStoreFile handles DELETE /v1/vector_stores/{id}/files/{file_id}.
+// The file itself stays in the Files API.
+func (h *ProxyHandler) handleDeleteVectorStoreFile(w http.ResponseWriter, r *http.Request, storeID, fileID string, log *zap.Logger) {
+	found, err := h.store.DeleteVectorStoreFile(storeID, fileID)
+	if err != nil {
+		h.handleError(w, r, http.StatusInternalServerError, "storage_error", fmt.Sprintf("Failed to delete vector store file: %v", err), log)
+		return
+	}
+	if !found {
+		h.handleError(w, r, http.StatusNotFound, "not_found", "Vector store file not found", log)
+		return
+	}
+
+	log.Info("vector store file deleted",
+		zap.String("vector_store_id", storeID),
+		zap.String("file_id", fileID),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.DeletedObject{
+		ID:      fileID,
+		Object:  "vector_store.file.deleted",
+		Deleted: true,
+	})
+}
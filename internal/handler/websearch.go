@@ -1,12 +1,12 @@
 package handler
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -41,237 +41,336 @@ func (h *WebSearchHandler) HasWebSearchCapability() bool {
 	return h.searchManager.HasAvailableProvider()
 }
 
+// includeWebSearchSources is the include value asking for the sources of
+// web_search_call actions
+const includeWebSearchSources = "web_search_call.action.sources"
+
 // WebSearchCall represents a tracked web search call
 type WebSearchCall struct {
-	ID     string
-	Query  string
-	Status string
+	ID      string
+	Query   string
+	Status  string
+	Sources []models.SearchResult // Numbered for citation after those of earlier calls
+}
+
+// webSearchSources returns the sources of calls in the order they are numbered
+func webSearchSources(calls []WebSearchCall) []models.SearchResult {
+	var sources []models.SearchResult
+	for _, call := range calls {
+		sources = append(sources, call.Sources...)
+	}
+	return sources
 }
 
+// maxWebSearchIterations bounds the upstream turns that may search before the
+// model has to answer
+const maxWebSearchIterations = 5
+
 // HandleWithWebSearch processes a request that may involve web_search tool calls
-// It loops until the model stops calling web_search or reaches max iterations
+// It loops until the model stops calling web_search or reaches max iterations.
+// Client tool calls that come with web_search calls end the turn once the
+// searches are run. Returns the last reply, the searches and the exchanges
+// with the web_search tool that precede the reply.
 func (h *WebSearchHandler) HandleWithWebSearch(
 	ctx context.Context,
 	chatReq *models.ChatCompletionRequest,
 	apiKey string,
 	targetCfg *config.TargetConfig,
 	log *zap.Logger,
-) (*models.ChatCompletionResponse, []WebSearchCall, error) {
-	maxIterations := 5
-	var webSearchCalls []WebSearchCall
+) (*models.ChatCompletionResponse, []WebSearchCall, []models.ChatMessage, error) {
+	var (
+		webSearchCalls []WebSearchCall
+		exchange       []models.ChatMessage
+	)
 
 	// Track accumulated messages
 	messages := make([]models.ChatMessage, len(chatReq.Messages))
 	copy(messages, chatReq.Messages)
 
-	for i := 0; i < maxIterations; i++ {
+	for i := 0; i < maxWebSearchIterations; i++ {
 		log.Debug("web_search iteration",
 			zap.Int("iteration", i+1),
 			zap.Int("message_count", len(messages)),
 		)
 
-		// Create request with current messages
-		currentReq := &models.ChatCompletionRequest{
-			Model:       chatReq.Model,
-			Messages:    messages,
-			Tools:       chatReq.Tools,
-			Stream:      false,
-			Temperature: chatReq.Temperature,
-			MaxTokens:   chatReq.MaxTokens,
-		}
+		// Create request with current messages, keeping every other parameter
+		currentReq := *chatReq
+		currentReq.Messages = messages
+		currentReq.Stream = false
 
 		// Send request to upstream
-		resp, err := h.sendToUpstream(ctx, currentReq, apiKey, targetCfg, log)
+		resp, err := h.sendToUpstream(ctx, &currentReq, apiKey, targetCfg, log)
 		if err != nil {
-			return nil, webSearchCalls, fmt.Errorf("upstream request failed: %w", err)
+			return nil, webSearchCalls, nil, fmt.Errorf("upstream request failed: %w", err)
 		}
 
 		// Check for web_search tool calls
 		if len(resp.Choices) == 0 {
-			return resp, webSearchCalls, nil
+			return resp, webSearchCalls, exchange, nil
 		}
 
-		choice := resp.Choices[0]
-		webSearchToolCalls := h.extractWebSearchCalls(choice.Message.ToolCalls)
+		msg := &resp.Choices[0].Message
+		webSearchToolCalls, rest := splitWebSearchCalls(msg.ToolCalls)
 
 		// If no web_search calls, we're done
 		if len(webSearchToolCalls) == 0 {
 			log.Debug("no more web_search calls, returning response")
-			return resp, webSearchCalls, nil
+			return resp, webSearchCalls, exchange, nil
 		}
 
 		log.Info("detected web_search calls",
 			zap.Int("count", len(webSearchToolCalls)),
+			zap.Int("client_calls", len(rest)),
 		)
 
-		// Add assistant message with tool calls to messages
-		assistantMsg := models.ChatMessage{
-			Role:      "assistant",
-			Content:   choice.Message.Content,
-			ToolCalls: choice.Message.ToolCalls,
-		}
-		messages = append(messages, assistantMsg)
-
-		// Process each web_search call
-		for _, tc := range webSearchToolCalls {
-			query, err := h.parseQueryFromArguments(tc.Function.Arguments)
-			if err != nil {
-				log.Error("failed to parse web_search arguments",
-					zap.Error(err),
-					zap.String("arguments", tc.Function.Arguments),
-				)
-				query = "unknown"
-			}
+		// Run the web_search calls of the turn concurrently
+		calls, toolMsgs := h.runWebSearches(ctx, webSearchToolCalls, webSearchQueries(webSearchToolCalls, log), chatReq.WebSearch, len(webSearchSources(webSearchCalls))+1, log)
+		webSearchCalls = append(webSearchCalls, calls...)
 
-			log.Info("executing web_search",
-				zap.String("query", query),
-				zap.String("call_id", tc.ID),
-			)
+		step := append([]models.ChatMessage{webSearchStep(msg, webSearchToolCalls, rest)}, toolMsgs...)
+		exchange = append(exchange, step...)
+		messages = append(messages, step...)
 
-			// Execute search
-			var searchContent string
-			searchResult, err := h.searchManager.Search(query)
-			if err != nil {
-				log.Error("web_search failed", zap.Error(err))
-				searchContent = fmt.Sprintf("Search failed: %s", err.Error())
-				webSearchCalls = append(webSearchCalls, WebSearchCall{
-					ID:     tc.ID,
-					Query:  query,
-					Status: "failed",
-				})
-			} else {
-				searchContent = search.FormatResults(searchResult)
-				webSearchCalls = append(webSearchCalls, WebSearchCall{
-					ID:     tc.ID,
-					Query:  query,
-					Status: "completed",
-				})
-			}
-
-			// Add tool result message
-			toolMsg := models.ChatMessage{
-				Role:       "tool",
-				ToolCallID: tc.ID,
-				Content:    searchContent,
-			}
-			messages = append(messages, toolMsg)
+		// Client tool calls end the turn; the reply keeps only them, and the
+		// model sees the search results together with the function outputs
+		// if the turn is stored or sealed (see droppedWebSearches)
+		if len(rest) > 0 {
+			msg.ToolCalls = rest
+			return resp, webSearchCalls, exchange, nil
 		}
 	}
 
 	// If we hit max iterations, make one final request
-	currentReq := &models.ChatCompletionRequest{
-		Model:       chatReq.Model,
-		Messages:    messages,
-		Tools:       chatReq.Tools,
-		Stream:      false,
-		Temperature: chatReq.Temperature,
-		MaxTokens:   chatReq.MaxTokens,
-	}
+	currentReq := *chatReq
+	currentReq.Messages = messages
+	currentReq.Stream = false
 
-	resp, err := h.sendToUpstream(ctx, currentReq, apiKey, targetCfg, log)
-	return resp, webSearchCalls, err
+	resp, err := h.sendToUpstream(ctx, &currentReq, apiKey, targetCfg, log)
+	return resp, webSearchCalls, exchange, err
 }
 
-// extractWebSearchCalls extracts web_search tool calls from the message
-func (h *WebSearchHandler) extractWebSearchCalls(toolCalls []models.ToolCall) []models.ToolCall {
-	var webSearchCalls []models.ToolCall
+// splitWebSearchCalls splits the tool calls of a turn into the web_search
+// calls the proxy runs and the calls of client tools
+func splitWebSearchCalls(toolCalls []models.ToolCall) (webSearch, rest []models.ToolCall) {
 	for _, tc := range toolCalls {
 		if tc.Function.Name == "web_search" {
-			webSearchCalls = append(webSearchCalls, tc)
+			webSearch = append(webSearch, tc)
+		} else {
+			rest = append(rest, tc)
 		}
 	}
-	return webSearchCalls
+	return webSearch, rest
+}
+
+// webSearchStep returns the assistant message that issues the web_search
+// calls of a turn. The text of a turn with client tool calls stays with
+// the reply.
+func webSearchStep(msg *models.ChatMessage, webSearchCalls, rest []models.ToolCall) models.ChatMessage {
+	step := models.ChatMessage{Role: "assistant", ToolCalls: webSearchCalls}
+	if len(rest) == 0 {
+		step.Content = msg.Content
+	}
+	return step
 }
 
-// parseQueryFromArguments parses the query from function arguments
-func (h *WebSearchHandler) parseQueryFromArguments(args string) (string, error) {
+// webSearchQuery returns the query of a web_search tool call
+func webSearchQuery(tc models.ToolCall, log *zap.Logger) string {
 	var parsed struct {
 		Query string `json:"query"`
 	}
-	if err := json.Unmarshal([]byte(args), &parsed); err != nil {
-		return "", err
+	if err := json.Unmarshal([]byte(tc.Function.Arguments), &parsed); err != nil {
+		log.Error("failed to parse web_search arguments",
+			zap.Error(err),
+			zap.String("arguments", tc.Function.Arguments),
+		)
+		return "unknown"
 	}
-	return parsed.Query, nil
+	return parsed.Query
 }
 
-// sendToUpstream sends a request to the upstream API
-func (h *WebSearchHandler) sendToUpstream(
-	ctx context.Context,
-	chatReq *models.ChatCompletionRequest,
-	apiKey string,
-	targetCfg *config.TargetConfig,
-	log *zap.Logger,
-) (*models.ChatCompletionResponse, error) {
-	// Marshal request
-	reqBody, err := json.Marshal(chatReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// runWebSearches executes the web_search tool calls of a model turn
+// concurrently with the options of the tool. The tracked calls and the tool
+// result messages for the model are returned in the order of the calls,
+// numbering the sources from firstSource on.
+func (h *WebSearchHandler) runWebSearches(ctx context.Context, tcs []models.ToolCall, queries []string, opts *models.WebSearchOptions, firstSource int, log *zap.Logger) ([]WebSearchCall, []models.ChatMessage) {
+	reqs := make([]models.SearchRequest, len(tcs))
+	for i, tc := range tcs {
+		log.Info("executing web_search",
+			zap.String("query", queries[i]),
+			zap.String("call_id", tc.ID),
+		)
+		reqs[i] = search.NewRequest(queries[i], opts)
 	}
 
-	// Build target URL
-	targetURL := targetCfg.BaseURL + targetCfg.PathSuffix
-
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	contextSize := ""
+	if opts != nil {
+		contextSize = opts.SearchContextSize
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", apiKey)
+	searchResults, errs := h.searchManager.SearchAll(ctx, reqs)
+	calls := make([]WebSearchCall, len(tcs))
+	toolMsgs := make([]models.ChatMessage, len(tcs))
+	for i, tc := range tcs {
+		call := WebSearchCall{ID: tc.ID, Query: queries[i], Status: "completed"}
+		var searchContent string
+		if err := errs[i]; err != nil {
+			log.Error("web_search failed",
+				zap.String("call_id", tc.ID),
+				zap.Error(err),
+			)
+			searchContent = webSearchFailedPrefix + err.Error()
+			call.Status = "failed"
+		} else {
+			call.Sources = searchResults[i].Results
+			searchContent = search.FormatResults(searchResults[i], firstSource, contextSize)
+			firstSource += len(call.Sources)
+		}
 
-	// Send request
-	resp, err := h.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		calls[i] = call
+		toolMsgs[i] = models.ChatMessage{
+			Role:       "tool",
+			ToolCallID: tc.ID,
+			Content:    searchContent,
+		}
 	}
-	defer resp.Body.Close()
+	return calls, toolMsgs
+}
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+// webSearchFailedPrefix starts the tool result of a failed search
+const webSearchFailedPrefix = "Search failed: "
+
+// webSearchToolResults maps the IDs of the web_search calls in messages to
+// their tool results
+func webSearchToolResults(messages []models.ChatMessage) map[string]string {
+	calls := make(map[string]bool)
+	results := make(map[string]string)
+	for _, msg := range messages {
+		for _, tc := range msg.ToolCalls {
+			if tc.Function.Name == "web_search" {
+				calls[tc.ID] = true
+			}
+		}
+		if msg.Role == "tool" && calls[msg.ToolCallID] {
+			content, _ := msg.Content.(string)
+			results[msg.ToolCallID] = content
+		}
 	}
+	return results
+}
 
-	log.Debug("upstream response",
-		zap.Int("status", resp.StatusCode),
-		zap.String("body", string(body)),
-	)
+// storedWebSearchItem rebuilds the web_search_call item of a stored call
+// from the call and its tool result
+func storedWebSearchItem(tc models.ToolCall, result string) models.OutputItem {
+	var args models.WebSearchFunctionArgs
+	json.Unmarshal([]byte(tc.Function.Arguments), &args)
 
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("upstream error: status %d, body: %s", resp.StatusCode, string(body))
+	call := WebSearchCall{ID: tc.ID, Query: args.Query, Status: "completed"}
+	if strings.HasPrefix(result, webSearchFailedPrefix) {
+		call.Status = "failed"
 	}
+	return webSearchItem(call, false)
+}
 
-	// Parse response
-	var chatResp models.ChatCompletionResponse
-	if err := json.Unmarshal(body, &chatResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+// webSearchQueries returns the queries of web_search tool calls
+func webSearchQueries(tcs []models.ToolCall, log *zap.Logger) []string {
+	queries := make([]string, len(tcs))
+	for i, tc := range tcs {
+		queries[i] = webSearchQuery(tc, log)
 	}
+	return queries
+}
 
-	return &chatResp, nil
+// sendToUpstream sends a request to the upstream API
+func (h *WebSearchHandler) sendToUpstream(
+	ctx context.Context,
+	chatReq *models.ChatCompletionRequest,
+	apiKey string,
+	targetCfg *config.TargetConfig,
+	log *zap.Logger,
+) (*models.ChatCompletionResponse, error) {
+	return sendChatCompletion(ctx, h.client, chatReq, apiKey, targetCfg, log)
 }
 
 // BuildWebSearchOutputItems builds OutputItems for web_search_call items
-func BuildWebSearchOutputItems(calls []WebSearchCall) []models.OutputItem {
+func BuildWebSearchOutputItems(calls []WebSearchCall, includeSources bool) []models.OutputItem {
 	items := make([]models.OutputItem, 0, len(calls))
 	for _, call := range calls {
-		items = append(items, models.OutputItem{
-			Type:   "web_search_call",
-			ID:     call.ID,
-			Status: call.Status,
-			// Note: Action field is not in the current OutputItem model
-			// We'll need to add it or use a custom type
-		})
+		items = append(items, webSearchItem(call, includeSources))
 	}
 	return items
 }
 
+// webSearchItem builds the web_search_call item of a call
+func webSearchItem(call WebSearchCall, includeSources bool) models.OutputItem {
+	action := &models.WebSearchCallAction{Type: "search", Query: call.Query}
+	if includeSources {
+		for _, source := range call.Sources {
+			if source.URL != "" {
+				action.Sources = append(action.Sources, models.WebSearchSource{Type: "url", URL: source.URL})
+			}
+		}
+	}
+	return models.OutputItem{
+		Type:         "web_search_call",
+		ID:           call.ID,
+		Status:       call.Status,
+		SearchAction: action,
+	}
+}
+
+// storedWebSearchTurn returns turn with the web_search_call items of calls,
+// sources included, among the items stored before the reply
+func storedWebSearchTurn(turn turnOptions, calls []WebSearchCall) turnOptions {
+	turn.output = append([]models.InputItem{}, turn.output...)
+	for _, item := range BuildWebSearchOutputItems(calls, true) {
+		turn.output = append(turn.output, inputItemOf(item))
+	}
+	return turn
+}
+
+// droppedWebSearches reports whether a turn ends with client tool calls
+// after web_search calls whose results are neither stored nor sealed. The
+// client replays the searches as web_search_call items, which keep their
+// sources but not the page contents, so the model answers the function
+// outputs without the search results.
+func droppedWebSearches(messages []models.ChatMessage, opts turnOptions) bool {
+	if opts.persist || opts.seal || len(messages) == 0 || len(messages[len(messages)-1].ToolCalls) == 0 {
+		return false
+	}
+	return slices.ContainsFunc(opts.output, func(item models.InputItem) bool {
+		return item.Type == "web_search_call"
+	})
+}
+
+// annotateURLCitations adds url_citation annotations for the sources the
+// answer cites to the output_text parts of a response
+func annotateURLCitations(resp *models.ResponsesResponse, calls []WebSearchCall) {
+	sources := webSearchSources(calls)
+	if len(sources) == 0 {
+		return
+	}
+
+	for i := range resp.Output {
+		item := &resp.Output[i]
+		if item.Type != "message" {
+			continue
+		}
+		for j := range item.Content {
+			if item.Content[j].Type == "output_text" {
+				item.Content[j].Annotations = converter.URLCitations(item.Content[j].Text, sources)
+			}
+		}
+	}
+}
+
 // ConvertResponseWithWebSearch converts ChatCompletionResponse to ResponsesResponse with web_search_call items
-func ConvertResponseWithWebSearch(resp *models.ChatCompletionResponse, requestID string, webSearchCalls []WebSearchCall) *models.ResponsesResponse {
+// and the citations of their sources
+func ConvertResponseWithWebSearch(resp *models.ChatCompletionResponse, requestID string, webSearchCalls []WebSearchCall, includeSources bool) *models.ResponsesResponse {
 	response := converter.ConvertResponse(resp, requestID)
+	annotateURLCitations(response, webSearchCalls)
 
 	// Prepend web_search_call items to output
-	webSearchItems := BuildWebSearchOutputItems(webSearchCalls)
+	webSearchItems := BuildWebSearchOutputItems(webSearchCalls, includeSources)
 	if len(webSearchItems) > 0 {
 		// Create new output with web_search_calls first
 		newOutput := make([]models.OutputItem, 0, len(webSearchItems)+len(response.Output))
@@ -294,11 +393,22 @@ type StreamingResult struct {
 	ResponseID     string
 	AssistantMsg   models.ChatMessage
 	WebSearchCalls []WebSearchCall
+	// Exchange holds the exchanges with the web_search tool that precede
+	// the reply
+	Exchange []models.ChatMessage
 }
 
-// HandleStreamingWithWebSearch handles streaming response with web_search support
-// This is more complex as we need to buffer the response and check for tool calls
-// Returns the result for storage
+// HandleStreamingWithWebSearch streams a request with web_search support.
+// Every upstream turn is streamed as it arrives; the searches the model asks
+// for run between turns and are streamed as web_search_call items. Client
+// tool calls that come with web_search calls end the turn once the searches
+// are done.
+// finish is called with the result and the ID the items of the final
+// assistant message were derived from before response.completed; the items
+// it returns are appended to the output.
+// Returns the result for storage, or nil if the request failed. An error is
+// returned only if the first upstream request failed; nothing was written
+// then, so the caller can answer with the status of the error.
 func (h *WebSearchHandler) HandleStreamingWithWebSearch(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -306,171 +416,126 @@ func (h *WebSearchHandler) HandleStreamingWithWebSearch(
 	apiKey string,
 	targetCfg *config.TargetConfig,
 	responseID string,
+	conversation *models.ConversationRef,
+	includeSources bool,
+	finish func(result *StreamingResult, itemID string) []models.OutputItem,
 	log *zap.Logger,
-) *StreamingResult {
-	// For streaming, we need to collect the entire response first
-	// to check for web_search tool calls
+) (*StreamingResult, error) {
 	ctx := r.Context()
 
-	resp, webSearchCalls, err := h.HandleWithWebSearch(ctx, chatReq, apiKey, targetCfg, log)
-	if err != nil {
-		log.Error("web_search handling failed", zap.Error(err))
-		h.writeError(w, err)
-		return nil
-	}
-
-	// Now stream the final response
-	// Since we already have the complete response, we'll simulate streaming
-	h.simulateStreaming(w, resp, responseID, webSearchCalls, log)
-
-	// Build result for storage
-	result := &StreamingResult{
-		ResponseID:     responseID,
-		WebSearchCalls: webSearchCalls,
-	}
+	// Track accumulated messages
+	messages := make([]models.ChatMessage, len(chatReq.Messages))
+	copy(messages, chatReq.Messages)
 
-	// Build assistant message from response
-	if len(resp.Choices) > 0 {
-		result.AssistantMsg = resp.Choices[0].Message
+	result := &StreamingResult{ResponseID: responseID}
+	opts := converter.StreamOptions{
+		ToolTypes: chatReq.ToolTypes,
+		HeldTools: map[string]bool{"web_search": true},
+		Annotate: func(text string) []models.Annotation {
+			return converter.URLCitations(text, webSearchSources(result.WebSearchCalls))
+		},
 	}
 
-	return result
-}
+	var stream *converter.EventStream
+	for i := 0; ; i++ {
+		// The last turn cannot search any more; its calls reach the client
+		if i == maxWebSearchIterations {
+			opts.HeldTools = nil
+		}
 
-// simulateStreaming simulates streaming for web_search handled responses
-func (h *WebSearchHandler) simulateStreaming(
-	w http.ResponseWriter,
-	resp *models.ChatCompletionResponse,
-	responseID string,
-	webSearchCalls []WebSearchCall,
-	log *zap.Logger,
-) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		log.Error("streaming not supported")
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
-		return
-	}
+		currentReq := *chatReq
+		currentReq.Messages = messages
+		currentReq.Stream = true
 
-	// Send response.created event
-	h.sendSSE(w, flusher, "response.created", map[string]interface{}{
-		"type": "response.created",
-		"response": map[string]interface{}{
-			"id":     fmt.Sprintf("resp-%s", responseID),
-			"status": "in_progress",
-		},
-	})
+		resp, err := openChatCompletion(ctx, h.client, &currentReq, apiKey, targetCfg, log)
+		if err != nil {
+			log.Error("web_search handling failed", zap.Error(err))
+			if stream == nil {
+				return nil, err
+			}
+			stream.Fail(err)
+			return nil, nil
+		}
 
-	// Send web_search_call events
-	for i, call := range webSearchCalls {
-		h.sendSSE(w, flusher, "response.output_item.added", map[string]interface{}{
-			"type":         "response.output_item.added",
-			"output_index": i,
-			"item": map[string]interface{}{
-				"type":   "web_search_call",
-				"id":     call.ID,
-				"status": call.Status,
-				"action": map[string]interface{}{
-					"type":  "search",
-					"query": call.Query,
-				},
-			},
-		})
-	}
+		// The stream starts once the first turn is accepted, so errors of
+		// the first request keep their status code
+		if stream == nil {
+			stream = converter.NewEventStream(w, models.ResponsesResponse{
+				ID:           fmt.Sprintf("resp-%s", responseID),
+				Model:        chatReq.Model,
+				Conversation: conversation,
+			}, log)
+			stream.Start()
+		}
 
-	// Send message content
-	if len(resp.Choices) > 0 {
-		choice := resp.Choices[0]
-		contentIndex := len(webSearchCalls)
-
-		// Send output_item.added for message
-		h.sendSSE(w, flusher, "response.output_item.added", map[string]interface{}{
-			"type":         "response.output_item.added",
-			"output_index": contentIndex,
-			"item": map[string]interface{}{
-				"type":   "message",
-				"id":     fmt.Sprintf("msg-%s", responseID),
-				"role":   "assistant",
-				"status": "in_progress",
-			},
-		})
-
-		// Send content delta
-		if content, ok := choice.Message.Content.(string); ok && content != "" {
-			h.sendSSE(w, flusher, "response.output_text.delta", map[string]interface{}{
-				"type":         "response.output_text.delta",
-				"delta":        content,
-				"output_index": contentIndex,
-			})
+		// Items of later turns get IDs of their own
+		itemID := responseID
+		if i > 0 {
+			itemID = fmt.Sprintf("%s-%d", responseID, i)
+		}
+		turn, err := converter.StreamTurn(stream, resp.Body, itemID, opts, log)
+		resp.Body.Close()
+		if err != nil {
+			log.Error("web_search stream failed", zap.Error(err))
+			stream.Fail(err)
+			return nil, nil
 		}
 
-		// Send output_item.done for message (required by Codex CLI)
-		h.sendSSE(w, flusher, "response.output_item.done", map[string]interface{}{
-			"type":         "response.output_item.done",
-			"output_index": contentIndex,
-			"item": map[string]interface{}{
-				"type":    "message",
-				"id":      fmt.Sprintf("msg-%s", responseID),
-				"role":    "assistant",
-				"content": []map[string]interface{}{{"type": "output_text", "text": choice.Message.Content}},
-				"status":  "completed",
-			},
-		})
-
-		// Send tool calls if any
-		for _, tc := range choice.Message.ToolCalls {
-			if tc.Function.Name != "web_search" { // Skip web_search, already handled
-				toolIndex := contentIndex + 1
-				h.sendSSE(w, flusher, "response.output_item.added", map[string]interface{}{
-					"type":         "response.output_item.added",
-					"output_index": toolIndex,
-					"item": map[string]interface{}{
-						"type":      "function_call",
-						"id":        fmt.Sprintf("fc-%s", responseID),
-						"call_id":   tc.ID,
-						"name":      tc.Function.Name,
-						"arguments": tc.Function.Arguments,
-						"status":    "completed",
-					},
-				})
+		// complete ends the response with the reply of the turn
+		complete := func() *StreamingResult {
+			result.AssistantMsg = streamedAssistantMessage(turn)
+			if finish != nil {
+				for _, item := range finish(result, itemID) {
+					stream.WriteItem(item)
+				}
+			}
+			if turn.Usage != nil {
+				stream.Response.Usage = *turn.Usage
 			}
+			stream.Complete()
+			return result
 		}
-	}
 
-	// Send response.completed
-	fullResp := ConvertResponseWithWebSearch(resp, responseID, webSearchCalls)
-	h.sendSSE(w, flusher, "response.completed", map[string]interface{}{
-		"type":     "response.completed",
-		"response": fullResp,
-	})
+		// If no web_search calls, we're done
+		if len(turn.HeldCalls) == 0 {
+			return complete(), nil
+		}
 
-	// Send done
-	h.sendSSE(w, flusher, "done", nil)
-}
+		log.Info("detected web_search calls",
+			zap.Int("count", len(turn.HeldCalls)),
+			zap.Int("client_calls", len(turn.ToolCalls)),
+		)
 
-// sendSSE sends a server-sent event
-func (h *WebSearchHandler) sendSSE(w http.ResponseWriter, flusher http.Flusher, event string, data interface{}) {
-	if data != nil {
-		dataBytes, _ := json.Marshal(data)
-		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, string(dataBytes))
-	} else {
-		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", event)
-	}
-	flusher.Flush()
-}
+		// Run the searches concurrently while their items report the progress
+		queries := webSearchQueries(turn.HeldCalls, log)
+		indexes := make([]int, len(turn.HeldCalls))
+		for j, tc := range turn.HeldCalls {
+			indexes[j] = stream.AddItem(webSearchItem(WebSearchCall{ID: tc.ID, Query: queries[j], Status: "in_progress"}, false))
+			stream.ItemStatus("response.web_search_call.in_progress", indexes[j])
+			stream.ItemStatus("response.web_search_call.searching", indexes[j])
+		}
 
-// writeError writes an error response
-func (h *WebSearchHandler) writeError(w http.ResponseWriter, err error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusInternalServerError)
-	json.NewEncoder(w).Encode(models.ErrorResponse{
-		Error: models.ErrorDetail{
-			Type:    "web_search_error",
-			Message: err.Error(),
-		},
-	})
+		calls, toolMsgs := h.runWebSearches(ctx, turn.HeldCalls, queries, chatReq.WebSearch, len(webSearchSources(result.WebSearchCalls))+1, log)
+		result.WebSearchCalls = append(result.WebSearchCalls, calls...)
+
+		for j, call := range calls {
+			stream.ItemStatus("response.web_search_call.completed", indexes[j])
+			stream.FinishItem(indexes[j], webSearchItem(call, includeSources))
+		}
+
+		// The client tool calls of the turn were streamed already
+		_, rest := splitWebSearchCalls(turn.Calls)
+		msg := models.ChatMessage{}
+		if turn.OutputText != "" {
+			msg.Content = turn.OutputText
+		}
+		step := append([]models.ChatMessage{webSearchStep(&msg, turn.HeldCalls, rest)}, toolMsgs...)
+		result.Exchange = append(result.Exchange, step...)
+		messages = append(messages, step...)
+
+		// Client tool calls end the turn; the reply keeps only them
+		if len(rest) > 0 {
+			return complete(), nil
+		}
+	}
 }
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// newFirecrawlServer starts a Firecrawl search API with one result per query
+// and counts its searches
+func newFirecrawlServer(t *testing.T, searches *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		searches.Add(1)
+		var req struct {
+			Query string `json:"query"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":true,"data":{"web":[{"url":"https://example.com/%d","title":%q,"description":"About it"}]}}`, searches.Load(), req.Query)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// withWebSearch enables web search on a Firecrawl server
+func withWebSearch(srv *httptest.Server) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WebSearch = config.WebSearchConfig{
+			Enabled: true,
+			Default: "fc",
+			Providers: map[string]config.ProviderConfig{
+				"fc": {Type: "firecrawl", BaseURL: srv.URL, APIKey: "x"},
+			},
+		}
+	}
+}
+
+// writeChunks streams Chat Completions chunks, each a JSON delta of choice 0
+func writeChunks(w http.ResponseWriter, deltas ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, delta := range deltas {
+		fmt.Fprintf(w, "data: {\"id\":\"c\",\"model\":\"m\",\"choices\":[{\"index\":0,%s}]}\n\n", delta)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// readEvents parses the SSE events of a response
+func readEvents(t *testing.T, rec *httptest.ResponseRecorder) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(rec.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("Invalid event %q: %v", data, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestWebSearchStreaming(t *testing.T) {
+	t.Run("Searches between turns", func(t *testing.T) {
+		var searches, upstreamCalls atomic.Int32
+		srv := newFirecrawlServer(t, &searches)
+		h, _ := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			if upstreamCalls.Add(1) == 1 {
+				writeChunks(w,
+					`"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"web_search","arguments":"{\"query\":\"golang\"}"}}]}`,
+					`"delta":{},"finish_reason":"tool_calls"`,
+				)
+				return
+			}
+			writeChunks(w,
+				`"delta":{"role":"assistant","content":"Go is "}`,
+				`"delta":{"content":"great"}`,
+				`"delta":{},"finish_reason":"stop"`,
+			)
+		}, withWebSearch(srv))
+
+		rec := serve(h, http.MethodPost, "/v1/responses", `{"model":"m","input":"what is go?","stream":true,"tools":[{"type":"web_search"}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var types []string
+		for i, event := range readEvents(t, rec) {
+			types = append(types, event["type"].(string))
+			if seq, _ := event["sequence_number"].(float64); int(seq) != i {
+				t.Errorf("Event %d (%s): expected sequence_number %d, got %v", i, event["type"], i, event["sequence_number"])
+			}
+		}
+		want := []string{
+			"response.created",
+			"response.in_progress",
+			"response.output_item.added",
+			"response.web_search_call.in_progress",
+			"response.web_search_call.searching",
+			"response.web_search_call.completed",
+			"response.output_item.done",
+			"response.output_item.added",
+			"response.content_part.added",
+			"response.output_text.delta",
+			"response.output_text.delta",
+			"response.output_text.done",
+			"response.content_part.done",
+			"response.output_item.done",
+			"response.completed",
+		}
+		if strings.Join(types, "\n") != strings.Join(want, "\n") {
+			t.Errorf("Unexpected events:\n%s\nwant:\n%s", strings.Join(types, "\n"), strings.Join(want, "\n"))
+		}
+		if searches.Load() != 1 || upstreamCalls.Load() != 2 {
+			t.Errorf("Expected 1 search and 2 upstream calls, got %d and %d", searches.Load(), upstreamCalls.Load())
+		}
+	})
+
+	t.Run("Upstream error keeps its status", func(t *testing.T) {
+		var searches atomic.Int32
+		srv := newFirecrawlServer(t, &searches)
+		h, _ := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"type":"rate_limit_error","message":"slow down"}}`)
+		}, withWebSearch(srv))
+
+		for _, stream := range []bool{true, false} {
+			body := fmt.Sprintf(`{"model":"m","input":"hi","stream":%t,"tools":[{"type":"web_search"}]}`, stream)
+			rec := serve(h, http.MethodPost, "/v1/responses", body)
+			if rec.Code != http.StatusTooManyRequests {
+				t.Errorf("stream=%t: expected 429, got %d: %s", stream, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), "slow down") {
+				t.Errorf("stream=%t: expected the upstream error, got %s", stream, rec.Body.String())
+			}
+		}
+	})
+}
+
+func TestWebSearchMixedCalls(t *testing.T) {
+	var searches, upstreamCalls atomic.Int32
+	var followUp models.ChatCompletionRequest
+	srv := newFirecrawlServer(t, &searches)
+	h, store := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if upstreamCalls.Add(1) == 1 {
+			fmt.Fprint(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","content":"Let me check.","tool_calls":[`+
+				`{"id":"call_ws","type":"function","function":{"name":"web_search","arguments":"{\"query\":\"weather news\"}"}},`+
+				`{"id":"call_fn","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&followUp)
+		fmt.Fprint(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","content":"Sunny"}}]}`)
+	}, withWebSearch(srv))
+
+	tools := `[{"type":"web_search"},{"type":"function","name":"get_weather","parameters":{"type":"object"}}]`
+	rec := serve(h, http.MethodPost, "/v1/responses", `{"model":"m","input":"weather in Paris?","tools":`+tools+`}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body := decodeBody(t, rec)
+
+	// The search runs in the proxy; only the client call reaches the client
+	var types []string
+	for _, item := range body["output"].([]interface{}) {
+		item := item.(map[string]interface{})
+		types = append(types, item["type"].(string))
+		if item["type"] == "function_call" && (item["call_id"] != "call_fn" || item["name"] != "get_weather") {
+			t.Errorf("Unexpected function_call: %v", item)
+		}
+	}
+	if strings.Join(types, ",") != "web_search_call,function_call,message" {
+		t.Errorf("Expected the search, the client call and the text, got %v", types)
+	}
+	if searches.Load() != 1 || upstreamCalls.Load() != 1 {
+		t.Errorf("Expected 1 search and 1 upstream call, got %d and %d", searches.Load(), upstreamCalls.Load())
+	}
+
+	// The stored history holds the search exchange before the client call
+	id := body["id"].(string)
+	history, ok := store.Get(id)
+	if !ok {
+		t.Fatal("Expected the turn to be stored")
+	}
+	var roles []string
+	for _, msg := range history {
+		roles = append(roles, msg.Role)
+	}
+	if strings.Join(roles, ",") != "user,assistant,tool,assistant" {
+		t.Fatalf("Unexpected history roles %v", roles)
+	}
+	if calls := history[1].ToolCalls; len(calls) != 1 || calls[0].ID != "call_ws" {
+		t.Errorf("Expected the search call first, got %+v", history[1])
+	}
+	if content, _ := history[2].Content.(string); history[2].ToolCallID != "call_ws" || !strings.Contains(content, "https://example.com/1") {
+		t.Errorf("Expected the search results, got %+v", history[2])
+	}
+	if calls := history[3].ToolCalls; len(calls) != 1 || calls[0].ID != "call_fn" || history[3].Content != "Let me check." {
+		t.Errorf("Expected the reply with the client call, got %+v", history[3])
+	}
+
+	// The next turn sends the search results along with the function output
+	rec = serve(h, http.MethodPost, "/v1/responses", fmt.Sprintf(
+		`{"model":"m","previous_response_id":%q,"input":[{"type":"function_call_output","call_id":"call_fn","output":"18C"}],"tools":%s}`, id, tools))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var toolIDs []string
+	for _, msg := range followUp.Messages {
+		if msg.Role == "tool" {
+			toolIDs = append(toolIDs, msg.ToolCallID)
+		}
+	}
+	if strings.Join(toolIDs, ",") != "call_ws,call_fn" {
+		t.Errorf("Expected the search and function results upstream, got %v", toolIDs)
+	}
+}
+
+func TestDroppedWebSearches(t *testing.T) {
+	search := []models.InputItem{{Type: "web_search_call", ID: "ws_1"}}
+	pending := []models.ChatMessage{{Role: "assistant", ToolCalls: []models.ToolCall{{ID: "call_fn"}}}}
+	answered := []models.ChatMessage{{Role: "assistant", Content: "done"}}
+
+	tests := []struct {
+		name     string
+		messages []models.ChatMessage
+		opts     turnOptions
+		want     bool
+	}{
+		{"Client calls after searches, not kept", pending, turnOptions{output: search}, true},
+		{"Stored", pending, turnOptions{output: search, persist: true}, false},
+		{"Sealed", pending, turnOptions{output: search, seal: true}, false},
+		{"Answered", answered, turnOptions{output: search}, false},
+		{"No searches", pending, turnOptions{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := droppedWebSearches(tt.messages, tt.opts); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSearchProviderStats(t *testing.T) {
+	var searches atomic.Int32
+	srv := newFirecrawlServer(t, &searches)
+	var upstreamCalls atomic.Int32
+	h, _ := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if upstreamCalls.Add(1) == 1 {
+			fmt.Fprint(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","tool_calls":[`+
+				`{"id":"call_ws","type":"function","function":{"name":"web_search","arguments":"{\"query\":\"go\"}"}}]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"c","model":"m","choices":[{"message":{"role":"assistant","content":"ok"}}]}`)
+	}, withWebSearch(srv))
+
+	if rec := serve(h, http.MethodPost, "/v1/responses", `{"model":"m","input":"go?","tools":[{"type":"web_search"}]}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := serve(h, http.MethodGet, "/providers", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	stats, _ := decodeBody(t, rec)["search_providers"].([]interface{})
+	if len(stats) != 1 {
+		t.Fatalf("Expected the stats of one provider, got %v", stats)
+	}
+	fc := stats[0].(map[string]interface{})
+	if fc["name"] != "fc" || fc["state"] != "closed" || fc["successes"] != float64(1) || fc["failures"] != float64(0) {
+		t.Errorf("Unexpected provider stats: %v", fc)
+	}
+}
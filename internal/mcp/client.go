@@ -0,0 +1,381 @@
+// Package mcp implements a client for remote MCP (Model Context Protocol)
+// servers speaking the Streamable HTTP transport
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/pkg/logger"
+)
+
+// ProtocolVersion is the MCP protocol version the client asks for
+const ProtocolVersion = "2025-03-26"
+
+// sessionHeader carries the session ID assigned by the server
+const sessionHeader = "Mcp-Session-Id"
+
+// Tool is a tool offered by an MCP server
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema,omitempty"`
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+// Content is a content part of a tool result
+type Content struct {
+	Type     string `json:"type"` // "text", "image", "audio", "resource", ...
+	Text     string `json:"text,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// CallResult is the result of a tools/call request
+type CallResult struct {
+	Content           []Content   `json:"content"`
+	StructuredContent interface{} `json:"structuredContent,omitempty"`
+	IsError           bool        `json:"isError,omitempty"`
+}
+
+// Text joins the text parts of the result. Structured content is used when
+// the result has no text.
+func (r *CallResult) Text() string {
+	var parts []string
+	for _, c := range r.Content {
+		if c.Type == "text" {
+			parts = append(parts, c.Text)
+		}
+	}
+	if len(parts) == 0 && r.StructuredContent != nil {
+		data, _ := json.Marshal(r.StructuredContent)
+		return string(data)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// RPCError is a JSON-RPC error returned by the server
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code: %d)", e.Message, e.Code)
+}
+
+// errSessionExpired is returned when the server no longer knows the session
+var errSessionExpired = errors.New("mcp session expired")
+
+// request is a JSON-RPC request or, without an ID, a notification
+type request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	ID      int64       `json:"id,omitempty"`
+}
+
+// response is a JSON-RPC response
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      *int64          `json:"id,omitempty"`
+}
+
+// Client talks to a single MCP server. It initializes a session on first
+// use and re-initializes it when the server reports it expired. A Client is
+// safe for concurrent use.
+type Client struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	nextID  atomic.Int64
+
+	// Session management
+	mu        sync.Mutex
+	ready     bool
+	sessionID string
+}
+
+// NewClient creates a client for the MCP server at url that sends its
+// requests with client. headers are sent with every request, e.g.
+// Authorization.
+func NewClient(url string, headers map[string]string, client *http.Client) *Client {
+	return &Client{
+		url:     url,
+		headers: headers,
+		client:  client,
+	}
+}
+
+// ListTools returns all tools the server offers
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	cursor := ""
+	for {
+		var params interface{}
+		if cursor != "" {
+			params = map[string]string{"cursor": cursor}
+		}
+
+		var page struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, err
+		}
+		tools = append(tools, page.Tools...)
+
+		if page.NextCursor == "" || page.NextCursor == cursor {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// CallTool calls a tool with the given arguments. A result with IsError set
+// is returned without an error; it describes a failure of the tool itself.
+func (c *Client) CallTool(ctx context.Context, name string, arguments map[string]interface{}) (*CallResult, error) {
+	if arguments == nil {
+		arguments = map[string]interface{}{}
+	}
+	params := map[string]interface{}{
+		"name":      name,
+		"arguments": arguments,
+	}
+
+	var result CallResult
+	if err := c.call(ctx, "tools/call", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Reset drops the current session; the next request starts a new one
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = false
+	c.sessionID = ""
+}
+
+// call sends a request within the session and decodes its result into out.
+// An expired session is re-initialized once.
+func (c *Client) call(ctx context.Context, method string, params, out interface{}) error {
+	for attempt := 0; ; attempt++ {
+		sessionID, err := c.ensureSession(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to initialize MCP session: %w", err)
+		}
+
+		resp, err := c.send(ctx, method, params, sessionID)
+		if errors.Is(err, errSessionExpired) && attempt == 0 {
+			c.Reset()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.Unmarshal(resp.Result, out); err != nil {
+			return fmt.Errorf("failed to parse %s result: %w", method, err)
+		}
+		return nil
+	}
+}
+
+// ensureSession performs the initialize handshake unless a session is
+// already established, and returns the session ID
+func (c *Client) ensureSession(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// If we already have a session, reuse it
+	if c.ready {
+		return c.sessionID, nil
+	}
+
+	log := logger.Log
+	log.Debug("initializing new MCP session", zap.String("url", c.url))
+
+	params := map[string]interface{}{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo": map[string]string{
+			"name":    "responses2chat",
+			"version": "1.0.0",
+		},
+	}
+	resp, err := c.send(ctx, "initialize", params, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+
+	// Servers without sessions simply do not return the header
+	c.sessionID = resp.sessionID
+	if c.sessionID == "" {
+		log.Debug("no mcp-session-id in response header, continuing without", zap.String("url", c.url))
+	}
+
+	// Complete the handshake; servers answer notifications with 202. Some
+	// servers do not expect it, so a failure is not fatal.
+	if err := c.notify(ctx, "notifications/initialized", c.sessionID); err != nil {
+		log.Debug("initialized notification failed", zap.String("url", c.url), zap.Error(err))
+	}
+	c.ready = true
+
+	log.Debug("MCP session initialized",
+		zap.String("url", c.url),
+		zap.String("session_id", c.sessionID),
+	)
+	return c.sessionID, nil
+}
+
+// sessionResponse is a response together with the session header
+type sessionResponse struct {
+	response
+	sessionID string
+}
+
+// send posts a request and waits for its response, which arrives either as
+// a JSON body or as an event of an SSE stream
+func (c *Client) send(ctx context.Context, method string, params interface{}, sessionID string) (*sessionResponse, error) {
+	id := c.nextID.Add(1)
+	httpResp, err := c.post(ctx, request{JSONRPC: "2.0", Method: method, Params: params, ID: id}, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	logger.Log.Debug("MCP raw response",
+		zap.String("method", method),
+		zap.Int("status", httpResp.StatusCode),
+		zap.String("body", string(body)),
+	)
+
+	if httpResp.StatusCode == http.StatusNotFound && sessionID != "" {
+		return nil, errSessionExpired
+	}
+	if httpResp.StatusCode >= 400 {
+		return nil, fmt.Errorf("MCP server returned status %d: %s", httpResp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	resp, err := findResponse(httpResp.Header.Get("Content-Type"), body, id)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionResponse{response: *resp, sessionID: httpResp.Header.Get(sessionHeader)}, nil
+}
+
+// notify sends a notification, which has no response
+func (c *Client) notify(ctx context.Context, method, sessionID string) error {
+	httpResp, err := c.post(ctx, request{JSONRPC: "2.0", Method: method}, sessionID)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	io.Copy(io.Discard, httpResp.Body)
+
+	if httpResp.StatusCode >= 400 {
+		return fmt.Errorf("MCP server returned status %d for %s", httpResp.StatusCode, method)
+	}
+	return nil
+}
+
+// post sends a JSON-RPC message to the server
+func (c *Client) post(ctx context.Context, msg request, sessionID string) (*http.Response, error) {
+	bodyBytes, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json, text/event-stream")
+	for k, v := range c.headers {
+		httpReq.Header.Set(k, v)
+	}
+	if sessionID != "" {
+		httpReq.Header.Set(sessionHeader, sessionID)
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	return resp, nil
+}
+
+// findResponse extracts the response with the given ID from a JSON body or
+// from the data lines of an SSE body. Server requests and notifications
+// interleaved in the stream are skipped.
+func findResponse(contentType string, body []byte, id int64) (*response, error) {
+	// Some servers send SSE without the matching content type
+	var payloads []string
+	if strings.HasPrefix(contentType, "text/event-stream") || !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		var data []string
+		for _, line := range strings.Split(string(body), "\n") {
+			line = strings.TrimRight(line, "\r")
+			switch {
+			case strings.HasPrefix(line, "data:"):
+				data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			case line == "" && len(data) > 0:
+				payloads = append(payloads, strings.Join(data, "\n"))
+				data = nil
+			}
+		}
+		if len(data) > 0 {
+			payloads = append(payloads, strings.Join(data, "\n"))
+		}
+	} else {
+		payloads = []string{string(body)}
+	}
+
+	for _, payload := range payloads {
+		var resp response
+		if err := json.Unmarshal([]byte(payload), &resp); err != nil {
+			continue
+		}
+		if resp.ID != nil && *resp.ID == id {
+			return &resp, nil
+		}
+	}
+	return nil, fmt.Errorf("no response to request %d in MCP server reply: %s", id, truncate(string(body), 200))
+}
+
+// truncate shortens s to at most n bytes for error messages
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
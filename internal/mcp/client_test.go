@@ -0,0 +1,226 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/young1lin/responses2chat/pkg/logger"
+)
+
+func init() {
+	logger.Init("error", "text")
+}
+
+// fakeServer is an MCP server recording the messages it receives
+type fakeServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	sessions int             // Sessions handed out so far
+	expired  map[string]bool // Sessions answered with 404
+	methods  []string        // Methods in order, with the session they came with
+	reply    func(msg map[string]interface{}) (contentType, body string)
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		f.t.Errorf("Invalid JSON-RPC message %q: %v", data, err)
+		return
+	}
+	method, _ := msg["method"].(string)
+	session := r.Header.Get(sessionHeader)
+
+	f.mu.Lock()
+	f.methods = append(f.methods, fmt.Sprintf("%s@%s", method, session))
+	expired := f.expired[session]
+	if method == "initialize" {
+		f.sessions++
+		session = fmt.Sprintf("s%d", f.sessions)
+	}
+	f.mu.Unlock()
+
+	switch {
+	case expired:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case method == "initialize":
+		if params, _ := msg["params"].(map[string]interface{}); params["protocolVersion"] != ProtocolVersion {
+			f.t.Errorf("Expected protocolVersion %s, got %v", ProtocolVersion, params["protocolVersion"])
+		}
+		w.Header().Set(sessionHeader, session)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%v,"result":{"protocolVersion":%q,"capabilities":{}}}`, msg["id"], ProtocolVersion)
+		return
+	case msg["id"] == nil:
+		// Notification
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	contentType, body := f.reply(msg)
+	w.Header().Set("Content-Type", contentType)
+	fmt.Fprint(w, body)
+}
+
+func (f *fakeServer) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.methods...)
+}
+
+func newFakeServer(t *testing.T, reply func(msg map[string]interface{}) (string, string)) (*fakeServer, *Client) {
+	f := &fakeServer{t: t, expired: make(map[string]bool), reply: reply}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, NewClient(srv.URL, map[string]string{"Authorization": "Bearer k"}, &http.Client{Timeout: 5 * time.Second})
+}
+
+// jsonResult answers a request with a JSON body holding result
+func jsonResult(result string) func(msg map[string]interface{}) (string, string) {
+	return func(msg map[string]interface{}) (string, string) {
+		return "application/json", fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"result":%s}`, msg["id"], result)
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Run("Initialize handshake", func(t *testing.T) {
+		f, c := newFakeServer(t, jsonResult(`{"tools":[{"name":"echo","description":"Echo"}]}`))
+
+		tools, err := c.ListTools(context.Background())
+		if err != nil {
+			t.Fatalf("ListTools failed: %v", err)
+		}
+		if len(tools) != 1 || tools[0].Name != "echo" {
+			t.Errorf("Expected the echo tool, got %+v", tools)
+		}
+		if _, err := c.ListTools(context.Background()); err != nil {
+			t.Fatalf("Second ListTools failed: %v", err)
+		}
+
+		want := []string{"initialize@", "notifications/initialized@s1", "tools/list@s1", "tools/list@s1"}
+		if got := f.calls(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("SSE reply", func(t *testing.T) {
+		_, c := newFakeServer(t, func(msg map[string]interface{}) (string, string) {
+			// A server notification precedes the response
+			return "text/event-stream", fmt.Sprintf("event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n"+
+				"event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":%v,\"result\":{\"content\":[{\"type\":\"text\",\"text\":\"hi\"}]}}\n\n", msg["id"])
+		})
+
+		result, err := c.CallTool(context.Background(), "echo", map[string]interface{}{"text": "hi"})
+		if err != nil {
+			t.Fatalf("CallTool failed: %v", err)
+		}
+		if result.Text() != "hi" {
+			t.Errorf("Expected hi, got %q", result.Text())
+		}
+	})
+
+	t.Run("Session expiry re-initializes", func(t *testing.T) {
+		f, c := newFakeServer(t, jsonResult(`{"content":[{"type":"text","text":"ok"}]}`))
+
+		if _, err := c.CallTool(context.Background(), "echo", nil); err != nil {
+			t.Fatalf("CallTool failed: %v", err)
+		}
+		f.mu.Lock()
+		f.expired["s1"] = true
+		f.mu.Unlock()
+
+		result, err := c.CallTool(context.Background(), "echo", nil)
+		if err != nil {
+			t.Fatalf("CallTool after expiry failed: %v", err)
+		}
+		if result.Text() != "ok" {
+			t.Errorf("Expected ok, got %q", result.Text())
+		}
+
+		want := []string{
+			"initialize@", "notifications/initialized@s1", "tools/call@s1",
+			"tools/call@s1", "initialize@", "notifications/initialized@s2", "tools/call@s2",
+		}
+		if got := f.calls(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("RPC error", func(t *testing.T) {
+		_, c := newFakeServer(t, func(msg map[string]interface{}) (string, string) {
+			return "application/json", fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"error":{"code":-32601,"message":"no such tool"}}`, msg["id"])
+		})
+
+		_, err := c.CallTool(context.Background(), "missing", nil)
+		rpcErr, ok := err.(*RPCError)
+		if !ok || rpcErr.Code != -32601 {
+			t.Errorf("Expected RPC error -32601, got %v", err)
+		}
+	})
+}
+
+func TestFindResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantResult  string
+		wantErr     bool
+	}{
+		{
+			name:        "JSON body",
+			contentType: "application/json",
+			body:        `{"jsonrpc":"2.0","id":7,"result":{"a":1}}`,
+			wantResult:  `{"a":1}`,
+		},
+		{
+			name:        "SSE with other messages first",
+			contentType: "text/event-stream",
+			body:        "data: {\"jsonrpc\":\"2.0\",\"id\":6,\"result\":{}}\n\ndata: {\"jsonrpc\":\"2.0\",\"id\":7,\"result\":{\"b\":2}}\n\n",
+			wantResult:  `{"b":2}`,
+		},
+		{
+			name:        "SSE with CRLF and no trailing blank line",
+			contentType: "text/event-stream; charset=utf-8",
+			body:        "event: message\r\ndata:{\"jsonrpc\":\"2.0\",\"id\":7,\"result\":{\"c\":3}}",
+			wantResult:  `{"c":3}`,
+		},
+		{
+			name:        "SSE without content type",
+			contentType: "application/json",
+			body:        "data: {\"jsonrpc\":\"2.0\",\"id\":7,\"result\":{\"d\":4}}\n\n",
+			wantResult:  `{"d":4}`,
+		},
+		{
+			name:        "Missing response",
+			contentType: "text/event-stream",
+			body:        "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := findResponse(tt.contentType, []byte(tt.body), 7)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findResponse failed: %v", err)
+			}
+			if string(resp.Result) != tt.wantResult {
+				t.Errorf("Expected result %s, got %s", tt.wantResult, resp.Result)
+			}
+		})
+	}
+}
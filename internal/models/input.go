@@ -0,0 +1,226 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParamError is a request error caused by a single parameter, reported to
+// the client in the param field of the error
+type ParamError struct {
+	Param   string // e.g. "input[1].content[0].type"
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	if e.Param == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Param, e.Message)
+}
+
+// InputItems is the input of a request. Besides an array of items it
+// accepts a plain string, which is a single user message.
+type InputItems []InputItem
+
+// UnmarshalJSON accepts a string or an array of input items
+func (items *InputItems) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*items = nil
+		return nil
+
+	case len(data) > 0 && data[0] == '"':
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return &ParamError{Param: "input", Message: err.Error()}
+		}
+		*items = InputItems{{
+			Type:    "message",
+			Role:    "user",
+			Content: []ContentItem{{Type: "input_text", Text: text}},
+		}}
+		return nil
+
+	case len(data) > 0 && data[0] == '[':
+		var raws []json.RawMessage
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return &ParamError{Param: "input", Message: err.Error()}
+		}
+		result := make(InputItems, len(raws))
+		for i, raw := range raws {
+			if err := json.Unmarshal(raw, &result[i]); err != nil {
+				return prefixParam(fmt.Sprintf("input[%d]", i), err)
+			}
+		}
+		*items = result
+		return nil
+
+	default:
+		return &ParamError{Param: "input", Message: "must be a string or an array of input items"}
+	}
+}
+
+// UnmarshalJSON accepts the shapes of the official API: items without a type
+// that have a role are messages, and content may be a string
+func (item *InputItem) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return &ParamError{Message: "must be an object"}
+	}
+
+	type inputItem InputItem
+	var raw struct {
+		inputItem
+		Content json.RawMessage `json:"content,omitempty"`
+		Output  json.RawMessage `json:"output,omitempty"`
+		Action  json.RawMessage `json:"action,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*item = InputItem(raw.inputItem)
+
+	// The action of a web_search_call is a search, not a shell command
+	if len(raw.Action) > 0 && !bytes.Equal(raw.Action, []byte("null")) {
+		var action interface{} = &item.Action
+		if item.Type == "web_search_call" {
+			action = &item.SearchAction
+		}
+		if err := json.Unmarshal(raw.Action, action); err != nil {
+			return prefixParam("action", err)
+		}
+	}
+
+	if item.Type == "" {
+		if item.Role == "" {
+			return &ParamError{Param: "type", Message: "is required unless role is set"}
+		}
+		item.Type = "message"
+	}
+	if item.Type == "message" && item.Role == "" {
+		return &ParamError{Param: "role", Message: "is required for message items"}
+	}
+
+	content, err := decodeContent(raw.Content, item.Role)
+	if err != nil {
+		return prefixParam("content", err)
+	}
+	item.Content = content
+
+	output, err := decodeOutput(raw.Output)
+	if err != nil {
+		return prefixParam("output", err)
+	}
+	item.Output = output
+	return nil
+}
+
+// MarshalJSON writes the action of a web_search_call item
+func (item InputItem) MarshalJSON() ([]byte, error) {
+	type inputItem InputItem
+	if item.Type == "web_search_call" {
+		return json.Marshal(struct {
+			inputItem
+			Action *WebSearchCallAction `json:"action,omitempty"`
+		}{inputItem(item), item.SearchAction})
+	}
+	return json.Marshal(inputItem(item))
+}
+
+// decodeContent decodes message content given as a string or as an array of
+// content parts. String content of assistant messages is output text.
+func decodeContent(data json.RawMessage, role string) ([]ContentItem, error) {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0 || bytes.Equal(data, []byte("null")):
+		return nil, nil
+
+	case data[0] == '"':
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return nil, err
+		}
+		partType := "input_text"
+		if role == "assistant" {
+			partType = "output_text"
+		}
+		return []ContentItem{{Type: partType, Text: text}}, nil
+
+	case data[0] == '[':
+		var raws []json.RawMessage
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return nil, err
+		}
+		parts := make([]ContentItem, len(raws))
+		for i, raw := range raws {
+			param := fmt.Sprintf("[%d]", i)
+			if raw := bytes.TrimSpace(raw); len(raw) == 0 || raw[0] != '{' {
+				return nil, &ParamError{Param: param, Message: "must be a content part object"}
+			}
+			if err := json.Unmarshal(raw, &parts[i]); err != nil {
+				return nil, prefixParam(param, err)
+			}
+			if parts[i].Type == "" {
+				return nil, &ParamError{Param: param + ".type", Message: "is required"}
+			}
+		}
+		return parts, nil
+
+	default:
+		return nil, &ParamError{Message: "must be a string or an array of content parts"}
+	}
+}
+
+// decodeOutput decodes a function call output given as a string or as an
+// array of content parts, whose text parts are joined
+func decodeOutput(data json.RawMessage) (string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return "", nil
+	}
+	if data[0] != '"' && data[0] != '[' {
+		return "", &ParamError{Message: "must be a string or an array of content parts"}
+	}
+
+	parts, err := decodeContent(data, "")
+	if err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// prefixParam roots the param of an error at prefix; other errors are
+// reported against prefix itself
+func prefixParam(prefix string, err error) error {
+	var paramErr *ParamError
+	if errors.As(err, &paramErr) {
+		switch {
+		case paramErr.Param == "":
+			return &ParamError{Param: prefix, Message: paramErr.Message}
+		case strings.HasPrefix(paramErr.Param, "["):
+			return &ParamError{Param: prefix + paramErr.Param, Message: paramErr.Message}
+		default:
+			return &ParamError{Param: prefix + "." + paramErr.Param, Message: paramErr.Message}
+		}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return &ParamError{
+			Param:   prefix + "." + typeErr.Field,
+			Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value),
+		}
+	}
+	return &ParamError{Param: prefix, Message: err.Error()}
+}
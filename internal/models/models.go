@@ -1,12 +1,17 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // ==================== Responses API Models ====================
 
 // ResponsesRequest represents the incoming Responses API request
 type ResponsesRequest struct {
 	Model              string                 `json:"model"`
 	Instructions       string                 `json:"instructions,omitempty"`
-	Input              []InputItem            `json:"input,omitempty"`
+	Input              InputItems             `json:"input,omitempty"` // A string or an array of items
 	Tools              []Tool                 `json:"tools,omitempty"`
 	Stream             bool                   `json:"stream,omitempty"`
 	Temperature        *float64               `json:"temperature,omitempty"`
@@ -14,33 +19,199 @@ type ResponsesRequest struct {
 	PreviousResponseID string                 `json:"previous_response_id,omitempty"`
 	Truncation         string                 `json:"truncation,omitempty"`
 	Metadata           map[string]interface{} `json:"metadata,omitempty"`
+	Text               *TextConfig            `json:"text,omitempty"`
+	ToolChoice         interface{}            `json:"tool_choice,omitempty"` // "auto", "none", "required" or an object
+	ParallelToolCalls  *bool                  `json:"parallel_tool_calls,omitempty"`
+	TopP               *float64               `json:"top_p,omitempty"`
+	Stop               interface{}            `json:"stop,omitempty"` // string or []string, not part of the official API
+	Seed               *int                   `json:"seed,omitempty"`
+	PresencePenalty    *float64               `json:"presence_penalty,omitempty"`
+	FrequencyPenalty   *float64               `json:"frequency_penalty,omitempty"`
+	User               string                 `json:"user,omitempty"`
+	SafetyIdentifier   string                 `json:"safety_identifier,omitempty"`
+	ServiceTier        string                 `json:"service_tier,omitempty"`
+	Store              *bool                  `json:"store,omitempty"`   // Defaults to true
+	Include            []string               `json:"include,omitempty"` // e.g. "reasoning.encrypted_content"
+	Background         bool                   `json:"background,omitempty"`
+	Conversation       interface{}            `json:"conversation,omitempty"` // "conv_..." or {"id": "conv_..."}
+}
+
+// TextConfig represents the text output configuration of a request
+type TextConfig struct {
+	Format    *TextFormat `json:"format,omitempty"`
+	Verbosity string      `json:"verbosity,omitempty"`
+}
+
+// TextFormat represents the requested output format (Structured Outputs)
+type TextFormat struct {
+	Type        string                 `json:"type"` // "text", "json_object", "json_schema"
+	Name        string                 `json:"name,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Strict      *bool                  `json:"strict,omitempty"`
 }
 
 // InputItem represents an item in the input array
 type InputItem struct {
-	Type      string        `json:"type"` // "message", "function_call", "function_call_output"
-	ID        string        `json:"id,omitempty"`
-	Role      string        `json:"role,omitempty"` // "user", "assistant", "system", "developer", "tool"
-	Content   []ContentItem `json:"content,omitempty"`
-	CallID    string        `json:"call_id,omitempty"`
-	Name      string        `json:"name,omitempty"`
-	Arguments string        `json:"arguments,omitempty"`
-	Output    string        `json:"output,omitempty"`
-	Status    string        `json:"status,omitempty"`
+	Type      string            `json:"type"` // "message", "function_call", "function_call_output", "custom_tool_call", ...
+	ID        string            `json:"id,omitempty"`
+	Role      string            `json:"role,omitempty"` // "user", "assistant", "system", "developer", "tool"
+	Content   []ContentItem     `json:"content,omitempty"`
+	CallID    string            `json:"call_id,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Arguments string            `json:"arguments,omitempty"`
+	Input     string            `json:"input,omitempty"`  // custom_tool_call
+	Action    *LocalShellAction `json:"action,omitempty"` // local_shell_call
+	Output    string            `json:"output,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	// SearchAction is the action of a web_search_call, read and written as
+	// action
+	SearchAction *WebSearchCallAction `json:"-"`
+	// MCP items: mcp_list_tools, mcp_call, mcp_approval_request and
+	// mcp_approval_response
+	ServerLabel       string        `json:"server_label,omitempty"`
+	Tools             []MCPToolInfo `json:"tools,omitempty"`
+	Error             string        `json:"error,omitempty"`
+	ApprovalRequestID string        `json:"approval_request_id,omitempty"`
+	Approve           *bool         `json:"approve,omitempty"`
+	Reason            string        `json:"reason,omitempty"`
+	// file_search_call items
+	Queries []string           `json:"queries,omitempty"`
+	Results []FileSearchResult `json:"results,omitempty"`
+	// code_interpreter_call items
+	Code        string                  `json:"code,omitempty"`
+	ContainerID string                  `json:"container_id,omitempty"`
+	Outputs     []CodeInterpreterOutput `json:"outputs,omitempty"`
+	// Reasoning items sent back by the client
+	Summary          []ContentItem `json:"summary,omitempty"`
+	EncryptedContent string        `json:"encrypted_content,omitempty"`
 }
 
 // ContentItem represents content within a message
 type ContentItem struct {
-	Type     string `json:"type"` // "input_text", "output_text", "input_image", "refusal"
+	Type     string `json:"type"` // "input_text", "output_text", "input_image", "input_file", "refusal", "summary_text"
 	Text     string `json:"text,omitempty"`
 	ImageURL string `json:"image_url,omitempty"`
 	Data     string `json:"data,omitempty"`
+	Refusal  string `json:"refusal,omitempty"`
+	// input_file parts reference an uploaded file, inline data or a URL
+	FileID   string `json:"file_id,omitempty"`
+	FileData string `json:"file_data,omitempty"` // Base64, optionally as a data URL
+	FileURL  string `json:"file_url,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	// Annotations of output_text parts, e.g. file citations of file_search
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+// MarshalJSON always emits text and annotations on output_text parts, which
+// start out empty while streaming
+func (c ContentItem) MarshalJSON() ([]byte, error) {
+	type contentItem ContentItem
+	if c.Type != "output_text" {
+		return json.Marshal(contentItem(c))
+	}
+	annotations := c.Annotations
+	if annotations == nil {
+		annotations = []Annotation{}
+	}
+	return json.Marshal(struct {
+		contentItem
+		Text        string       `json:"text"`
+		Annotations []Annotation `json:"annotations"`
+	}{contentItem(c), c.Text, annotations})
+}
+
+// Annotation marks a position of an output_text part, e.g. a file_citation
+type Annotation struct {
+	Type     string `json:"type"`  // "file_citation", "url_citation"
+	Index    int    `json:"index"` // Position in the text, in characters
+	FileID   string `json:"file_id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	// url_citation annotations span a range of the text, in characters
+	StartIndex int    `json:"start_index,omitempty"`
+	EndIndex   int    `json:"end_index,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Title      string `json:"title,omitempty"`
+}
+
+// MarshalJSON emits url_citation annotations with their range and source
+// only, as clients expect them
+func (a Annotation) MarshalJSON() ([]byte, error) {
+	type annotation Annotation
+	if a.Type != "url_citation" {
+		return json.Marshal(annotation(a))
+	}
+	return json.Marshal(struct {
+		Type       string `json:"type"`
+		StartIndex int    `json:"start_index"`
+		EndIndex   int    `json:"end_index"`
+		URL        string `json:"url"`
+		Title      string `json:"title"`
+	}{a.Type, a.StartIndex, a.EndIndex, a.URL, a.Title})
 }
 
 // Tool represents a tool definition (Responses API)
+// Function tools come flat in the Responses API (name, parameters, ... at the
+// top level); the nested Chat Completions form in Function is accepted too.
 type Tool struct {
-	Type     string      `json:"type"` // "function", "web_search", "code_interpreter", etc.
-	Function FunctionDef `json:"function,omitempty"`
+	Type        string                 `json:"type"` // "function", "web_search", "code_interpreter", etc.
+	Function    FunctionDef            `json:"function,omitempty"`
+	Name        string                 `json:"name,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Strict      *bool                  `json:"strict,omitempty"`
+	// Format constrains the free-form input of a "custom" tool
+	Format *CustomToolFormat `json:"format,omitempty"`
+	// Remote MCP server of an "mcp" tool
+	ServerLabel       string            `json:"server_label,omitempty"`
+	ServerURL         string            `json:"server_url,omitempty"`
+	ServerDescription string            `json:"server_description,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	AllowedTools      interface{}       `json:"allowed_tools,omitempty"`    // []string or {"tool_names": [...]}
+	RequireApproval   interface{}       `json:"require_approval,omitempty"` // "always", "never" or {"always"|"never": {"tool_names": [...]}}
+	// Local vector stores searched by a "file_search" tool
+	VectorStoreIDs []string           `json:"vector_store_ids,omitempty"`
+	MaxNumResults  int                `json:"max_num_results,omitempty"`
+	RankingOptions *FileSearchRanking `json:"ranking_options,omitempty"`
+	// Container of a "code_interpreter" tool: a container ID or
+	// {"type": "auto", "file_ids": [...]}
+	Container interface{} `json:"container,omitempty"`
+	// Options of a "web_search" tool
+	Filters           *WebSearchFilters      `json:"filters,omitempty"`
+	UserLocation      *WebSearchUserLocation `json:"user_location,omitempty"`
+	SearchContextSize string                 `json:"search_context_size,omitempty"`
+}
+
+// FileSearchRanking holds the ranking options of a file_search tool
+type FileSearchRanking struct {
+	Ranker         string  `json:"ranker,omitempty"`
+	ScoreThreshold float64 `json:"score_threshold,omitempty"` // Results scoring lower are dropped, 0 to 1
+}
+
+// MCPToolInfo describes a tool of an MCP server in an mcp_list_tools item
+type MCPToolInfo struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+// CustomToolFormat is the input format of a custom tool, e.g. the lark
+// grammar of Codex apply_patch
+type CustomToolFormat struct {
+	Type       string `json:"type"`             // "text" or "grammar"
+	Syntax     string `json:"syntax,omitempty"` // "lark" or "regex"
+	Definition string `json:"definition,omitempty"`
+}
+
+// LocalShellAction is the command of a local_shell_call item
+type LocalShellAction struct {
+	Type             string            `json:"type"` // "exec"
+	Command          []string          `json:"command"`
+	WorkingDirectory string            `json:"working_directory,omitempty"`
+	TimeoutMs        *int              `json:"timeout_ms,omitempty"`
+	Env              map[string]string `json:"env"`
+	User             string            `json:"user,omitempty"`
 }
 
 // FunctionDef represents function definition
@@ -62,18 +233,100 @@ type ResponsesResponse struct {
 	Model     string       `json:"model"`
 	Output    []OutputItem `json:"output"`
 	Usage     UsageInfo    `json:"usage,omitempty"`
+	// Background responses report their progress through Status
+	Background   bool             `json:"background,omitempty"`
+	Error        *ErrorDetail     `json:"error,omitempty"`
+	Conversation *ConversationRef `json:"conversation,omitempty"`
+}
+
+// ConversationRef identifies the conversation a response belongs to
+type ConversationRef struct {
+	ID string `json:"id"`
+}
+
+// Conversation represents a Conversations API conversation
+type Conversation struct {
+	ID        string                 `json:"id"`
+	Object    string                 `json:"object"` // "conversation"
+	CreatedAt int64                  `json:"created_at"`
+	Metadata  map[string]interface{} `json:"metadata"`
+}
+
+// ConversationRequest is the body of conversation create and update requests
+type ConversationRequest struct {
+	Items    InputItems             `json:"items,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // OutputItem represents an item in the output array
 type OutputItem struct {
-	Type      string        `json:"type"` // "message", "function_call"
-	ID        string        `json:"id"`
-	Role      string        `json:"role,omitempty"`
-	Content   []ContentItem `json:"content,omitempty"`
-	CallID    string        `json:"call_id,omitempty"`
-	Name      string        `json:"name,omitempty"`
-	Arguments string        `json:"arguments,omitempty"`
-	Status    string        `json:"status,omitempty"`
+	Type      string            `json:"type"` // "message", "function_call", "custom_tool_call"
+	ID        string            `json:"id"`
+	Role      string            `json:"role,omitempty"`
+	Content   []ContentItem     `json:"content,omitempty"`
+	CallID    string            `json:"call_id,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Arguments string            `json:"arguments,omitempty"`
+	Input     string            `json:"input,omitempty"`  // Free-form input of a custom_tool_call
+	Action    *LocalShellAction `json:"action,omitempty"` // local_shell_call
+	Output    string            `json:"output,omitempty"` // mcp_call
+	Status    string            `json:"status,omitempty"`
+	// SearchAction is the action of a web_search_call, sent as action
+	SearchAction *WebSearchCallAction `json:"-"`
+	// MCP items: mcp_list_tools, mcp_call and mcp_approval_request
+	ServerLabel       string        `json:"server_label,omitempty"`
+	Tools             []MCPToolInfo `json:"tools,omitempty"`
+	Error             string        `json:"error,omitempty"`
+	ApprovalRequestID string        `json:"approval_request_id,omitempty"`
+	// file_search_call items; results are only set when included
+	Queries []string           `json:"queries,omitempty"`
+	Results []FileSearchResult `json:"results,omitempty"`
+	// code_interpreter_call items; outputs are only set when included
+	Code        string                  `json:"code,omitempty"`
+	ContainerID string                  `json:"container_id,omitempty"`
+	Outputs     []CodeInterpreterOutput `json:"outputs,omitempty"`
+	// Summary carries the reasoning summary parts of a "reasoning" item
+	Summary []ContentItem `json:"summary,omitempty"`
+	// EncryptedContent carries sealed conversation state in stateless mode
+	EncryptedContent string `json:"encrypted_content,omitempty"`
+}
+
+// MarshalJSON always emits summary on reasoning items, content on messages
+// and arguments on function calls, where clients such as Codex and the
+// stream helpers of the OpenAI SDKs require them even when they are empty
+func (o OutputItem) MarshalJSON() ([]byte, error) {
+	type outputItem OutputItem
+	switch o.Type {
+	case "reasoning":
+		summary := o.Summary
+		if summary == nil {
+			summary = []ContentItem{}
+		}
+		return json.Marshal(struct {
+			outputItem
+			Summary []ContentItem `json:"summary"`
+		}{outputItem(o), summary})
+	case "message":
+		content := o.Content
+		if content == nil {
+			content = []ContentItem{}
+		}
+		return json.Marshal(struct {
+			outputItem
+			Content []ContentItem `json:"content"`
+		}{outputItem(o), content})
+	case "function_call":
+		return json.Marshal(struct {
+			outputItem
+			Arguments string `json:"arguments"`
+		}{outputItem(o), o.Arguments})
+	case "web_search_call":
+		return json.Marshal(struct {
+			outputItem
+			Action *WebSearchCallAction `json:"action,omitempty"`
+		}{outputItem(o), o.SearchAction})
+	}
+	return json.Marshal(outputItem(o))
 }
 
 // UsageInfo represents token usage information
@@ -106,6 +359,84 @@ type ChatCompletionRequest struct {
 	Stream      bool          `json:"stream,omitempty"`
 	Temperature *float64      `json:"temperature,omitempty"`
 	MaxTokens   int           `json:"max_tokens,omitempty"`
+	// ResponseFormat is only set for providers that support it natively
+	ResponseFormat    *ResponseFormat `json:"response_format,omitempty"`
+	ToolChoice        interface{}     `json:"tool_choice,omitempty"` // "auto", "none", "required" or ChatToolChoice
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+	TopP              *float64        `json:"top_p,omitempty"`
+	Stop              interface{}     `json:"stop,omitempty"`
+	Seed              *int            `json:"seed,omitempty"`
+	PresencePenalty   *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty  *float64        `json:"frequency_penalty,omitempty"`
+	User              string          `json:"user,omitempty"`
+	ServiceTier       string          `json:"service_tier,omitempty"`
+	// ExtraBody holds vendor-specific fields (e.g. Qwen enable_thinking)
+	// merged into the top level of the request body by MarshalJSON
+	ExtraBody map[string]interface{} `json:"-"`
+	// ToolTypes maps the function tools that stand in for Responses tools
+	// such as custom and local_shell to the tool type; their calls are
+	// unwrapped to the matching output items
+	ToolTypes map[string]string `json:"-"`
+	// WebSearch holds the options of the web_search tool the proxy runs
+	WebSearch *WebSearchOptions `json:"-"`
+}
+
+// protectedChatFields cannot be set through ExtraBody, even when the
+// request leaves them out
+var protectedChatFields = map[string]bool{
+	"model":    true,
+	"messages": true,
+	"stream":   true,
+}
+
+// MarshalJSON merges ExtraBody into the top level of the request body.
+// Fields the request already sets win, so ExtraBody only adds fields and
+// cannot replace e.g. the tools or max_tokens of the converted request. A
+// nil value in ExtraBody adds nothing.
+func (r ChatCompletionRequest) MarshalJSON() ([]byte, error) {
+	type plain ChatCompletionRequest
+	data, err := json.Marshal(plain(r))
+	if err != nil || len(r.ExtraBody) == 0 {
+		return data, err
+	}
+
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for k, v := range r.ExtraBody {
+		if _, set := merged[k]; set || v == nil || protectedChatFields[k] {
+			continue
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("extra_body field %q: %w", k, err)
+		}
+		merged[k] = raw
+	}
+	return json.Marshal(merged)
+}
+
+// ChatToolChoice forces a specific function in Chat Completions
+type ChatToolChoice struct {
+	Type     string `json:"type"` // "function"; "custom" or "local_shell" for emulated tool calls kept by the proxy, never sent upstream
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+// ResponseFormat represents the response_format parameter in Chat Completions
+type ResponseFormat struct {
+	Type       string            `json:"type"` // "text", "json_object", "json_schema"
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat represents the json_schema object of response_format
+type JSONSchemaFormat struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Strict      *bool                  `json:"strict,omitempty"`
 }
 
 // ChatMessage represents a message in Chat Completions
@@ -115,6 +446,10 @@ type ChatMessage struct {
 	Name       string      `json:"name,omitempty"`
 	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
 	ToolCallID string      `json:"tool_call_id,omitempty"`
+	// ReasoningContent is the chain-of-thought returned by reasoning models
+	// (DeepSeek-R1, GLM-Z1, Qwen). It is kept in stored history but stripped
+	// before messages are sent upstream.
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 }
 
 // ChatContentPart represents a content part for multimodal messages
@@ -134,12 +469,17 @@ type ChatTool struct {
 
 // ToolCall represents a tool call in a message
 type ToolCall struct {
+	// Index identifies the tool call across streaming chunks; it is only
+	// present in stream deltas
+	Index    *int   `json:"index,omitempty"`
 	ID       string `json:"id"`
-	Type     string `json:"type"` // "function"
+	Type     string `json:"type"` // "function"; "custom", "local_shell" or "mcp_approval_request" for tool calls kept by the proxy, never sent upstream
 	Function struct {
 		Name      string `json:"name"`
 		Arguments string `json:"arguments"`
 	} `json:"function"`
+	// ServerLabel names the MCP server of an mcp_approval_request call
+	ServerLabel string `json:"server_label,omitempty"`
 }
 
 // ==================== Chat Completions API Response Models ====================
@@ -163,9 +503,11 @@ type ChatChoice struct {
 
 // ChatUsage represents token usage in Chat Completions
 type ChatUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens      int                         `json:"prompt_tokens"`
+	CompletionTokens  int                         `json:"completion_tokens"`
+	TotalTokens       int                         `json:"total_tokens"`
+	PromptDetails     *ChatChunkPromptDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionDetails *ChatChunkCompletionDetails `json:"completion_tokens_details,omitempty"`
 }
 
 // ==================== Streaming Models ====================
@@ -183,10 +525,10 @@ type ChatCompletionChunk struct {
 
 // ChatChunkUsage represents usage info in streaming chunk
 type ChatChunkUsage struct {
-	PromptTokens     int                      `json:"prompt_tokens"`
-	CompletionTokens int                      `json:"completion_tokens"`
-	TotalTokens      int                      `json:"total_tokens"`
-	PromptDetails    *ChatChunkPromptDetails  `json:"prompt_tokens_details,omitempty"`
+	PromptTokens      int                         `json:"prompt_tokens"`
+	CompletionTokens  int                         `json:"completion_tokens"`
+	TotalTokens       int                         `json:"total_tokens"`
+	PromptDetails     *ChatChunkPromptDetails     `json:"prompt_tokens_details,omitempty"`
 	CompletionDetails *ChatChunkCompletionDetails `json:"completion_tokens_details,omitempty"`
 }
 
@@ -209,9 +551,10 @@ type ChatChunkChoice struct {
 
 // ChatDelta represents the delta in a streaming chunk
 type ChatDelta struct {
-	Role      string     `json:"role,omitempty"`
-	Content   string     `json:"content,omitempty"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Role             string     `json:"role,omitempty"`
+	Content          string     `json:"content,omitempty"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
 }
 
 // ==================== SSE Event Models ====================
@@ -222,43 +565,235 @@ type SSEEvent struct {
 	Data  string `json:"data"`
 }
 
-// ResponseCreatedEvent represents response.created event
-type ResponseCreatedEvent struct {
-	Type     string          `json:"type"`
-	Response ResponseSummary `json:"response"`
+// StreamEvent holds the fields every streaming event has
+type StreamEvent struct {
+	Type           string `json:"type"`
+	SequenceNumber int    `json:"sequence_number"`
 }
 
-// ResponseSummary represents basic response info
-type ResponseSummary struct {
-	ID     string `json:"id"`
-	Status string `json:"status,omitempty"`
+// Sequence numbers the event and returns its type
+func (e *StreamEvent) Sequence(n int) string {
+	e.SequenceNumber = n
+	return e.Type
 }
 
-// OutputTextDeltaEvent represents response.output_text.delta event
-type OutputTextDeltaEvent struct {
-	Type        string `json:"type"`
-	Delta       string `json:"delta"`
-	OutputIndex int    `json:"output_index"`
+// ResponseEvent represents the response.created, response.in_progress,
+// response.completed and response.failed events, which carry the response
+type ResponseEvent struct {
+	StreamEvent
+	Response ResponsesResponse `json:"response"`
 }
 
-// OutputItemAddedEvent represents response.output_item.added event
-type OutputItemAddedEvent struct {
-	Type        string     `json:"type"`
-	OutputIndex int        `json:"output_index,omitempty"`
+// OutputItemEvent represents response.output_item.added and
+// response.output_item.done events
+type OutputItemEvent struct {
+	StreamEvent
+	OutputIndex int        `json:"output_index"`
 	Item        OutputItem `json:"item"`
 }
 
-// OutputItemDoneEvent represents response.output_item.done event
-type OutputItemDoneEvent struct {
-	Type        string     `json:"type"`
-	OutputIndex int        `json:"output_index,omitempty"`
-	Item        OutputItem `json:"item"`
+// ContentPartEvent represents response.content_part.added and
+// response.content_part.done events
+type ContentPartEvent struct {
+	StreamEvent
+	ItemID       string      `json:"item_id"`
+	OutputIndex  int         `json:"output_index"`
+	ContentIndex int         `json:"content_index"`
+	Part         ContentItem `json:"part"`
+}
+
+// OutputTextEvent represents response.output_text.delta and
+// response.output_text.done events; Delta is set on the first, Text on the
+// second
+type OutputTextEvent struct {
+	StreamEvent
+	ItemID       string        `json:"item_id"`
+	OutputIndex  int           `json:"output_index"`
+	ContentIndex int           `json:"content_index"`
+	Delta        *string       `json:"delta,omitempty"`
+	Text         *string       `json:"text,omitempty"`
+	Logprobs     []interface{} `json:"logprobs"`
+}
+
+// AnnotationAddedEvent represents response.output_text.annotation.added event
+type AnnotationAddedEvent struct {
+	StreamEvent
+	ItemID          string     `json:"item_id"`
+	OutputIndex     int        `json:"output_index"`
+	ContentIndex    int        `json:"content_index"`
+	AnnotationIndex int        `json:"annotation_index"`
+	Annotation      Annotation `json:"annotation"`
+}
+
+// ReasoningSummaryPartEvent represents response.reasoning_summary_part.added
+// and response.reasoning_summary_part.done events
+type ReasoningSummaryPartEvent struct {
+	StreamEvent
+	ItemID       string      `json:"item_id"`
+	OutputIndex  int         `json:"output_index"`
+	SummaryIndex int         `json:"summary_index"`
+	Part         ContentItem `json:"part"`
+}
+
+// ReasoningSummaryTextEvent represents response.reasoning_summary_text.delta
+// and response.reasoning_summary_text.done events
+type ReasoningSummaryTextEvent struct {
+	StreamEvent
+	ItemID       string  `json:"item_id"`
+	OutputIndex  int     `json:"output_index"`
+	SummaryIndex int     `json:"summary_index"`
+	Delta        *string `json:"delta,omitempty"`
+	Text         *string `json:"text,omitempty"`
+}
+
+// ItemDeltaEvent represents the events that stream a string field of an
+// item, e.g. response.function_call_arguments.delta or
+// response.custom_tool_call_input.done. One of the value fields is set.
+type ItemDeltaEvent struct {
+	StreamEvent
+	ItemID      string  `json:"item_id"`
+	OutputIndex int     `json:"output_index"`
+	Delta       *string `json:"delta,omitempty"`
+	Arguments   *string `json:"arguments,omitempty"` // function_call_arguments.done, mcp_call_arguments.done
+	Input       *string `json:"input,omitempty"`     // custom_tool_call_input.done
+	Code        *string `json:"code,omitempty"`      // code_interpreter_call_code.done
+}
+
+// ItemStatusEvent represents the progress events of built-in tool calls,
+// e.g. response.file_search_call.searching
+type ItemStatusEvent struct {
+	StreamEvent
+	ItemID      string `json:"item_id"`
+	OutputIndex int    `json:"output_index"`
 }
 
-// ResponseCompletedEvent represents response.completed event
-type ResponseCompletedEvent struct {
-	Type     string            `json:"type"`
-	Response ResponsesResponse `json:"response"`
+// ErrorEvent represents the error event
+type ErrorEvent struct {
+	StreamEvent
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// FileObject represents a file uploaded through the Files API
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"` // "file"
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"` // e.g. "user_data", "assistants", "vision"
+	Status    string `json:"status,omitempty"`
+}
+
+// VectorStore represents a local vector store of the Vector Stores API
+type VectorStore struct {
+	ID           string                 `json:"id"`
+	Object       string                 `json:"object"` // "vector_store"
+	CreatedAt    int64                  `json:"created_at"`
+	Name         string                 `json:"name"`
+	UsageBytes   int64                  `json:"usage_bytes"`
+	FileCounts   VectorStoreFileCounts  `json:"file_counts"`
+	Status       string                 `json:"status"` // "completed"
+	LastActiveAt int64                  `json:"last_active_at"`
+	Metadata     map[string]interface{} `json:"metadata"`
+}
+
+// VectorStoreFileCounts counts the files of a vector store by status
+type VectorStoreFileCounts struct {
+	InProgress int `json:"in_progress"`
+	Completed  int `json:"completed"`
+	Failed     int `json:"failed"`
+	Cancelled  int `json:"cancelled"`
+	Total      int `json:"total"`
+}
+
+// VectorStoreFile represents a file added to a vector store
+type VectorStoreFile struct {
+	ID               string                 `json:"id"`     // ID of the file in the Files API
+	Object           string                 `json:"object"` // "vector_store.file"
+	CreatedAt        int64                  `json:"created_at"`
+	VectorStoreID    string                 `json:"vector_store_id"`
+	Status           string                 `json:"status"` // "completed" or "failed"
+	UsageBytes       int64                  `json:"usage_bytes"`
+	LastError        *VectorStoreFileError  `json:"last_error"`
+	ChunkingStrategy *ChunkingStrategy      `json:"chunking_strategy,omitempty"`
+	Attributes       map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// VectorStoreFileError describes why a file could not be indexed
+type VectorStoreFileError struct {
+	Code    string `json:"code"` // "unsupported_file" or "server_error"
+	Message string `json:"message"`
+}
+
+// ChunkingStrategy controls how files are split into chunks
+type ChunkingStrategy struct {
+	Type   string          `json:"type"` // "auto" or "static"
+	Static *StaticChunking `json:"static,omitempty"`
+}
+
+// StaticChunking sets the chunk size of the "static" chunking strategy
+type StaticChunking struct {
+	MaxChunkSizeTokens int `json:"max_chunk_size_tokens"`
+	ChunkOverlapTokens int `json:"chunk_overlap_tokens"`
+}
+
+// VectorStoreRequest is the body of vector store create requests
+type VectorStoreRequest struct {
+	Name             string                 `json:"name,omitempty"`
+	FileIDs          []string               `json:"file_ids,omitempty"`
+	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+	ChunkingStrategy *ChunkingStrategy      `json:"chunking_strategy,omitempty"`
+}
+
+// VectorStoreFileRequest is the body of requests adding a file to a vector store
+type VectorStoreFileRequest struct {
+	FileID           string                 `json:"file_id"`
+	Attributes       map[string]interface{} `json:"attributes,omitempty"`
+	ChunkingStrategy *ChunkingStrategy      `json:"chunking_strategy,omitempty"`
+}
+
+// VectorStoreChunk is an indexed chunk of a vector store file. It is only
+// stored, never returned by the API.
+type VectorStoreChunk struct {
+	FileID    string    `json:"file_id"`
+	Filename  string    `json:"filename"`
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// FileSearchResult is a chunk found by file_search
+type FileSearchResult struct {
+	FileID     string                 `json:"file_id"`
+	Filename   string                 `json:"filename"`
+	Score      float64                `json:"score"`
+	Text       string                 `json:"text,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// CodeInterpreterOutput is an output of a code_interpreter_call: the logs of
+// the run or a file it wrote, kept in the Files API
+type CodeInterpreterOutput struct {
+	Type     string `json:"type"` // "logs" or "file"
+	Logs     string `json:"logs,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// ListResponse is the cursor-paginated list envelope of list endpoints
+type ListResponse struct {
+	Object  string      `json:"object"` // "list"
+	Data    interface{} `json:"data"`
+	FirstID string      `json:"first_id"`
+	LastID  string      `json:"last_id"`
+	HasMore bool        `json:"has_more"`
+}
+
+// DeletedObject is returned by delete endpoints
+type DeletedObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"` // e.g. "response.deleted"
+	Deleted bool   `json:"deleted"`
 }
 
 // ErrorResponse represents an error response
@@ -271,4 +806,5 @@ type ErrorDetail struct {
 	Type    string `json:"type"`
 	Code    string `json:"code,omitempty"`
 	Message string `json:"message"`
+	Param   string `json:"param,omitempty"` // The invalid request parameter, if any
 }
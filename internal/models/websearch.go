@@ -7,6 +7,43 @@ type SearchProviderResult struct {
 	Error   string         `json:"error,omitempty"`
 }
 
+// CachedSearchResult represents a search result kept by the search cache
+type CachedSearchResult struct {
+	Result    *SearchProviderResult `json:"result"`
+	ExpiresAt int64                 `json:"expires_at"` // Unix seconds
+}
+
+// SearchRequest represents a query to a search provider with the options of
+// the web_search tool
+type SearchRequest struct {
+	Query          string
+	MaxResults     int      // 0 leaves the provider default
+	AllowedDomains []string // Results outside these domains are dropped
+	UserLocation   *WebSearchUserLocation
+}
+
+// WebSearchOptions holds the options of a web_search tool
+type WebSearchOptions struct {
+	AllowedDomains    []string
+	UserLocation      *WebSearchUserLocation
+	SearchContextSize string // "low", "medium" or "high"
+}
+
+// WebSearchFilters represents the filters of a web_search tool
+type WebSearchFilters struct {
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+}
+
+// WebSearchUserLocation represents the approximate location of the user
+// a web_search tool localizes its results for
+type WebSearchUserLocation struct {
+	Type     string `json:"type,omitempty"` // "approximate"
+	City     string `json:"city,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Country  string `json:"country,omitempty"` // ISO 3166-1 alpha-2, e.g. "US"
+	Timezone string `json:"timezone,omitempty"`
+}
+
 // SearchResult represents a single search result
 type SearchResult struct {
 	Title   string `json:"title"`
@@ -27,6 +64,14 @@ type WebSearchCallItem struct {
 type WebSearchCallAction struct {
 	Type  string `json:"type"`  // "search"
 	Query string `json:"query"` // search query
+	// Sources lists the results of the search, when include asks for them
+	Sources []WebSearchSource `json:"sources,omitempty"`
+}
+
+// WebSearchSource represents a source of a web_search_call action
+type WebSearchSource struct {
+	Type string `json:"type"` // "url"
+	URL  string `json:"url"`
 }
 
 // WebSearchFunctionArgs represents arguments for web_search function
@@ -0,0 +1,56 @@
+package retrieval
+
+import "math"
+
+// BM25 parameters, the common defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// BM25 scores each document against the query. Documents without any word
+// of the query score 0.
+func BM25(query string, docs []string) []float64 {
+	scores := make([]float64, len(docs))
+	terms := make(map[string]bool)
+	for _, t := range tokenize(query) {
+		terms[t.text] = true
+	}
+	if len(terms) == 0 || len(docs) == 0 {
+		return scores
+	}
+
+	// Frequencies of the query terms per document
+	freqs := make([]map[string]int, len(docs))
+	lengths := make([]int, len(docs))
+	df := make(map[string]int)
+	total := 0
+	for i, doc := range docs {
+		freqs[i] = make(map[string]int)
+		tokens := tokenize(doc)
+		lengths[i] = len(tokens)
+		total += len(tokens)
+		for _, t := range tokens {
+			if terms[t.text] {
+				if freqs[i][t.text] == 0 {
+					df[t.text]++
+				}
+				freqs[i][t.text]++
+			}
+		}
+	}
+	avgLength := float64(total) / float64(len(docs))
+	if avgLength == 0 {
+		return scores
+	}
+
+	n := float64(len(docs))
+	for i := range docs {
+		for term, tf := range freqs[i] {
+			idf := math.Log(1 + (n-float64(df[term])+0.5)/(float64(df[term])+0.5))
+			f := float64(tf)
+			scores[i] += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(lengths[i])/avgLength))
+		}
+	}
+	return scores
+}
@@ -0,0 +1,93 @@
+package retrieval
+
+import (
+	"math"
+	"sort"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// rrfK damps the weight of top ranks in reciprocal rank fusion
+const rrfK = 60
+
+// Result is a chunk ranked against a query
+type Result struct {
+	Chunk models.VectorStoreChunk
+	Score float64 // 0 to 1, relative to the best possible match
+}
+
+// Rank orders chunks by relevance to the query and returns at most limit of
+// them. Chunks are ranked with BM25 and, given a query embedding, by cosine
+// similarity to chunks with an embedding of the same size; the two rankings
+// are merged by reciprocal rank fusion. Chunks matching neither are left out.
+func Rank(query string, queryEmbedding []float32, chunks []models.VectorStoreChunk, limit int) []Result {
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.Text
+	}
+	lexicalScores := BM25(query, texts)
+	lexical := rankBy(lexicalScores, func(i int) bool { return lexicalScores[i] > 0 })
+
+	var semantic []int
+	if len(queryEmbedding) > 0 {
+		similarities := make([]float64, len(chunks))
+		for i, c := range chunks {
+			if len(c.Embedding) == len(queryEmbedding) {
+				similarities[i] = cosine(queryEmbedding, c.Embedding)
+			}
+		}
+		semantic = rankBy(similarities, func(i int) bool { return len(chunks[i].Embedding) == len(queryEmbedding) })
+	}
+
+	var results []Result
+	if len(semantic) == 0 {
+		for _, i := range lexical {
+			results = append(results, Result{Chunk: chunks[i], Score: lexicalScores[i] / lexicalScores[lexical[0]]})
+		}
+	} else {
+		fused := make([]float64, len(chunks))
+		for rank, i := range lexical {
+			fused[i] += 1 / float64(rrfK+rank+1)
+		}
+		for rank, i := range semantic {
+			fused[i] += 1 / float64(rrfK+rank+1)
+		}
+		best := 2 / float64(rrfK+1)
+		for _, i := range rankBy(fused, func(i int) bool { return fused[i] > 0 }) {
+			results = append(results, Result{Chunk: chunks[i], Score: fused[i] / best})
+		}
+	}
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// rankBy returns the indexes accepted by keep, highest score first
+func rankBy(scores []float64, keep func(int) bool) []int {
+	var order []int
+	for i := range scores {
+		if keep(i) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	return order
+}
+
+// cosine returns the cosine similarity of two vectors of the same size
+func cosine(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
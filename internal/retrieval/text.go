@@ -0,0 +1,82 @@
+// Package retrieval splits files into chunks and ranks the chunks against
+// a query for the file_search tool
+package retrieval
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is a word of a text and its byte offsets
+type token struct {
+	text       string // Lower case
+	start, end int
+}
+
+// tokenize splits text into lower case words. Han, kana and hangul
+// characters are words of their own, as such text has no spaces.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, token{text: strings.ToLower(text[start:end]), start: start, end: end})
+			start = -1
+		}
+	}
+
+	for i, r := range text {
+		switch {
+		case isIdeographic(r):
+			flush(i)
+			end := i + utf8.RuneLen(r)
+			tokens = append(tokens, token{text: text[i:end], start: i, end: end})
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+// isIdeographic reports whether r belongs to a script written without spaces
+func isIdeographic(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Chunk splits text into chunks of at most maxTokens words, where
+// neighbouring chunks share overlap words. Words stand in for model tokens.
+// Chunks keep the original text between their words.
+func Chunk(text string, maxTokens, overlap int) []string {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return nil
+	}
+	step := maxTokens - overlap
+	if step < 1 {
+		step = 1
+	}
+
+	var chunks []string
+	for first := 0; ; first += step {
+		last := min(first+maxTokens, len(tokens))
+
+		start, end := 0, len(text)
+		if first > 0 {
+			start = tokens[first-1].end
+		}
+		if last < len(tokens) {
+			end = tokens[last-1].end
+		}
+		chunks = append(chunks, strings.TrimSpace(text[start:end]))
+
+		if last == len(tokens) {
+			return chunks
+		}
+	}
+}
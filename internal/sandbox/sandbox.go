@@ -0,0 +1,443 @@
+// Package sandbox runs the Python code of code_interpreter tools in a local
+// subprocess without network access, with resource limits and a timeout.
+// The code sees a root file system of read-only system directories and its
+// container, a working directory whose files persist between runs.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/young1lin/responses2chat/internal/config"
+)
+
+// ErrContainerNotFound is returned when a container does not exist
+var ErrContainerNotFound = errors.New("container not found")
+
+// containerIDPattern keeps container IDs usable as directory names
+var containerIDPattern = regexp.MustCompile(`^cntr_[A-Za-z0-9_-]+$`)
+
+// WorkDir is where the container directory is mounted in the sandbox
+const WorkDir = "/workspace"
+
+// systemPaths are mounted read-only into the sandbox for the interpreter
+// and its libraries; /etc, /home, /root and the proxy's files are not
+var systemPaths = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32"}
+
+// setupFailedCode is the exit code of a run whose sandbox could not be set
+// up, together with setupFailedPrefix on stderr
+const (
+	setupFailedCode   = 125
+	setupFailedPrefix = "sandbox setup failed: "
+)
+
+// bootstrap applies the resource limits passed as arguments and runs the
+// code read from stdin as __main__, leaving itself out of tracebacks
+const bootstrap = `import os, resource, sys
+
+def limit(kind, value):
+    _, hard = resource.getrlimit(kind)
+    if hard != resource.RLIM_INFINITY:
+        value = min(value, hard)
+    resource.setrlimit(kind, (value, value))
+
+memory, cpu, fsize = (int(a) for a in sys.argv[1:4])
+limit(resource.RLIMIT_AS, memory)
+limit(resource.RLIMIT_CPU, cpu)
+limit(resource.RLIMIT_FSIZE, fsize)
+limit(resource.RLIMIT_CORE, 0)
+
+code = sys.stdin.read()
+sys.stdin = open(os.devnull)
+sys.argv = [""]
+sys.path.insert(0, os.getcwd())
+try:
+    exec(compile(code, "<code>", "exec"), {"__name__": "__main__"})
+except SystemExit:
+    raise
+except BaseException:
+    import traceback
+    kind, value, tb = sys.exc_info()
+    traceback.print_exception(kind, value, tb.tb_next)
+    sys.exit(1)
+`
+
+// Sandbox runs code in containers below a directory
+type Sandbox struct {
+	dir         string
+	root        string   // Empty directory the sandbox root is mounted on
+	readOnly    []string // Host paths visible read-only in the sandbox
+	python      string
+	timeout     time.Duration
+	memoryLimit int64 // Bytes
+	maxFileSize int64 // Bytes a run may write to a single file
+	maxOutput   int   // Bytes kept of stdout and of stderr
+}
+
+// Result is the outcome of a run
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	Timeout  time.Duration
+	Files    []string // Files the run created or changed, relative to the container
+}
+
+// New creates a sandbox keeping its containers in dir.
+// Returns an error if the platform cannot isolate the code or the
+// interpreter does not run isolated.
+func New(cfg config.CodeInterpreterConfig, dir string, maxFileSize int64) (*Sandbox, error) {
+	if err := checkSupport(); err != nil {
+		return nil, err
+	}
+	python := cfg.Python
+	if python == "" {
+		python = "python3"
+	}
+	path, err := exec.LookPath(python)
+	if err != nil {
+		return nil, fmt.Errorf("python interpreter %q not found: %w", python, err)
+	}
+	root := filepath.Join(dir, ".root")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+	memoryLimit := cfg.MemoryLimit
+	if memoryLimit <= 0 {
+		memoryLimit = 512
+	}
+	maxOutput := cfg.MaxOutput
+	if maxOutput <= 0 {
+		maxOutput = 20000
+	}
+	s := &Sandbox{
+		dir:         dir,
+		root:        root,
+		readOnly:    readOnlyPaths(path),
+		python:      path,
+		timeout:     time.Duration(timeout) * time.Second,
+		memoryLimit: int64(memoryLimit) * 1024 * 1024,
+		maxFileSize: maxFileSize,
+		maxOutput:   maxOutput,
+	}
+	if err := s.probe(); err != nil {
+		return nil, fmt.Errorf("python interpreter %q does not run isolated: %w", python, err)
+	}
+	return s, nil
+}
+
+// readOnlyPaths returns the system paths plus the installation prefix of
+// the interpreter, e.g. of a virtual environment, unless they cover it.
+// A prefix of / is never added.
+func readOnlyPaths(python string) []string {
+	paths := append([]string{}, systemPaths...)
+	candidates := []string{python}
+	if resolved, err := filepath.EvalSymlinks(python); err == nil {
+		candidates = append(candidates, resolved)
+	}
+	for _, c := range candidates {
+		prefix := filepath.Dir(filepath.Dir(c))
+		if prefix == "/" || covered(paths, prefix) {
+			continue
+		}
+		paths = append(paths, prefix)
+	}
+	return paths
+}
+
+// covered reports whether path is one of paths or below one of them
+func covered(paths []string, path string) bool {
+	for _, p := range paths {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// probe runs an empty program in the sandbox to check that this host can
+// isolate code
+func (s *Sandbox) probe() error {
+	dir, err := os.MkdirTemp(s.dir, ".probe-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := own(dir); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, err := s.command(ctx, dir, "-I", "-c", "pass")
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// command creates the isolated command running the interpreter with args in
+// a container directory
+func (s *Sandbox) command(ctx context.Context, dir string, args ...string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, s.python, args...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"HOME=" + WorkDir,
+		"TMPDIR=" + WorkDir,
+		"LANG=C.UTF-8",
+		"MPLBACKEND=Agg",
+		"PYTHONDONTWRITEBYTECODE=1",
+		"PYTHONIOENCODING=utf-8",
+	}
+	cmd.WaitDelay = time.Second
+	if err := isolate(cmd, s.root, dir, s.readOnly); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// NewContainerID generates the ID of a new container
+func NewContainerID() string {
+	return fmt.Sprintf("cntr_%s", strings.ReplaceAll(uuid.New().String(), "-", ""))
+}
+
+// ValidContainerID reports whether id has the form of a container ID
+func ValidContainerID(id string) bool {
+	return containerIDPattern.MatchString(id)
+}
+
+// containerDir returns the working directory of a container
+func (s *Sandbox) containerDir(id string) (string, error) {
+	if !ValidContainerID(id) {
+		return "", fmt.Errorf("invalid container ID %q", id)
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+// Exists reports whether a container exists
+func (s *Sandbox) Exists(id string) bool {
+	dir, err := s.containerDir(id)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// Create creates a container unless it exists
+func (s *Sandbox) Create(id string) error {
+	dir, err := s.containerDir(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return own(dir)
+}
+
+// WriteFile puts a file into a container under the base name of name
+func (s *Sandbox) WriteFile(id, name string, content []byte) error {
+	dir, err := s.containerDir(id)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.Base(name))
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return err
+	}
+	return own(path)
+}
+
+// ReadFile returns the content of a file of a container, refusing files
+// larger than the sandbox lets a run write
+func (s *Sandbox) ReadFile(id, name string) ([]byte, error) {
+	dir, err := s.containerDir(id)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("invalid file name %q", name)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	}
+	if s.maxFileSize > 0 && info.Size() > s.maxFileSize {
+		return nil, fmt.Errorf("%s exceeds %d bytes", name, s.maxFileSize)
+	}
+	return os.ReadFile(path)
+}
+
+// Run executes Python code in a container. Errors of the code are part of
+// the result; an error is returned only if the code could not be run.
+func (s *Sandbox) Run(ctx context.Context, id, code string) (*Result, error) {
+	if !s.Exists(id) {
+		return nil, ErrContainerNotFound
+	}
+	dir, _ := s.containerDir(id)
+	before := snapshot(dir)
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	cpuSeconds := int64(s.timeout/time.Second) + 1
+	fileSize := s.maxFileSize
+	if fileSize <= 0 {
+		fileSize = s.memoryLimit
+	}
+	cmd, err := s.command(ctx, dir, "-I", "-c", bootstrap,
+		strconv.FormatInt(s.memoryLimit, 10),
+		strconv.FormatInt(cpuSeconds, 10),
+		strconv.FormatInt(fileSize, 10),
+	)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = strings.NewReader(code)
+	stdout := &limitedBuffer{max: s.maxOutput}
+	stderr := &limitedBuffer{max: s.maxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	result := &Result{Timeout: s.timeout}
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.TimedOut = true
+		result.ExitCode = -1
+	case errors.As(err, &exitErr):
+		if exitErr.ExitCode() == setupFailedCode && strings.HasPrefix(stderr.String(), setupFailedPrefix) {
+			return nil, fmt.Errorf("failed to start the sandbox: %s", strings.TrimSpace(stderr.String()))
+		}
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return nil, fmt.Errorf("failed to start the sandbox: %w", err)
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Files = changedFiles(before, snapshot(dir))
+	return result, nil
+}
+
+// Logs returns stdout followed by stderr and a note on how the run ended
+func (r *Result) Logs() string {
+	logs := r.Stdout
+	if r.Stderr != "" {
+		if logs != "" && !strings.HasSuffix(logs, "\n") {
+			logs += "\n"
+		}
+		logs += r.Stderr
+	}
+	switch {
+	case r.TimedOut:
+		logs = strings.TrimRight(logs, "\n") + fmt.Sprintf("\nExecution timed out after %s", r.Timeout)
+	case r.ExitCode != 0:
+		logs = strings.TrimRight(logs, "\n") + fmt.Sprintf("\nProcess exited with status %d", r.ExitCode)
+	}
+	return strings.TrimLeft(logs, "\n")
+}
+
+// fileState identifies a version of a file
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshot records the regular files of a directory tree, skipping hidden
+// files and Python caches
+func snapshot(dir string) map[string]fileState {
+	files := make(map[string]fileState)
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		name := d.Name()
+		if path != dir && (strings.HasPrefix(name, ".") || name == "__pycache__") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files
+}
+
+// changedFiles returns the files of after that are new or differ from before
+func changedFiles(before, after map[string]fileState) []string {
+	var changed []string
+	for name, state := range after {
+		if old, ok := before[name]; !ok || old != state {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return strings.ToValidUTF8(b.buf.String(), "") + "\n... (output truncated)\n"
+	}
+	return b.buf.String()
+}
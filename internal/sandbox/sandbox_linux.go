@@ -0,0 +1,274 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// nobody is the user that runs code when the proxy itself runs as root
+const nobody = 65534
+
+// initArg is the argv[0] of the proxy binary re-executed as the init process
+// of a sandbox. It sets up the sandbox root and then executes the
+// interpreter, as Go cannot run code between clone and exec of a child.
+const initArg = "responses2chat-sandbox-init"
+
+// Capabilities and prctl options not in the syscall package
+const (
+	capSysAdmin          = 21
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	prSetNoNewPrivs      = 38
+)
+
+// Mount flags of statfs that a remount in a user namespace must keep
+const (
+	stNoSuid     = 0x2
+	stNoDev      = 0x4
+	stNoExec     = 0x8
+	stNoAtime    = 0x400
+	stNoDirAtime = 0x800
+	stRelAtime   = 0x1000
+)
+
+// devices are bound into the sandbox root from the host
+var devices = []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"}
+
+// initSpec tells the init process how to set up the sandbox
+type initSpec struct {
+	Root     string   `json:"root"`      // Empty directory the root tmpfs is mounted on
+	WorkDir  string   `json:"work_dir"`  // Container directory, mounted at WorkDir
+	ReadOnly []string `json:"read_only"` // Host paths mounted read-only at the same place
+	UID      int      `json:"uid"`       // User the code runs as; -1 keeps the current one
+	GID      int      `json:"gid"`
+}
+
+func init() {
+	if len(os.Args) > 1 && os.Args[0] == initArg {
+		runInit(os.Args[1], os.Args[2:])
+	}
+}
+
+// checkSupport reports whether the sandbox can run on this platform
+func checkSupport() error {
+	return nil
+}
+
+// isolate makes the command run through the init process in namespaces of
+// its own: a mount namespace whose root holds only the read-only paths, a
+// few devices and the container directory; a network namespace with no
+// interface but a loopback that is down; and PID, IPC and UTS namespaces
+// that hide the proxy. It runs in its own process group so a timeout kills
+// every process the code started. Run as root, the code runs as nobody;
+// otherwise a user namespace maps the current user, without capabilities.
+func isolate(cmd *exec.Cmd, root, workDir string, readOnly []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the proxy binary: %w", err)
+	}
+
+	spec := initSpec{Root: root, WorkDir: workDir, ReadOnly: readOnly, UID: -1, GID: -1}
+	attr := &syscall.SysProcAttr{
+		Setpgid:    true,
+		Pdeathsig:  syscall.SIGKILL,
+		Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+	}
+	if os.Getuid() == 0 {
+		// The init process mounts as root, then switches to nobody
+		spec.UID, spec.GID = nobody, nobody
+	} else {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+		// Lets the init process mount in its user namespace; it clears
+		// the capability before the interpreter starts
+		attr.AmbientCaps = []uintptr{capSysAdmin}
+	}
+
+	rawSpec, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	cmd.Args = append([]string{initArg, string(rawSpec), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = exe
+	cmd.SysProcAttr = attr
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return nil
+}
+
+// runInit sets up the sandbox described by rawSpec and executes argv. It
+// does not return; setup failures exit with setupFailedCode.
+func runInit(rawSpec string, argv []string) {
+	// Capabilities and no_new_privs are per thread; exec from this one
+	runtime.LockOSThread()
+
+	var spec initSpec
+	err := json.Unmarshal([]byte(rawSpec), &spec)
+	if err == nil && len(argv) == 0 {
+		err = fmt.Errorf("no command")
+	}
+	if err == nil {
+		err = setupRoot(&spec)
+	}
+	if err == nil {
+		err = dropPrivileges(&spec)
+	}
+	if err == nil {
+		err = syscall.Exec(argv[0], argv, os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "%s%v\n", setupFailedPrefix, err)
+	os.Exit(setupFailedCode)
+}
+
+// setupRoot builds the sandbox root on a tmpfs and pivots into it, leaving
+// the host file system unreachable
+func setupRoot(spec *initSpec) error {
+	// Keep the mounts below out of the host's mount namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+
+	root := spec.Root
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "size=1m,mode=0755"); err != nil {
+		return fmt.Errorf("mount root: %w", err)
+	}
+	for _, path := range spec.ReadOnly {
+		if err := bindReadOnly(path, filepath.Join(root, path)); err != nil {
+			return fmt.Errorf("mount %s: %w", path, err)
+		}
+	}
+	for _, dev := range devices {
+		if err := bindFile(dev, filepath.Join(root, dev)); err != nil {
+			return fmt.Errorf("mount %s: %w", dev, err)
+		}
+	}
+	workspace := filepath.Join(root, WorkDir)
+	if err := os.MkdirAll(workspace, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(spec.WorkDir, workspace, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("mount work dir: %w", err)
+	}
+	if err := syscall.Mount("", root, "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount root read-only: %w", err)
+	}
+
+	// Stack the new root on the old one and detach the old one
+	if err := syscall.Chdir(root); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach old root: %w", err)
+	}
+	return syscall.Chdir(WorkDir)
+}
+
+// bindReadOnly mounts a host path read-only at dst. Symbolic links are
+// copied, e.g. /bin on merged-/usr systems; missing paths are skipped.
+// Submounts are included but stay writable if they were.
+func bindReadOnly(src, dst string) error {
+	info, err := os.Lstat(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dst, &st); err != nil {
+		return err
+	}
+	return syscall.Mount("", dst, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|lockedFlags(st.Flags), "")
+}
+
+// lockedFlags returns the mount flags of statfs flags that a remount in a
+// user namespace may not clear
+func lockedFlags(flags int64) uintptr {
+	var mflags uintptr
+	for st, ms := range map[int64]uintptr{
+		stNoSuid:     syscall.MS_NOSUID,
+		stNoDev:      syscall.MS_NODEV,
+		stNoExec:     syscall.MS_NOEXEC,
+		stNoAtime:    syscall.MS_NOATIME,
+		stNoDirAtime: syscall.MS_NODIRATIME,
+		stRelAtime:   syscall.MS_RELATIME,
+	} {
+		if flags&st != 0 {
+			mflags |= ms
+		}
+	}
+	return mflags
+}
+
+// bindFile mounts a host file, e.g. a device, at dst
+func bindFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return syscall.Mount(src, dst, "", syscall.MS_BIND, "")
+}
+
+// dropPrivileges switches to the user of the code and makes sure neither
+// the capabilities of the init process nor setuid binaries reach it
+func dropPrivileges(spec *initSpec) error {
+	if spec.UID >= 0 {
+		if err := syscall.Setgroups(nil); err != nil {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+		if err := syscall.Setgid(spec.GID); err != nil {
+			return fmt.Errorf("setgid: %w", err)
+		}
+		if err := syscall.Setuid(spec.UID); err != nil {
+			return fmt.Errorf("setuid: %w", err)
+		}
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0); errno != 0 {
+		return fmt.Errorf("clear ambient capabilities: %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs: %w", errno)
+	}
+	return nil
+}
+
+// own hands a container file or directory to the user that runs the code
+func own(path string) error {
+	if os.Getuid() != 0 {
+		return nil
+	}
+	return os.Chown(path, nobody, nobody)
+}
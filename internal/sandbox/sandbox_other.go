@@ -0,0 +1,22 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// checkSupport reports whether the sandbox can run on this platform; it
+// relies on Linux namespaces to isolate the code
+func checkSupport() error {
+	return errors.New("the code_interpreter sandbox requires Linux")
+}
+
+func isolate(cmd *exec.Cmd, root, workDir string, readOnly []string) error {
+	return checkSupport()
+}
+
+func own(path string) error {
+	return nil
+}
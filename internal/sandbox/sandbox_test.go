@@ -0,0 +1,131 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/young1lin/responses2chat/internal/config"
+)
+
+// newTestSandbox creates a sandbox running the system interpreter, skipping
+// the test where it cannot run isolated
+func newTestSandbox(t *testing.T) *Sandbox {
+	t.Helper()
+	if _, err := os.Stat("/usr/bin/python3"); err != nil {
+		t.Skip("no system python3")
+	}
+	s, err := New(config.CodeInterpreterConfig{Python: "/usr/bin/python3", Timeout: 10}, t.TempDir(), 1<<20)
+	if err != nil {
+		t.Skipf("sandbox unavailable: %v", err)
+	}
+	return s
+}
+
+func TestRun(t *testing.T) {
+	s := newTestSandbox(t)
+	id := NewContainerID()
+	if err := s.Create(id); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Files persist in the container", func(t *testing.T) {
+		result, err := s.Run(context.Background(), id, "import os\nopen('out.txt', 'w').write('hi')\nprint(os.getcwd())")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ExitCode != 0 || strings.TrimSpace(result.Stdout) != WorkDir {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		if len(result.Files) != 1 || result.Files[0] != "out.txt" {
+			t.Errorf("expected out.txt to be reported, got %v", result.Files)
+		}
+		content, err := s.ReadFile(id, "out.txt")
+		if err != nil || string(content) != "hi" {
+			t.Errorf("expected the file on the host, got %q (%v)", content, err)
+		}
+	})
+
+	t.Run("Host is hidden", func(t *testing.T) {
+		secret := filepath.Join(s.dir, "secret.db")
+		if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		code := `
+import os, socket
+paths = [` + "'" + secret + "'" + `, '/etc/passwd', '/root', '/proc/1/environ']
+for path in paths:
+    print(path, os.path.exists(path))
+try:
+    open('/usr/evil', 'w')
+    print('usr writable')
+except OSError:
+    print('usr read-only')
+try:
+    socket.create_connection(('1.1.1.1', 80), timeout=2)
+    print('network up')
+except OSError:
+    print('network down')
+print('pid', os.getpid())
+`
+		result, err := s.Run(context.Background(), id, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ExitCode != 0 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		for _, want := range []string{
+			secret + " False",
+			"/etc/passwd False",
+			"/root False",
+			"/proc/1/environ False",
+			"usr read-only",
+			"network down",
+			"pid 1",
+		} {
+			if !strings.Contains(result.Stdout, want+"\n") {
+				t.Errorf("expected %q in the output, got:\n%s", want, result.Stdout)
+			}
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		s.timeout = 500 * time.Millisecond
+		result, err := s.Run(context.Background(), id, "while True: pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.TimedOut {
+			t.Errorf("expected the run to time out, got %+v", result)
+		}
+	})
+}
+
+func TestReadOnlyPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		python string
+		want   string // Path added to the system paths, if any
+	}{
+		{"System interpreter", "/usr/bin/python3", ""},
+		{"Virtual environment", "/opt/venv/bin/python", "/opt/venv"},
+		{"Interpreter at the root", "/python", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := readOnlyPaths(tt.python)
+			extra := paths[len(systemPaths):]
+			if tt.want == "" && len(extra) != 0 {
+				t.Errorf("expected no extra paths, got %v", extra)
+			}
+			if tt.want != "" && (len(extra) != 1 || extra[0] != tt.want) {
+				t.Errorf("expected %q to be added, got %v", tt.want, extra)
+			}
+		})
+	}
+}
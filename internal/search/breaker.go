@@ -0,0 +1,126 @@
+package search
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned for searches on a provider whose breaker is open
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// Breaker states
+const (
+	breakerClosed   = "closed"    // Searches pass
+	breakerOpen     = "open"      // Searches are rejected until the cooldown ends
+	breakerHalfOpen = "half_open" // One probe search passes
+)
+
+// ProviderStats reports the searches of a provider and its breaker
+type ProviderStats struct {
+	Name                string `json:"name"`
+	State               string `json:"state"`
+	Successes           int64  `json:"successes"`
+	Failures            int64  `json:"failures"`
+	Rejected            int64  `json:"rejected"` // Searches skipped while the breaker was open
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	AvgLatencyMs        int64  `json:"avg_latency_ms"`
+	LastLatencyMs       int64  `json:"last_latency_ms"`
+	LastError           string `json:"last_error,omitempty"`
+}
+
+// breaker is the circuit breaker of a provider. It opens after threshold
+// consecutive failures, rejects searches for cooldown, then lets one probe
+// through: a success closes it, a failure opens it again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time // Clock of the cooldown, replaced in tests
+
+	mu       sync.Mutex
+	state    string
+	openedAt time.Time
+	probing  bool // A half-open probe is in flight
+	stats    ProviderStats
+	latency  time.Duration // Sum over all recorded searches
+}
+
+// newBreaker creates a closed breaker
+func newBreaker(name string, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     breakerClosed,
+		stats:     ProviderStats{Name: name},
+	}
+}
+
+// allow reports whether a search may run, moving an open breaker whose
+// cooldown has ended to half-open
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = breakerHalfOpen
+	}
+	switch {
+	case b.state == breakerClosed:
+		return true
+	case b.state == breakerHalfOpen && !b.probing:
+		b.probing = true
+		return true
+	}
+	b.stats.Rejected++
+	return false
+}
+
+// record counts the outcome of an allowed search
+func (b *breaker) record(err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	b.latency += latency
+	b.stats.LastLatencyMs = latency.Milliseconds()
+
+	if err == nil {
+		b.stats.Successes++
+		b.stats.ConsecutiveFailures = 0
+		b.state = breakerClosed
+		return
+	}
+
+	b.stats.Failures++
+	b.stats.ConsecutiveFailures++
+	b.stats.LastError = err.Error()
+	if b.state == breakerHalfOpen || b.stats.ConsecutiveFailures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// release ends an allowed search without an outcome, e.g. when the client
+// went away, so that a half-open breaker can probe again
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Stats returns the counts and current state of the breaker
+func (b *breaker) Stats() ProviderStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.stats
+	stats.State = b.state
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		stats.State = breakerHalfOpen
+	}
+	if calls := stats.Successes + stats.Failures; calls > 0 {
+		stats.AvgLatencyMs = b.latency.Milliseconds() / calls
+	}
+	return stats
+}
@@ -0,0 +1,156 @@
+package search
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	// step is an action on the breaker and the state it leaves
+	type step struct {
+		do      string // allow, success, failure, release or wait
+		wait    time.Duration
+		allowed bool // Expected result of allow
+		state   string
+	}
+	allow := func(allowed bool, state string) step { return step{do: "allow", allowed: allowed, state: state} }
+	success := func(state string) step { return step{do: "success", state: state} }
+	failure := func(state string) step { return step{do: "failure", state: state} }
+	wait := func(d time.Duration, state string) step { return step{do: "wait", wait: d, state: state} }
+	release := func(state string) step { return step{do: "release", state: state} }
+
+	// fail runs n allowed, failing searches
+	fail := func(n int, last string) []step {
+		var steps []step
+		for i := 1; i <= n; i++ {
+			state := breakerClosed
+			if i == n {
+				state = last
+			}
+			steps = append(steps, allow(true, breakerClosed), failure(state))
+		}
+		return steps
+	}
+	concat := func(parts ...[]step) []step {
+		var steps []step
+		for _, part := range parts {
+			steps = append(steps, part...)
+		}
+		return steps
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"Opens after threshold consecutive failures", concat(
+			fail(3, breakerOpen),
+			[]step{allow(false, breakerOpen)},
+		)},
+		{"Success resets the failure count", concat(
+			fail(2, breakerClosed),
+			[]step{allow(true, breakerClosed), success(breakerClosed)},
+			fail(2, breakerClosed),
+		)},
+		{"Stays open during the cooldown", concat(
+			fail(3, breakerOpen),
+			[]step{wait(29*time.Second, breakerOpen), allow(false, breakerOpen)},
+		)},
+		{"Half-open after the cooldown lets a single probe through", concat(
+			fail(3, breakerOpen),
+			[]step{
+				wait(30*time.Second, breakerHalfOpen),
+				allow(true, breakerHalfOpen),
+				allow(false, breakerHalfOpen),
+			},
+		)},
+		{"Probe success closes", concat(
+			fail(3, breakerOpen),
+			[]step{
+				wait(30*time.Second, breakerHalfOpen),
+				allow(true, breakerHalfOpen),
+				success(breakerClosed),
+				allow(true, breakerClosed),
+			},
+		)},
+		{"Probe failure opens again for a full cooldown", concat(
+			fail(3, breakerOpen),
+			[]step{
+				wait(30*time.Second, breakerHalfOpen),
+				allow(true, breakerHalfOpen),
+				failure(breakerOpen),
+				wait(29*time.Second, breakerOpen),
+				allow(false, breakerOpen),
+				wait(time.Second, breakerHalfOpen),
+			},
+		)},
+		{"Released probe can be retried", concat(
+			fail(3, breakerOpen),
+			[]step{
+				wait(30*time.Second, breakerHalfOpen),
+				allow(true, breakerHalfOpen),
+				release(breakerHalfOpen),
+				allow(true, breakerHalfOpen),
+			},
+		)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			b := newBreaker("p", 3, 30*time.Second)
+			b.now = clock.Now
+
+			for i, s := range tt.steps {
+				switch s.do {
+				case "allow":
+					if got := b.allow(); got != s.allowed {
+						t.Fatalf("Step %d: expected allow %v, got %v", i, s.allowed, got)
+					}
+				case "success":
+					b.record(nil, 10*time.Millisecond)
+				case "failure":
+					b.record(errors.New("boom"), 10*time.Millisecond)
+				case "release":
+					b.release()
+				case "wait":
+					clock.Advance(s.wait)
+				}
+				if got := b.Stats().State; got != s.state {
+					t.Fatalf("Step %d (%s): expected state %s, got %s", i, s.do, s.state, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerStats(t *testing.T) {
+	clock := newFakeClock()
+	b := newBreaker("p", 2, time.Minute)
+	b.now = clock.Now
+
+	b.allow()
+	b.record(nil, 100*time.Millisecond)
+	b.allow()
+	b.record(errors.New("first"), 200*time.Millisecond)
+	b.allow()
+	b.record(errors.New("second"), 300*time.Millisecond)
+	b.allow()
+	b.allow()
+
+	want := ProviderStats{
+		Name:                "p",
+		State:               breakerOpen,
+		Successes:           1,
+		Failures:            2,
+		Rejected:            2,
+		ConsecutiveFailures: 2,
+		AvgLatencyMs:        200,
+		LastLatencyMs:       300,
+		LastError:           "second",
+	}
+	if got := b.Stats(); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,238 @@
+package search
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/pkg/logger"
+)
+
+// CacheStore persists cached search results across restarts, e.g. in the
+// BBolt file of the conversation store
+type CacheStore interface {
+	GetSearchResult(key string) (*models.CachedSearchResult, bool)
+	StoreSearchResult(key string, entry *models.CachedSearchResult) error
+	PruneSearchResults(now time.Time) (int, error)
+}
+
+// CacheStats counts the lookups of the search cache
+type CacheStats struct {
+	Entries   int   `json:"entries"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Coalesced int64 `json:"coalesced"` // Searches that waited for an identical one in flight
+}
+
+// cacheEntry is a search result in the LRU list
+type cacheEntry struct {
+	key       string
+	result    *models.SearchProviderResult
+	expiresAt time.Time
+}
+
+// flight is a search in progress that identical searches wait for
+type flight struct {
+	done   chan struct{}
+	result *models.SearchProviderResult
+	err    error
+}
+
+// cache keeps search results in memory for a TTL, evicting the least
+// recently used ones beyond size, and optionally in a store. Identical
+// searches that run at the same time share one provider call.
+type cache struct {
+	size  int
+	ttl   time.Duration
+	store CacheStore       // Nil unless results persist
+	now   func() time.Time // Clock of the TTL, replaced in tests
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List // Most recently used first
+	inflight map[string]*flight
+	stats    CacheStats
+}
+
+// newCache creates a cache; store may be nil
+func newCache(size int, ttl time.Duration, store CacheStore) *cache {
+	c := &cache{
+		size:     size,
+		ttl:      ttl,
+		store:    store,
+		now:      time.Now,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*flight),
+	}
+
+	if store != nil {
+		if count, err := store.PruneSearchResults(time.Now()); err != nil {
+			logger.Error("failed to prune search cache", zap.Error(err))
+		} else if count > 0 {
+			logger.Info("pruned expired search results", zap.Int("count", count))
+		}
+	}
+	return c
+}
+
+// cacheKey derives the cache key of a request to a provider from the
+// normalized query and the options that change the results
+func cacheKey(provider string, req models.SearchRequest) string {
+	domains := make([]string, 0, len(req.AllowedDomains))
+	for _, d := range req.AllowedDomains {
+		domains = append(domains, normalizeDomain(d))
+	}
+	slices.Sort(domains)
+
+	var location string
+	if loc := req.UserLocation; loc != nil {
+		location = strings.ToLower(strings.Join([]string{loc.City, loc.Region, loc.Country, loc.Timezone}, "|"))
+	}
+
+	raw := strings.Join([]string{
+		provider,
+		strings.Join(strings.Fields(strings.ToLower(req.Query)), " "),
+		strconv.Itoa(req.MaxResults),
+		strings.Join(domains, ","),
+		location,
+	}, "\n")
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// do returns the cached result of key, waits for an identical search in
+// flight or starts search and caches its result. Errors are not cached.
+// The search runs on a context detached from the caller's, so that a
+// caller going away fails neither the search nor the callers waiting for
+// it; only ctx's own cancellation ends the wait of a caller.
+func (c *cache) do(ctx context.Context, key string, search func(context.Context) (*models.SearchProviderResult, error)) (*models.SearchProviderResult, error) {
+	c.mu.Lock()
+	result, ok := c.lookup(key)
+	if !ok && c.store != nil && c.inflight[key] == nil {
+		// Read the store without blocking other lookups
+		c.mu.Unlock()
+		stored, found := c.load(key)
+		c.mu.Lock()
+		if found {
+			result, ok = c.add(key, stored.Result, time.Unix(stored.ExpiresAt, 0)).result, true
+		}
+	}
+	if ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return copyResult(result), nil
+	}
+
+	f, ok := c.inflight[key]
+	if ok {
+		c.stats.Coalesced++
+	} else {
+		c.stats.Misses++
+		f = &flight{done: make(chan struct{})}
+		c.inflight[key] = f
+		go c.run(context.WithoutCancel(ctx), key, f, search)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return copyResult(f.result), nil
+}
+
+// run runs the search of a flight and caches its result
+func (c *cache) run(ctx context.Context, key string, f *flight, search func(context.Context) (*models.SearchProviderResult, error)) {
+	f.result, f.err = search(ctx)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	var entry *cacheEntry
+	if f.err == nil {
+		entry = c.add(key, f.result, c.now().Add(c.ttl))
+	}
+	c.mu.Unlock()
+	close(f.done)
+
+	if f.err == nil && c.store != nil {
+		stored := &models.CachedSearchResult{Result: entry.result, ExpiresAt: entry.expiresAt.Unix()}
+		if err := c.store.StoreSearchResult(key, stored); err != nil {
+			logger.Error("failed to persist search result", zap.Error(err))
+		}
+	}
+}
+
+// lookup returns the unexpired result of key from memory, dropping an
+// expired one. The caller holds mu.
+func (c *cache) lookup(key string) (*models.SearchProviderResult, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.now().Before(entry.expiresAt) {
+		c.order.MoveToFront(el)
+		return entry.result, true
+	}
+	c.order.Remove(el)
+	delete(c.entries, key)
+	return nil, false
+}
+
+// load returns the unexpired result of key from the store
+func (c *cache) load(key string) (*models.CachedSearchResult, bool) {
+	stored, ok := c.store.GetSearchResult(key)
+	if !ok || stored.Result == nil || c.now().Unix() >= stored.ExpiresAt {
+		return nil, false
+	}
+	return stored, true
+}
+
+// add keeps a result in memory, evicting the least recently used entry
+// beyond size. The caller holds mu.
+func (c *cache) add(key string, result *models.SearchProviderResult, expiresAt time.Time) *cacheEntry {
+	entry := &cacheEntry{key: key, result: copyResult(result), expiresAt: expiresAt}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return entry
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return entry
+}
+
+// Stats returns the lookup counts and the number of entries in memory
+func (c *cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// copyResult copies a result, so that callers cannot modify cached ones
+func copyResult(result *models.SearchProviderResult) *models.SearchProviderResult {
+	copied := *result
+	copied.Results = slices.Clone(result.Results)
+	return &copied
+}
@@ -0,0 +1,291 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/pkg/logger"
+)
+
+func init() {
+	logger.Init("error", "text")
+}
+
+// fakeClock is a settable clock for TTLs and cooldowns
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// memoryStore is a CacheStore in a map
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*models.CachedSearchResult
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{entries: make(map[string]*models.CachedSearchResult)}
+}
+
+func (s *memoryStore) GetSearchResult(key string) (*models.CachedSearchResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	return entry, ok
+}
+
+func (s *memoryStore) StoreSearchResult(key string, entry *models.CachedSearchResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry
+	return nil
+}
+
+func (s *memoryStore) PruneSearchResults(now time.Time) (int, error) {
+	return 0, nil
+}
+
+// countingSearch returns a search that counts its calls and answers query
+func countingSearch(calls *atomic.Int32, query string) func(context.Context) (*models.SearchProviderResult, error) {
+	return func(context.Context) (*models.SearchProviderResult, error) {
+		calls.Add(1)
+		return &models.SearchProviderResult{Query: query, Results: []models.SearchResult{{Title: query}}}, nil
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	clock := newFakeClock()
+	c := newCache(10, time.Minute, nil)
+	c.now = clock.Now
+	var calls atomic.Int32
+	ctx := context.Background()
+
+	for _, step := range []struct {
+		advance   time.Duration
+		wantCalls int32
+	}{
+		{0, 1},                // Miss
+		{59 * time.Second, 1}, // Hit before the TTL ends
+		{time.Second, 2},      // Expired
+		{30 * time.Second, 2}, // Hit on the new entry
+	} {
+		clock.Advance(step.advance)
+		result, err := c.do(ctx, "key", countingSearch(&calls, "q"))
+		if err != nil || result.Query != "q" {
+			t.Fatalf("unexpected result %+v (%v)", result, err)
+		}
+		if calls.Load() != step.wantCalls {
+			t.Fatalf("after %s: expected %d searches, got %d", step.advance, step.wantCalls, calls.Load())
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := newCache(2, time.Minute, nil)
+	var calls atomic.Int32
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		if _, err := c.do(ctx, key, countingSearch(&calls, key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b was the least recently used when c was added
+	if _, err := c.do(ctx, "a", countingSearch(&calls, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected a to stay cached, got %d searches", calls.Load())
+	}
+	if _, err := c.do(ctx, "b", countingSearch(&calls, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("expected b to be evicted, got %d searches", calls.Load())
+	}
+}
+
+func TestCacheStore(t *testing.T) {
+	clock := newFakeClock()
+	store := newMemoryStore()
+	var calls atomic.Int32
+	ctx := context.Background()
+
+	first := newCache(10, time.Minute, store)
+	first.now = clock.Now
+	if _, err := first.do(ctx, "key", countingSearch(&calls, "q")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, ok := store.GetSearchResult("key")
+		return ok
+	})
+
+	// A new cache, e.g. after a restart, reads the stored result until it expires
+	second := newCache(10, time.Minute, store)
+	second.now = clock.Now
+	if _, err := second.do(ctx, "key", countingSearch(&calls, "q")); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected the stored result, got %d searches", calls.Load())
+	}
+
+	third := newCache(10, time.Minute, store)
+	third.now = clock.Now
+	clock.Advance(time.Minute)
+	if _, err := third.do(ctx, "key", countingSearch(&calls, "q")); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected the expired stored result to be ignored, got %d searches", calls.Load())
+	}
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	t.Run("Identical searches share one call", func(t *testing.T) {
+		c := newCache(10, time.Minute, nil)
+		release := make(chan struct{})
+		var calls atomic.Int32
+		search := func(context.Context) (*models.SearchProviderResult, error) {
+			calls.Add(1)
+			<-release
+			return &models.SearchProviderResult{Query: "q"}, nil
+		}
+
+		const callers = 5
+		var wg sync.WaitGroup
+		errs := make(chan error, callers)
+		for range callers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := c.do(context.Background(), "key", search)
+				errs <- err
+			}()
+		}
+		waitFor(t, func() bool {
+			stats := c.Stats()
+			return stats.Misses+stats.Coalesced == callers
+		})
+		close(release)
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if calls.Load() != 1 {
+			t.Errorf("expected one search, got %d", calls.Load())
+		}
+		if stats := c.Stats(); stats.Misses != 1 || stats.Coalesced != callers-1 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	})
+
+	t.Run("Leader going away does not fail waiters", func(t *testing.T) {
+		c := newCache(10, time.Minute, nil)
+		started := make(chan struct{})
+		release := make(chan struct{})
+		var searchErr atomic.Value
+		search := func(ctx context.Context) (*models.SearchProviderResult, error) {
+			close(started)
+			<-release
+			if err := ctx.Err(); err != nil {
+				searchErr.Store(err)
+				return nil, err
+			}
+			return &models.SearchProviderResult{Query: "q"}, nil
+		}
+
+		leaderCtx, cancelLeader := context.WithCancel(context.Background())
+		leaderErr := make(chan error, 1)
+		go func() {
+			_, err := c.do(leaderCtx, "key", search)
+			leaderErr <- err
+		}()
+		<-started
+
+		waiterResult := make(chan error, 1)
+		go func() {
+			result, err := c.do(context.Background(), "key", search)
+			if err == nil && result.Query != "q" {
+				err = errors.New("unexpected result")
+			}
+			waiterResult <- err
+		}()
+		waitFor(t, func() bool { return c.Stats().Coalesced == 1 })
+
+		cancelLeader()
+		if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the leader to see its cancellation, got %v", err)
+		}
+		close(release)
+		if err := <-waiterResult; err != nil {
+			t.Errorf("expected the waiter to get the result, got %v", err)
+		}
+		if err := searchErr.Load(); err != nil {
+			t.Errorf("expected the search to run on a live context, got %v", err)
+		}
+		if c.Stats().Entries != 1 {
+			t.Error("expected the result to be cached")
+		}
+	})
+
+	t.Run("Errors are shared but not cached", func(t *testing.T) {
+		c := newCache(10, time.Minute, nil)
+		var calls atomic.Int32
+		search := func(context.Context) (*models.SearchProviderResult, error) {
+			calls.Add(1)
+			return nil, errors.New("boom")
+		}
+		for range 2 {
+			if _, err := c.do(context.Background(), "key", search); err == nil || err.Error() != "boom" {
+				t.Errorf("expected the search error, got %v", err)
+			}
+		}
+		if calls.Load() != 2 {
+			t.Errorf("expected the failed search to be retried, got %d calls", calls.Load())
+		}
+	})
+}
+
+// waitFor polls cond until it holds or a second passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -23,6 +24,7 @@ type FirecrawlProvider struct {
 	baseURL    string
 	timeout    int
 	maxResults int
+	tbs        string
 	client     *http.Client
 }
 
@@ -44,6 +46,7 @@ func NewFirecrawlProvider(name string, cfg *config.ProviderConfig) *FirecrawlPro
 		baseURL:    cfg.BaseURL,
 		timeout:    cfg.Timeout,
 		maxResults: cfg.MaxResults,
+		tbs:        cfg.TBS,
 		client: &http.Client{
 			Timeout: time.Duration(cfg.Timeout) * time.Second,
 		},
@@ -62,8 +65,10 @@ func (p *FirecrawlProvider) IsAvailable() bool {
 
 // firecrawlSearchRequest represents the search request body
 type firecrawlSearchRequest struct {
-	Query string `json:"query"`
-	Limit int    `json:"limit,omitempty"`
+	Query    string `json:"query"`
+	Limit    int    `json:"limit,omitempty"`
+	Location string `json:"location,omitempty"` // e.g. "San Francisco,California,United States"
+	TBS      string `json:"tbs,omitempty"`      // Time-based search, e.g. "qdr:w" for the past week
 }
 
 // firecrawlSearchResponse represents the search response
@@ -86,9 +91,40 @@ type firecrawlSearchResult struct {
 	Markdown    string `json:"markdown,omitempty"`
 }
 
+// siteQuery restricts a query to the allowed domains with site: operators,
+// for search APIs without a domain filter
+func siteQuery(query string, domains []string) string {
+	var sites []string
+	for _, d := range domains {
+		if d = normalizeDomain(d); d != "" {
+			sites = append(sites, "site:"+d)
+		}
+	}
+	if len(sites) == 0 {
+		return query
+	}
+	return fmt.Sprintf("%s (%s)", query, strings.Join(sites, " OR "))
+}
+
+// firecrawlLocation returns the location string Firecrawl localizes results
+// for, from the most to the least specific part
+func firecrawlLocation(loc *models.WebSearchUserLocation) string {
+	if loc == nil {
+		return ""
+	}
+	var parts []string
+	for _, part := range []string{loc.City, loc.Region, loc.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
 // Search performs a search query using Firecrawl
-func (p *FirecrawlProvider) Search(query string) (*models.SearchProviderResult, error) {
+func (p *FirecrawlProvider) Search(ctx context.Context, searchReq models.SearchRequest) (*models.SearchProviderResult, error) {
 	log := logger.Log
+	query := searchReq.Query
 
 	if !p.IsAvailable() {
 		return nil, fmt.Errorf("%s provider not configured: missing API key", p.name)
@@ -96,8 +132,13 @@ func (p *FirecrawlProvider) Search(query string) (*models.SearchProviderResult,
 
 	// Build request
 	reqBody := firecrawlSearchRequest{
-		Query: query,
-		Limit: p.maxResults,
+		Query:    siteQuery(query, searchReq.AllowedDomains),
+		Limit:    p.maxResults,
+		Location: firecrawlLocation(searchReq.UserLocation),
+		TBS:      p.tbs,
+	}
+	if searchReq.MaxResults > 0 {
+		reqBody.Limit = searchReq.MaxResults
 	}
 
 	bodyBytes, err := json.Marshal(reqBody)
@@ -107,7 +148,7 @@ func (p *FirecrawlProvider) Search(query string) (*models.SearchProviderResult,
 
 	// Create request
 	url := fmt.Sprintf("%s/search", p.baseURL)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.timeout)*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
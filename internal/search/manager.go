@@ -1,7 +1,14 @@
 package search
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -15,14 +22,29 @@ type Manager struct {
 	providers       map[string]Provider
 	defaultProvider string
 	enabled         bool
+	maxConcurrency  int
+	callTimeout     time.Duration
+	cache           *cache   // Nil if results are not cached
+	chain           []string // Provider names in the order searches try them
+	breakers        map[string]*breaker
 }
 
-// NewManager creates a new search manager
-func NewManager(cfg *config.WebSearchConfig) *Manager {
+// NewManager creates a new search manager. store keeps cached results when
+// the cache persists; it may be nil.
+func NewManager(cfg *config.WebSearchConfig, store CacheStore) *Manager {
 	m := &Manager{
 		providers:       make(map[string]Provider),
+		breakers:        make(map[string]*breaker),
 		defaultProvider: cfg.Default,
 		enabled:         cfg.Enabled,
+		maxConcurrency:  cfg.MaxConcurrency,
+		callTimeout:     time.Duration(cfg.CallTimeout) * time.Second,
+	}
+	if m.maxConcurrency <= 0 {
+		m.maxConcurrency = 4
+	}
+	if m.callTimeout <= 0 {
+		m.callTimeout = 30 * time.Second
 	}
 
 	if !cfg.Enabled {
@@ -30,19 +52,38 @@ func NewManager(cfg *config.WebSearchConfig) *Manager {
 		return m
 	}
 
-	// Dynamically create providers based on type
-	for name, providerCfg := range cfg.Providers {
-		if providerCfg.APIKey == "" {
-			logger.Debug("skipping provider with no API key", zap.String("provider", name))
-			continue
+	if cfg.Cache.Enabled {
+		size := cfg.Cache.Size
+		if size <= 0 {
+			size = 500
+		}
+		ttl := time.Duration(cfg.Cache.TTL) * time.Second
+		if ttl <= 0 {
+			ttl = 10 * time.Minute
 		}
+		if !cfg.Cache.Persist {
+			store = nil
+		}
+		m.cache = newCache(size, ttl, store)
+	}
 
+	// Dynamically create providers based on type
+	for name, providerCfg := range cfg.Providers {
 		var provider Provider
 		switch providerCfg.Type {
 		case "mcp":
 			provider = NewMCPProvider(name, &providerCfg)
 		case "firecrawl":
 			provider = NewFirecrawlProvider(name, &providerCfg)
+		case "rest":
+			rest, err := NewRESTProvider(name, &providerCfg)
+			if err != nil {
+				logger.Error("invalid rest provider, skipping",
+					zap.String("provider", name),
+					zap.Error(err))
+				continue
+			}
+			provider = rest
 		default:
 			logger.Warn("unknown provider type, skipping",
 				zap.String("provider", name),
@@ -50,6 +91,12 @@ func NewManager(cfg *config.WebSearchConfig) *Manager {
 			continue
 		}
 
+		// A REST provider may need no API key, e.g. a self-hosted SearXNG
+		if !provider.IsAvailable() {
+			logger.Debug("skipping unconfigured provider", zap.String("provider", name))
+			continue
+		}
+
 		m.providers[name] = provider
 		logger.Info("provider initialized",
 			zap.String("name", name),
@@ -57,10 +104,24 @@ func NewManager(cfg *config.WebSearchConfig) *Manager {
 		)
 	}
 
+	failures := cfg.CircuitBreaker.Failures
+	if failures <= 0 {
+		failures = 3
+	}
+	cooldown := time.Duration(cfg.CircuitBreaker.Cooldown) * time.Second
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	for name := range m.providers {
+		m.breakers[name] = newBreaker(name, failures, cooldown)
+	}
+	m.chain = m.failoverChain(cfg.Fallback)
+
 	logger.Info("search manager initialized",
 		zap.Bool("enabled", cfg.Enabled),
 		zap.String("default_provider", cfg.Default),
 		zap.Int("provider_count", len(m.providers)),
+		zap.Strings("chain", m.chain),
 	)
 
 	return m
@@ -79,35 +140,65 @@ func (m *Manager) HasAvailableProvider() bool {
 	return false
 }
 
-// Search performs a search using the default provider
-func (m *Manager) Search(query string) (*models.SearchProviderResult, error) {
+// failoverChain orders the providers: the default one, then those of
+// fallback, or all others by name if fallback is empty. Providers that were
+// not initialized are left out.
+func (m *Manager) failoverChain(fallback []string) []string {
+	if len(fallback) == 0 {
+		for name := range m.providers {
+			fallback = append(fallback, name)
+		}
+		slices.Sort(fallback)
+	}
+
+	var chain []string
+	for _, name := range append([]string{m.defaultProvider}, fallback...) {
+		if _, ok := m.providers[name]; ok && !slices.Contains(chain, name) {
+			chain = append(chain, name)
+		}
+	}
+	return chain
+}
+
+// Search performs a search on the providers of the failover chain in order,
+// until one succeeds. Providers whose breaker is open are skipped.
+func (m *Manager) Search(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error) {
 	if !m.enabled {
 		return nil, fmt.Errorf("web search is disabled")
 	}
 
-	// Try default provider first
-	if m.defaultProvider != "" {
-		if p, ok := m.providers[m.defaultProvider]; ok && p.IsAvailable() {
-			return p.Search(query)
+	var failures []string
+	for _, name := range m.chain {
+		p := m.providers[name]
+		if !p.IsAvailable() {
+			continue
 		}
-	}
 
-	// Fall back to any available provider
-	for name, p := range m.providers {
-		if p.IsAvailable() {
-			logger.Debug("using fallback provider",
+		result, err := m.search(ctx, p, req)
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if !errors.Is(err, errCircuitOpen) {
+			logger.Warn("search provider failed, trying the next one",
 				zap.String("provider", name),
-				zap.String("query", query),
+				zap.String("query", req.Query),
+				zap.Error(err),
 			)
-			return p.Search(query)
 		}
+		failures = append(failures, fmt.Sprintf("%s: %v", name, err))
 	}
 
-	return nil, fmt.Errorf("no available search provider")
+	if len(failures) == 0 {
+		return nil, fmt.Errorf("no available search provider")
+	}
+	return nil, fmt.Errorf("all search providers failed: %s", strings.Join(failures, "; "))
 }
 
 // SearchWithProvider performs a search using a specific provider
-func (m *Manager) SearchWithProvider(providerName, query string) (*models.SearchProviderResult, error) {
+func (m *Manager) SearchWithProvider(ctx context.Context, providerName string, req models.SearchRequest) (*models.SearchProviderResult, error) {
 	if !m.enabled {
 		return nil, fmt.Errorf("web search is disabled")
 	}
@@ -121,18 +212,210 @@ func (m *Manager) SearchWithProvider(providerName, query string) (*models.Search
 		return nil, fmt.Errorf("provider not available: %s", providerName)
 	}
 
-	return p.Search(query)
+	return m.search(ctx, p, req)
+}
+
+// SearchAll runs several searches concurrently, at most maxConcurrency at a
+// time. Results and errors are returned in the order of reqs; a failed
+// search does not affect the others.
+func (m *Manager) SearchAll(ctx context.Context, reqs []models.SearchRequest) ([]*models.SearchProviderResult, []error) {
+	results := make([]*models.SearchProviderResult, len(reqs))
+	errs := make([]error, len(reqs))
+
+	slots := make(chan struct{}, m.maxConcurrency)
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+			}
+			// A free slot may win over a context that ended already
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+			results[i], errs[i] = m.Search(ctx, req)
+		}()
+	}
+	wg.Wait()
+
+	return results, errs
+}
+
+// CacheStats returns the counts of the search cache; false if results are
+// not cached
+func (m *Manager) CacheStats() (CacheStats, bool) {
+	if m.cache == nil {
+		return CacheStats{}, false
+	}
+	return m.cache.Stats(), true
+}
+
+// search runs a request on a provider, reusing a cached result of the same
+// request if there is one
+func (m *Manager) search(ctx context.Context, p Provider, req models.SearchRequest) (*models.SearchProviderResult, error) {
+	if m.cache == nil {
+		return m.call(ctx, p, req)
+	}
+	result, err := m.cache.do(ctx, cacheKey(p.Name(), req), func(ctx context.Context) (*models.SearchProviderResult, error) {
+		return m.call(ctx, p, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The cached result may come from a query that differs in case or spacing
+	result.Query = req.Query
+	return result, nil
+}
+
+// call runs a request on a provider through its circuit breaker, recording
+// the outcome unless ctx ended first
+func (m *Manager) call(ctx context.Context, p Provider, req models.SearchRequest) (*models.SearchProviderResult, error) {
+	b := m.breakers[p.Name()]
+	if !b.allow() {
+		return nil, errCircuitOpen
+	}
+
+	start := time.Now()
+	result, err := m.searchProvider(ctx, p, req)
+	if err != nil && ctx.Err() != nil {
+		b.release()
+		return nil, err
+	}
+	b.record(err, time.Since(start))
+	return result, err
+}
+
+// ProviderStats returns the search counts, latency and breaker state of the
+// providers in the order of the failover chain, followed by those outside it
+func (m *Manager) ProviderStats() []ProviderStats {
+	var others []string
+	for name := range m.providers {
+		if !slices.Contains(m.chain, name) {
+			others = append(others, name)
+		}
+	}
+	slices.Sort(others)
+
+	stats := make([]ProviderStats, 0, len(m.providers))
+	for _, name := range append(slices.Clone(m.chain), others...) {
+		stats = append(stats, m.breakers[name].Stats())
+	}
+	return stats
+}
+
+// searchProvider runs a request on a provider within the call timeout and
+// enforces the options providers may only honor in part: results outside the
+// allowed domains are dropped and at most MaxResults are kept
+func (m *Manager) searchProvider(ctx context.Context, p Provider, req models.SearchRequest) (*models.SearchProviderResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.callTimeout)
+	defer cancel()
+
+	result, err := p.Search(ctx, req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("search timed out after %s: %w", m.callTimeout, err)
+		}
+		return nil, err
+	}
+
+	if len(req.AllowedDomains) > 0 {
+		kept := result.Results[:0]
+		for _, r := range result.Results {
+			if inAllowedDomains(r.URL, req.AllowedDomains) {
+				kept = append(kept, r)
+			}
+		}
+		result.Results = kept
+	}
+	if req.MaxResults > 0 && len(result.Results) > req.MaxResults {
+		result.Results = result.Results[:req.MaxResults]
+	}
+	return result, nil
+}
+
+// normalizeDomain returns the host name of an allowed_domains entry, which
+// may be given with a scheme or path, e.g. "https://go.dev/doc" is "go.dev"
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if i := strings.Index(domain, "://"); i >= 0 {
+		domain = domain[i+3:]
+	}
+	if i := strings.IndexAny(domain, "/?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	return strings.TrimPrefix(domain, "www.")
+}
+
+// inAllowedDomains reports whether a URL is on one of the domains or
+// their subdomains
+func inAllowedDomains(rawURL string, domains []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, d := range domains {
+		d = normalizeDomain(d)
+		if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// contextSize is how much of the search results a search_context_size
+// passes to the model
+type contextSize struct {
+	results      int // 0 leaves the provider default
+	contentChars int
+}
+
+// contextSizes maps search_context_size to what is passed to the model;
+// "medium" is the default
+var contextSizes = map[string]contextSize{
+	"low":    {results: 3, contentChars: 200},
+	"medium": {results: 0, contentChars: 500},
+	"high":   {results: 10, contentChars: 2000},
+}
+
+// contextSizeOf returns the context size of a search_context_size
+func contextSizeOf(size string) contextSize {
+	if cs, ok := contextSizes[size]; ok {
+		return cs
+	}
+	return contextSizes["medium"]
+}
+
+// NewRequest builds the search request of a web_search query with the
+// options of the tool, which may be nil
+func NewRequest(query string, opts *models.WebSearchOptions) models.SearchRequest {
+	req := models.SearchRequest{Query: query}
+	if opts == nil {
+		return req
+	}
+	req.MaxResults = contextSizeOf(opts.SearchContextSize).results
+	req.AllowedDomains = opts.AllowedDomains
+	req.UserLocation = opts.UserLocation
+	return req
 }
 
-// FormatResults formats search results as a string for tool message content
-func FormatResults(result *models.SearchProviderResult) string {
+// FormatResults formats search results as a string for tool message content.
+// Results are numbered from firstSource on, so that the sources of several
+// searches keep distinct numbers the model can cite; searchContextSize
+// bounds how much of each page is passed on.
+func FormatResults(result *models.SearchProviderResult, firstSource int, searchContextSize string) string {
 	if result == nil || len(result.Results) == 0 {
 		return "No search results found."
 	}
 
 	output := fmt.Sprintf("Search results for: %s\n\n", result.Query)
 	for i, r := range result.Results {
-		output += fmt.Sprintf("%d. %s\n", i+1, r.Title)
+		output += fmt.Sprintf("[%d] %s\n", firstSource+i, r.Title)
 		if r.URL != "" {
 			output += fmt.Sprintf("   URL: %s\n", r.URL)
 		}
@@ -142,13 +425,14 @@ func FormatResults(result *models.SearchProviderResult) string {
 		if r.Content != "" && r.Content != r.Snippet {
 			// Truncate content if too long
 			content := r.Content
-			if len(content) > 500 {
-				content = content[:500] + "..."
+			if limit := contextSizeOf(searchContextSize).contentChars; len(content) > limit {
+				content = content[:limit] + "..."
 			}
 			output += fmt.Sprintf("   Content: %s\n", content)
 		}
 		output += "\n"
 	}
+	output += fmt.Sprintf("Cite the sources you use by their number in brackets, e.g. [%d], right after the statement they support.\n", firstSource)
 
 	return output
 }
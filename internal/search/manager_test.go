@@ -0,0 +1,337 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// fakeProvider answers searches with search, counting the calls and the
+// searches in flight
+type fakeProvider struct {
+	name        string
+	unavailable bool
+	search      func(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error)
+
+	calls       atomic.Int32
+	inflight    atomic.Int32
+	maxInflight atomic.Int32
+}
+
+func (p *fakeProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeProvider) IsAvailable() bool {
+	return !p.unavailable
+}
+
+func (p *fakeProvider) Search(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error) {
+	p.calls.Add(1)
+	n := p.inflight.Add(1)
+	defer p.inflight.Add(-1)
+	for {
+		max := p.maxInflight.Load()
+		if n <= max || p.maxInflight.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	if p.search == nil {
+		return answer(p.name, req), nil
+	}
+	return p.search(ctx, req)
+}
+
+// answer is the result of a search with one result naming the provider
+func answer(provider string, req models.SearchRequest) *models.SearchProviderResult {
+	return &models.SearchProviderResult{
+		Query:   req.Query,
+		Results: []models.SearchResult{{Title: provider + ": " + req.Query, URL: "https://example.com/" + provider}},
+	}
+}
+
+// newTestManager creates an enabled manager on providers, the first being
+// the default, with the breaker settings of cfg
+func newTestManager(cfg config.WebSearchConfig, providers ...*fakeProvider) *Manager {
+	cfg.Enabled = true
+	cfg.Default = providers[0].name
+	m := NewManager(&cfg, nil)
+	for _, p := range providers {
+		m.providers[p.name] = p
+		m.breakers[p.name] = newBreaker(p.name, cfg.CircuitBreaker.Failures, time.Duration(cfg.CircuitBreaker.Cooldown)*time.Second)
+	}
+	m.chain = m.failoverChain(cfg.Fallback)
+	return m
+}
+
+// queries returns search requests for queries
+func queries(qs ...string) []models.SearchRequest {
+	reqs := make([]models.SearchRequest, len(qs))
+	for i, q := range qs {
+		reqs[i] = models.SearchRequest{Query: q}
+	}
+	return reqs
+}
+
+func TestSearchAll(t *testing.T) {
+	breakers := config.CircuitBreakerConfig{Failures: 10, Cooldown: 30}
+
+	t.Run("Results keep the order of the requests", func(t *testing.T) {
+		// Later queries finish first
+		p := &fakeProvider{name: "p", search: func(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error) {
+			var n int
+			fmt.Sscanf(req.Query, "q%d", &n)
+			time.Sleep(time.Duration(5-n) * 5 * time.Millisecond)
+			return answer("p", req), nil
+		}}
+		m := newTestManager(config.WebSearchConfig{MaxConcurrency: 5, CircuitBreaker: breakers}, p)
+
+		results, errs := m.SearchAll(context.Background(), queries("q1", "q2", "q3", "q4", "q5"))
+		for i, result := range results {
+			if errs[i] != nil {
+				t.Fatalf("Search %d failed: %v", i, errs[i])
+			}
+			if want := fmt.Sprintf("q%d", i+1); result.Query != want {
+				t.Errorf("Result %d: expected %s, got %s", i, want, result.Query)
+			}
+		}
+	})
+
+	t.Run("Concurrency is bounded", func(t *testing.T) {
+		p := &fakeProvider{name: "p", search: func(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error) {
+			time.Sleep(20 * time.Millisecond)
+			return answer("p", req), nil
+		}}
+		m := newTestManager(config.WebSearchConfig{MaxConcurrency: 2, CircuitBreaker: breakers}, p)
+
+		_, errs := m.SearchAll(context.Background(), queries("a", "b", "c", "d", "e", "f"))
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("Search %d failed: %v", i, err)
+			}
+		}
+		if p.calls.Load() != 6 {
+			t.Errorf("Expected 6 searches, got %d", p.calls.Load())
+		}
+		if got := p.maxInflight.Load(); got != 2 {
+			t.Errorf("Expected 2 searches at a time, got %d", got)
+		}
+	})
+
+	t.Run("Timeout fails only the slow search", func(t *testing.T) {
+		p := &fakeProvider{name: "p", search: func(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error) {
+			if req.Query == "slow" {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return answer("p", req), nil
+		}}
+		m := newTestManager(config.WebSearchConfig{MaxConcurrency: 4, CircuitBreaker: breakers}, p)
+		m.callTimeout = 50 * time.Millisecond
+
+		start := time.Now()
+		results, errs := m.SearchAll(context.Background(), queries("fast", "slow", "fast too"))
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the call timeout to end the search, took %s", elapsed)
+		}
+		if errs[1] == nil || !strings.Contains(errs[1].Error(), "timed out") {
+			t.Errorf("Expected the slow search to time out, got %v", errs[1])
+		}
+		for _, i := range []int{0, 2} {
+			if errs[i] != nil || results[i] == nil {
+				t.Errorf("Search %d: expected a result, got %v", i, errs[i])
+			}
+		}
+		if stats := m.ProviderStats()[0]; stats.Failures != 1 || stats.Successes != 2 {
+			t.Errorf("Expected the timeout to count as a failure, got %+v", stats)
+		}
+	})
+
+	t.Run("Cancelled context", func(t *testing.T) {
+		p := &fakeProvider{name: "p"}
+		m := newTestManager(config.WebSearchConfig{MaxConcurrency: 2, CircuitBreaker: breakers}, p)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, errs := m.SearchAll(ctx, queries("a", "b", "c"))
+		for i, err := range errs {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Search %d: expected context.Canceled, got %v", i, err)
+			}
+		}
+		if stats := m.ProviderStats()[0]; stats.Failures != 0 {
+			t.Errorf("Expected cancelled searches not to count as failures, got %+v", stats)
+		}
+	})
+}
+
+// failing returns a provider whose searches fail
+func failing(name string) *fakeProvider {
+	return &fakeProvider{name: name, search: func(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error) {
+		return nil, errors.New(name + " is down")
+	}}
+}
+
+func TestFailoverChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback []string
+		want     []string
+	}{
+		{"Default, then the others by name", nil, []string{"b", "a", "c"}},
+		{"Default, then the fallback", []string{"c", "a"}, []string{"b", "c", "a"}},
+		{"Unknown and repeated names are left out", []string{"x", "b", "c", "c"}, []string{"b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(config.WebSearchConfig{Fallback: tt.fallback}, &fakeProvider{name: "b"}, &fakeProvider{name: "a"}, &fakeProvider{name: "c"})
+			if strings.Join(m.chain, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected chain %v, got %v", tt.want, m.chain)
+			}
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	cfg := config.WebSearchConfig{CircuitBreaker: config.CircuitBreakerConfig{Failures: 2, Cooldown: 60}}
+	req := models.SearchRequest{Query: "go"}
+
+	t.Run("Skips open providers in chain order", func(t *testing.T) {
+		clock := newFakeClock()
+		down, backup, last := failing("down"), &fakeProvider{name: "backup"}, &fakeProvider{name: "last"}
+		m := newTestManager(cfg, down, backup, last)
+		for _, b := range m.breakers {
+			b.now = clock.Now
+		}
+
+		// Each search fails over to the next provider; the second failure
+		// opens the breaker of the first one
+		for i := range 4 {
+			result, err := m.Search(context.Background(), req)
+			if err != nil || result.Results[0].Title != "backup: go" {
+				t.Fatalf("Search %d: expected the backup result, got %+v (%v)", i, result, err)
+			}
+		}
+		if down.calls.Load() != 2 || backup.calls.Load() != 4 || last.calls.Load() != 0 {
+			t.Errorf("Expected 2, 4 and 0 calls, got %d, %d and %d", down.calls.Load(), backup.calls.Load(), last.calls.Load())
+		}
+
+		// After the cooldown one probe reaches the first provider again
+		clock.Advance(time.Minute)
+		if _, err := m.Search(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+		if down.calls.Load() != 3 {
+			t.Errorf("Expected one probe, got %d calls", down.calls.Load())
+		}
+
+		// The probe failed, so the provider is skipped for another cooldown
+		if _, err := m.Search(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+		if down.calls.Load() != 3 {
+			t.Errorf("Expected the provider to be skipped after the failed probe, got %d calls", down.calls.Load())
+		}
+	})
+
+	t.Run("Probe success closes the breaker", func(t *testing.T) {
+		clock := newFakeClock()
+		var healthy atomic.Bool
+		flaky := &fakeProvider{name: "flaky", search: func(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error) {
+			if !healthy.Load() {
+				return nil, errors.New("flaky is down")
+			}
+			return answer("flaky", req), nil
+		}}
+		m := newTestManager(cfg, flaky, &fakeProvider{name: "backup"})
+		for _, b := range m.breakers {
+			b.now = clock.Now
+		}
+
+		for range 2 {
+			m.Search(context.Background(), req)
+		}
+		if state := m.breakers["flaky"].Stats().State; state != breakerOpen {
+			t.Fatalf("Expected the breaker to be open, got %s", state)
+		}
+
+		healthy.Store(true)
+		clock.Advance(time.Minute)
+		result, err := m.Search(context.Background(), req)
+		if err != nil || result.Results[0].Title != "flaky: go" {
+			t.Fatalf("Expected the probe to answer, got %+v (%v)", result, err)
+		}
+		if state := m.breakers["flaky"].Stats().State; state != breakerClosed {
+			t.Errorf("Expected the breaker to close, got %s", state)
+		}
+	})
+
+	t.Run("Unavailable providers are skipped", func(t *testing.T) {
+		off := &fakeProvider{name: "off", unavailable: true}
+		m := newTestManager(cfg, off, &fakeProvider{name: "on"})
+		result, err := m.Search(context.Background(), req)
+		if err != nil || result.Results[0].Title != "on: go" {
+			t.Fatalf("Expected the available provider to answer, got %+v (%v)", result, err)
+		}
+		if off.calls.Load() != 0 {
+			t.Error("Expected the unavailable provider not to be called")
+		}
+	})
+
+	t.Run("All providers fail", func(t *testing.T) {
+		m := newTestManager(cfg, failing("a"), failing("b"))
+		_, err := m.Search(context.Background(), req)
+		if err == nil || !strings.Contains(err.Error(), "a: a is down; b: b is down") {
+			t.Errorf("Expected the errors of both providers, got %v", err)
+		}
+
+		// Open breakers are reported as such
+		m.Search(context.Background(), req)
+		_, err = m.Search(context.Background(), req)
+		if err == nil || !strings.Contains(err.Error(), "a: circuit breaker open; b: circuit breaker open") {
+			t.Errorf("Expected open breakers, got %v", err)
+		}
+	})
+}
+
+func TestProviderStats(t *testing.T) {
+	cfg := config.WebSearchConfig{
+		Fallback:       []string{"down"},
+		CircuitBreaker: config.CircuitBreakerConfig{Failures: 1, Cooldown: 60},
+	}
+	m := newTestManager(cfg, &fakeProvider{name: "main"}, failing("down"), &fakeProvider{name: "aside"})
+
+	// The first search is answered by main; down is only reached once main
+	// is unavailable
+	if _, err := m.Search(context.Background(), models.SearchRequest{Query: "go"}); err != nil {
+		t.Fatal(err)
+	}
+	m.providers["main"].(*fakeProvider).unavailable = true
+	m.Search(context.Background(), models.SearchRequest{Query: "go"})
+	m.Search(context.Background(), models.SearchRequest{Query: "go"})
+
+	stats := m.ProviderStats()
+	var names []string
+	for _, s := range stats {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "main,down,aside" {
+		t.Fatalf("Expected the chain, then the others, got %v", names)
+	}
+	if s := stats[0]; s.State != breakerClosed || s.Successes != 1 || s.Failures != 0 {
+		t.Errorf("Unexpected stats of main: %+v", s)
+	}
+	if s := stats[1]; s.State != breakerOpen || s.Failures != 1 || s.Rejected != 1 || s.ConsecutiveFailures != 1 || s.LastError != "down is down" {
+		t.Errorf("Unexpected stats of down: %+v", s)
+	}
+	if s := stats[2]; s.State != breakerClosed || s.Successes != 0 || s.Failures != 0 {
+		t.Errorf("Unexpected stats of aside: %+v", s)
+	}
+}
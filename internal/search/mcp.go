@@ -1,37 +1,38 @@
 package search
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/mcp"
 	"github.com/young1lin/responses2chat/internal/models"
 	"github.com/young1lin/responses2chat/pkg/logger"
 )
 
 // MCPProvider implements a generic MCP (Model Context Protocol) provider
-// This can be used with any MCP-compatible search service
+// This can be used with any MCP-compatible search service that offers a
+// search tool taking the query as an argument, and optionally the domain,
+// location and number of results
 type MCPProvider struct {
 	name       string
 	baseURL    string
 	apiKey     string
 	toolName   string // The MCP tool name to call, e.g., "webSearchPrime", "search"
 	queryParam string // The query parameter name, e.g., "search_query", "query"
-	timeout    int
-	client     *http.Client
-
-	// Session management
-	sessionID    string
-	sessionMutex sync.Mutex
+	// Parameters of the web_search options, empty if the tool has none
+	domainParam   string
+	locationParam string
+	countParam    string
+	timeout       int
+	client        *mcp.Client
 }
 
 // NewMCPProvider creates a new generic MCP provider
@@ -48,17 +49,23 @@ func NewMCPProvider(name string, cfg *config.ProviderConfig) *MCPProvider {
 	if cfg.QueryParam == "" {
 		cfg.QueryParam = "search_query"
 	}
+	if cfg.ToolName == "webSearchPrime" && cfg.DomainParam == "" {
+		cfg.DomainParam = "search_domain_filter"
+	}
 
 	return &MCPProvider{
-		name:       name,
-		baseURL:    cfg.BaseURL,
-		apiKey:     cfg.APIKey,
-		toolName:   cfg.ToolName,
-		queryParam: cfg.QueryParam,
-		timeout:    cfg.Timeout,
-		client: &http.Client{
-			Timeout: time.Duration(cfg.Timeout+10) * time.Second,
-		},
+		name:          name,
+		baseURL:       cfg.BaseURL,
+		apiKey:        cfg.APIKey,
+		toolName:      cfg.ToolName,
+		queryParam:    cfg.QueryParam,
+		domainParam:   cfg.DomainParam,
+		locationParam: cfg.LocationParam,
+		countParam:    cfg.CountParam,
+		timeout:       cfg.Timeout,
+		client: mcp.NewClient(cfg.BaseURL, map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", cfg.APIKey),
+		}, &http.Client{Timeout: time.Duration(cfg.Timeout+10) * time.Second}),
 	}
 }
 
@@ -72,41 +79,6 @@ func (p *MCPProvider) IsAvailable() bool {
 	return p.apiKey != ""
 }
 
-// mcpRequest represents a JSON-RPC request to MCP
-type mcpRequest struct {
-	JSONRPC string      `json:"jsonrpc"`
-	Method  string      `json:"method"`
-	Params  interface{} `json:"params,omitempty"`
-	ID      int         `json:"id"`
-}
-
-// mcpResponse represents a JSON-RPC response from MCP
-type mcpResponse struct {
-	JSONRPC string          `json:"jsonrpc"`
-	Result  json.RawMessage `json:"result,omitempty"`
-	Error   *mcpError       `json:"error,omitempty"`
-	ID      int             `json:"id"`
-}
-
-// mcpError represents an MCP error
-type mcpError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-}
-
-// mcpInitializeParams represents initialize parameters
-type mcpInitializeParams struct {
-	ProtocolVersion string                 `json:"protocolVersion"`
-	Capabilities    map[string]interface{} `json:"capabilities"`
-	ClientInfo      map[string]string      `json:"clientInfo"`
-}
-
-// mcpToolCallParams represents tools/call parameters
-type mcpToolCallParams struct {
-	Name      string                 `json:"name"`
-	Arguments map[string]interface{} `json:"arguments"`
-}
-
 // mcpSearchResult represents a generic search result from MCP
 type mcpSearchResult struct {
 	Title   string `json:"title"`
@@ -116,216 +88,64 @@ type mcpSearchResult struct {
 	Snippet string `json:"snippet,omitempty"`
 }
 
-// ensureSession ensures we have a valid MCP session
-func (p *MCPProvider) ensureSession() error {
-	p.sessionMutex.Lock()
-	defer p.sessionMutex.Unlock()
-
-	// If we already have a session, reuse it
-	if p.sessionID != "" {
-		return nil
-	}
-
-	log := logger.Log
-	log.Debug("initializing new MCP session", zap.String("provider", p.name))
-
-	// Initialize session
-	req := mcpRequest{
-		JSONRPC: "2.0",
-		Method:  "initialize",
-		Params: mcpInitializeParams{
-			ProtocolVersion: "2024-11-05",
-			Capabilities:    map[string]interface{}{},
-			ClientInfo: map[string]string{
-				"name":    "responses2chat",
-				"version": "1.0.0",
-			},
-		},
-		ID: 1,
-	}
-
-	// Send request and get session ID from response header
-	bodyBytes, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
-	defer cancel()
-
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL, bytes.NewReader(bodyBytes))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "application/json, text/event-stream")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
-
-	resp, err := p.client.Do(httpReq)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Log all headers for debugging
-	log.Debug("MCP response headers",
-		zap.String("provider", p.name),
-		zap.Any("headers", resp.Header))
-
-	// Get session ID from response header (case-insensitive in Go)
-	p.sessionID = resp.Header.Get("Mcp-Session-Id")
-	if p.sessionID == "" {
-		p.sessionID = resp.Header.Get("mcp-session-id")
-	}
-	if p.sessionID == "" {
-		// Try all variations
-		for k, v := range resp.Header {
-			if strings.EqualFold(k, "mcp-session-id") && len(v) > 0 {
-				p.sessionID = v[0]
-				break
-			}
-		}
-	}
-
-	if p.sessionID == "" {
-		log.Warn("no mcp-session-id in response header, continuing without",
-			zap.String("provider", p.name))
-	}
-
-	log.Debug("MCP session initialized",
-		zap.String("provider", p.name),
-		zap.String("session_id", p.sessionID))
-	return nil
+// isAuthError reports whether an MCP error looks like a rejected API key,
+// which some servers return for sessions they no longer accept
+func isAuthError(message string) bool {
+	return strings.Contains(message, "apikey") || strings.Contains(message, "-401")
 }
 
-// sendMCPRequest sends a JSON-RPC request to MCP (for tools/call)
-func (p *MCPProvider) sendMCPRequest(req mcpRequest) (*mcpResponse, error) {
-	log := logger.Log
-	bodyBytes, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
-	defer cancel()
-
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL, bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// arguments returns the tool arguments of a search request; options without
+// a configured parameter are left to the manager to enforce
+func (p *MCPProvider) arguments(req models.SearchRequest) map[string]interface{} {
+	args := map[string]interface{}{
+		p.queryParam: req.Query,
 	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "application/json, text/event-stream")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
-
-	// Add session ID header (required for tools/call)
-	if p.sessionID != "" {
-		httpReq.Header.Set("mcp-session-id", p.sessionID)
+	// Most search tools filter on a single domain only
+	if p.domainParam != "" && len(req.AllowedDomains) == 1 {
+		args[p.domainParam] = normalizeDomain(req.AllowedDomains[0])
 	}
-
-	resp, err := p.client.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	if p.locationParam != "" && req.UserLocation != nil && req.UserLocation.Country != "" {
+		args[p.locationParam] = strings.ToLower(req.UserLocation.Country)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	if p.countParam != "" && req.MaxResults > 0 {
+		args[p.countParam] = req.MaxResults
 	}
-
-	log.Debug("MCP raw response",
-		zap.String("provider", p.name),
-		zap.String("body", string(body)),
-	)
-
-	// Parse SSE format response
-	jsonData := p.parseSSEResponse(string(body))
-
-	var mcpResp mcpResponse
-	if err := json.Unmarshal([]byte(jsonData), &mcpResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w (body: %s)", err, string(body))
-	}
-
-	return &mcpResp, nil
-}
-
-// parseSSEResponse extracts JSON data from SSE format response
-func (p *MCPProvider) parseSSEResponse(body string) string {
-	// SSE format: "id:1\nevent:message\ndata:{...}"
-	lines := strings.Split(body, "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "data:") {
-			return strings.TrimPrefix(line, "data:")
-		}
-	}
-	// If no data: prefix found, return the whole body
-	return body
+	return args
 }
 
 // Search performs a search query using MCP
-func (p *MCPProvider) Search(query string) (*models.SearchProviderResult, error) {
+func (p *MCPProvider) Search(ctx context.Context, req models.SearchRequest) (*models.SearchProviderResult, error) {
 	log := logger.Log
+	query := req.Query
 	if !p.IsAvailable() {
 		return nil, fmt.Errorf("%s provider not configured: missing API key", p.name)
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
 	// Try up to 2 times (in case session expired)
 	for attempt := 0; attempt < 2; attempt++ {
-		// Ensure we have a valid session
-		if err := p.ensureSession(); err != nil {
-			return nil, fmt.Errorf("failed to establish MCP session: %w", err)
-		}
-
-		// Call the configured tool with the configured query parameter
-		req := mcpRequest{
-			JSONRPC: "2.0",
-			Method:  "tools/call",
-			Params: mcpToolCallParams{
-				Name: p.toolName,
-				Arguments: map[string]interface{}{
-					p.queryParam: query,
-				},
-			},
-			ID: 2,
-		}
-
-		resp, err := p.sendMCPRequest(req)
+		// Call the configured tool with the configured parameters
+		result, err := p.client.CallTool(ctx, p.toolName, p.arguments(req))
 		if err != nil {
-			return nil, fmt.Errorf("failed to call search tool: %w", err)
-		}
-
-		if resp.Error != nil {
 			// Check if it's an auth error - might need to re-initialize session
-			if resp.Error.Code == -401 || strings.Contains(resp.Error.Message, "apikey") {
+			var rpcErr *mcp.RPCError
+			if errors.As(err, &rpcErr) && (rpcErr.Code == -401 || isAuthError(rpcErr.Message)) {
 				// Clear session and retry
-				p.clearSession()
+				p.client.Reset()
 				continue
 			}
-			return nil, fmt.Errorf("search tool error: %s (code: %d)", resp.Error.Message, resp.Error.Code)
-		}
-
-		// Parse the response - MCP returns content array
-		var contentResult struct {
-			Content []struct {
-				Type string `json:"type"`
-				Text string `json:"text"`
-			} `json:"content"`
-			IsError bool `json:"isError"`
-		}
-
-		if err := json.Unmarshal(resp.Result, &contentResult); err != nil {
-			return nil, fmt.Errorf("failed to parse content result: %w", err)
+			return nil, fmt.Errorf("failed to call search tool: %w", err)
 		}
 
 		// Check for error in response
-		if contentResult.IsError {
-			if len(contentResult.Content) > 0 {
-				errText := contentResult.Content[0].Text
+		if result.IsError {
+			if len(result.Content) > 0 {
+				errText := result.Content[0].Text
 				// Check if it's an auth error - retry with new session
-				if strings.Contains(errText, "apikey") || strings.Contains(errText, "-401") {
-					p.clearSession()
+				if isAuthError(errText) {
+					p.client.Reset()
 					continue
 				}
 				return nil, fmt.Errorf("MCP error: %s", errText)
@@ -333,28 +153,21 @@ func (p *MCPProvider) Search(query string) (*models.SearchProviderResult, error)
 			return nil, fmt.Errorf("MCP error: unknown error")
 		}
 
-		if len(contentResult.Content) == 0 {
+		if len(result.Content) == 0 {
 			return nil, fmt.Errorf("no content in response")
 		}
 
 		// Parse the nested JSON in text field (double JSON encoding)
 		log.Debug("MCP content text",
 			zap.String("provider", p.name),
-			zap.String("text", contentResult.Content[0].Text),
+			zap.String("text", result.Content[0].Text),
 		)
-		return p.parseResults(query, contentResult.Content[0].Text)
+		return p.parseResults(query, result.Content[0].Text)
 	}
 
 	return nil, fmt.Errorf("failed after retry: session error")
 }
 
-// clearSession clears the current session
-func (p *MCPProvider) clearSession() {
-	p.sessionMutex.Lock()
-	defer p.sessionMutex.Unlock()
-	p.sessionID = ""
-}
-
 // parseResults parses the JSON response (handles both single and double encoding)
 func (p *MCPProvider) parseResults(query, text string) (*models.SearchProviderResult, error) {
 	log := logger.Log
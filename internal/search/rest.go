@@ -0,0 +1,431 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/models"
+	"github.com/young1lin/responses2chat/pkg/logger"
+)
+
+// restPresets are the built-in settings of well-known search APIs; a
+// provider of type "rest" names one with preset and overrides what it needs
+var restPresets = map[string]config.ProviderConfig{
+	"tavily": {
+		BaseURL:    "https://api.tavily.com",
+		Method:     http.MethodPost,
+		URL:        "{base_url}/search",
+		Body:       `{"query": {query}, "max_results": {max_results}, "include_domains": {domains}}`,
+		AuthHeader: "Authorization",
+		AuthScheme: "Bearer",
+		Mapping: config.RESTMapping{
+			Results: "results",
+			Title:   "title",
+			URL:     "url",
+			Snippet: "content",
+			Content: "raw_content",
+		},
+	},
+	"brave": {
+		BaseURL: "https://api.search.brave.com/res/v1",
+		Method:  http.MethodGet,
+		URL:     "{base_url}/web/search",
+		Params: map[string]string{
+			"q":       "{query}",
+			"count":   "{max_results}",
+			"country": "{country}",
+		},
+		Headers:       map[string]string{"Accept": "application/json"},
+		AuthHeader:    "X-Subscription-Token",
+		SiteOperators: true,
+		Mapping: config.RESTMapping{
+			Results: "web.results",
+			Title:   "title",
+			URL:     "url",
+			Snippet: "description",
+		},
+	},
+	// SearXNG is self-hosted: base_url is required, an API key is not. The
+	// instance must enable the json format under search.formats.
+	"searxng": {
+		Method: http.MethodGet,
+		URL:    "{base_url}/search",
+		Params: map[string]string{
+			"q":      "{query}",
+			"format": "json",
+		},
+		SiteOperators: true,
+		Mapping: config.RESTMapping{
+			Results: "results",
+			Title:   "title",
+			URL:     "url",
+			Snippet: "content",
+		},
+	},
+}
+
+// RESTProvider implements the Provider interface for search APIs described
+// by configuration: requests are built from templates with the placeholders
+// {query}, {max_results}, {country}, {domains}, {api_key} and {base_url},
+// and results are read from the response with JSON paths
+type RESTProvider struct {
+	name          string
+	baseURL       string
+	apiKey        string
+	method        string
+	url           string
+	params        map[string]string
+	headers       map[string]string
+	body          string
+	authHeader    string
+	authScheme    string
+	siteOperators bool
+	mapping       config.RESTMapping
+	maxResults    int
+	timeout       int
+	client        *http.Client
+}
+
+// NewRESTProvider creates a new REST provider, filling the settings left
+// empty from its preset. Returns an error if the preset is unknown.
+func NewRESTProvider(name string, cfg *config.ProviderConfig) (*RESTProvider, error) {
+	if cfg.Preset != "" {
+		preset, ok := restPresets[cfg.Preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q, expected one of %s",
+				cfg.Preset, strings.Join(slices.Sorted(maps.Keys(restPresets)), ", "))
+		}
+		applyRESTPreset(cfg, &preset)
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodGet
+		if cfg.Body != "" {
+			cfg.Method = http.MethodPost
+		}
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30
+	}
+	if cfg.MaxResults == 0 {
+		cfg.MaxResults = 5
+	}
+
+	return &RESTProvider{
+		name:          name,
+		baseURL:       strings.TrimSuffix(cfg.BaseURL, "/"),
+		apiKey:        cfg.APIKey,
+		method:        strings.ToUpper(cfg.Method),
+		url:           cfg.URL,
+		params:        cfg.Params,
+		headers:       cfg.Headers,
+		body:          cfg.Body,
+		authHeader:    cfg.AuthHeader,
+		authScheme:    cfg.AuthScheme,
+		siteOperators: cfg.SiteOperators,
+		mapping:       cfg.Mapping,
+		maxResults:    cfg.MaxResults,
+		timeout:       cfg.Timeout,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+		},
+	}, nil
+}
+
+// applyRESTPreset fills the settings of cfg left empty from preset. Params
+// and headers are merged, those of cfg taking precedence.
+func applyRESTPreset(cfg, preset *config.ProviderConfig) {
+	for _, field := range []struct {
+		value  *string
+		preset string
+	}{
+		{&cfg.BaseURL, preset.BaseURL},
+		{&cfg.Method, preset.Method},
+		{&cfg.URL, preset.URL},
+		{&cfg.Body, preset.Body},
+		{&cfg.AuthHeader, preset.AuthHeader},
+		{&cfg.AuthScheme, preset.AuthScheme},
+		{&cfg.Mapping.Results, preset.Mapping.Results},
+		{&cfg.Mapping.Title, preset.Mapping.Title},
+		{&cfg.Mapping.URL, preset.Mapping.URL},
+		{&cfg.Mapping.Snippet, preset.Mapping.Snippet},
+		{&cfg.Mapping.Content, preset.Mapping.Content},
+	} {
+		if *field.value == "" {
+			*field.value = field.preset
+		}
+	}
+	cfg.SiteOperators = cfg.SiteOperators || preset.SiteOperators
+
+	params := maps.Clone(preset.Params)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	maps.Copy(params, cfg.Params)
+	cfg.Params = params
+
+	headers := maps.Clone(preset.Headers)
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	maps.Copy(headers, cfg.Headers)
+	cfg.Headers = headers
+}
+
+// Name returns the provider name
+func (p *RESTProvider) Name() string {
+	return p.name
+}
+
+// IsAvailable returns true if the provider is properly configured: it has a
+// URL, a base URL if the URL refers to it, and an API key if it sends one
+func (p *RESTProvider) IsAvailable() bool {
+	if p.url == "" || (strings.Contains(p.url, "{base_url}") && p.baseURL == "") {
+		return false
+	}
+	return p.authHeader == "" || p.apiKey != ""
+}
+
+// restValues returns the placeholder values of a request; domains are
+// comma-separated
+func (p *RESTProvider) restValues(req models.SearchRequest) map[string]string {
+	query := req.Query
+	if p.siteOperators {
+		query = siteQuery(query, req.AllowedDomains)
+	}
+	limit := p.maxResults
+	if req.MaxResults > 0 {
+		limit = req.MaxResults
+	}
+	var country string
+	if req.UserLocation != nil {
+		country = req.UserLocation.Country
+	}
+
+	return map[string]string{
+		"query":       query,
+		"max_results": strconv.Itoa(limit),
+		"country":     country,
+		"domains":     strings.Join(req.AllowedDomains, ","),
+		"api_key":     p.apiKey,
+		"base_url":    p.baseURL,
+	}
+}
+
+// expand replaces the placeholders of a template, passing each value
+// through escape
+func expand(template string, values map[string]string, escape func(key, value string) string) string {
+	oldnew := make([]string, 0, len(values)*2)
+	for key, value := range values {
+		oldnew = append(oldnew, "{"+key+"}", escape(key, value))
+	}
+	return strings.NewReplacer(oldnew...).Replace(template)
+}
+
+// jsonValue returns a placeholder value as JSON for body templates: a
+// number for max_results, an array for domains and a string otherwise
+func jsonValue(domains []string) func(key, value string) string {
+	return func(key, value string) string {
+		var v interface{} = value
+		switch key {
+		case "max_results":
+			return value
+		case "domains":
+			if domains == nil {
+				domains = []string{}
+			}
+			v = domains
+		}
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// buildRequest creates the HTTP request of a search from the templates
+func (p *RESTProvider) buildRequest(ctx context.Context, req models.SearchRequest) (*http.Request, error) {
+	values := p.restValues(req)
+	raw := func(_, value string) string { return value }
+
+	target := expand(p.url, values, func(key, value string) string {
+		if key == "base_url" {
+			return value
+		}
+		return url.PathEscape(value)
+	})
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q: %w", target, err)
+	}
+	if len(p.params) > 0 {
+		query := u.Query()
+		for name, template := range p.params {
+			if value := expand(template, values, raw); value != "" {
+				query.Set(name, value)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	var body io.Reader
+	if p.body != "" {
+		body = strings.NewReader(expand(p.body, values, jsonValue(req.AllowedDomains)))
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, p.method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for name, template := range p.headers {
+		httpReq.Header.Set(name, expand(template, values, raw))
+	}
+	if p.authHeader != "" {
+		key := p.apiKey
+		if p.authScheme != "" {
+			key = p.authScheme + " " + key
+		}
+		httpReq.Header.Set(p.authHeader, key)
+	}
+	return httpReq, nil
+}
+
+// Search performs a search query using the configured API
+func (p *RESTProvider) Search(ctx context.Context, searchReq models.SearchRequest) (*models.SearchProviderResult, error) {
+	log := logger.Log
+	query := searchReq.Query
+
+	if !p.IsAvailable() {
+		return nil, fmt.Errorf("%s provider not configured: missing url or API key", p.name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
+	req, err := p.buildRequest(ctx, searchReq)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	log.Debug("rest search response",
+		zap.String("provider", p.name),
+		zap.Int("status", resp.StatusCode),
+		zap.String("body", string(body)),
+	)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s search failed: status %d: %s", p.name, resp.StatusCode, body[:min(len(body), 200)])
+	}
+
+	// Parse response
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	items, ok := lookupPath(data, p.mapping.Results).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s search failed: no results array at %q", p.name, p.mapping.Results)
+	}
+
+	limit := p.maxResults
+	if searchReq.MaxResults > 0 {
+		limit = searchReq.MaxResults
+	}
+
+	// Convert results
+	result := &models.SearchProviderResult{
+		Query:   query,
+		Results: make([]models.SearchResult, 0, min(len(items), limit)),
+	}
+	for _, item := range items {
+		if len(result.Results) == limit {
+			break
+		}
+		r := models.SearchResult{
+			Title:   pathString(item, p.mapping.Title),
+			URL:     pathString(item, p.mapping.URL),
+			Content: pathString(item, p.mapping.Content),
+			Snippet: pathString(item, p.mapping.Snippet),
+		}
+		if r.URL == "" {
+			continue
+		}
+		result.Results = append(result.Results, r)
+	}
+
+	log.Info("rest search completed",
+		zap.String("provider", p.name),
+		zap.String("query", query),
+		zap.Int("result_count", len(result.Results)),
+	)
+
+	return result, nil
+}
+
+// lookupPath returns the value at a dot-separated path of decoded JSON, or
+// nil if there is none; the empty path is the value itself
+func lookupPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// pathString returns the value at a path as a string; unset paths and
+// missing values are empty
+func pathString(v interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+	switch value := lookupPath(v, path).(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		b, _ := json.Marshal(value)
+		return string(b)
+	}
+}
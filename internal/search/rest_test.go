@@ -0,0 +1,367 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/young1lin/responses2chat/internal/config"
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// newTestRESTProvider creates a REST provider, failing the test on errors
+func newTestRESTProvider(t *testing.T, cfg config.ProviderConfig) *RESTProvider {
+	t.Helper()
+	p, err := NewRESTProvider("rest", &cfg)
+	if err != nil {
+		t.Fatalf("NewRESTProvider failed: %v", err)
+	}
+	return p
+}
+
+func TestApplyRESTPreset(t *testing.T) {
+	cfg := config.ProviderConfig{
+		Preset:  "brave",
+		URL:     "{base_url}/custom",
+		Params:  map[string]string{"count": "20", "safesearch": "strict"},
+		Headers: map[string]string{"X-Extra": "1"},
+		Mapping: config.RESTMapping{Snippet: "extra_snippets.0"},
+	}
+	preset := restPresets["brave"]
+	applyRESTPreset(&cfg, &preset)
+
+	if cfg.URL != "{base_url}/custom" || cfg.BaseURL != preset.BaseURL || cfg.Method != http.MethodGet {
+		t.Errorf("Expected set fields to override the preset and empty ones to be filled, got %+v", cfg)
+	}
+	wantParams := map[string]string{"q": "{query}", "count": "20", "country": "{country}", "safesearch": "strict"}
+	if !reflect.DeepEqual(cfg.Params, wantParams) {
+		t.Errorf("Expected merged params %v, got %v", wantParams, cfg.Params)
+	}
+	wantHeaders := map[string]string{"Accept": "application/json", "X-Extra": "1"}
+	if !reflect.DeepEqual(cfg.Headers, wantHeaders) {
+		t.Errorf("Expected merged headers %v, got %v", wantHeaders, cfg.Headers)
+	}
+	if cfg.Mapping.Snippet != "extra_snippets.0" || cfg.Mapping.Results != "web.results" {
+		t.Errorf("Expected the mapping to be merged field by field, got %+v", cfg.Mapping)
+	}
+	if !cfg.SiteOperators {
+		t.Error("Expected site operators from the preset")
+	}
+	if restPresets["brave"].Params["count"] != "{max_results}" {
+		t.Error("Expected the preset to stay unchanged")
+	}
+}
+
+func TestNewRESTProvider(t *testing.T) {
+	t.Run("Unknown preset", func(t *testing.T) {
+		_, err := NewRESTProvider("rest", &config.ProviderConfig{Preset: "bing"})
+		if err == nil || !strings.Contains(err.Error(), `"bing"`) || !strings.Contains(err.Error(), "brave, searxng, tavily") {
+			t.Errorf("Expected an unknown preset error, got %v", err)
+		}
+	})
+
+	t.Run("Skipped by the manager", func(t *testing.T) {
+		m := NewManager(&config.WebSearchConfig{
+			Enabled: true,
+			Default: "typo",
+			Providers: map[string]config.ProviderConfig{
+				"typo": {Type: "rest", Preset: "bing", APIKey: "k"},
+				"sx":   {Type: "rest", Preset: "searxng", BaseURL: "http://searx.local"},
+			},
+		}, nil)
+		if _, ok := m.providers["typo"]; ok {
+			t.Error("Expected the provider with an unknown preset to be skipped")
+		}
+		if _, ok := m.providers["sx"]; !ok {
+			t.Error("Expected the valid provider to be registered")
+		}
+	})
+
+	t.Run("Availability", func(t *testing.T) {
+		tests := []struct {
+			name string
+			cfg  config.ProviderConfig
+			want bool
+		}{
+			{"Preset with API key", config.ProviderConfig{Preset: "tavily", APIKey: "k"}, true},
+			{"Preset without API key", config.ProviderConfig{Preset: "tavily"}, false},
+			{"SearXNG without base URL", config.ProviderConfig{Preset: "searxng"}, false},
+			{"Custom URL without auth", config.ProviderConfig{URL: "https://search.local/api"}, true},
+			{"No URL", config.ProviderConfig{}, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if got := newTestRESTProvider(t, tt.cfg).IsAvailable(); got != tt.want {
+					t.Errorf("Expected %v, got %v", tt.want, got)
+				}
+			})
+		}
+	})
+}
+
+func TestBuildRequest(t *testing.T) {
+	req := models.SearchRequest{
+		Query:          `a "b" & c/d?`,
+		MaxResults:     3,
+		AllowedDomains: []string{"go.dev"},
+		UserLocation:   &models.WebSearchUserLocation{Country: "FR"},
+	}
+
+	t.Run("URL path", func(t *testing.T) {
+		p := newTestRESTProvider(t, config.ProviderConfig{BaseURL: "https://api.local/v1/", URL: "{base_url}/search/{query}/{max_results}"})
+		httpReq, err := p.buildRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "https://api.local/v1/search/a%20%22b%22%20&%20c%2Fd%3F/3"; httpReq.URL.String() != want {
+			t.Errorf("Expected %s, got %s", want, httpReq.URL.String())
+		}
+		if httpReq.Method != http.MethodGet || httpReq.Body != nil {
+			t.Errorf("Expected a GET without body, got %s", httpReq.Method)
+		}
+	})
+
+	t.Run("Params and headers", func(t *testing.T) {
+		p := newTestRESTProvider(t, config.ProviderConfig{
+			URL:        "https://api.local/search?fixed=1",
+			APIKey:     "secret",
+			Params:     map[string]string{"q": "{query}", "n": "{max_results}", "cc": "{country}", "sites": "{domains}", "literal": "{unknown}"},
+			Headers:    map[string]string{"X-Key": "key={api_key}"},
+			AuthHeader: "Authorization",
+			AuthScheme: "Token",
+		})
+		req := req
+		req.AllowedDomains = []string{"go.dev", "pkg.go.dev"}
+		httpReq, err := p.buildRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := httpReq.URL.Query()
+		for name, want := range map[string]string{"fixed": "1", "q": `a "b" & c/d?`, "n": "3", "cc": "FR", "sites": "go.dev,pkg.go.dev", "literal": "{unknown}"} {
+			if got := query.Get(name); got != want {
+				t.Errorf("Param %s: expected %q, got %q", name, want, got)
+			}
+		}
+		if got := httpReq.Header.Get("X-Key"); got != "key=secret" {
+			t.Errorf("Expected the API key in the header template, got %q", got)
+		}
+		if got := httpReq.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Expected the auth header with its scheme, got %q", got)
+		}
+	})
+
+	t.Run("Empty params are left out", func(t *testing.T) {
+		p := newTestRESTProvider(t, config.ProviderConfig{URL: "https://api.local/search", Params: map[string]string{"q": "{query}", "cc": "{country}"}})
+		httpReq, err := p.buildRequest(context.Background(), models.SearchRequest{Query: "go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if httpReq.URL.RawQuery != "q=go" {
+			t.Errorf("Expected only q, got %s", httpReq.URL.RawQuery)
+		}
+	})
+
+	t.Run("JSON body", func(t *testing.T) {
+		p := newTestRESTProvider(t, config.ProviderConfig{
+			URL:  "https://api.local/search",
+			Body: `{"q": {query}, "limit": {max_results}, "sites": {domains}, "cc": {country}}`,
+		})
+		for _, tt := range []struct {
+			name string
+			req  models.SearchRequest
+			want string
+		}{
+			{"Values", req, `{"q":"a \"b\" & c/d?","limit":3,"sites":["go.dev"],"cc":"FR"}`},
+			{"Defaults", models.SearchRequest{Query: "go"}, `{"q":"go","limit":5,"sites":[],"cc":""}`},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				httpReq, err := p.buildRequest(context.Background(), tt.req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if httpReq.Method != http.MethodPost || httpReq.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("Expected a JSON POST, got %s %q", httpReq.Method, httpReq.Header.Get("Content-Type"))
+				}
+				raw, _ := io.ReadAll(httpReq.Body)
+				var got, want interface{}
+				if err := json.Unmarshal(raw, &got); err != nil {
+					t.Fatalf("Invalid body %s: %v", raw, err)
+				}
+				json.Unmarshal([]byte(tt.want), &want)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Expected %s, got %s", tt.want, raw)
+				}
+			})
+		}
+	})
+}
+
+func TestRESTPresets(t *testing.T) {
+	req := models.SearchRequest{Query: "golang", MaxResults: 2, AllowedDomains: []string{"go.dev"}, UserLocation: &models.WebSearchUserLocation{Country: "US"}}
+
+	tests := []struct {
+		preset string
+		apiKey string
+		// check asserts the outgoing request
+		check    func(t *testing.T, r *http.Request, body map[string]interface{})
+		response string
+		want     []models.SearchResult
+	}{
+		{
+			preset: "tavily",
+			apiKey: "tvly",
+			check: func(t *testing.T, r *http.Request, body map[string]interface{}) {
+				if r.Method != http.MethodPost || r.URL.Path != "/search" {
+					t.Errorf("Expected POST /search, got %s %s", r.Method, r.URL.Path)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer tvly" {
+					t.Errorf("Expected the bearer key, got %q", got)
+				}
+				want := map[string]interface{}{"query": "golang", "max_results": float64(2), "include_domains": []interface{}{"go.dev"}}
+				if !reflect.DeepEqual(body, want) {
+					t.Errorf("Expected body %v, got %v", want, body)
+				}
+			},
+			response: `{"results":[
+				{"title":"Go","url":"https://go.dev","content":"The Go language","raw_content":"Full page"},
+				{"title":"No URL","content":"dropped"},
+				{"title":"Tour","url":"https://go.dev/tour","content":"A tour"}]}`,
+			want: []models.SearchResult{
+				{Title: "Go", URL: "https://go.dev", Snippet: "The Go language", Content: "Full page"},
+				{Title: "Tour", URL: "https://go.dev/tour", Snippet: "A tour"},
+			},
+		},
+		{
+			preset: "brave",
+			apiKey: "bsa",
+			check: func(t *testing.T, r *http.Request, _ map[string]interface{}) {
+				if r.Method != http.MethodGet || r.URL.Path != "/web/search" {
+					t.Errorf("Expected GET /web/search, got %s %s", r.Method, r.URL.Path)
+				}
+				if got := r.Header.Get("X-Subscription-Token"); got != "bsa" {
+					t.Errorf("Expected the subscription token, got %q", got)
+				}
+				if got := r.Header.Get("Accept"); got != "application/json" {
+					t.Errorf("Expected Accept: application/json, got %q", got)
+				}
+				query := r.URL.Query()
+				if query.Get("q") != "golang (site:go.dev)" || query.Get("count") != "2" || query.Get("country") != "US" {
+					t.Errorf("Unexpected query %s", r.URL.RawQuery)
+				}
+			},
+			response: `{"web":{"results":[{"title":"Go","url":"https://go.dev","description":"The Go language"}]}}`,
+			want:     []models.SearchResult{{Title: "Go", URL: "https://go.dev", Snippet: "The Go language"}},
+		},
+		{
+			preset: "searxng",
+			check: func(t *testing.T, r *http.Request, _ map[string]interface{}) {
+				if r.Method != http.MethodGet || r.URL.Path != "/search" {
+					t.Errorf("Expected GET /search, got %s %s", r.Method, r.URL.Path)
+				}
+				if r.Header.Get("Authorization") != "" {
+					t.Error("Expected no credentials")
+				}
+				query := r.URL.Query()
+				if query.Get("q") != "golang (site:go.dev)" || query.Get("format") != "json" {
+					t.Errorf("Unexpected query %s", r.URL.RawQuery)
+				}
+			},
+			response: `{"results":[
+				{"title":"Go","url":"https://go.dev","content":"The Go language"},
+				{"title":"Blog","url":"https://go.dev/blog","content":"News"},
+				{"title":"Docs","url":"https://go.dev/doc","content":"Beyond max_results"}]}`,
+			want: []models.SearchResult{
+				{Title: "Go", URL: "https://go.dev", Snippet: "The Go language"},
+				{Title: "Blog", URL: "https://go.dev/blog", Snippet: "News"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]interface{}
+				if r.Body != nil {
+					json.NewDecoder(r.Body).Decode(&body)
+				}
+				tt.check(t, r, body)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, tt.response)
+			}))
+			defer srv.Close()
+
+			p := newTestRESTProvider(t, config.ProviderConfig{Preset: tt.preset, BaseURL: srv.URL, APIKey: tt.apiKey})
+			result, err := p.Search(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if result.Query != "golang" {
+				t.Errorf("Expected the query of the request, got %q", result.Query)
+			}
+			if !reflect.DeepEqual(result.Results, tt.want) {
+				t.Errorf("Expected results %+v, got %+v", tt.want, result.Results)
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, tt := range []struct {
+			name     string
+			status   int
+			response string
+			want     string
+		}{
+			{"Status", http.StatusUnauthorized, `{"error":"bad key"}`, "status 401"},
+			{"Not JSON", http.StatusOK, `<html>`, "failed to parse response"},
+			{"No results array", http.StatusOK, `{"results":{}}`, `no results array at "results"`},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+					io.WriteString(w, tt.response)
+				}))
+				defer srv.Close()
+
+				p := newTestRESTProvider(t, config.ProviderConfig{Preset: "searxng", BaseURL: srv.URL})
+				if _, err := p.Search(context.Background(), models.SearchRequest{Query: "go"}); err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+				}
+			})
+		}
+	})
+}
+
+func TestLookupPath(t *testing.T) {
+	var data interface{}
+	json.Unmarshal([]byte(`{"web":{"results":[{"title":"Go","meta":{"rank":2.5,"tags":["a"]}}]}}`), &data)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"web.results.0.title", "Go"},
+		{"web.results.0.meta.rank", "2.5"},
+		{"web.results.0.meta.tags", `["a"]`},
+		{"web.results.1.title", ""},
+		{"web.results.x", ""},
+		{"web.missing.title", ""},
+		{"web.results.0.title.more", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := pathString(data, tt.path); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if lookupPath(data, "") == nil {
+		t.Error("Expected the empty path to be the value itself")
+	}
+}
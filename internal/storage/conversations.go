@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// ErrConversationNotFound is returned when a conversation does not exist
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// CreateConversation saves a new conversation with its initial items
+func (s *ConversationStore) CreateConversation(conv *models.Conversation, items []models.InputItem) error {
+	data, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(conversationsMetaBucket).Put([]byte(conv.ID), data); err != nil {
+			return err
+		}
+		if _, err := tx.Bucket(conversationItemsBucket).CreateBucketIfNotExists([]byte(conv.ID)); err != nil {
+			return err
+		}
+		return appendConversationItems(tx, conv.ID, items)
+	})
+}
+
+// GetConversation retrieves a conversation by ID
+// Returns the conversation and true if found, nil and false otherwise
+func (s *ConversationStore) GetConversation(convID string) (*models.Conversation, bool) {
+	var conv *models.Conversation
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(conversationsMetaBucket).Get([]byte(convID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &conv)
+	})
+
+	if err != nil || conv == nil {
+		return nil, false
+	}
+
+	return conv, true
+}
+
+// UpdateConversation replaces the stored conversation object, e.g. after a
+// metadata change. Returns ErrConversationNotFound if it does not exist.
+func (s *ConversationStore) UpdateConversation(conv *models.Conversation) error {
+	data, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(conversationsMetaBucket)
+		if b.Get([]byte(conv.ID)) == nil {
+			return ErrConversationNotFound
+		}
+		return b.Put([]byte(conv.ID), data)
+	})
+}
+
+// DeleteConversation removes a conversation and all of its items
+// Returns false if the conversation does not exist
+func (s *ConversationStore) DeleteConversation(convID string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		key := []byte(convID)
+		meta := tx.Bucket(conversationsMetaBucket)
+		if meta.Get(key) == nil {
+			return nil
+		}
+		found = true
+
+		parent := tx.Bucket(conversationItemsBucket)
+		if items := parent.Bucket(key); items != nil {
+			var ids []string
+			items.ForEach(func(_, v []byte) error {
+				var item models.InputItem
+				if json.Unmarshal(v, &item) == nil {
+					ids = append(ids, item.ID)
+				}
+				return nil
+			})
+			if err := deleteOwnedItems(tx.Bucket(itemsBucket), convID, ids); err != nil {
+				return err
+			}
+			if err := parent.DeleteBucket(key); err != nil {
+				return err
+			}
+		}
+		return meta.Delete(key)
+	})
+	return found, err
+}
+
+// AppendConversationItems adds items to the end of a conversation
+// Returns ErrConversationNotFound if it does not exist
+func (s *ConversationStore) AppendConversationItems(convID string, items []models.InputItem) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return appendConversationItems(tx, convID, items)
+	})
+}
+
+// GetConversationItems retrieves the items of a conversation in the order
+// they were added
+// Returns the items and true if found, nil and false otherwise
+func (s *ConversationStore) GetConversationItems(convID string) ([]models.InputItem, bool) {
+	var items []models.InputItem
+	found := false
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(conversationItemsBucket).Bucket([]byte(convID))
+		if b == nil {
+			return nil
+		}
+		found = true
+		return b.ForEach(func(_, v []byte) error {
+			var item models.InputItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+
+	if err != nil || !found {
+		return nil, false
+	}
+
+	return items, true
+}
+
+// DeleteConversationItem removes one item from a conversation
+// Returns false if the conversation or the item does not exist
+func (s *ConversationStore) DeleteConversationItem(convID, itemID string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(conversationItemsBucket).Bucket([]byte(convID))
+		if b == nil {
+			return nil
+		}
+
+		var key []byte
+		b.ForEach(func(k, v []byte) error {
+			var item models.InputItem
+			if key == nil && json.Unmarshal(v, &item) == nil && item.ID == itemID {
+				key = append([]byte(nil), k...)
+			}
+			return nil
+		})
+		if key == nil {
+			return nil
+		}
+		found = true
+
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+		return deleteOwnedItems(tx.Bucket(itemsBucket), convID, []string{itemID})
+	})
+	return found, err
+}
+
+// appendConversationItems stores items under increasing sequence keys and
+// indexes them for item_reference lookups
+func appendConversationItems(tx *bbolt.Tx, convID string, items []models.InputItem) error {
+	b := tx.Bucket(conversationItemsBucket).Bucket([]byte(convID))
+	if b == nil {
+		return ErrConversationNotFound
+	}
+
+	for _, item := range items {
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+	}
+
+	return indexItems(tx.Bucket(itemsBucket), convID, items)
+}
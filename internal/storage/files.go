@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+var filesBucket = []byte("files") // File ID -> models.FileObject
+
+// ErrFileTooLarge is returned when an upload exceeds the size limit
+var ErrFileTooLarge = errors.New("file exceeds the maximum size")
+
+// SaveFile writes the content of an uploaded file to the files directory
+// beside the database and records its metadata. Bytes is set from the
+// content; at most maxBytes are accepted.
+func (s *ConversationStore) SaveFile(file *models.FileObject, r io.Reader, maxBytes int64) error {
+	if err := os.MkdirAll(s.filesDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.filesDir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(r, maxBytes+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n > maxBytes {
+		return ErrFileTooLarge
+	}
+	file.Bytes = n
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.filePath(file.ID)); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(filesBucket).Put([]byte(file.ID), data)
+	})
+}
+
+// GetFile retrieves the metadata of a file
+// Returns the file and true if found, nil and false otherwise
+func (s *ConversationStore) GetFile(fileID string) (*models.FileObject, bool) {
+	var file *models.FileObject
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(filesBucket).Get([]byte(fileID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &file)
+	})
+
+	if err != nil || file == nil {
+		return nil, false
+	}
+
+	return file, true
+}
+
+// ReadFile retrieves a file with its content
+// Returns the file, its content and true if found
+func (s *ConversationStore) ReadFile(fileID string) (*models.FileObject, []byte, bool) {
+	file, ok := s.GetFile(fileID)
+	if !ok {
+		return nil, nil, false
+	}
+
+	content, err := os.ReadFile(s.filePath(file.ID))
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return file, content, true
+}
+
+// ListFiles returns all files in upload order
+func (s *ConversationStore) ListFiles() ([]models.FileObject, error) {
+	var files []models.FileObject
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(filesBucket).ForEach(func(_, v []byte) error {
+			var file models.FileObject
+			if err := json.Unmarshal(v, &file); err != nil {
+				return err
+			}
+			files = append(files, file)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt < files[j].CreatedAt
+		}
+		return files[i].ID < files[j].ID
+	})
+	return files, nil
+}
+
+// DeleteFile removes a file and its content, also from vector stores
+// Returns false if the file does not exist
+func (s *ConversationStore) DeleteFile(fileID string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(filesBucket)
+		if b.Get([]byte(fileID)) == nil {
+			return nil
+		}
+		found = true
+		if err := b.Delete([]byte(fileID)); err != nil {
+			return err
+		}
+		return removeFileFromVectorStores(tx, fileID)
+	})
+	if err != nil || !found {
+		return found, err
+	}
+
+	// Only IDs found in the bucket reach the filesystem
+	if err := os.Remove(s.filePath(fileID)); err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+	return true, nil
+}
+
+// filePath returns the path of the content of a file
+func (s *ConversationStore) filePath(fileID string) string {
+	return filepath.Join(s.filesDir, filepath.Base(fileID))
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+// sealedPrefix marks encrypted_content produced by this proxy so foreign
+// reasoning items (e.g. from OpenAI itself) are skipped cheaply
+const sealedPrefix = "r2c1:"
+
+// sealedAAD binds ciphertexts to their purpose
+var sealedAAD = []byte("responses2chat conversation state v1")
+
+// StateSealer seals conversation history into an opaque token that clients
+// carry between turns, so multi-turn conversations work without any
+// server-side storage
+type StateSealer struct {
+	aead cipher.AEAD
+}
+
+// NewStateSealer creates a sealer using AES-256-GCM with a key derived from
+// secret. An empty secret generates a random key, which means sealed state
+// does not survive a restart.
+func NewStateSealer(secret string) (*StateSealer, error) {
+	var key [32]byte
+	if secret == "" {
+		if _, err := rand.Read(key[:]); err != nil {
+			return nil, err
+		}
+	} else {
+		key = sha256.Sum256([]byte(secret))
+	}
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &StateSealer{aead: aead}, nil
+}
+
+// Seal compresses and encrypts a conversation history
+func (s *StateSealer) Seal(messages []models.ChatMessage) (string, error) {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return "", err
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, compressed.Bytes(), sealedAAD)
+
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a token produced by Seal
+func (s *StateSealer) Open(token string) ([]models.ChatMessage, error) {
+	if !strings.HasPrefix(token, sealedPrefix) {
+		return nil, errors.New("not a sealed conversation state")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, sealedPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed state encoding: %w", err)
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, errors.New("sealed state too short")
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	compressed, err := s.aead.Open(nil, nonce, ciphertext, sealedAAD)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sealed state: %w", err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []models.ChatMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
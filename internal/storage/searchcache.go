@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+var searchCacheBucket = []byte("search_cache") // Cache key -> models.CachedSearchResult
+
+// GetSearchResult retrieves a cached search result by cache key
+// Returns the entry and true if found, nil and false otherwise; expired
+// entries are returned as well
+func (s *ConversationStore) GetSearchResult(key string) (*models.CachedSearchResult, bool) {
+	var entry *models.CachedSearchResult
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(searchCacheBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &entry)
+	})
+
+	if err != nil || entry == nil {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// StoreSearchResult saves a search result under its cache key
+func (s *ConversationStore) StoreSearchResult(key string, entry *models.CachedSearchResult) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(searchCacheBucket).Put([]byte(key), data)
+	})
+}
+
+// PruneSearchResults deletes the cached search results that expired before
+// now and returns how many were deleted
+func (s *ConversationStore) PruneSearchResults(now time.Time) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(searchCacheBucket)
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var entry models.CachedSearchResult
+			if err := json.Unmarshal(v, &entry); err != nil || entry.ExpiresAt < now.Unix() {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(expired)
+		return nil
+	})
+	return count, err
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/json"
+	"path/filepath"
 
 	"go.etcd.io/bbolt"
 	"go.uber.org/zap"
@@ -10,11 +11,32 @@ import (
 	"github.com/young1lin/responses2chat/pkg/logger"
 )
 
-var bucketName = []byte("conversations")
+var (
+	bucketName       = []byte("conversations")
+	responsesBucket  = []byte("responses")
+	inputItemsBucket = []byte("input_items") // Response ID -> responseItems
+	itemsBucket      = []byte("items")       // Item ID -> itemEntry
+
+	conversationsMetaBucket = []byte("conversation_meta")  // Conversation ID -> models.Conversation
+	conversationItemsBucket = []byte("conversation_items") // Conversation ID -> bucket of sequence -> models.InputItem
+)
+
+// responseItems lists the items that belong to a response
+type responseItems struct {
+	Input     []models.InputItem `json:"input"`
+	OutputIDs []string           `json:"output_ids,omitempty"`
+}
+
+// itemEntry is an item indexed by ID for item_reference lookups
+type itemEntry struct {
+	Owner string           `json:"owner"` // Response or conversation ID
+	Item  models.InputItem `json:"item"`
+}
 
 // ConversationStore provides persistent storage for conversation history using BBolt
 type ConversationStore struct {
-	db *bbolt.DB
+	db       *bbolt.DB
+	filesDir string // Uploaded file contents, beside the database
 }
 
 // NewConversationStore creates a new conversation store with the given database path
@@ -24,10 +46,19 @@ func NewConversationStore(path string) (*ConversationStore, error) {
 		return nil, err
 	}
 
-	// Create bucket if not exists
+	// Create buckets if not exist
 	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucketName)
-		return err
+		for _, name := range [][]byte{
+			bucketName, responsesBucket, inputItemsBucket, itemsBucket,
+			conversationsMetaBucket, conversationItemsBucket, filesBucket,
+			vectorStoresBucket, vectorStoreFilesBucket, vectorStoreChunksBucket,
+			searchCacheBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 	if err != nil {
 		db.Close()
@@ -35,7 +66,10 @@ func NewConversationStore(path string) (*ConversationStore, error) {
 	}
 
 	logger.Info("conversation store initialized", zap.String("path", path))
-	return &ConversationStore{db: db}, nil
+	return &ConversationStore{
+		db:       db,
+		filesDir: filepath.Join(filepath.Dir(path), "files"),
+	}, nil
 }
 
 // Store saves a conversation history with the given response ID
@@ -80,6 +114,223 @@ func (s *ConversationStore) Delete(responseID string) error {
 	})
 }
 
+// StoreResponse saves a response record, e.g. the live state of a background response
+func (s *ConversationStore) StoreResponse(resp *models.ResponsesResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(responsesBucket)
+		return b.Put([]byte(resp.ID), data)
+	})
+}
+
+// GetResponse retrieves a response record by response ID
+// Returns the record and true if found, nil and false otherwise
+func (s *ConversationStore) GetResponse(responseID string) (*models.ResponsesResponse, bool) {
+	var resp *models.ResponsesResponse
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(responsesBucket)
+		data := b.Get([]byte(responseID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &resp)
+	})
+
+	if err != nil || resp == nil {
+		return nil, false
+	}
+
+	return resp, true
+}
+
+// FailInterruptedResponses marks every response record that is still queued
+// or in progress as failed. It is meant to run at startup, when no job from a
+// previous process can still be running.
+// Returns the number of records updated.
+func (s *ConversationStore) FailInterruptedResponses(message string) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(responsesBucket)
+		updates := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			var resp models.ResponsesResponse
+			if err := json.Unmarshal(v, &resp); err != nil {
+				return nil // Skip unreadable records
+			}
+			if resp.Status != "queued" && resp.Status != "in_progress" {
+				return nil
+			}
+			resp.Status = "failed"
+			resp.Error = &models.ErrorDetail{
+				Type:    "server_error",
+				Message: message,
+			}
+			data, err := json.Marshal(&resp)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = data
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Keys cannot be modified while iterating
+		for k, data := range updates {
+			if err := b.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+		count = len(updates)
+		return nil
+	})
+	return count, err
+}
+
+// StoreItems saves the input items of a response and indexes them, together
+// with its output items, by item ID. An ID that is already indexed keeps
+// pointing at the response that introduced it.
+func (s *ConversationStore) StoreItems(responseID string, input, output []models.InputItem) error {
+	record := responseItems{Input: input}
+	for _, item := range output {
+		record.OutputIDs = append(record.OutputIDs, item.ID)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(inputItemsBucket).Put([]byte(responseID), data); err != nil {
+			return err
+		}
+
+		items := tx.Bucket(itemsBucket)
+		if err := indexItems(items, responseID, input); err != nil {
+			return err
+		}
+		return indexItems(items, responseID, output)
+	})
+}
+
+// indexItems adds items to the item index unless their ID is already taken
+func indexItems(index *bbolt.Bucket, owner string, items []models.InputItem) error {
+	for _, item := range items {
+		if item.ID == "" || index.Get([]byte(item.ID)) != nil {
+			continue
+		}
+		entry, err := json.Marshal(itemEntry{Owner: owner, Item: item})
+		if err != nil {
+			return err
+		}
+		if err := index.Put([]byte(item.ID), entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetInputItems retrieves the input items of a response in request order
+// Returns the items and true if found, nil and false otherwise
+func (s *ConversationStore) GetInputItems(responseID string) ([]models.InputItem, bool) {
+	var record *responseItems
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(inputItemsBucket).Get([]byte(responseID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &record)
+	})
+
+	if err != nil || record == nil {
+		return nil, false
+	}
+
+	return record.Input, true
+}
+
+// GetItem retrieves an input or output item by item ID
+// Returns the item and true if found, nil and false otherwise
+func (s *ConversationStore) GetItem(itemID string) (*models.InputItem, bool) {
+	var entry *itemEntry
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(itemsBucket).Get([]byte(itemID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &entry)
+	})
+
+	if err != nil || entry == nil {
+		return nil, false
+	}
+
+	return &entry.Item, true
+}
+
+// DeleteResponse removes everything stored for a response: its conversation
+// history, its response record and its items
+// Returns false if nothing was stored for the response
+func (s *ConversationStore) DeleteResponse(responseID string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		key := []byte(responseID)
+
+		if data := tx.Bucket(inputItemsBucket).Get(key); data != nil {
+			found = true
+			var record responseItems
+			if err := json.Unmarshal(data, &record); err == nil {
+				ids := record.OutputIDs
+				for _, item := range record.Input {
+					ids = append(ids, item.ID)
+				}
+				if err := deleteOwnedItems(tx.Bucket(itemsBucket), responseID, ids); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, name := range [][]byte{bucketName, responsesBucket, inputItemsBucket} {
+			b := tx.Bucket(name)
+			if b.Get(key) == nil {
+				continue
+			}
+			found = true
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// deleteOwnedItems removes the index entries of ids that belong to owner
+func deleteOwnedItems(items *bbolt.Bucket, owner string, ids []string) error {
+	for _, id := range ids {
+		data := items.Get([]byte(id))
+		if data == nil {
+			continue
+		}
+		var entry itemEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Owner != owner {
+			continue
+		}
+		if err := items.Delete([]byte(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close closes the database connection
 func (s *ConversationStore) Close() error {
 	return s.db.Close()
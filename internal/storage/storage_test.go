@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"errors"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/young1lin/responses2chat/internal/models"
 )
@@ -185,3 +188,496 @@ func TestConversationStore_Persistence(t *testing.T) {
 		t.Errorf("Expected 'Test persistence', got '%v'", got[0].Content)
 	}
 }
+
+func TestStateSealer(t *testing.T) {
+	sealer, err := NewStateSealer("test-secret")
+	if err != nil {
+		t.Fatalf("Failed to create sealer: %v", err)
+	}
+
+	messages := []models.ChatMessage{
+		{Role: "user", Content: "Hello"},
+		{Role: "assistant", Content: "Hi!", ReasoningContent: "Greet back"},
+	}
+
+	t.Run("Seal and Open", func(t *testing.T) {
+		token, err := sealer.Seal(messages)
+		if err != nil {
+			t.Fatalf("Failed to seal: %v", err)
+		}
+
+		got, err := sealer.Open(token)
+		if err != nil {
+			t.Fatalf("Failed to open: %v", err)
+		}
+
+		if len(got) != 2 || got[1].ReasoningContent != "Greet back" {
+			t.Errorf("Unexpected opened messages: %+v", got)
+		}
+	})
+
+	t.Run("Same secret opens after restart", func(t *testing.T) {
+		token, _ := sealer.Seal(messages)
+
+		restarted, _ := NewStateSealer("test-secret")
+		if _, err := restarted.Open(token); err != nil {
+			t.Errorf("Expected token to open with the same secret: %v", err)
+		}
+	})
+
+	t.Run("Wrong key", func(t *testing.T) {
+		token, _ := sealer.Seal(messages)
+
+		other, _ := NewStateSealer("other-secret")
+		if _, err := other.Open(token); err == nil {
+			t.Error("Expected error opening with a different key")
+		}
+	})
+
+	t.Run("Foreign content", func(t *testing.T) {
+		if _, err := sealer.Open("gAAAAABfOpenAIEncryptedContent"); err == nil {
+			t.Error("Expected error for foreign encrypted content")
+		}
+	})
+}
+
+func TestResponseRecords(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "responses.db")
+
+	store, err := NewConversationStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	t.Run("Store and Get", func(t *testing.T) {
+		resp := &models.ResponsesResponse{
+			ID:         "resp-bg-1",
+			Object:     "response",
+			Status:     "queued",
+			Background: true,
+		}
+		if err := store.StoreResponse(resp); err != nil {
+			t.Fatalf("Failed to store response: %v", err)
+		}
+
+		got, found := store.GetResponse("resp-bg-1")
+		if !found {
+			t.Fatal("Expected to find stored response")
+		}
+		if got.Status != "queued" || !got.Background {
+			t.Errorf("Unexpected response record: %+v", got)
+		}
+	})
+
+	t.Run("Get non-existent", func(t *testing.T) {
+		if _, found := store.GetResponse("resp-nonexistent"); found {
+			t.Error("Expected not to find non-existent response")
+		}
+	})
+
+	t.Run("Fail interrupted responses", func(t *testing.T) {
+		store.StoreResponse(&models.ResponsesResponse{ID: "resp-running", Status: "in_progress"})
+		store.StoreResponse(&models.ResponsesResponse{ID: "resp-done", Status: "completed"})
+
+		count, err := store.FailInterruptedResponses("interrupted by restart")
+		if err != nil {
+			t.Fatalf("Failed to fail interrupted responses: %v", err)
+		}
+		if count != 2 {
+			t.Errorf("Expected 2 interrupted responses, got %d", count)
+		}
+
+		got, _ := store.GetResponse("resp-running")
+		if got.Status != "failed" || got.Error == nil || got.Error.Message != "interrupted by restart" {
+			t.Errorf("Expected running response to be failed, got %+v", got)
+		}
+
+		done, _ := store.GetResponse("resp-done")
+		if done.Status != "completed" {
+			t.Errorf("Expected completed response to be untouched, got %s", done.Status)
+		}
+	})
+}
+
+func TestResponseItems(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "items.db")
+
+	store, err := NewConversationStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	input := []models.InputItem{
+		{Type: "message", ID: "msg-in-1", Role: "user", Content: []models.ContentItem{{Type: "input_text", Text: "Hi"}}},
+	}
+	output := []models.InputItem{
+		{Type: "message", ID: "msg-out-1", Role: "assistant", Content: []models.ContentItem{{Type: "output_text", Text: "Hello"}}},
+	}
+
+	if err := store.Store("resp-items-1", []models.ChatMessage{{Role: "user", Content: "Hi"}}); err != nil {
+		t.Fatalf("Failed to store history: %v", err)
+	}
+	if err := store.StoreItems("resp-items-1", input, output); err != nil {
+		t.Fatalf("Failed to store items: %v", err)
+	}
+
+	t.Run("Input items", func(t *testing.T) {
+		got, found := store.GetInputItems("resp-items-1")
+		if !found || len(got) != 1 || got[0].ID != "msg-in-1" {
+			t.Errorf("Unexpected input items: %+v", got)
+		}
+	})
+
+	t.Run("Item lookup", func(t *testing.T) {
+		got, found := store.GetItem("msg-out-1")
+		if !found || got.Role != "assistant" {
+			t.Errorf("Expected to find output item, got %+v", got)
+		}
+	})
+
+	t.Run("Referenced items keep their owner", func(t *testing.T) {
+		// A later turn that sends msg-out-1 back must not take it over
+		store.StoreItems("resp-items-2", []models.InputItem{output[0]}, nil)
+		store.DeleteResponse("resp-items-2")
+
+		if _, found := store.GetItem("msg-out-1"); !found {
+			t.Error("Expected item to survive deletion of a response that only referenced it")
+		}
+	})
+
+	t.Run("Delete response", func(t *testing.T) {
+		found, err := store.DeleteResponse("resp-items-1")
+		if err != nil || !found {
+			t.Fatalf("Expected response to be deleted, found=%v err=%v", found, err)
+		}
+
+		if _, found := store.Get("resp-items-1"); found {
+			t.Error("Expected history to be deleted")
+		}
+		if _, found := store.GetInputItems("resp-items-1"); found {
+			t.Error("Expected input items to be deleted")
+		}
+		if _, found := store.GetItem("msg-in-1"); found {
+			t.Error("Expected item index to be deleted")
+		}
+
+		found, _ = store.DeleteResponse("resp-items-1")
+		if found {
+			t.Error("Expected second delete to find nothing")
+		}
+	})
+}
+
+func TestConversations(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "conversations.db")
+
+	store, err := NewConversationStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	conv := &models.Conversation{ID: "conv_test", Object: "conversation", Metadata: map[string]interface{}{"topic": "demo"}}
+	initial := []models.InputItem{{Type: "message", ID: "msg-1", Role: "user"}}
+	if err := store.CreateConversation(conv, initial); err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+
+	t.Run("Append keeps order", func(t *testing.T) {
+		err := store.AppendConversationItems("conv_test", []models.InputItem{
+			{Type: "message", ID: "msg-2", Role: "assistant"},
+			{Type: "message", ID: "msg-3", Role: "user"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to append items: %v", err)
+		}
+
+		items, found := store.GetConversationItems("conv_test")
+		if !found || len(items) != 3 {
+			t.Fatalf("Expected 3 items, got %d", len(items))
+		}
+		for i, id := range []string{"msg-1", "msg-2", "msg-3"} {
+			if items[i].ID != id {
+				t.Errorf("Expected item %d to be %s, got %s", i, id, items[i].ID)
+			}
+		}
+
+		if _, found := store.GetItem("msg-2"); !found {
+			t.Error("Expected conversation items to be indexed")
+		}
+	})
+
+	t.Run("Append to missing conversation", func(t *testing.T) {
+		err := store.AppendConversationItems("conv_missing", []models.InputItem{{Type: "message", ID: "msg-x"}})
+		if err != ErrConversationNotFound {
+			t.Errorf("Expected ErrConversationNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete item", func(t *testing.T) {
+		found, err := store.DeleteConversationItem("conv_test", "msg-2")
+		if err != nil || !found {
+			t.Fatalf("Expected item to be deleted, found=%v err=%v", found, err)
+		}
+
+		items, _ := store.GetConversationItems("conv_test")
+		if len(items) != 2 || items[1].ID != "msg-3" {
+			t.Errorf("Unexpected items after delete: %+v", items)
+		}
+	})
+
+	t.Run("Update metadata", func(t *testing.T) {
+		conv.Metadata = map[string]interface{}{"topic": "changed"}
+		if err := store.UpdateConversation(conv); err != nil {
+			t.Fatalf("Failed to update conversation: %v", err)
+		}
+
+		got, _ := store.GetConversation("conv_test")
+		if got.Metadata["topic"] != "changed" {
+			t.Errorf("Expected updated metadata, got %v", got.Metadata)
+		}
+	})
+
+	t.Run("Delete conversation", func(t *testing.T) {
+		found, err := store.DeleteConversation("conv_test")
+		if err != nil || !found {
+			t.Fatalf("Expected conversation to be deleted, found=%v err=%v", found, err)
+		}
+
+		if _, found := store.GetConversation("conv_test"); found {
+			t.Error("Expected conversation to be gone")
+		}
+		if _, found := store.GetConversationItems("conv_test"); found {
+			t.Error("Expected conversation items to be gone")
+		}
+		if _, found := store.GetItem("msg-1"); found {
+			t.Error("Expected item index entries to be gone")
+		}
+	})
+}
+
+func TestFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "conversations.db")
+
+	store, err := NewConversationStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	first := &models.FileObject{ID: "file-1", Object: "file", CreatedAt: 1, Filename: "notes.txt", Purpose: "user_data"}
+	if err := store.SaveFile(first, strings.NewReader("hello"), 1024); err != nil {
+		t.Fatalf("Failed to save file: %v", err)
+	}
+	second := &models.FileObject{ID: "file-2", Object: "file", CreatedAt: 2, Filename: "main.go", Purpose: "assistants"}
+	if err := store.SaveFile(second, strings.NewReader("package main"), 1024); err != nil {
+		t.Fatalf("Failed to save file: %v", err)
+	}
+
+	t.Run("Read and list", func(t *testing.T) {
+		file, content, found := store.ReadFile("file-1")
+		if !found {
+			t.Fatal("Expected to find file")
+		}
+		if string(content) != "hello" || file.Bytes != 5 {
+			t.Errorf("Expected 5 bytes 'hello', got %d bytes %q", file.Bytes, content)
+		}
+
+		files, err := store.ListFiles()
+		if err != nil {
+			t.Fatalf("Failed to list files: %v", err)
+		}
+		if len(files) != 2 || files[0].ID != "file-1" || files[1].ID != "file-2" {
+			t.Errorf("Expected files in upload order, got %+v", files)
+		}
+	})
+
+	t.Run("Size limit", func(t *testing.T) {
+		big := &models.FileObject{ID: "file-big", Object: "file"}
+		err := store.SaveFile(big, strings.NewReader("too large"), 4)
+		if !errors.Is(err, ErrFileTooLarge) {
+			t.Errorf("Expected ErrFileTooLarge, got %v", err)
+		}
+		if _, found := store.GetFile("file-big"); found {
+			t.Error("Expected oversized file not to be stored")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		found, err := store.DeleteFile("file-1")
+		if err != nil || !found {
+			t.Fatalf("Expected delete to succeed, got %v, %v", found, err)
+		}
+		if _, _, found := store.ReadFile("file-1"); found {
+			t.Error("Expected file to be deleted")
+		}
+
+		found, err = store.DeleteFile("file-1")
+		if err != nil || found {
+			t.Errorf("Expected second delete to report not found, got %v, %v", found, err)
+		}
+	})
+}
+
+func TestVectorStores(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "conversations.db")
+
+	store, err := NewConversationStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	vs := &models.VectorStore{ID: "vs_1", Object: "vector_store", CreatedAt: 1, Name: "docs", Status: "completed"}
+	if err := store.CreateVectorStore(vs); err != nil {
+		t.Fatalf("Failed to create vector store: %v", err)
+	}
+	for _, f := range []*models.FileObject{
+		{ID: "file-1", Object: "file", CreatedAt: 1, Filename: "a.md"},
+		{ID: "file-2", Object: "file", CreatedAt: 2, Filename: "b.md"},
+	} {
+		if err := store.SaveFile(f, strings.NewReader("content"), 1024); err != nil {
+			t.Fatalf("Failed to save file: %v", err)
+		}
+	}
+
+	put := func(fileID string, createdAt int64, status string, chunks ...string) {
+		t.Helper()
+		file := &models.VectorStoreFile{ID: fileID, Object: "vector_store.file", CreatedAt: createdAt, VectorStoreID: "vs_1", Status: status, UsageBytes: 10}
+		var stored []models.VectorStoreChunk
+		for _, text := range chunks {
+			stored = append(stored, models.VectorStoreChunk{FileID: fileID, Text: text})
+		}
+		if err := store.PutVectorStoreFile(file, stored); err != nil {
+			t.Fatalf("Failed to put vector store file: %v", err)
+		}
+	}
+
+	t.Run("Files, chunks and counts", func(t *testing.T) {
+		put("file-1", 1, "completed", "one", "two")
+		put("file-2", 2, "failed")
+
+		got, found := store.GetVectorStore("vs_1")
+		if !found {
+			t.Fatal("Expected to find vector store")
+		}
+		if got.FileCounts.Total != 2 || got.FileCounts.Completed != 1 || got.FileCounts.Failed != 1 || got.UsageBytes != 20 {
+			t.Errorf("Unexpected counts %+v, usage %d", got.FileCounts, got.UsageBytes)
+		}
+
+		files, err := store.ListVectorStoreFiles("vs_1")
+		if err != nil || len(files) != 2 || files[0].ID != "file-1" {
+			t.Errorf("Expected files in the order they were added, got %+v, %v", files, err)
+		}
+
+		chunks, err := store.GetVectorStoreChunks([]string{"vs_1"})
+		if err != nil || len(chunks) != 2 || chunks[0].Text != "one" || chunks[1].Text != "two" {
+			t.Errorf("Expected chunks in order, got %+v, %v", chunks, err)
+		}
+	})
+
+	t.Run("Re-adding replaces chunks", func(t *testing.T) {
+		put("file-1", 1, "completed", "three")
+
+		chunks, err := store.GetVectorStoreChunks([]string{"vs_1"})
+		if err != nil || len(chunks) != 1 || chunks[0].Text != "three" {
+			t.Errorf("Expected only the new chunk, got %+v, %v", chunks, err)
+		}
+	})
+
+	t.Run("Missing vector store", func(t *testing.T) {
+		if _, err := store.GetVectorStoreChunks([]string{"vs_1", "vs_missing"}); !errors.Is(err, ErrVectorStoreNotFound) {
+			t.Errorf("Expected ErrVectorStoreNotFound, got %v", err)
+		}
+		if _, err := store.ListVectorStoreFiles("vs_missing"); !errors.Is(err, ErrVectorStoreNotFound) {
+			t.Errorf("Expected ErrVectorStoreNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Deleted files leave the store", func(t *testing.T) {
+		if found, err := store.DeleteFile("file-1"); err != nil || !found {
+			t.Fatalf("Expected delete to succeed, got %v, %v", found, err)
+		}
+		if _, found := store.GetVectorStoreFile("vs_1", "file-1"); found {
+			t.Error("Expected file to be removed from the vector store")
+		}
+		chunks, _ := store.GetVectorStoreChunks([]string{"vs_1"})
+		if len(chunks) != 0 {
+			t.Errorf("Expected chunks of the file to be removed, got %+v", chunks)
+		}
+		if got, _ := store.GetVectorStore("vs_1"); got.FileCounts.Total != 1 {
+			t.Errorf("Expected 1 file left, got %+v", got.FileCounts)
+		}
+	})
+
+	t.Run("Delete vector store", func(t *testing.T) {
+		found, err := store.DeleteVectorStore("vs_1")
+		if err != nil || !found {
+			t.Fatalf("Expected delete to succeed, got %v, %v", found, err)
+		}
+		if _, found := store.GetVectorStore("vs_1"); found {
+			t.Error("Expected vector store to be deleted")
+		}
+		if _, found := store.GetFile("file-2"); !found {
+			t.Error("Expected files to stay in the Files API")
+		}
+
+		found, err = store.DeleteVectorStore("vs_1")
+		if err != nil || found {
+			t.Errorf("Expected second delete to report not found, got %v, %v", found, err)
+		}
+	})
+}
+
+func TestSearchCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "conversations.db")
+
+	store, err := NewConversationStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	fresh := &models.CachedSearchResult{
+		Result:    &models.SearchProviderResult{Query: "go", Results: []models.SearchResult{{Title: "Go", URL: "https://go.dev"}}},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+	stale := &models.CachedSearchResult{
+		Result:    &models.SearchProviderResult{Query: "old"},
+		ExpiresAt: now.Add(-time.Hour).Unix(),
+	}
+	if err := store.StoreSearchResult("fresh", fresh); err != nil {
+		t.Fatalf("Failed to store search result: %v", err)
+	}
+	if err := store.StoreSearchResult("stale", stale); err != nil {
+		t.Fatalf("Failed to store search result: %v", err)
+	}
+
+	got, ok := store.GetSearchResult("fresh")
+	if !ok || got.Result.Query != "go" || len(got.Result.Results) != 1 || got.ExpiresAt != fresh.ExpiresAt {
+		t.Errorf("Expected the fresh entry, got %+v", got)
+	}
+	if _, ok := store.GetSearchResult("missing"); ok {
+		t.Error("Expected no entry for an unknown key")
+	}
+
+	count, err := store.PruneSearchResults(now)
+	if err != nil || count != 1 {
+		t.Errorf("Expected 1 pruned entry, got %d (%v)", count, err)
+	}
+	if _, ok := store.GetSearchResult("stale"); ok {
+		t.Error("Expected the stale entry to be pruned")
+	}
+	if _, ok := store.GetSearchResult("fresh"); !ok {
+		t.Error("Expected the fresh entry to be kept")
+	}
+}
@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/young1lin/responses2chat/internal/models"
+)
+
+var (
+	vectorStoresBucket      = []byte("vector_stores")       // Vector store ID -> models.VectorStore
+	vectorStoreFilesBucket  = []byte("vector_store_files")  // Vector store ID -> bucket of file ID -> models.VectorStoreFile
+	vectorStoreChunksBucket = []byte("vector_store_chunks") // Vector store ID -> bucket of chunkKey -> models.VectorStoreChunk
+)
+
+// ErrVectorStoreNotFound is returned when a vector store does not exist
+var ErrVectorStoreNotFound = errors.New("vector store not found")
+
+// chunkKey orders the chunks of a file after each other
+func chunkKey(fileID string, seq int) []byte {
+	return []byte(fmt.Sprintf("%s/%08d", fileID, seq))
+}
+
+// CreateVectorStore saves a new, empty vector store
+func (s *ConversationStore) CreateVectorStore(store *models.VectorStore) error {
+	data, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(vectorStoresBucket).Put([]byte(store.ID), data); err != nil {
+			return err
+		}
+		if _, err := tx.Bucket(vectorStoreFilesBucket).CreateBucketIfNotExists([]byte(store.ID)); err != nil {
+			return err
+		}
+		_, err := tx.Bucket(vectorStoreChunksBucket).CreateBucketIfNotExists([]byte(store.ID))
+		return err
+	})
+}
+
+// GetVectorStore retrieves a vector store by ID
+// Returns the vector store and true if found, nil and false otherwise
+func (s *ConversationStore) GetVectorStore(storeID string) (*models.VectorStore, bool) {
+	var store *models.VectorStore
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(vectorStoresBucket).Get([]byte(storeID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &store)
+	})
+
+	if err != nil || store == nil {
+		return nil, false
+	}
+
+	return store, true
+}
+
+// ListVectorStores returns all vector stores in creation order
+func (s *ConversationStore) ListVectorStores() ([]models.VectorStore, error) {
+	var stores []models.VectorStore
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(vectorStoresBucket).ForEach(func(_, v []byte) error {
+			var store models.VectorStore
+			if err := json.Unmarshal(v, &store); err != nil {
+				return err
+			}
+			stores = append(stores, store)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(stores, func(i, j int) bool {
+		if stores[i].CreatedAt != stores[j].CreatedAt {
+			return stores[i].CreatedAt < stores[j].CreatedAt
+		}
+		return stores[i].ID < stores[j].ID
+	})
+	return stores, nil
+}
+
+// DeleteVectorStore removes a vector store with its files and chunks. The
+// files themselves stay in the Files API.
+// Returns false if the vector store does not exist
+func (s *ConversationStore) DeleteVectorStore(storeID string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(vectorStoresBucket)
+		if b.Get([]byte(storeID)) == nil {
+			return nil
+		}
+		found = true
+		if err := b.Delete([]byte(storeID)); err != nil {
+			return err
+		}
+		for _, name := range [][]byte{vectorStoreFilesBucket, vectorStoreChunksBucket} {
+			if err := tx.Bucket(name).DeleteBucket([]byte(storeID)); err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// PutVectorStoreFile adds a file to a vector store, or replaces it, together
+// with its chunks and updates the file counts of the store.
+// Returns ErrVectorStoreNotFound if the vector store does not exist.
+func (s *ConversationStore) PutVectorStoreFile(file *models.VectorStoreFile, chunks []models.VectorStoreChunk) error {
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		files, chunkIndex, err := vectorStoreBuckets(tx, file.VectorStoreID)
+		if err != nil {
+			return err
+		}
+		if err := files.Put([]byte(file.ID), data); err != nil {
+			return err
+		}
+
+		if err := deleteChunks(chunkIndex, file.ID); err != nil {
+			return err
+		}
+		for i, chunk := range chunks {
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				return err
+			}
+			if err := chunkIndex.Put(chunkKey(file.ID, i), data); err != nil {
+				return err
+			}
+		}
+		return refreshVectorStore(tx, file.VectorStoreID)
+	})
+}
+
+// GetVectorStoreFile retrieves a file of a vector store
+// Returns the file and true if found, nil and false otherwise
+func (s *ConversationStore) GetVectorStoreFile(storeID, fileID string) (*models.VectorStoreFile, bool) {
+	var file *models.VectorStoreFile
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		files, _, err := vectorStoreBuckets(tx, storeID)
+		if err != nil {
+			return err
+		}
+		data := files.Get([]byte(fileID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &file)
+	})
+
+	if err != nil || file == nil {
+		return nil, false
+	}
+
+	return file, true
+}
+
+// ListVectorStoreFiles returns the files of a vector store in the order they
+// were added. Returns ErrVectorStoreNotFound if the vector store does not exist.
+func (s *ConversationStore) ListVectorStoreFiles(storeID string) ([]models.VectorStoreFile, error) {
+	var files []models.VectorStoreFile
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b, _, err := vectorStoreBuckets(tx, storeID)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var file models.VectorStoreFile
+			if err := json.Unmarshal(v, &file); err != nil {
+				return err
+			}
+			files = append(files, file)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt < files[j].CreatedAt
+		}
+		return files[i].ID < files[j].ID
+	})
+	return files, nil
+}
+
+// DeleteVectorStoreFile removes a file and its chunks from a vector store
+// Returns false if the vector store or the file does not exist
+func (s *ConversationStore) DeleteVectorStoreFile(storeID, fileID string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		files, chunkIndex, err := vectorStoreBuckets(tx, storeID)
+		if errors.Is(err, ErrVectorStoreNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if files.Get([]byte(fileID)) == nil {
+			return nil
+		}
+		found = true
+		if err := files.Delete([]byte(fileID)); err != nil {
+			return err
+		}
+		if err := deleteChunks(chunkIndex, fileID); err != nil {
+			return err
+		}
+		return refreshVectorStore(tx, storeID)
+	})
+	return found, err
+}
+
+// GetVectorStoreChunks returns the chunks of the given vector stores.
+// Returns ErrVectorStoreNotFound if one of them does not exist.
+func (s *ConversationStore) GetVectorStoreChunks(storeIDs []string) ([]models.VectorStoreChunk, error) {
+	var chunks []models.VectorStoreChunk
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		for _, id := range storeIDs {
+			_, chunkIndex, err := vectorStoreBuckets(tx, id)
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, id)
+			}
+			err = chunkIndex.ForEach(func(_, v []byte) error {
+				var chunk models.VectorStoreChunk
+				if err := json.Unmarshal(v, &chunk); err != nil {
+					return err
+				}
+				chunks = append(chunks, chunk)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// vectorStoreBuckets returns the file and chunk buckets of a vector store
+func vectorStoreBuckets(tx *bbolt.Tx, storeID string) (*bbolt.Bucket, *bbolt.Bucket, error) {
+	files := tx.Bucket(vectorStoreFilesBucket).Bucket([]byte(storeID))
+	chunks := tx.Bucket(vectorStoreChunksBucket).Bucket([]byte(storeID))
+	if files == nil || chunks == nil {
+		return nil, nil, ErrVectorStoreNotFound
+	}
+	return files, chunks, nil
+}
+
+// deleteChunks removes the chunks of a file from a chunk bucket
+func deleteChunks(chunkIndex *bbolt.Bucket, fileID string) error {
+	prefix := []byte(fileID + "/")
+	c := chunkIndex.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := chunkIndex.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshVectorStore recounts the files and usage of a vector store and marks
+// it active
+func refreshVectorStore(tx *bbolt.Tx, storeID string) error {
+	stores := tx.Bucket(vectorStoresBucket)
+	data := stores.Get([]byte(storeID))
+	if data == nil {
+		return ErrVectorStoreNotFound
+	}
+	var store models.VectorStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return err
+	}
+
+	files, _, err := vectorStoreBuckets(tx, storeID)
+	if err != nil {
+		return err
+	}
+	store.FileCounts = models.VectorStoreFileCounts{}
+	store.UsageBytes = 0
+	err = files.ForEach(func(_, v []byte) error {
+		var file models.VectorStoreFile
+		if err := json.Unmarshal(v, &file); err != nil {
+			return err
+		}
+		switch file.Status {
+		case "completed":
+			store.FileCounts.Completed++
+		case "failed":
+			store.FileCounts.Failed++
+		case "cancelled":
+			store.FileCounts.Cancelled++
+		default:
+			store.FileCounts.InProgress++
+		}
+		store.FileCounts.Total++
+		store.UsageBytes += file.UsageBytes
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	store.LastActiveAt = time.Now().Unix()
+
+	data, err = json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	return stores.Put([]byte(storeID), data)
+}
+
+// removeFileFromVectorStores removes a deleted file from every vector store
+func removeFileFromVectorStores(tx *bbolt.Tx, fileID string) error {
+	var storeIDs []string
+	all := tx.Bucket(vectorStoreFilesBucket)
+	err := all.ForEach(func(k, v []byte) error {
+		if files := all.Bucket(k); v == nil && files != nil && files.Get([]byte(fileID)) != nil {
+			storeIDs = append(storeIDs, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range storeIDs {
+		files, chunkIndex, err := vectorStoreBuckets(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := files.Delete([]byte(fileID)); err != nil {
+			return err
+		}
+		if err := deleteChunks(chunkIndex, fileID); err != nil {
+			return err
+		}
+		if err := refreshVectorStore(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}